@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/jorben/rsync-object-storage/log"
+)
+
+const (
+	// casObjectsDir CAS模式下内容对象在远端路径下的目录，对象名为内容的SHA256
+	casObjectsDir = ".objects/"
+	// casManifestName CAS模式下记录路径与内容对应关系的清单对象
+	casManifestName = ".manifest.json"
+	// casFlushInterval 清单变更后写回远端的间隔
+	casFlushInterval = 10 * time.Second
+	// casGCGrace 未被清单引用的内容对象超过该时间才清理，避免删除已上传但清单尚未写回的内容
+	casGCGrace = time.Hour
+)
+
+var (
+	// errBlobRemoved 内容对象在写入清单前被清理，重试时重新上传
+	errBlobRemoved = errors.New("content object was removed by garbage collection")
+	// errManifestNotLoaded 清单尚未从远端加载，此时记录的路径会在写回时覆盖远端清单中的其他路径
+	errManifestNotLoaded = errors.New("manifest is not loaded")
+)
+
+// CASManifest CAS模式的清单，记录远端路径下的全部路径
+type CASManifest struct {
+	UpdatedAt time.Time           `json:"updated_at"`
+	Files     map[string]CASEntry `json:"files"`
+}
+
+// CASEntry 清单中的路径，key为相对本地路径，目录只记录权限，符号链接只记录地址
+type CASEntry struct {
+	Hash    string      `json:"hash,omitempty"` // 普通文件内容的SHA256
+	Size    int64       `json:"size"`
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+	Link    string      `json:"link,omitempty"` // addr策略的符号链接指向的地址
+}
+
+// CAS 内容寻址存储的状态，相同内容在远端只存储一次
+// 清单在首次使用时从远端加载，变更先记录在内存中，由 Storage.RunCAS 定期写回
+type CAS struct {
+	mu       sync.Mutex
+	loaded   bool
+	manifest CASManifest
+	blobs    map[string]bool // 已确认远端存在的内容
+	dirty    bool
+	saveMu   sync.Mutex // 保证清单按变更的先后顺序写回
+}
+
+// NewCAS 创建CAS状态实例
+func NewCAS() *CAS {
+	return &CAS{
+		manifest: CASManifest{Files: make(map[string]CASEntry)},
+		blobs:    make(map[string]bool),
+	}
+}
+
+// lookup 获取路径在清单中的记录
+func (c *CAS) lookup(key string) (CASEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.manifest.Files[key]
+	return entry, ok
+}
+
+// set 记录路径，清单尚未加载时返回 errManifestNotLoaded，普通文件的内容在记录前已被清理时返回 errBlobRemoved
+func (c *CAS) set(key string, entry CASEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.loaded {
+		return errManifestNotLoaded
+	}
+	if entry.Hash != "" && !c.blobs[entry.Hash] {
+		return errBlobRemoved
+	}
+	c.manifest.Files[key] = entry
+	c.dirty = true
+	return nil
+}
+
+// addBlob 记录远端已存在的内容
+func (c *CAS) addBlob(hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blobs[hash] = true
+}
+
+// hasBlob 判断内容是否已确认远端存在
+func (c *CAS) hasBlob(hash string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.blobs[hash]
+}
+
+// casLoad 首次使用时加载远端清单，清单不存在时从空清单开始
+// 通过列举判断清单是否存在，避免把读取失败当作不存在而在写回时覆盖远端的清单
+func (s *Storage) casLoad(ctx context.Context) error {
+	s.CAS.mu.Lock()
+	defer s.CAS.mu.Unlock()
+	if s.CAS.loaded {
+		return nil
+	}
+	name := s.casManifestName()
+	var found bool
+	var listErr error
+	for object := range s.Backend.ListObjects(ctx, name, false) {
+		if object.Err != nil {
+			listErr = object.Err
+			continue
+		}
+		found = found || object.Key == name
+	}
+	if listErr != nil {
+		return listErr
+	}
+	manifest := CASManifest{Files: make(map[string]CASEntry)}
+	if found {
+		reader, err := s.Backend.GetObject(ctx, name)
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+		if err = json.NewDecoder(reader).Decode(&manifest); err != nil {
+			return fmt.Errorf("invalid manifest %s: %s", name, err.Error())
+		}
+		if manifest.Files == nil {
+			manifest.Files = make(map[string]CASEntry)
+		}
+	}
+	for _, entry := range manifest.Files {
+		if entry.Hash != "" {
+			s.CAS.blobs[entry.Hash] = true
+		}
+	}
+	s.CAS.manifest = manifest
+	s.CAS.loaded = true
+	log.Infof("Manifest loaded, %d path(s), remote: %s", len(manifest.Files), s.Name)
+	return nil
+}
+
+// FlushCAS 把清单的变更写回远端，没有变更时不写入
+// 清单尚未加载时内存中不是完整的清单，返回 errManifestNotLoaded，不覆盖远端的清单
+func (s *Storage) FlushCAS(ctx context.Context) error {
+	s.CAS.saveMu.Lock()
+	defer s.CAS.saveMu.Unlock()
+	s.CAS.mu.Lock()
+	if !s.CAS.dirty {
+		s.CAS.mu.Unlock()
+		return nil
+	}
+	if !s.CAS.loaded {
+		s.CAS.mu.Unlock()
+		return errManifestNotLoaded
+	}
+	s.CAS.manifest.UpdatedAt = time.Now().UTC()
+	data, err := json.Marshal(s.CAS.manifest)
+	s.CAS.dirty = false
+	s.CAS.mu.Unlock()
+	if err == nil {
+		err = s.PutObject(ctx, s.casManifestName(), data)
+	}
+	if err != nil {
+		// 下次继续写回
+		s.CAS.mu.Lock()
+		s.CAS.dirty = true
+		s.CAS.mu.Unlock()
+	}
+	return err
+}
+
+// RunCAS 定期把清单的变更写回远端，context取消后退出，退出后由调用方执行最后一次写回
+func (s *Storage) RunCAS(ctx context.Context) {
+	ticker := time.NewTicker(casFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.FlushCAS(ctx); err != nil && ctx.Err() == nil {
+				log.Errorf("Save manifest err: %s, remote: %s", err.Error(), s.Name)
+			}
+		}
+	}
+}
+
+// CASEntries 获取清单中全部路径的副本，key为相对本地路径
+func (s *Storage) CASEntries(ctx context.Context) (map[string]CASEntry, error) {
+	if err := s.casLoad(ctx); err != nil {
+		return nil, err
+	}
+	s.CAS.mu.Lock()
+	defer s.CAS.mu.Unlock()
+	entries := make(map[string]CASEntry, len(s.CAS.manifest.Files))
+	for key, entry := range s.CAS.manifest.Files {
+		entries[key] = entry
+	}
+	return entries, nil
+}
+
+// casDiff 比较本地路径与清单中的记录，返回不一致的原因，一致（或无需同步）时返回空字符串
+// 默认比较大小、修改时间、权限和链接地址，启用 checksum 时普通文件还比较内容的SHA256
+// 清单加载失败时返回错误，由调用方重试，不能当作新路径记录到未加载的清单中
+func (s *Storage) casDiff(ctx context.Context, localPath string) (string, error) {
+	if err := s.casLoad(ctx); err != nil {
+		return "", err
+	}
+	key := s.casKey(localPath)
+	if key == "" {
+		return "", nil
+	}
+	entry, _, err := s.casEntry(localPath)
+	if err != nil {
+		if errors.Is(err, enum.ErrSkipTransfer) {
+			return "", nil
+		}
+		return enum.DiffNew, nil
+	}
+	last, ok := s.CAS.lookup(key)
+	if !ok {
+		return enum.DiffNew, nil
+	}
+	if !isSameCASEntry(entry, last) {
+		return enum.DiffManifest, nil
+	}
+	if s.Checksum && entry.Mode.IsRegular() {
+		hash, err := helper.FileSha256(localPath)
+		if err != nil {
+			log.Errorf("SHA256 error: %s", err.Error())
+			return enum.DiffSha256, nil
+		}
+		log.Debugf("Compare %s, Local SHA256: %s, Manifest SHA256: %s", localPath, hash, last.Hash)
+		if hash != last.Hash {
+			return enum.DiffSha256, nil
+		}
+	}
+	return "", nil
+}
+
+// casPut 记录本地路径到清单，普通文件的内容远端不存在时才上传
+// 清单加载失败时返回错误，上传后文件与计算哈希前不一致时删除刚上传的内容并返回错误，由重试重新计算
+func (s *Storage) casPut(ctx context.Context, localPath string) error {
+	reason, err := s.casDiff(ctx, localPath)
+	if err != nil {
+		return err
+	}
+	if reason == "" {
+		return enum.ErrSkipTransfer
+	}
+	key := s.casKey(localPath)
+	entry, before, err := s.casEntry(localPath)
+	if err != nil {
+		return err
+	}
+	if !entry.Mode.IsRegular() {
+		if s.DryRun {
+			log.Infof("Dry-run: would record %s in manifest, reason: %s", key, reason)
+			return enum.ErrDryRun
+		}
+		return s.CAS.set(key, entry)
+	}
+
+	if entry.Hash, err = helper.FileSha256(localPath); err != nil {
+		return err
+	}
+	blobName := s.casBlobName(entry.Hash)
+	if s.CAS.hasBlob(entry.Hash) || s.casBlobExists(ctx, blobName, entry.Size) {
+		log.Debugf("Content exists %s, path: %s", blobName, localPath)
+		if s.DryRun {
+			log.Infof("Dry-run: would record %s in manifest, reason: %s", key, reason)
+			return enum.ErrDryRun
+		}
+		return s.CAS.set(key, entry)
+	}
+
+	if s.DryRun {
+		log.Infof("Dry-run: would upload %s, size: %s, reason: %s", blobName, helper.ByteFormat(entry.Size), reason)
+		return enum.ErrDryRun
+	}
+	if _, changed, err := s.streamUploadOnce(ctx, blobName, localPath); err != nil {
+		return err
+	} else if after, err := os.Stat(localPath); changed || err != nil || !isUnchangedFile(before, after) {
+		// 计算哈希后文件被修改，上传的内容与对象名不一致
+		_ = s.Backend.RemoveObject(ctx, blobName)
+		return fmt.Errorf("file changed during upload: %s", localPath)
+	}
+	s.CAS.addBlob(entry.Hash)
+	return s.CAS.set(key, entry)
+}
+
+// casCopy 重命名后把旧路径在清单中的内容记录到新路径，不重新计算哈希和上传
+// 旧路径不在清单中或与本地文件不一致时按 FPutObject 处理，返回是否通过复制记录完成
+func (s *Storage) casCopy(ctx context.Context, oldPath, localPath string) (bool, error) {
+	reason, err := s.casDiff(ctx, localPath)
+	if err != nil {
+		return false, err
+	}
+	if reason == "" {
+		return false, enum.ErrSkipTransfer
+	}
+	entry, _, err := s.casEntry(localPath)
+	if err != nil || !entry.Mode.IsRegular() {
+		return false, s.FPutObject(ctx, localPath)
+	}
+	last, ok := s.CAS.lookup(s.casKey(oldPath))
+	if !ok || last.Hash == "" || !isSameCASEntry(entry, last) {
+		log.Debugf("Can not copy from %s, upload %s", oldPath, localPath)
+		return false, s.FPutObject(ctx, localPath)
+	}
+	key := s.casKey(localPath)
+	if s.DryRun {
+		log.Infof("Dry-run: would record %s in manifest, reason: renamed", key)
+		return true, enum.ErrDryRun
+	}
+	entry.Hash = last.Hash
+	if err := s.CAS.set(key, entry); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// casRemove 从清单中删除本地路径及其子路径，内容对象在清理孤儿对象时删除
+func (s *Storage) casRemove(ctx context.Context, localPath string) error {
+	if err := s.casLoad(ctx); err != nil {
+		return err
+	}
+	key := s.casKey(localPath)
+	s.CAS.mu.Lock()
+	defer s.CAS.mu.Unlock()
+	var keys []string
+	for path := range s.CAS.manifest.Files {
+		if key == "" || path == key || strings.HasPrefix(path, key+"/") {
+			keys = append(keys, path)
+		}
+	}
+	sort.Strings(keys)
+	for _, path := range keys {
+		if s.DryRun {
+			log.Infof("Dry-run: would remove %s from manifest, reason: local removed", path)
+			continue
+		}
+		delete(s.CAS.manifest.Files, path)
+		log.Infof("Will be delete %s", path)
+	}
+	if s.DryRun {
+		return enum.ErrDryRun
+	}
+	s.CAS.dirty = s.CAS.dirty || len(keys) > 0
+	return nil
+}
+
+// CollectGarbage 删除清单中已不再引用的内容对象，返回删除的数量
+// 最近上传的内容可能尚未写入清单，超过 casGCGrace 的才删除
+func (s *Storage) CollectGarbage(ctx context.Context, now time.Time) (int, error) {
+	if err := s.casLoad(ctx); err != nil {
+		return 0, err
+	}
+	var candidates []ObjectInfo
+	var someError error
+	for object := range s.Backend.ListObjects(ctx, s.GetRemoteRoot()+casObjectsDir, true) {
+		if object.Err != nil {
+			someError = object.Err
+			continue
+		}
+		if now.Sub(object.LastModified) >= casGCGrace {
+			candidates = append(candidates, object)
+		}
+	}
+
+	// 在同一次加锁中判断引用并移出已存在的内容，之后引用这些内容的记录需要重新上传
+	s.CAS.mu.Lock()
+	referenced := make(map[string]bool, len(s.CAS.manifest.Files))
+	for _, entry := range s.CAS.manifest.Files {
+		referenced[entry.Hash] = true
+	}
+	orphans := candidates[:0]
+	for _, object := range candidates {
+		hash := strings.TrimPrefix(object.Key, s.GetRemoteRoot()+casObjectsDir)
+		if referenced[hash] {
+			continue
+		}
+		if !s.DryRun {
+			delete(s.CAS.blobs, hash)
+		}
+		orphans = append(orphans, object)
+	}
+	s.CAS.mu.Unlock()
+
+	var removed int
+	for _, object := range orphans {
+		if s.DryRun {
+			log.Infof("Dry-run: would delete %s, size: %s, reason: unreferenced", object.Key, helper.ByteFormat(object.Size))
+			removed++
+			continue
+		}
+		// 删除前再次确认没有被重新上传
+		if objectInfo, err := s.Backend.StatObject(ctx, object.Key); err != nil || now.Sub(objectInfo.LastModified) < casGCGrace {
+			continue
+		}
+		if err := s.Backend.RemoveObject(ctx, object.Key); err != nil {
+			log.Errorf("RemoveObject err: %s, path: %s", err.Error(), object.Key)
+			someError = err
+			continue
+		}
+		log.Infof("Remove unreferenced object %s", object.Key)
+		removed++
+	}
+	return removed, someError
+}
+
+// casEntry 按同步策略生成本地路径在清单中的记录，不计算内容的哈希，同时返回用于判断文件是否变化的文件信息
+// 跳过的符号链接和不支持的文件类型返回 enum.ErrSkipTransfer
+func (s *Storage) casEntry(localPath string) (CASEntry, os.FileInfo, error) {
+	fileInfo, err := os.Lstat(localPath)
+	if err != nil {
+		return CASEntry{}, nil, err
+	}
+	if fileInfo.Mode()&fs.ModeSymlink != 0 {
+		switch s.SymLink {
+		case enum.SymlinkFile:
+			if targetInfo, err := os.Stat(localPath); err == nil && !targetInfo.IsDir() {
+				fileInfo = targetInfo
+				break
+			}
+			// 如果是文件夹 则应用Addr策略
+			fallthrough
+		case enum.SymlinkAddr:
+			target, err := helper.GetSymlinkTarget(localPath)
+			if err != nil {
+				return CASEntry{}, nil, err
+			}
+			// 符号链接的修改时间无法恢复，只记录地址
+			return CASEntry{Mode: fileInfo.Mode(), Link: target}, fileInfo, nil
+		default:
+			return CASEntry{}, nil, enum.ErrSkipTransfer
+		}
+	}
+	switch {
+	case fileInfo.IsDir():
+		return CASEntry{Mode: fileInfo.Mode()}, fileInfo, nil
+	case fileInfo.Mode().IsRegular():
+		return CASEntry{Size: fileInfo.Size(), Mode: fileInfo.Mode(), ModTime: fileInfo.ModTime()}, fileInfo, nil
+	default:
+		return CASEntry{}, nil, enum.ErrSkipTransfer
+	}
+}
+
+// isSameCASEntry 比较清单中除内容哈希以外的记录，修改时间按纳秒比较
+func isSameCASEntry(a, b CASEntry) bool {
+	return a.Size == b.Size && a.Mode == b.Mode && a.ModTime.Equal(b.ModTime) && a.Link == b.Link
+}
+
+// casBlobExists 判断清单未引用的内容对象是否已存在于远端，存在时记录下来避免重复查询
+// 较早上传的对象可能正在被清理，只信任上传时间在保护时间一半以内的对象，否则重新上传以刷新上传时间
+func (s *Storage) casBlobExists(ctx context.Context, blobName string, size int64) bool {
+	objectInfo, err := s.Backend.StatObject(ctx, blobName)
+	if err != nil || objectInfo.Size != size || time.Since(objectInfo.LastModified) >= casGCGrace/2 {
+		return false
+	}
+	s.CAS.addBlob(strings.TrimPrefix(blobName, s.GetRemoteRoot()+casObjectsDir))
+	return true
+}
+
+// casKey 获取本地路径在清单中的key，本地根目录返回空字符串
+func (s *Storage) casKey(localPath string) string {
+	rel, err := filepath.Rel(s.LocalPrefix, localPath)
+	if err != nil || rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// casBlobName 获取内容对象的对象名
+func (s *Storage) casBlobName(hash string) string {
+	return s.GetRemoteRoot() + casObjectsDir + hash
+}
+
+// casManifestName 获取清单的对象名
+func (s *Storage) casManifestName() string {
+	return s.GetRemoteRoot() + casManifestName
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCAS_FPutObject 测试相同内容只上传一次，路径记录在清单中
+func TestCAS_FPutObject(t *testing.T) {
+	ctx := context.Background()
+	localDir, remoteDir := t.TempDir(), t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(localDir, "docs"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "docs", "a.txt"), []byte("same"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "b.txt"), []byte("same"), 0600))
+	assert.NoError(t, os.Symlink("docs/a.txt", filepath.Join(localDir, "link")))
+	hash, err := helper.FileSha256(filepath.Join(localDir, "b.txt"))
+	assert.NoError(t, err)
+
+	s := newLocalStorage(t, localDir, remoteDir, withCAS())
+	for _, path := range []string{
+		filepath.Join(localDir, "docs"),
+		filepath.Join(localDir, "docs", "a.txt"),
+		filepath.Join(localDir, "b.txt"),
+		filepath.Join(localDir, "link"),
+	} {
+		assert.Equal(t, enum.DiffNew, s.diff(ctx, path, ""))
+		assert.NoError(t, s.FPutObject(ctx, path))
+		assert.True(t, s.IsSameV2(ctx, path, ""))
+		assert.ErrorIs(t, s.FPutObject(ctx, path), enum.ErrSkipTransfer)
+	}
+	assert.ErrorIs(t, s.FPutObject(ctx, localDir), enum.ErrSkipTransfer)
+	assert.NoError(t, s.FlushCAS(ctx))
+	assert.ElementsMatch(t, []string{"backup/.manifest.json", "backup/.objects/" + hash},
+		objectKeys(collectObjects(t, s.ListObjects(ctx))))
+
+	// 重新加载的清单与写入时一致
+	entries, err := newLocalStorage(t, localDir, remoteDir, withCAS()).CASEntries(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4)
+	assert.True(t, entries["docs"].Mode.IsDir())
+	assert.Equal(t, hash, entries["docs/a.txt"].Hash)
+	assert.Equal(t, hash, entries["b.txt"].Hash)
+	assert.Equal(t, os.FileMode(0600), entries["b.txt"].Mode.Perm())
+	assert.Equal(t, "docs/a.txt", entries["link"].Link)
+
+	// 修改后按清单判断不一致
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "b.txt"), []byte("changed"), 0600))
+	assert.Equal(t, enum.DiffManifest, s.diff(ctx, filepath.Join(localDir, "b.txt"), ""))
+	assert.NoError(t, os.Chmod(filepath.Join(localDir, "docs", "a.txt"), 0600))
+	assert.Equal(t, enum.DiffManifest, s.diff(ctx, filepath.Join(localDir, "docs", "a.txt"), ""))
+}
+
+// TestCAS_Checksum 测试启用checksum时比较内容的SHA256
+func TestCAS_Checksum(t *testing.T) {
+	ctx := context.Background()
+	localDir := t.TempDir()
+	path := filepath.Join(localDir, "a.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("aaaa"), 0644))
+	fileInfo, err := os.Stat(path)
+	assert.NoError(t, err)
+
+	s := newLocalStorage(t, localDir, t.TempDir(), withCAS())
+	s.Checksum = true
+	assert.NoError(t, s.FPutObject(ctx, path))
+
+	// 大小和修改时间不变的修改
+	assert.NoError(t, os.WriteFile(path, []byte("bbbb"), 0644))
+	assert.NoError(t, os.Chtimes(path, fileInfo.ModTime(), fileInfo.ModTime()))
+	assert.Equal(t, enum.DiffSha256, s.diff(ctx, path, ""))
+	s.Checksum = false
+	assert.Equal(t, "", s.diff(ctx, path, ""))
+}
+
+// TestCAS_FCopyObject 测试重命名后只更新清单
+func TestCAS_FCopyObject(t *testing.T) {
+	ctx := context.Background()
+	localDir := t.TempDir()
+	oldPath, newPath := filepath.Join(localDir, "old.txt"), filepath.Join(localDir, "new.txt")
+	assert.NoError(t, os.WriteFile(oldPath, []byte("content"), 0644))
+
+	s := newLocalStorage(t, localDir, t.TempDir(), withCAS())
+	assert.NoError(t, s.FPutObject(ctx, oldPath))
+	assert.NoError(t, os.Rename(oldPath, newPath))
+
+	copied, err := s.FCopyObject(ctx, oldPath, newPath)
+	assert.NoError(t, err)
+	assert.True(t, copied)
+	assert.True(t, s.IsSameV2(ctx, newPath, ""))
+	assert.NoError(t, s.RemoveObjects(ctx, oldPath))
+
+	entries, err := s.CASEntries(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Contains(t, entries, "new.txt")
+}
+
+// TestCAS_RemoveObjects 测试删除目录时从清单中删除其下全部路径，内容对象保留
+func TestCAS_RemoveObjects(t *testing.T) {
+	ctx := context.Background()
+	localDir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(localDir, "docs"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "docs", "a.txt"), []byte("a"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "docs.txt"), []byte("b"), 0644))
+
+	s := newLocalStorage(t, localDir, t.TempDir(), withCAS())
+	for _, path := range []string{
+		filepath.Join(localDir, "docs"),
+		filepath.Join(localDir, "docs", "a.txt"),
+		filepath.Join(localDir, "docs.txt"),
+	} {
+		assert.NoError(t, s.FPutObject(ctx, path))
+	}
+
+	s.DryRun = true
+	assert.ErrorIs(t, s.RemoveObjects(ctx, filepath.Join(localDir, "docs")), enum.ErrDryRun)
+	entries, err := s.CASEntries(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	s.DryRun = false
+	assert.NoError(t, s.RemoveObjects(ctx, filepath.Join(localDir, "docs")))
+	entries, err = s.CASEntries(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Contains(t, entries, "docs.txt")
+	assert.Len(t, collectObjects(t, s.ListPrefix(ctx, "backup/"+casObjectsDir, true)), 2)
+}
+
+// failListBackend 前 failures 次列举返回错误的存储后端
+type failListBackend struct {
+	StorageBackend
+	failures int
+}
+
+func (b *failListBackend) ListObjects(ctx context.Context, prefix string, recursive bool) <-chan ObjectInfo {
+	if b.failures > 0 {
+		b.failures--
+		ch := make(chan ObjectInfo, 1)
+		ch <- ObjectInfo{Err: assert.AnError}
+		close(ch)
+		return ch
+	}
+	return b.StorageBackend.ListObjects(ctx, prefix, recursive)
+}
+
+// TestCAS_LoadFailed 测试清单加载失败时上传返回错误，不把只有新路径的清单写回覆盖远端的清单
+func TestCAS_LoadFailed(t *testing.T) {
+	ctx := context.Background()
+	localDir, remoteDir := t.TempDir(), t.TempDir()
+	aPath, bPath := filepath.Join(localDir, "a.txt"), filepath.Join(localDir, "b.txt")
+	assert.NoError(t, os.WriteFile(aPath, []byte("a"), 0644))
+	assert.NoError(t, os.WriteFile(bPath, []byte("b"), 0644))
+	s := newLocalStorage(t, localDir, remoteDir, withCAS())
+	assert.NoError(t, s.FPutObject(ctx, aPath))
+	assert.NoError(t, s.FlushCAS(ctx))
+
+	s = newLocalStorage(t, localDir, remoteDir, withCAS())
+	s.Backend = &failListBackend{StorageBackend: s.Backend, failures: 2}
+	assert.Equal(t, enum.DiffNew, s.diff(ctx, bPath, ""))
+	assert.ErrorIs(t, s.FPutObject(ctx, bPath), assert.AnError)
+	_, err := s.FCopyObject(ctx, aPath, bPath)
+	assert.NoError(t, err)
+	assert.NoError(t, s.FlushCAS(ctx))
+
+	// 重试时加载完整的清单后再记录
+	entries, err := newLocalStorage(t, localDir, remoteDir, withCAS()).CASEntries(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Contains(t, entries, "a.txt")
+	assert.Contains(t, entries, "b.txt")
+
+	// 未加载的清单不记录路径，也不写回
+	c := NewCAS()
+	assert.ErrorIs(t, c.set("a.txt", CASEntry{}), errManifestNotLoaded)
+	s = newLocalStorage(t, localDir, t.TempDir())
+	s.CAS = c
+	c.dirty = true
+	assert.ErrorIs(t, s.FlushCAS(ctx), errManifestNotLoaded)
+}
+
+// TestCAS_CollectGarbage 测试只删除清单不再引用且超过保护时间的内容对象
+func TestCAS_CollectGarbage(t *testing.T) {
+	ctx := context.Background()
+	localDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "a.txt"), []byte("a"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "b.txt"), []byte("b"), 0644))
+
+	s := newLocalStorage(t, localDir, t.TempDir(), withCAS())
+	assert.NoError(t, s.FPutObject(ctx, filepath.Join(localDir, "a.txt")))
+	assert.NoError(t, s.FPutObject(ctx, filepath.Join(localDir, "b.txt")))
+	assert.NoError(t, s.RemoveObjects(ctx, filepath.Join(localDir, "b.txt")))
+	blobs := collectObjects(t, s.ListPrefix(ctx, "backup/"+casObjectsDir, true))
+	assert.Len(t, blobs, 2)
+
+	// 保护时间内不删除
+	removed, err := s.CollectGarbage(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+
+	removed, err = s.CollectGarbage(ctx, time.Now().Add(casGCGrace+time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	hash, err := helper.FileSha256(filepath.Join(localDir, "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"backup/.objects/" + hash},
+		objectKeys(collectObjects(t, s.ListPrefix(ctx, "backup/"+casObjectsDir, true))))
+
+	// 已删除的内容重新出现时重新上传
+	assert.NoError(t, s.FPutObject(ctx, filepath.Join(localDir, "b.txt")))
+	assert.Len(t, collectObjects(t, s.ListPrefix(ctx, "backup/"+casObjectsDir, true)), 2)
+}
+
+// TestRestore_Run_CAS 测试按清单重建本地路径
+func TestRestore_Run_CAS(t *testing.T) {
+	ctx := context.Background()
+	localDir, remoteDir := t.TempDir(), t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(localDir, "docs", "empty"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "docs", "a.txt"), []byte("same"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "b.txt"), []byte("same"), 0644))
+	assert.NoError(t, os.Symlink("docs/a.txt", filepath.Join(localDir, "link")))
+	modTime := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	assert.NoError(t, os.Chtimes(filepath.Join(localDir, "b.txt"), modTime, modTime))
+
+	s := newLocalStorage(t, localDir, remoteDir, withCAS())
+	for _, path := range []string{
+		filepath.Join(localDir, "docs"),
+		filepath.Join(localDir, "docs", "empty"),
+		filepath.Join(localDir, "docs", "a.txt"),
+		filepath.Join(localDir, "b.txt"),
+		filepath.Join(localDir, "link"),
+	} {
+		assert.NoError(t, s.FPutObject(ctx, path))
+	}
+	assert.NoError(t, s.FlushCAS(ctx))
+
+	restoreDir := t.TempDir()
+	target := newLocalStorage(t, restoreDir, remoteDir, withCAS())
+	r := &Restore{
+		LocalPrefix:   restoreDir,
+		SymLink:       enum.SymlinkAddr,
+		IgnoreMatcher: helper.NewIgnoreMatcher(nil),
+		Storage:       target,
+	}
+	assert.NoError(t, r.Run(ctx))
+
+	content, err := os.ReadFile(filepath.Join(restoreDir, "docs", "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "same", string(content))
+	fileInfo, err := os.Stat(filepath.Join(restoreDir, "docs", "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fileInfo.Mode().Perm())
+	fileInfo, err = os.Stat(filepath.Join(restoreDir, "b.txt"))
+	assert.NoError(t, err)
+	assert.True(t, modTime.Equal(fileInfo.ModTime()))
+	assert.DirExists(t, filepath.Join(restoreDir, "docs", "empty"))
+	linkTarget, err := os.Readlink(filepath.Join(restoreDir, "link"))
+	assert.NoError(t, err)
+	assert.Equal(t, "docs/a.txt", linkTarget)
+
+	// 恢复后的路径与清单一致，再次恢复时全部跳过
+	for _, path := range []string{"docs", "docs/empty", "docs/a.txt", "b.txt", "link"} {
+		assert.True(t, target.IsSameV2(ctx, filepath.Join(restoreDir, path), ""), path)
+	}
+	assert.NoError(t, r.Run(ctx))
+}
+
+// TestRestore_Run_CASSymlinkParent 测试清单中路径的上级目录被记录为符号链接时不经由链接写到本地路径之外
+func TestRestore_Run_CASSymlinkParent(t *testing.T) {
+	ctx := context.Background()
+	localDir, remoteDir, outsideDir := t.TempDir(), t.TempDir(), t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "a.txt"), []byte("a"), 0644))
+	s := newLocalStorage(t, localDir, remoteDir, withCAS())
+	assert.NoError(t, s.FPutObject(ctx, filepath.Join(localDir, "a.txt")))
+	entry, ok := s.CAS.lookup("a.txt")
+	assert.True(t, ok)
+	assert.NoError(t, s.CAS.set("x", CASEntry{Mode: os.ModeSymlink | 0777, Link: outsideDir}))
+	assert.NoError(t, s.CAS.set("x/job", entry))
+	assert.NoError(t, s.CAS.set("x/dir", CASEntry{Mode: os.ModeDir | 0700}))
+	assert.NoError(t, s.FlushCAS(ctx))
+
+	restoreDir := t.TempDir()
+	r := &Restore{
+		LocalPrefix:   restoreDir,
+		SymLink:       enum.SymlinkAddr,
+		IgnoreMatcher: helper.NewIgnoreMatcher(nil),
+		Storage:       newLocalStorage(t, restoreDir, remoteDir, withCAS()),
+	}
+	assert.Error(t, r.Run(ctx))
+
+	assert.FileExists(t, filepath.Join(restoreDir, "a.txt"))
+	entries, err := os.ReadDir(outsideDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+// TestRestore_Run_CASMismatch 测试内容对象校验失败时保留本地文件并清理临时文件
+func TestRestore_Run_CASMismatch(t *testing.T) {
+	ctx := context.Background()
+	localDir, remoteDir := t.TempDir(), t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "a.txt"), []byte("remote"), 0644))
+	hash, err := helper.FileSha256(filepath.Join(localDir, "a.txt"))
+	assert.NoError(t, err)
+	s := newLocalStorage(t, localDir, remoteDir, withCAS())
+	assert.NoError(t, s.FPutObject(ctx, filepath.Join(localDir, "a.txt")))
+	assert.NoError(t, s.FlushCAS(ctx))
+	// 篡改远端内容对象
+	assert.NoError(t, os.WriteFile(filepath.Join(remoteDir, "backup", casObjectsDir, hash), []byte("corrupt"), 0644))
+
+	restoreDir := t.TempDir()
+	restored := filepath.Join(restoreDir, "a.txt")
+	assert.NoError(t, os.WriteFile(restored, []byte("local"), 0644))
+	r := &Restore{
+		LocalPrefix:   restoreDir,
+		SymLink:       enum.SymlinkAddr,
+		IgnoreMatcher: helper.NewIgnoreMatcher(nil),
+		Storage:       newLocalStorage(t, restoreDir, remoteDir, withCAS()),
+	}
+	assert.Error(t, r.Run(ctx))
+	content, err := os.ReadFile(restored)
+	assert.NoError(t, err)
+	assert.Equal(t, "local", string(content))
+	assert.NoFileExists(t, restored+downloadPartSuffix)
+}
@@ -3,28 +3,34 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/jorben/rsync-object-storage/config"
+	"github.com/jorben/rsync-object-storage/enum"
 	"github.com/jorben/rsync-object-storage/helper"
 	"github.com/jorben/rsync-object-storage/log"
 	"io/fs"
-	"path/filepath"
 )
 
 // CheckJob 定时对账任务
 type CheckJob struct {
-	InitialDelay time.Duration
-	Interval     int
-	Enable       bool
-	PutChan      chan string
-	LocalPrefix  string
-	Ignore       []string
-	Storage      *Storage
+	InitialDelay  time.Duration
+	Interval      int
+	Enable        bool
+	DeleteOrphans bool
+	Scheduler     *Scheduler // 远端的变更队列，对账发现的差异按积压任务调度
+	LocalPrefix   string
+	Ignore        []string
+	Storage       *Storage
+	Snapshot      *Snapshot // 快照模式下每次执行生成一个新快照，为nil时对账同步到远端路径
 }
 
 // NewCheckJob 创建Job实例
-func NewCheckJob(c *config.SyncConfig, ch chan string, storage *Storage) *CheckJob {
+func NewCheckJob(c *config.SyncConfig, scheduler *Scheduler, storage *Storage) *CheckJob {
 	// 计算首次执行时间
 	now := time.Now()
 	targetTime, err := time.ParseInLocation("2006-01-02 15:04:05",
@@ -45,20 +51,28 @@ func NewCheckJob(c *config.SyncConfig, ch chan string, storage *Storage) *CheckJ
 		targetTime = targetTime.Add(24 * time.Hour)
 	}
 
+	var snapshot *Snapshot
+	if c.Sync.Snapshot.Enable {
+		snapshot = NewSnapshot(c, storage)
+	}
+
 	return &CheckJob{
-		InitialDelay: targetTime.Sub(now),
-		Interval:     c.Sync.CheckJob.Interval,
-		Enable:       c.Sync.CheckJob.Enable,
-		Storage:      storage,
-		LocalPrefix:  c.Local.Path,
-		PutChan:      ch,
-		Ignore:       c.Sync.Ignore,
+		Snapshot:      snapshot,
+		InitialDelay:  targetTime.Sub(now),
+		Interval:      c.Sync.CheckJob.Interval,
+		Enable:        c.Sync.CheckJob.Enable,
+		DeleteOrphans: c.Sync.CheckJob.DeleteOrphans,
+		Storage:       storage,
+		LocalPrefix:   c.Local.Path,
+		Scheduler:     scheduler,
+		Ignore:        c.Sync.Ignore,
 	}
 }
 
 // Run Check job 启动入口
 // 支持通过context取消实现优雅退出
 func (c *CheckJob) Run(ctx context.Context) {
+	c.ResumeUploads(ctx)
 	if !c.Enable {
 		log.Debug("The check job is disabled")
 		// 即使禁用也需要响应context取消
@@ -74,7 +88,7 @@ func (c *CheckJob) Run(ctx context.Context) {
 		return
 	case <-time.After(c.InitialDelay):
 		// 执行首次校对任务
-		c.Walk(ctx)
+		_ = c.RunOnce(ctx)
 	}
 
 	// 创建周期定时器
@@ -88,14 +102,43 @@ func (c *CheckJob) Run(ctx context.Context) {
 			return
 		case <-ticker.C:
 			// 执行周期校对任务
-			c.Walk(ctx)
+			_ = c.RunOnce(ctx)
+		}
+	}
+}
+
+// ResumeUploads 清理遗留的分片上传，并把上次中断的分片上传重新丢入变更队列，只上传剩余的分片
+func (c *CheckJob) ResumeUploads(ctx context.Context) {
+	c.Storage.AbortStaleUploads(ctx)
+	for _, path := range c.Storage.PendingUploads() {
+		if err := c.Scheduler.Push(enum.OpPut, false, path); err != nil {
+			log.Errorf("Push queue err: %s, path: %s", err.Error(), path)
+			continue
 		}
+		log.Infof("Resume upload %s", path)
 	}
 }
 
+// RunOnce 执行一次任务，快照模式下生成快照，否则对账同步
+// 快照失败、遍历本地或列举远端失败时返回错误，上传和删除的结果由Transfer统计
+func (c *CheckJob) RunOnce(ctx context.Context) error {
+	// 清理中断后无法继续的分片上传
+	c.Storage.AbortStaleUploads(ctx)
+	if c.Snapshot == nil {
+		return c.Walk(ctx)
+	}
+	if err := c.Snapshot.Take(ctx); err != nil {
+		log.Errorf("Snapshot err: %s", err.Error())
+		return err
+	}
+	return nil
+}
+
 // Walk 遍历本地文件，对比与远端差异，存在差异的丢入变更队列
-func (c *CheckJob) Walk(ctx context.Context) {
+// 无法读取的路径跳过后继续遍历，结束时返回遇到的第一个错误
+func (c *CheckJob) Walk(ctx context.Context) error {
 	log.Info("Check job begin")
+	var someError error
 	err := filepath.WalkDir(c.LocalPrefix, func(path string, d fs.DirEntry, err error) error {
 		// 检查是否需要退出
 		select {
@@ -106,6 +149,9 @@ func (c *CheckJob) Walk(ctx context.Context) {
 
 		if err != nil {
 			log.Errorf("WalkDir err: %s, skipping %s", err.Error(), path)
+			if someError == nil {
+				someError = err
+			}
 			return filepath.SkipDir
 		}
 		// 在忽略名单的文件夹直接跳过,不进入
@@ -116,11 +162,13 @@ func (c *CheckJob) Walk(ctx context.Context) {
 		if !helper.IsIgnore(path, c.Ignore) {
 			if isSame := c.Storage.IsSameV2(ctx, path, ""); !isSame {
 				// 文件存在差异，丢入变更队列
-				select {
-				case c.PutChan <- path:
+				if err := c.Scheduler.Push(enum.OpPut, false, path); err != nil {
+					log.Errorf("Push queue err: %s, path: %s", err.Error(), path)
+					if someError == nil {
+						someError = err
+					}
+				} else {
 					log.Infof("Differences found %s", path)
-				case <-ctx.Done():
-					return ctx.Err()
 				}
 			}
 		}
@@ -128,7 +176,173 @@ func (c *CheckJob) Walk(ctx context.Context) {
 	})
 	if err != nil && err != context.Canceled {
 		log.Errorf("WalkDir err: %s", err.Error())
-		return
+		return err
+	}
+	// 镜像模式下清理远端孤儿对象
+	if c.DeleteOrphans && ctx.Err() == nil {
+		if err := c.Prune(ctx); err != nil && someError == nil {
+			someError = err
+		}
+	}
+	// 分块模式下每次对账都清理不再被引用的数据块，与是否清理孤儿对象无关
+	if c.Storage.ChunkFileSize > 0 && ctx.Err() == nil {
+		if err := c.CollectChunks(ctx); err != nil && someError == nil {
+			someError = err
+		}
 	}
 	log.Info("Check job ends")
+	return someError
+}
+
+// Prune 遍历远端对象，把本地已不存在源文件的孤儿对象丢入删除队列
+// 列举远端失败时继续处理已列出的对象，结束时返回遇到的第一个错误
+func (c *CheckJob) Prune(ctx context.Context) error {
+	if c.Storage.CAS != nil {
+		return c.pruneManifest(ctx)
+	}
+	log.Info("Orphan prune begin")
+	var orphans int
+	var someError error
+	queued := make(map[string]struct{})
+	for object := range c.Storage.ListObjects(ctx) {
+		if object.Err != nil {
+			log.Errorf("ListObjects err: %s", object.Err.Error())
+			if someError == nil {
+				someError = object.Err
+			}
+			continue
+		}
+		if c.Storage.IsTrash(object.Key) || c.Storage.IsChunkStore(object.Key) || c.Storage.IsSnapshot(object.Key) {
+			continue
+		}
+		path, isOrphan := c.orphanPath(object)
+		if !isOrphan {
+			continue
+		}
+		orphans++
+		// 同一个已删除目录下的对象只需要删除一次
+		if _, ok := queued[path]; ok {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := c.Scheduler.Push(enum.OpDelete, false, path); err != nil {
+			log.Errorf("Push queue err: %s, path: %s", err.Error(), path)
+			if someError == nil {
+				someError = err
+			}
+			continue
+		}
+		queued[path] = struct{}{}
+		log.Infof("Orphan found %s", path)
+	}
+	log.Infof("Orphan prune ends, %d orphan object(s) under %d path(s) queued for deletion", orphans, len(queued))
+	return someError
+}
+
+// CollectChunks 清理分块模式下已不被任何块列表引用的数据块
+// 文件被修改、实时删除或孤儿清理后旧的数据块不再被引用，本次删除的块列表引用的数据块在下一次清理中删除
+func (c *CheckJob) CollectChunks(ctx context.Context) error {
+	removed, err := c.Storage.CollectChunks(ctx, time.Now())
+	if err != nil {
+		log.Errorf("Collect chunks err: %s", err.Error())
+	}
+	log.Infof("Chunk collect ends, %d unreferenced chunk(s) removed", removed)
+	return err
+}
+
+// orphanPath 判断远端对象在本地是否已无对应源文件，返回需要删除的本地路径
+// 对象所在的目录也已删除时，返回最上层已删除的目录，以便整体删除
+func (c *CheckJob) orphanPath(object ObjectInfo) (string, bool) {
+	objectName := object.Key
+	name := objectName
+	switch {
+	case strings.HasSuffix(objectName, "/.keep") && object.Size == 0:
+		// 空目录用.keep文件构建，有内容的 .keep 是真实文件
+		name = strings.TrimSuffix(objectName, "/.keep")
+	case strings.HasSuffix(objectName, chunkListSuffix):
+		// 分块上传的文件以块列表表示
+		name = strings.TrimSuffix(objectName, chunkListSuffix)
+	}
+	path, err := c.Storage.GetLocalPath(name)
+	if err != nil {
+		// 映射到本地路径之外的对象不处理
+		log.Errorf("GetLocalPath err: %s", err.Error())
+		return "", false
+	}
+	// addr策略上传的符号链接，链接本身或同名文件存在都不算孤儿
+	if strings.HasSuffix(objectName, ".link") && isLexist(strings.TrimSuffix(path, ".link")) {
+		return "", false
+	}
+	return c.deletedRoot(path)
+}
+
+// deletedRoot 判断本地路径是否已删除，返回最上层已删除的路径
+func (c *CheckJob) deletedRoot(path string) (string, bool) {
+	if path == c.LocalPrefix || isLexist(path) || helper.IsIgnore(path, c.Ignore) {
+		return "", false
+	}
+	for parent := filepath.Dir(path); parent != c.LocalPrefix && strings.HasPrefix(parent, c.LocalPrefix); parent = filepath.Dir(parent) {
+		if isLexist(parent) {
+			break
+		}
+		path = parent
+	}
+	return path, true
+}
+
+// pruneManifest CAS模式下把清单中本地已不存在的路径丢入删除队列，并删除清单不再引用的内容对象
+// 本次删除的路径引用的内容在写回清单后的下一次清理中删除
+func (c *CheckJob) pruneManifest(ctx context.Context) error {
+	log.Info("Orphan prune begin")
+	entries, err := c.Storage.CASEntries(ctx)
+	if err != nil {
+		log.Errorf("Load manifest err: %s", err.Error())
+		return err
+	}
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	// 上级目录先于子路径，已删除目录下的路径只需要删除一次
+	sort.Strings(keys)
+	var someError error
+	queued := make(map[string]struct{})
+	for _, key := range keys {
+		path, isOrphan := c.deletedRoot(filepath.Join(c.LocalPrefix, filepath.FromSlash(key)))
+		if !isOrphan {
+			continue
+		}
+		if _, ok := queued[path]; ok {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := c.Scheduler.Push(enum.OpDelete, false, path); err != nil {
+			log.Errorf("Push queue err: %s, path: %s", err.Error(), path)
+			if someError == nil {
+				someError = err
+			}
+			continue
+		}
+		queued[path] = struct{}{}
+		log.Infof("Orphan found %s", path)
+	}
+	removed, err := c.Storage.CollectGarbage(ctx, time.Now())
+	if err != nil {
+		log.Errorf("Collect garbage err: %s", err.Error())
+		if someError == nil {
+			someError = err
+		}
+	}
+	log.Infof("Orphan prune ends, %d path(s) queued for deletion, %d unreferenced object(s) removed", len(queued), removed)
+	return someError
+}
+
+// isLexist 判断路径是否存在，符号链接不跟随
+func isLexist(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
 }
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"testing"
@@ -9,8 +11,10 @@ import (
 
 	"github.com/jorben/rsync-object-storage/config"
 	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
 	"github.com/jorben/rsync-object-storage/log"
 	"github.com/jorben/rsync-object-storage/mocks"
+	"github.com/jorben/rsync-object-storage/state"
 	"github.com/minio/minio-go/v7"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -36,20 +40,57 @@ func createTestConfig() *config.SyncConfig {
 	return cfg
 }
 
+// newTestScheduler 创建测试用的变更调度，队列为持久化的临时文件
+func newTestScheduler(t *testing.T) *Scheduler {
+	journal, err := state.OpenJournal(filepath.Join(t.TempDir(), "queue.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() {
+		_ = journal.Close()
+	})
+	queue, err := journal.Queue("test-remote")
+	assert.NoError(t, err)
+	return &Scheduler{Queue: queue, SmallFileSize: 8 * 1024 * 1024, LargeFileSize: 1024 * 1024 * 1024}
+}
+
+// drainQueue 关闭队列并取出全部指定操作的任务路径
+func drainQueue(t *testing.T, scheduler *Scheduler, op string) []string {
+	var paths []string
+	for _, item := range queueItems(t, scheduler) {
+		if item.Op == op {
+			paths = append(paths, item.Path)
+		}
+	}
+	return paths
+}
+
+// queueItems 关闭队列并取出全部任务
+func queueItems(t *testing.T, scheduler *Scheduler) []state.QueueItem {
+	scheduler.Close()
+	var items []state.QueueItem
+	for {
+		item, err := scheduler.Pop(context.Background())
+		if errors.Is(err, state.ErrQueueClosed) || !assert.NoError(t, err) {
+			return items
+		}
+		assert.NoError(t, scheduler.Ack(item))
+		item.Seq = 0
+		items = append(items, item)
+	}
+}
+
 // TestNewCheckJob 测试 CheckJob 创建
 func TestNewCheckJob(t *testing.T) {
-	putCh := make(chan string, 10)
+	scheduler := newTestScheduler(t)
 	mockClient := new(mocks.MockObjectStorageClient)
 
 	storage := &Storage{
-		Client:       mockClient,
-		Bucket:       "test-bucket",
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
 		LocalPrefix:  "/data/local",
 		RemotePrefix: "remote",
 	}
 
 	cfg := createTestConfig()
-	job := NewCheckJob(cfg, putCh, storage)
+	job := NewCheckJob(cfg, scheduler, storage)
 
 	assert.NotNil(t, job)
 	assert.True(t, job.Enable)
@@ -60,18 +101,17 @@ func TestNewCheckJob(t *testing.T) {
 
 // TestNewCheckJob_StartAtParsing 测试 StartAt 时间解析
 func TestNewCheckJob_StartAtParsing(t *testing.T) {
-	putCh := make(chan string, 10)
+	scheduler := newTestScheduler(t)
 	mockClient := new(mocks.MockObjectStorageClient)
 
 	storage := &Storage{
-		Client: mockClient,
-		Bucket: "test-bucket",
+		Backend: NewS3Backend(mockClient, "test-bucket"),
 	}
 
 	t.Run("有效的时间格式", func(t *testing.T) {
 		cfg := createTestConfig()
 		cfg.Sync.CheckJob.StartAt = "03:30:00"
-		job := NewCheckJob(cfg, putCh, storage)
+		job := NewCheckJob(cfg, scheduler, storage)
 
 		assert.NotNil(t, job)
 		// InitialDelay 应该是正数
@@ -81,7 +121,7 @@ func TestNewCheckJob_StartAtParsing(t *testing.T) {
 	t.Run("无效的时间格式", func(t *testing.T) {
 		cfg := createTestConfig()
 		cfg.Sync.CheckJob.StartAt = "invalid"
-		job := NewCheckJob(cfg, putCh, storage)
+		job := NewCheckJob(cfg, scheduler, storage)
 
 		assert.NotNil(t, job)
 		// 无效格式应该回退到 00:00:00
@@ -90,18 +130,17 @@ func TestNewCheckJob_StartAtParsing(t *testing.T) {
 
 // TestNewCheckJob_IntervalMinimum 测试 Interval 最小值
 func TestNewCheckJob_IntervalMinimum(t *testing.T) {
-	putCh := make(chan string, 10)
+	scheduler := newTestScheduler(t)
 	mockClient := new(mocks.MockObjectStorageClient)
 
 	storage := &Storage{
-		Client: mockClient,
-		Bucket: "test-bucket",
+		Backend: NewS3Backend(mockClient, "test-bucket"),
 	}
 
 	t.Run("Interval 为 0 时设为 1", func(t *testing.T) {
 		cfg := createTestConfig()
 		cfg.Sync.CheckJob.Interval = 0
-		job := NewCheckJob(cfg, putCh, storage)
+		job := NewCheckJob(cfg, scheduler, storage)
 
 		assert.Equal(t, 1, job.Interval)
 	})
@@ -109,7 +148,7 @@ func TestNewCheckJob_IntervalMinimum(t *testing.T) {
 	t.Run("Interval 为负数时设为 1", func(t *testing.T) {
 		cfg := createTestConfig()
 		cfg.Sync.CheckJob.Interval = -5
-		job := NewCheckJob(cfg, putCh, storage)
+		job := NewCheckJob(cfg, scheduler, storage)
 
 		assert.Equal(t, 1, job.Interval)
 	})
@@ -117,17 +156,16 @@ func TestNewCheckJob_IntervalMinimum(t *testing.T) {
 
 // TestCheckJob_Run_Disabled 测试禁用状态
 func TestCheckJob_Run_Disabled(t *testing.T) {
-	putCh := make(chan string, 10)
+	scheduler := newTestScheduler(t)
 	mockClient := new(mocks.MockObjectStorageClient)
 
 	storage := &Storage{
-		Client: mockClient,
-		Bucket: "test-bucket",
+		Backend: NewS3Backend(mockClient, "test-bucket"),
 	}
 
 	cfg := createTestConfig()
 	cfg.Sync.CheckJob.Enable = false
-	job := NewCheckJob(cfg, putCh, storage)
+	job := NewCheckJob(cfg, scheduler, storage)
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -151,17 +189,16 @@ func TestCheckJob_Run_Disabled(t *testing.T) {
 
 // TestCheckJob_Run_ContextCancel 测试 Context 取消
 func TestCheckJob_Run_ContextCancel(t *testing.T) {
-	putCh := make(chan string, 10)
+	scheduler := newTestScheduler(t)
 	mockClient := new(mocks.MockObjectStorageClient)
 
 	storage := &Storage{
-		Client: mockClient,
-		Bucket: "test-bucket",
+		Backend: NewS3Backend(mockClient, "test-bucket"),
 	}
 
 	cfg := createTestConfig()
 	cfg.Sync.CheckJob.Enable = true
-	job := NewCheckJob(cfg, putCh, storage)
+	job := NewCheckJob(cfg, scheduler, storage)
 	// 设置一个很长的初始延迟
 	job.InitialDelay = time.Hour
 
@@ -195,7 +232,7 @@ func TestCheckJob_Walk(t *testing.T) {
 	err := os.WriteFile(testFile, []byte("test content"), 0644)
 	assert.NoError(t, err)
 
-	putCh := make(chan string, 10)
+	scheduler := newTestScheduler(t)
 	mockClient := new(mocks.MockObjectStorageClient)
 
 	// Mock StatObject 返回错误（文件不存在于远程，需要同步）
@@ -203,8 +240,7 @@ func TestCheckJob_Walk(t *testing.T) {
 		Return(minio.ObjectInfo{}, assert.AnError)
 
 	storage := &Storage{
-		Client:       mockClient,
-		Bucket:       "test-bucket",
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
 		LocalPrefix:  tmpDir,
 		RemotePrefix: "remote",
 		SymLink:      enum.SymlinkSkip,
@@ -212,26 +248,35 @@ func TestCheckJob_Walk(t *testing.T) {
 
 	job := &CheckJob{
 		Enable:      true,
-		PutChan:     putCh,
+		Scheduler:   scheduler,
 		LocalPrefix: tmpDir,
 		Ignore:      []string{},
 		Storage:     storage,
 	}
 
 	ctx := context.Background()
-	job.Walk(ctx)
+	assert.NoError(t, job.Walk(ctx))
 
-	// 检查是否有文件被发送到 PutChan
-	select {
-	case path := <-putCh:
-		assert.Contains(t, path, "test.txt")
-	case <-time.After(time.Second):
-		t.Fatal("未收到预期的同步任务")
-	}
+	// 检查是否有文件被丢入变更队列
+	assert.Contains(t, drainQueue(t, scheduler, enum.OpPut), testFile)
 
 	mockClient.AssertExpectations(t)
 }
 
+// TestCheckJob_Walk_Error 测试本地路径无法遍历时返回错误
+func TestCheckJob_Walk_Error(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	job := &CheckJob{
+		Enable:      true,
+		Scheduler:   newTestScheduler(t),
+		LocalPrefix: missing,
+		Storage:     &Storage{LocalPrefix: missing, RemotePrefix: "remote"},
+	}
+
+	assert.Error(t, job.Walk(context.Background()))
+	assert.Error(t, job.RunOnce(context.Background()))
+}
+
 // TestCheckJob_Walk_IgnoreFiles 测试 Walk 忽略文件
 func TestCheckJob_Walk_IgnoreFiles(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -248,7 +293,7 @@ func TestCheckJob_Walk_IgnoreFiles(t *testing.T) {
 	err = os.WriteFile(normalFile, []byte("content"), 0644)
 	assert.NoError(t, err)
 
-	putCh := make(chan string, 10)
+	scheduler := newTestScheduler(t)
 	mockClient := new(mocks.MockObjectStorageClient)
 
 	// Mock StatObject 返回错误（文件不存在于远程）
@@ -256,8 +301,7 @@ func TestCheckJob_Walk_IgnoreFiles(t *testing.T) {
 		Return(minio.ObjectInfo{}, assert.AnError)
 
 	storage := &Storage{
-		Client:       mockClient,
-		Bucket:       "test-bucket",
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
 		LocalPrefix:  tmpDir,
 		RemotePrefix: "remote",
 		SymLink:      enum.SymlinkSkip,
@@ -265,7 +309,7 @@ func TestCheckJob_Walk_IgnoreFiles(t *testing.T) {
 
 	job := &CheckJob{
 		Enable:      true,
-		PutChan:     putCh,
+		Scheduler:   scheduler,
 		LocalPrefix: tmpDir,
 		Ignore:      []string{".git"},
 		Storage:     storage,
@@ -274,20 +318,9 @@ func TestCheckJob_Walk_IgnoreFiles(t *testing.T) {
 	ctx := context.Background()
 	job.Walk(ctx)
 
-	// 收集所有发送的路径
-	var sentPaths []string
-	timeout := time.After(500 * time.Millisecond)
-	for {
-		select {
-		case path := <-putCh:
-			sentPaths = append(sentPaths, path)
-		case <-timeout:
-			goto done
-		}
-	}
-done:
-
-	// 检查 .git 目录下的文件未被发送
+	// 检查 .git 目录下的文件未被丢入队列
+	sentPaths := drainQueue(t, scheduler, enum.OpPut)
+	assert.Contains(t, sentPaths, normalFile)
 	for _, path := range sentPaths {
 		assert.NotContains(t, path, ".git")
 	}
@@ -302,7 +335,7 @@ func TestCheckJob_Walk_SameFile(t *testing.T) {
 	err := os.WriteFile(testFile, []byte("content"), 0644)
 	assert.NoError(t, err)
 
-	putCh := make(chan string, 10)
+	scheduler := newTestScheduler(t)
 	mockClient := new(mocks.MockObjectStorageClient)
 
 	// Mock StatObject 返回一致的 MD5
@@ -313,8 +346,7 @@ func TestCheckJob_Walk_SameFile(t *testing.T) {
 		}, nil)
 
 	storage := &Storage{
-		Client:       mockClient,
-		Bucket:       "test-bucket",
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
 		LocalPrefix:  tmpDir,
 		RemotePrefix: "remote",
 		SymLink:      enum.SymlinkSkip,
@@ -322,7 +354,7 @@ func TestCheckJob_Walk_SameFile(t *testing.T) {
 
 	job := &CheckJob{
 		Enable:      true,
-		PutChan:     putCh,
+		Scheduler:   scheduler,
 		LocalPrefix: tmpDir,
 		Ignore:      []string{},
 		Storage:     storage,
@@ -331,13 +363,8 @@ func TestCheckJob_Walk_SameFile(t *testing.T) {
 	ctx := context.Background()
 	job.Walk(ctx)
 
-	// 一致的文件不应该被发送到 PutChan
-	select {
-	case <-putCh:
-		t.Fatal("一致的文件不应该被发送到同步队列")
-	case <-time.After(200 * time.Millisecond):
-		// 预期行为
-	}
+	// 一致的文件不应该被丢入变更队列
+	assert.Empty(t, drainQueue(t, scheduler, enum.OpPut), "一致的文件不应该被发送到同步队列")
 
 	mockClient.AssertExpectations(t)
 }
@@ -353,7 +380,7 @@ func TestCheckJob_Walk_ContextCancel(t *testing.T) {
 		assert.NoError(t, err)
 	}
 
-	putCh := make(chan string, 1) // 小缓冲区
+	scheduler := newTestScheduler(t)
 	mockClient := new(mocks.MockObjectStorageClient)
 
 	// Mock StatObject 返回错误
@@ -361,8 +388,7 @@ func TestCheckJob_Walk_ContextCancel(t *testing.T) {
 		Return(minio.ObjectInfo{}, assert.AnError)
 
 	storage := &Storage{
-		Client:       mockClient,
-		Bucket:       "test-bucket",
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
 		LocalPrefix:  tmpDir,
 		RemotePrefix: "remote",
 		SymLink:      enum.SymlinkSkip,
@@ -370,7 +396,7 @@ func TestCheckJob_Walk_ContextCancel(t *testing.T) {
 
 	job := &CheckJob{
 		Enable:      true,
-		PutChan:     putCh,
+		Scheduler:   scheduler,
 		LocalPrefix: tmpDir,
 		Ignore:      []string{},
 		Storage:     storage,
@@ -400,7 +426,7 @@ func TestCheckJob_Walk_ContextCancel(t *testing.T) {
 func TestCheckJob_Walk_EmptyDirectory(t *testing.T) {
 	tmpDir := t.TempDir()
 
-	putCh := make(chan string, 10)
+	scheduler := newTestScheduler(t)
 	mockClient := new(mocks.MockObjectStorageClient)
 
 	// 空目录也会被尝试同步，需要 Mock StatObject
@@ -408,8 +434,7 @@ func TestCheckJob_Walk_EmptyDirectory(t *testing.T) {
 		Return(minio.ObjectInfo{}, assert.AnError)
 
 	storage := &Storage{
-		Client:       mockClient,
-		Bucket:       "test-bucket",
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
 		LocalPrefix:  tmpDir,
 		RemotePrefix: "remote",
 		SymLink:      enum.SymlinkSkip,
@@ -417,7 +442,7 @@ func TestCheckJob_Walk_EmptyDirectory(t *testing.T) {
 
 	job := &CheckJob{
 		Enable:      true,
-		PutChan:     putCh,
+		Scheduler:   scheduler,
 		LocalPrefix: tmpDir,
 		Ignore:      []string{},
 		Storage:     storage,
@@ -426,13 +451,8 @@ func TestCheckJob_Walk_EmptyDirectory(t *testing.T) {
 	ctx := context.Background()
 	job.Walk(ctx)
 
-	// 空目录应该正常完成
-	select {
-	case <-putCh:
-		// 可能会同步空目录本身
-	case <-time.After(200 * time.Millisecond):
-		// 也可能什么都不发送
-	}
+	// 空目录应该正常完成，可能会同步空目录本身
+	assert.LessOrEqual(t, len(drainQueue(t, scheduler, enum.OpPut)), 1)
 }
 
 // TestCheckJob_Walk_NestedDirectories 测试 Walk 嵌套目录
@@ -449,7 +469,7 @@ func TestCheckJob_Walk_NestedDirectories(t *testing.T) {
 	err = os.WriteFile(deepFile, []byte("deep content"), 0644)
 	assert.NoError(t, err)
 
-	putCh := make(chan string, 10)
+	scheduler := newTestScheduler(t)
 	mockClient := new(mocks.MockObjectStorageClient)
 
 	// Mock StatObject 返回错误
@@ -457,8 +477,7 @@ func TestCheckJob_Walk_NestedDirectories(t *testing.T) {
 		Return(minio.ObjectInfo{}, assert.AnError)
 
 	storage := &Storage{
-		Client:       mockClient,
-		Bucket:       "test-bucket",
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
 		LocalPrefix:  tmpDir,
 		RemotePrefix: "remote",
 		SymLink:      enum.SymlinkSkip,
@@ -466,7 +485,7 @@ func TestCheckJob_Walk_NestedDirectories(t *testing.T) {
 
 	job := &CheckJob{
 		Enable:      true,
-		PutChan:     putCh,
+		Scheduler:   scheduler,
 		LocalPrefix: tmpDir,
 		Ignore:      []string{},
 		Storage:     storage,
@@ -475,21 +494,240 @@ func TestCheckJob_Walk_NestedDirectories(t *testing.T) {
 	ctx := context.Background()
 	job.Walk(ctx)
 
-	// 收集发送的路径
-	var found bool
-	timeout := time.After(500 * time.Millisecond)
-	for {
-		select {
-		case path := <-putCh:
-			if path == deepFile {
-				found = true
-			}
-		case <-timeout:
-			goto done
-		}
+	assert.Contains(t, drainQueue(t, scheduler, enum.OpPut), deepFile, "应该找到深层嵌套的文件")
+	mockClient.AssertExpectations(t)
+}
+
+// TestCheckJob_Prune 测试清理远端孤儿对象
+func TestCheckJob_Prune(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// 本地仍然存在的文件和符号链接
+	err := os.WriteFile(filepath.Join(tmpDir, "keep.txt"), []byte("content"), 0644)
+	assert.NoError(t, err)
+	err = os.Symlink(filepath.Join(tmpDir, "keep.txt"), filepath.Join(tmpDir, "link"))
+	assert.NoError(t, err)
+	err = os.Mkdir(filepath.Join(tmpDir, "empty"), 0755)
+	assert.NoError(t, err)
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket",
+		minio.ListObjectsOptions{Prefix: "remote/", Recursive: true}).
+		Return(objectsChan(
+			minio.ObjectInfo{Key: "remote/keep.txt"},
+			minio.ObjectInfo{Key: "remote/link.link"},
+			minio.ObjectInfo{Key: "remote/empty/.keep"},
+			minio.ObjectInfo{Key: "remote/gone.txt"},
+			minio.ObjectInfo{Key: "remote/dead.link"},
+			minio.ObjectInfo{Key: "remote/olddir/a.txt"},
+			minio.ObjectInfo{Key: "remote/olddir/sub/b.txt"},
+			minio.ObjectInfo{Key: "remote/olddir/empty/.keep"},
+			minio.ObjectInfo{Key: "remote/.git/config"},
+			minio.ObjectInfo{Key: "remote/.snapshots/2026-10-15T04:00:00Z/gone.txt"},
+			minio.ObjectInfo{Key: "remote/.snapshots/2026-10-15T04:00:00Z" + manifestSuffix},
+		))
+
+	storage := &Storage{
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
+		LocalPrefix:  tmpDir,
+		RemotePrefix: "remote",
+		SymLink:      enum.SymlinkAddr,
+	}
+
+	scheduler := newTestScheduler(t)
+	job := &CheckJob{
+		Enable:        true,
+		DeleteOrphans: true,
+		Scheduler:     scheduler,
+		LocalPrefix:   tmpDir,
+		Ignore:        []string{".git"},
+		Storage:       storage,
 	}
-done:
 
-	assert.True(t, found, "应该找到深层嵌套的文件")
+	assert.NoError(t, job.Prune(context.Background()))
+
+	deleted := drainQueue(t, scheduler, enum.OpDelete)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(tmpDir, "gone.txt"),
+		filepath.Join(tmpDir, "dead.link"),
+		filepath.Join(tmpDir, "olddir"),
+	}, deleted)
 	mockClient.AssertExpectations(t)
 }
+
+// TestCheckJob_Prune_ListError 测试列举远端失败时返回错误，已列出的孤儿仍丢入删除队列
+func TestCheckJob_Prune_ListError(t *testing.T) {
+	tmpDir := t.TempDir()
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket",
+		minio.ListObjectsOptions{Prefix: "remote/", Recursive: true}).
+		Return(objectsChan(
+			minio.ObjectInfo{Key: "remote/gone.txt"},
+			minio.ObjectInfo{Err: assert.AnError},
+		))
+
+	scheduler := newTestScheduler(t)
+	job := &CheckJob{
+		Enable:        true,
+		DeleteOrphans: true,
+		Scheduler:     scheduler,
+		LocalPrefix:   tmpDir,
+		Storage: &Storage{
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
+			LocalPrefix:  tmpDir,
+			RemotePrefix: "remote",
+		},
+	}
+
+	assert.ErrorIs(t, job.Prune(context.Background()), assert.AnError)
+	assert.Equal(t, []string{filepath.Join(tmpDir, "gone.txt")}, drainQueue(t, scheduler, enum.OpDelete))
+}
+
+// TestCheckJob_Prune_CAS 测试CAS模式下按清单查找本地已删除的路径
+func TestCheckJob_Prune_CAS(t *testing.T) {
+	tmpDir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "olddir", "sub"), 0755))
+	for _, name := range []string{"keep.txt", "gone.txt", "olddir/a.txt", "olddir/sub/b.txt"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, name), []byte(name), 0644))
+	}
+
+	storage := newLocalStorage(t, tmpDir, t.TempDir(), withCAS())
+	err := filepath.WalkDir(tmpDir, func(path string, d fs.DirEntry, err error) error {
+		if path != tmpDir {
+			assert.NoError(t, storage.FPutObject(context.Background(), path))
+		}
+		return err
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, os.Remove(filepath.Join(tmpDir, "gone.txt")))
+	assert.NoError(t, os.RemoveAll(filepath.Join(tmpDir, "olddir")))
+
+	scheduler := newTestScheduler(t)
+	job := &CheckJob{
+		Enable:        true,
+		DeleteOrphans: true,
+		Scheduler:     scheduler,
+		LocalPrefix:   tmpDir,
+		Storage:       storage,
+	}
+
+	job.Prune(context.Background())
+
+	deleted := drainQueue(t, scheduler, enum.OpDelete)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(tmpDir, "gone.txt"),
+		filepath.Join(tmpDir, "olddir"),
+	}, deleted)
+}
+
+// TestCheckJob_Prune_Chunk 测试分块上传的文件按块列表判断孤儿，数据块不作为孤儿删除
+func TestCheckJob_Prune_Chunk(t *testing.T) {
+	tmpDir := t.TempDir()
+	storage := newLocalStorage(t, tmpDir, t.TempDir(), withChunk())
+	for _, name := range []string{"keep.bin", "gone.bin"} {
+		path := filepath.Join(tmpDir, name)
+		writeRandomFile(t, path, 128*1024, 1)
+		assert.NoError(t, storage.FPutObject(context.Background(), path))
+	}
+	assert.NoError(t, os.Remove(filepath.Join(tmpDir, "gone.bin")))
+
+	scheduler := newTestScheduler(t)
+	job := &CheckJob{
+		Enable:        true,
+		DeleteOrphans: true,
+		Scheduler:     scheduler,
+		LocalPrefix:   tmpDir,
+		Storage:       storage,
+	}
+
+	job.Prune(context.Background())
+
+	deleted := drainQueue(t, scheduler, enum.OpDelete)
+	assert.Equal(t, []string{filepath.Join(tmpDir, "gone.bin")}, deleted)
+}
+
+// TestCheckJob_Walk_CollectChunks 测试未开启清理孤儿对象时，对账后仍清理文件修改后不再被引用的数据块
+func TestCheckJob_Walk_CollectChunks(t *testing.T) {
+	ctx := context.Background()
+	tmpDir, remoteDir := t.TempDir(), t.TempDir()
+	storage := newLocalStorage(t, tmpDir, remoteDir, withChunk())
+	path := filepath.Join(tmpDir, "data.bin")
+	writeRandomFile(t, path, 128*1024, 1)
+	assert.NoError(t, storage.FPutObject(ctx, path))
+	writeRandomFile(t, path, 128*1024, 2)
+	assert.NoError(t, storage.FPutObject(ctx, path))
+
+	// 数据块超过保护时间后才清理
+	chunkDir := filepath.Join(remoteDir, "backup", chunksDir)
+	entries, err := os.ReadDir(chunkDir)
+	assert.NoError(t, err)
+	old := time.Now().Add(-chunkGCGrace - time.Minute)
+	for _, entry := range entries {
+		assert.NoError(t, os.Chtimes(filepath.Join(chunkDir, entry.Name()), old, old))
+	}
+
+	job := &CheckJob{
+		Enable:      true,
+		Scheduler:   newTestScheduler(t),
+		LocalPrefix: tmpDir,
+		Storage:     storage,
+	}
+	assert.NoError(t, job.Walk(ctx))
+
+	chunks, err := helper.FileChunks(path, storage.ChunkSize)
+	assert.NoError(t, err)
+	var expected []string
+	for _, chunk := range chunks {
+		expected = append(expected, storage.chunkName(chunk.Hash))
+	}
+	assert.Less(t, len(expected), len(entries))
+	assert.ElementsMatch(t, expected, objectKeys(collectObjects(t, storage.ListPrefix(ctx, "backup/"+chunksDir, true))))
+}
+
+// TestCheckJob_Walk_DeleteOrphansDisabled 测试未开启时不列举远端对象
+func TestCheckJob_Walk_DeleteOrphansDisabled(t *testing.T) {
+	tmpDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(tmpDir, "test.txt"), []byte("test content"), 0644)
+	assert.NoError(t, err)
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("StatObject", mock.Anything, "test-bucket", mock.Anything, minio.StatObjectOptions{}).
+		Return(minio.ObjectInfo{}, assert.AnError)
+
+	storage := &Storage{
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
+		LocalPrefix:  tmpDir,
+		RemotePrefix: "remote",
+		SymLink:      enum.SymlinkSkip,
+	}
+
+	job := &CheckJob{
+		Enable:      true,
+		Scheduler:   newTestScheduler(t),
+		LocalPrefix: tmpDir,
+		Storage:     storage,
+	}
+
+	job.Walk(context.Background())
+
+	mockClient.AssertNotCalled(t, "ListObjects", mock.Anything, mock.Anything, mock.Anything)
+}
+
+// TestCheckJob_ResumeUploads 测试启动时把未完成的分片上传重新丢入变更队列
+func TestCheckJob_ResumeUploads(t *testing.T) {
+	ctx := context.Background()
+	client, core := new(mocks.MockObjectStorageClient), new(mocks.MockMultipartClient)
+	s, bigFile := newMultipartStorage(t, client, core)
+	fileInfo, err := os.Stat(bigFile)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Uploads.Set("remote/big.bin", state.Upload{UploadID: "active", Source: bigFile,
+		Size: fileInfo.Size(), ModTime: fileInfo.ModTime(), CreatedAt: time.Now()}))
+	core.On("ListMultipartUploads", ctx, "test-bucket", "remote/", "", "", "", 1000).
+		Return(minio.ListMultipartUploadsResult{Uploads: []minio.ObjectMultipartInfo{
+			{Key: "remote/big.bin", UploadID: "active", Initiated: time.Now()},
+		}}, nil)
+
+	job := &CheckJob{Scheduler: newTestScheduler(t), Storage: s}
+	job.ResumeUploads(ctx)
+	assert.Equal(t, []string{bigFile}, drainQueue(t, job.Scheduler, enum.OpPut))
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/jorben/rsync-object-storage/log"
+)
+
+const (
+	// chunksDir 分块模式下数据块在远端路径下的目录，对象名为数据块内容的SHA256
+	chunksDir = ".chunks/"
+	// chunkListSuffix 分块上传的文件在远端以带该后缀的块列表对象表示
+	chunkListSuffix = ".chunklist"
+	// chunkGCGrace 未被块列表引用的数据块超过该时间才清理，避免删除已上传但块列表尚未写入的数据块
+	chunkGCGrace = time.Hour
+)
+
+// ChunkList 分块上传的文件，按顺序记录组成文件内容的数据块
+type ChunkList struct {
+	Size    int64          `json:"size"`
+	ModTime time.Time      `json:"mod_time"`
+	Chunks  []helper.Chunk `json:"chunks"`
+}
+
+// isChunkedPath 判断本地路径是否分块上传，只有达到大小阈值的普通文件（或按file策略上传的符号链接）才分块
+func (s *Storage) isChunkedPath(localPath string) bool {
+	if s.ChunkFileSize == 0 {
+		return false
+	}
+	if isLink, _ := helper.IsSymlink(localPath); isLink && s.SymLink != enum.SymlinkFile {
+		return false
+	}
+	fileInfo, err := os.Stat(localPath)
+	return err == nil && fileInfo.Mode().IsRegular() && fileInfo.Size() >= s.ChunkFileSize
+}
+
+// IsChunkStore 判断对象是否为数据块，数据块不对应本地路径
+func (s *Storage) IsChunkStore(objectName string) bool {
+	return strings.HasPrefix(objectName, s.GetRemoteRoot()+chunksDir)
+}
+
+// chunkDiff 比较本地文件与远端块列表，返回不一致的原因，一致时返回空字符串
+// 分块结果按文件的修改时间、大小和inode缓存，未变化的文件不重复读取
+func (s *Storage) chunkDiff(ctx context.Context, localPath, listName string) string {
+	list, err := s.loadChunkList(ctx, listName)
+	if err != nil {
+		log.Debugf("Load chunk list %s, path: %s", err.Error(), listName)
+		return enum.DiffNew
+	}
+	chunks, err := helper.GetCachedFileChunks(localPath, s.ChunkSize)
+	if err != nil {
+		log.Errorf("Chunk file err: %s", err.Error())
+		return enum.DiffChunks
+	}
+	log.Debugf("Compare %s, Local chunks: %d, Remote chunks: %d", localPath, len(chunks), len(list.Chunks))
+	if !isSameChunks(chunks, list.Chunks) {
+		return enum.DiffChunks
+	}
+	return ""
+}
+
+// chunkUpload 分块上传文件，只上传远端不存在的数据块，最多同时上传 ParallelParts 个，全部完成后写入块列表
+// 上传过程中文件发生变化时返回错误，由重试重新分块
+func (s *Storage) chunkUpload(ctx context.Context, localPath, reason string) error {
+	objectName := s.GetRemotePath(localPath)
+	listName := objectName + chunkListSuffix
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	before, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	chunker, err := helper.NewChunker(file, s.ChunkSize)
+	if err != nil {
+		return err
+	}
+	// 上一版本的数据块仍被原块列表引用，不会被清理，可以直接复用
+	known := make(map[string]bool)
+	if last, err := s.loadChunkList(ctx, listName); err == nil {
+		for _, chunk := range last.Chunks {
+			known[chunk.Hash] = true
+		}
+	}
+
+	type chunkData struct {
+		hash string
+		data []byte
+	}
+	var mu sync.Mutex
+	var uploadErr error
+	var uploaded int
+	var uploadedSize int64
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return uploadErr != nil
+	}
+	chunks := make(chan chunkData)
+	var wg sync.WaitGroup
+	for i := 0; i < max(1, s.ParallelParts); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunk := range chunks {
+				if failed() {
+					continue
+				}
+				chunkName := s.chunkName(chunk.hash)
+				size := int64(len(chunk.data))
+				if s.chunkExists(ctx, chunkName, size) {
+					continue
+				}
+				var err error
+				if !s.DryRun {
+					reader := helper.NewRateLimitedReader(ctx, bytes.NewReader(chunk.data), s.Limiter)
+					_, err = s.Backend.PutObject(ctx, chunkName, reader, size)
+				}
+				mu.Lock()
+				if err != nil {
+					if uploadErr == nil {
+						uploadErr = err
+					}
+				} else {
+					uploaded++
+					uploadedSize += size
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	list := ChunkList{Size: before.Size(), ModTime: before.ModTime()}
+	for !failed() {
+		data, err := chunker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			mu.Lock()
+			uploadErr = err
+			mu.Unlock()
+			break
+		}
+		chunk := helper.NewChunk(data)
+		list.Chunks = append(list.Chunks, chunk)
+		if known[chunk.Hash] {
+			continue
+		}
+		known[chunk.Hash] = true
+		// 分块器会复用缓冲区，交给上传协程前先复制
+		chunks <- chunkData{hash: chunk.Hash, data: bytes.Clone(data)}
+	}
+	close(chunks)
+	wg.Wait()
+	if uploadErr != nil {
+		return uploadErr
+	}
+
+	if s.DryRun {
+		log.Infof("Dry-run: would upload %s, chunks: %d/%d, size: %s, reason: %s", listName, uploaded,
+			len(list.Chunks), helper.ByteFormat(uploadedSize), reason)
+		return enum.ErrDryRun
+	}
+	if after, err := os.Stat(localPath); err != nil || !isUnchangedFile(before, after) {
+		return fmt.Errorf("file changed during upload: %s", localPath)
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	if err = s.PutObject(ctx, listName, data); err != nil {
+		return err
+	}
+	helper.SetCachedFileChunks(localPath, s.ChunkSize, before, list.Chunks)
+	log.Infof("Upload chunks %s, chunks: %d/%d, size: %s", listName, uploaded, len(list.Chunks), helper.ByteFormat(uploadedSize))
+	// 文件此前未分块上传时删除原对象
+	if _, err := s.Backend.StatObject(ctx, objectName); err == nil {
+		if err := s.Backend.RemoveObject(ctx, objectName); err != nil {
+			log.Errorf("RemoveObject err: %s, path: %s", err.Error(), objectName)
+		}
+	}
+	return nil
+}
+
+// chunkExists 判断数据块是否已存在于远端
+// 较早上传的数据块可能正在被清理，只信任上传时间在保护时间一半以内的数据块，否则重新上传以刷新上传时间
+func (s *Storage) chunkExists(ctx context.Context, chunkName string, size int64) bool {
+	objectInfo, err := s.Backend.StatObject(ctx, chunkName)
+	return err == nil && objectInfo.Size == size && time.Since(objectInfo.LastModified) < chunkGCGrace/2
+}
+
+// loadChunkList 读取远端的块列表
+func (s *Storage) loadChunkList(ctx context.Context, listName string) (ChunkList, error) {
+	reader, err := s.Backend.GetObject(ctx, listName)
+	if err != nil {
+		return ChunkList{}, err
+	}
+	defer reader.Close()
+	var list ChunkList
+	if err = json.NewDecoder(reader).Decode(&list); err != nil {
+		return ChunkList{}, fmt.Errorf("decode chunk list %s err: %s", listName, err.Error())
+	}
+	return list, nil
+}
+
+// CollectChunks 删除已不被任何块列表（包括回收站中的）引用的数据块，返回删除的数量
+// 最近上传的数据块可能尚未写入块列表，超过 chunkGCGrace 的才删除
+func (s *Storage) CollectChunks(ctx context.Context, now time.Time) (int, error) {
+	var candidates, lists []ObjectInfo
+	var someError error
+	for object := range s.ListObjects(ctx) {
+		if object.Err != nil {
+			someError = object.Err
+			continue
+		}
+		switch {
+		case s.IsChunkStore(object.Key):
+			if now.Sub(object.LastModified) >= chunkGCGrace {
+				candidates = append(candidates, object)
+			}
+		case strings.HasSuffix(object.Key, chunkListSuffix):
+			lists = append(lists, object)
+		}
+	}
+	if someError != nil {
+		// 列举不完整时可能遗漏仍被引用的数据块
+		return 0, someError
+	}
+	if len(candidates) == 0 {
+		return 0, nil
+	}
+	referenced := make(map[string]bool)
+	for _, object := range lists {
+		list, err := s.loadChunkList(ctx, object.Key)
+		if err != nil {
+			// 块列表读取失败时无法确认引用，本次不清理
+			return 0, err
+		}
+		for _, chunk := range list.Chunks {
+			referenced[chunk.Hash] = true
+		}
+	}
+
+	var removed int
+	for _, object := range candidates {
+		if referenced[strings.TrimPrefix(object.Key, s.GetRemoteRoot()+chunksDir)] {
+			continue
+		}
+		if s.DryRun {
+			log.Infof("Dry-run: would delete %s, size: %s, reason: unreferenced", object.Key, helper.ByteFormat(object.Size))
+			removed++
+			continue
+		}
+		// 删除前再次确认没有被重新上传
+		if objectInfo, err := s.Backend.StatObject(ctx, object.Key); err != nil || now.Sub(objectInfo.LastModified) < chunkGCGrace {
+			continue
+		}
+		if err := s.Backend.RemoveObject(ctx, object.Key); err != nil {
+			log.Errorf("RemoveObject err: %s, path: %s", err.Error(), object.Key)
+			someError = err
+			continue
+		}
+		log.Infof("Remove unreferenced object %s", object.Key)
+		removed++
+	}
+	return removed, someError
+}
+
+// isSameChunks 比较两个块列表的数据块是否依次一致
+func isSameChunks(a, b []helper.Chunk) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// chunkName 获取数据块的对象名
+func (s *Storage) chunkName(hash string) string {
+	return s.GetRemoteRoot() + chunksDir + hash
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeRandomFile 写入指定大小的随机内容
+func writeRandomFile(t *testing.T, path string, size int, seed int64) []byte {
+	t.Helper()
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+	return data
+}
+
+// TestChunk_FPutObject 测试大文件分块上传，修改部分内容后只上传新的数据块
+func TestChunk_FPutObject(t *testing.T) {
+	ctx := context.Background()
+	localDir := t.TempDir()
+	bigPath, smallPath := filepath.Join(localDir, "big.bin"), filepath.Join(localDir, "small.txt")
+	data := writeRandomFile(t, bigPath, 512*1024, 1)
+	assert.NoError(t, os.WriteFile(smallPath, []byte("small"), 0644))
+
+	s := newLocalStorage(t, localDir, t.TempDir(), withChunk())
+	chunkRoot := "backup/" + chunksDir
+	assert.Equal(t, enum.DiffNew, s.diff(ctx, bigPath, ""))
+	assert.NoError(t, s.FPutObject(ctx, bigPath))
+	assert.NoError(t, s.FPutObject(ctx, smallPath))
+	assert.True(t, s.IsSameV2(ctx, bigPath, ""))
+	assert.ErrorIs(t, s.FPutObject(ctx, bigPath), enum.ErrSkipTransfer)
+	chunks := objectKeys(collectObjects(t, s.ListPrefix(ctx, chunkRoot, true)))
+	assert.Greater(t, len(chunks), 16)
+	// 小文件按原方式上传
+	assert.ElementsMatch(t, []string{"backup/" + chunksDir, "backup/big.bin.chunklist", "backup/small.txt"},
+		objectKeys(collectObjects(t, s.ListPrefix(ctx, "backup/", false))))
+
+	// 修改中间的内容后只有附近的数据块不一致
+	copy(data[300000:], "modified")
+	assert.NoError(t, os.WriteFile(bigPath, data, 0644))
+	assert.Equal(t, enum.DiffChunks, s.diff(ctx, bigPath, ""))
+	s.DryRun = true
+	assert.ErrorIs(t, s.FPutObject(ctx, bigPath), enum.ErrDryRun)
+	assert.Len(t, collectObjects(t, s.ListPrefix(ctx, chunkRoot, true)), len(chunks))
+	s.DryRun = false
+	assert.NoError(t, s.FPutObject(ctx, bigPath))
+	assert.True(t, s.IsSameV2(ctx, bigPath, ""))
+	assert.LessOrEqual(t, len(collectObjects(t, s.ListPrefix(ctx, chunkRoot, true))), len(chunks)+3)
+
+	// 缩小到阈值以下后按原方式上传，删除块列表
+	assert.NoError(t, os.WriteFile(bigPath, data[:1024], 0644))
+	assert.Equal(t, enum.DiffNew, s.diff(ctx, bigPath, ""))
+	assert.NoError(t, s.FPutObject(ctx, bigPath))
+	assert.ElementsMatch(t, []string{"backup/" + chunksDir, "backup/big.bin", "backup/small.txt"},
+		objectKeys(collectObjects(t, s.ListPrefix(ctx, "backup/", false))))
+}
+
+// TestChunk_FCopyObject 测试重命名后只复制块列表，删除时删除块列表
+func TestChunk_FCopyObject(t *testing.T) {
+	ctx := context.Background()
+	localDir := t.TempDir()
+	oldPath, newPath := filepath.Join(localDir, "old.bin"), filepath.Join(localDir, "new.bin")
+	writeRandomFile(t, oldPath, 128*1024, 2)
+
+	s := newLocalStorage(t, localDir, t.TempDir(), withChunk())
+	assert.NoError(t, s.FPutObject(ctx, oldPath))
+	chunks := collectObjects(t, s.ListPrefix(ctx, "backup/"+chunksDir, true))
+	assert.NoError(t, os.Rename(oldPath, newPath))
+
+	copied, err := s.FCopyObject(ctx, oldPath, newPath)
+	assert.NoError(t, err)
+	assert.True(t, copied)
+	assert.True(t, s.IsSameV2(ctx, newPath, ""))
+	assert.NoError(t, s.RemoveObjects(ctx, oldPath))
+	assert.ElementsMatch(t, []string{"backup/" + chunksDir, "backup/new.bin.chunklist"},
+		objectKeys(collectObjects(t, s.ListPrefix(ctx, "backup/", false))))
+	assert.Len(t, collectObjects(t, s.ListPrefix(ctx, "backup/"+chunksDir, true)), len(chunks))
+}
+
+// TestChunk_CollectChunks 测试只删除不被块列表引用且超过保护时间的数据块
+func TestChunk_CollectChunks(t *testing.T) {
+	ctx := context.Background()
+	localDir := t.TempDir()
+	aPath, bPath := filepath.Join(localDir, "a.bin"), filepath.Join(localDir, "b.bin")
+	writeRandomFile(t, aPath, 128*1024, 3)
+	writeRandomFile(t, bPath, 128*1024, 4)
+
+	s := newLocalStorage(t, localDir, t.TempDir(), withChunk())
+	assert.NoError(t, s.FPutObject(ctx, aPath))
+	assert.NoError(t, s.FPutObject(ctx, bPath))
+	aChunks, err := helper.FileChunks(aPath, s.ChunkSize)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Remove(bPath))
+	assert.NoError(t, s.RemoveObjects(ctx, bPath))
+
+	// 保护时间内不删除
+	removed, err := s.CollectChunks(ctx, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+
+	removed, err = s.CollectChunks(ctx, time.Now().Add(chunkGCGrace+time.Minute))
+	assert.NoError(t, err)
+	assert.Greater(t, removed, 0)
+	var expected []string
+	for _, chunk := range aChunks {
+		expected = append(expected, s.chunkName(chunk.Hash))
+	}
+	assert.ElementsMatch(t, expected, objectKeys(collectObjects(t, s.ListPrefix(ctx, "backup/"+chunksDir, true))))
+	assert.True(t, s.IsSameV2(ctx, aPath, ""))
+}
+
+// TestRestore_Run_Chunk 测试按块列表恢复文件
+func TestRestore_Run_Chunk(t *testing.T) {
+	ctx := context.Background()
+	localDir, remoteDir := t.TempDir(), t.TempDir()
+	path := filepath.Join(localDir, "docs", "big.bin")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	data := writeRandomFile(t, path, 256*1024, 5)
+	modTime := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	assert.NoError(t, os.Chtimes(path, modTime, modTime))
+	assert.NoError(t, newLocalStorage(t, localDir, remoteDir, withChunk()).FPutObject(ctx, path))
+
+	restoreDir := t.TempDir()
+	target := newLocalStorage(t, restoreDir, remoteDir, withChunk())
+	r := &Restore{
+		LocalPrefix:   restoreDir,
+		SymLink:       enum.SymlinkAddr,
+		IgnoreMatcher: helper.NewIgnoreMatcher(nil),
+		Storage:       target,
+	}
+	assert.NoError(t, r.Run(ctx))
+
+	restored := filepath.Join(restoreDir, "docs", "big.bin")
+	content, err := os.ReadFile(restored)
+	assert.NoError(t, err)
+	assert.Equal(t, data, content)
+	fileInfo, err := os.Stat(restored)
+	assert.NoError(t, err)
+	assert.True(t, modTime.Equal(fileInfo.ModTime()))
+	assert.True(t, target.IsSameV2(ctx, restored, ""))
+	assert.NoFileExists(t, restored+downloadPartSuffix)
+
+	// 数据块损坏时恢复失败，不替换本地文件
+	assert.NoError(t, os.WriteFile(restored, []byte("local"), 0644))
+	chunks := collectObjects(t, target.ListPrefix(ctx, "backup/"+chunksDir, true))
+	assert.NoError(t, target.PutObject(ctx, chunks[0].Key, []byte("corrupted")))
+	assert.Error(t, r.Run(ctx))
+	content, err = os.ReadFile(restored)
+	assert.NoError(t, err)
+	assert.Equal(t, "local", string(content))
+}
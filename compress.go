@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/jorben/rsync-object-storage/log"
+)
+
+const (
+	// metaCompression 对象用户元数据，记录压缩算法
+	metaCompression = "ros-compression"
+	// metaOriginalSize 对象用户元数据，记录压缩、加密前的原始大小
+	metaOriginalSize = "ros-original-size"
+	// metaOriginalMd5 对象用户元数据，记录压缩、加密前原始内容的MD5，用于比较本地文件是否变化
+	metaOriginalMd5 = "ros-original-md5"
+)
+
+// Compression 上传前按文件名规则压缩
+type Compression struct {
+	Algorithm string
+	Patterns  *helper.IgnoreMatcher // 只压缩匹配的文件，为空时压缩全部文件
+	Skip      *helper.IgnoreMatcher // 不压缩的文件
+}
+
+// NewCompression 创建压缩规则实例
+func NewCompression(algorithm string, patterns, skip []string) *Compression {
+	return &Compression{
+		Algorithm: algorithm,
+		Patterns:  helper.NewIgnoreMatcher(patterns),
+		Skip:      helper.NewIgnoreMatcher(skip),
+	}
+}
+
+// Match 判断文件是否需要压缩
+func (c *Compression) Match(path string) bool {
+	if c == nil || c.Skip.Match(path) {
+		return false
+	}
+	return c.Patterns.IsEmpty() || c.Patterns.Match(path)
+}
+
+// shouldEncode 判断本地路径是否压缩或加密后上传，只处理匹配压缩规则或启用了加密的普通文件
+func (s *Storage) shouldEncode(localPath string) bool {
+	if s.Encrypt == nil && !s.Compress.Match(localPath) {
+		return false
+	}
+	fileInfo, err := os.Stat(localPath)
+	return err == nil && fileInfo.Mode().IsRegular()
+}
+
+// encodeUpload 压缩、加密到 sync.temp_dir 下的临时文件后上传，算法和原始内容的大小、MD5写入对象的用户元数据
+// 压缩后没有变小的文件不压缩，未启用加密时按原内容上传
+// 编码后达到分片大小的文件使用可断点续传的分片上传，上传完成前保留临时文件，因此需要与编码后大小相当的临时空间
+func (s *Storage) encodeUpload(ctx context.Context, objectName, localPath string) (ObjectInfo, error) {
+	backend, ok := s.Backend.(MetadataBackend)
+	if !ok {
+		return ObjectInfo{}, fmt.Errorf("remote %s does not support object metadata", s.Name)
+	}
+	sourceInfo, err := os.Stat(localPath)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	// 源文件未变化时继续上次未完成的分片上传，不重新编码
+	if encoded := s.resumableEncoded(objectName, localPath, sourceInfo); encoded != nil {
+		return s.encodedMultipartUpload(ctx, objectName, localPath, sourceInfo, encoded)
+	}
+
+	compress := s.Compress.Match(localPath)
+	encoded, original, err := s.encodeFile(localPath, compress)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if compress && encoded.Size >= original.Size() {
+		log.Debugf("Compression does not reduce size, upload original %s", localPath)
+		_ = os.Remove(encoded.Path)
+		if s.Encrypt == nil {
+			return s.streamUpload(ctx, objectName, localPath)
+		}
+		if encoded, original, err = s.encodeFile(localPath, false); err != nil {
+			return ObjectInfo{}, err
+		}
+	}
+	log.Debugf("Encoded %s, compression: %s, encryption: %s, size: %s -> %s", localPath, encoded.Metadata[metaCompression],
+		encoded.Metadata[metaEncryption], helper.ByteFormat(original.Size()), helper.ByteFormat(encoded.Size))
+	if s.isResumable(encoded.Size) {
+		return s.encodedMultipartUpload(ctx, objectName, localPath, original, encoded)
+	}
+
+	defer os.Remove(encoded.Path)
+	tmp, err := os.Open(encoded.Path)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer tmp.Close()
+	return backend.PutObjectWithMetadata(ctx, objectName, helper.NewRateLimitedReader(ctx, tmp, s.Limiter),
+		encoded.Size, encoded.Metadata)
+}
+
+// encodedMultipartUpload 分片上传编码后的临时文件，上传完成后删除临时文件
+// 上传失败时上传记录仍引用临时文件，保留以便下次只上传剩余的分片
+func (s *Storage) encodedMultipartUpload(ctx context.Context, objectName, localPath string, sourceInfo os.FileInfo,
+	encoded *encodedFile) (ObjectInfo, error) {
+	tmp, err := os.Open(encoded.Path)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	uploadInfo, err := s.multipartUpload(ctx, objectName, localPath, sourceInfo, tmp, encoded)
+	_ = tmp.Close()
+	if upload, ok := s.Uploads.Get(objectName); err == nil || !ok || upload.Encoded != encoded.Path {
+		_ = os.Remove(encoded.Path)
+	}
+	return uploadInfo, err
+}
+
+// encodeFile 把本地文件压缩、加密写入 sync.temp_dir 下的临时文件，返回临时文件和编码前的文件信息
+// 编码过程中文件发生变化时返回错误，由重试重新编码
+func (s *Storage) encodeFile(localPath string, compress bool) (*encodedFile, os.FileInfo, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	before, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	randomString, err := helper.RandomString(32)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmp, err := os.Create(s.tempPath("." + randomString))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tmp.Close()
+	fail := func(err error) (*encodedFile, os.FileInfo, error) {
+		_ = os.Remove(tmp.Name())
+		return nil, nil, err
+	}
+
+	// 先压缩后加密，写入器按相反的顺序创建
+	metadata := make(map[string]string)
+	var writer io.WriteCloser = tmp
+	var writers []io.WriteCloser
+	if s.Encrypt != nil {
+		dataKey, err := s.Encrypt.newDataKey(metadata)
+		if err != nil {
+			return fail(err)
+		}
+		if writer, err = helper.NewEncryptWriter(dataKey, writer); err != nil {
+			return fail(err)
+		}
+		writers = append(writers, writer)
+	}
+	if compress {
+		if writer, err = helper.NewCompressWriter(s.Compress.Algorithm, writer); err != nil {
+			return fail(err)
+		}
+		writers = append(writers, writer)
+		metadata[metaCompression] = s.Compress.Algorithm
+	}
+	hash := md5.New()
+	if _, err = io.Copy(writer, io.TeeReader(file, hash)); err != nil {
+		return fail(err)
+	}
+	for i := len(writers) - 1; i >= 0; i-- {
+		if err = writers[i].Close(); err != nil {
+			return fail(err)
+		}
+	}
+	if after, err := os.Stat(localPath); err != nil || !isUnchangedFile(before, after) {
+		return fail(fmt.Errorf("file changed during encoding: %s", localPath))
+	}
+	encoded, err := os.Stat(tmp.Name())
+	if err != nil {
+		return fail(err)
+	}
+	metadata[metaOriginalSize] = strconv.FormatInt(before.Size(), 10)
+	metadata[metaOriginalMd5] = hex.EncodeToString(hash.Sum(nil))
+	return &encodedFile{Path: tmp.Name(), Size: encoded.Size(), Metadata: metadata}, before, nil
+}
+
+// originalDiff 按元数据记录的原始大小和MD5比较压缩或加密上传的对象，返回不一致的原因
+func originalDiff(localPath string, object ObjectInfo) string {
+	fileInfo, err := os.Stat(localPath)
+	if err != nil {
+		log.Errorf("Stat file err: %s", err.Error())
+		return enum.DiffOriginalMd5
+	}
+	if size, err := strconv.ParseInt(object.Metadata[metaOriginalSize], 10, 64); err != nil || size != fileInfo.Size() {
+		return enum.DiffOriginalMd5
+	}
+	localMd5, err := helper.GetCachedFileMd5(localPath)
+	if err != nil {
+		log.Errorf("MD5 error: %s", err.Error())
+		return enum.DiffOriginalMd5
+	}
+	log.Debugf("Compare %s, Local Md5: %s, Original Md5: %s", localPath, localMd5, object.Metadata[metaOriginalMd5])
+	if !strings.EqualFold(localMd5, object.Metadata[metaOriginalMd5]) {
+		return enum.DiffOriginalMd5
+	}
+	return ""
+}
+
+// downloadObject 下载对象到本地路径，自动创建上级目录
+// 对象的用户元数据记录了加密或压缩算法时解密、解压，并校验原始内容的MD5
+func (s *Storage) downloadObject(ctx context.Context, object ObjectInfo, localPath string) error {
+	algorithm := object.Metadata[metaCompression]
+	if algorithm == "" && object.Metadata[metaEncryption] == "" {
+		return s.FGetObject(ctx, object.Key, localPath)
+	}
+	var dataKey []byte
+	if object.Metadata[metaEncryption] != "" {
+		var err error
+		if dataKey, err = s.Encrypt.dataKey(object.Metadata); err != nil {
+			return fmt.Errorf("decrypt %s err: %s", object.Key, err.Error())
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	reader, err := s.Backend.GetObject(ctx, object.Key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	var content io.Reader = reader
+	if dataKey != nil {
+		if content, err = helper.NewDecryptReader(dataKey, content); err != nil {
+			return err
+		}
+	}
+	if algorithm != "" {
+		decompressor, err := helper.NewDecompressReader(algorithm, content)
+		if err != nil {
+			return err
+		}
+		defer decompressor.Close()
+		content = decompressor
+	}
+	// 校验通过后再替换本地文件
+	tmp := localPath + downloadPartSuffix
+	hash := md5.New()
+	if err = saveToFile(ctx, io.TeeReader(content, hash), tmp); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(actual, object.Metadata[metaOriginalMd5]) {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("original MD5 mismatch, expected %s, got %s", object.Metadata[metaOriginalMd5], actual)
+	}
+	return os.Rename(tmp, localPath)
+}
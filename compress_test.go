@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/jorben/rsync-object-storage/mocks"
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// TestCompression_Match 测试压缩规则的匹配
+func TestCompression_Match(t *testing.T) {
+	c := NewCompression(enum.CompressZstd, []string{"*.log"}, []string{"*.jpg", "archive"})
+	assert.True(t, c.Match("/data/app.log"))
+	assert.False(t, c.Match("/data/app.txt"))
+	assert.False(t, c.Match("/data/archive/app.log"))
+
+	c = NewCompression(enum.CompressGzip, nil, []string{"*.jpg", "*.zip"})
+	assert.True(t, c.Match("/data/app.txt"))
+	assert.False(t, c.Match("/data/photo.jpg"))
+	assert.False(t, c.Match("/data/backup.zip"))
+	assert.False(t, (*Compression)(nil).Match("/data/app.log"))
+}
+
+// TestCompress_FPutObject 测试匹配的文件压缩上传，按元数据中的原始MD5比较
+func TestCompress_FPutObject(t *testing.T) {
+	ctx := context.Background()
+	localDir, remoteDir := t.TempDir(), t.TempDir()
+	logPath := filepath.Join(localDir, "app.log")
+	data := bytes.Repeat([]byte("2026-10-16 08:00:00 INFO request handled\n"), 1000)
+	assert.NoError(t, os.WriteFile(logPath, data, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "skip.log"), data, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "a.txt"), data, 0644))
+	writeRandomFile(t, filepath.Join(localDir, "random.log"), 4096, 1)
+
+	s := newLocalStorage(t, localDir, remoteDir, withCompress())
+	for _, name := range []string{"app.log", "skip.log", "a.txt", "random.log"} {
+		path := filepath.Join(localDir, name)
+		assert.NoError(t, s.FPutObject(ctx, path))
+		assert.True(t, s.IsSameV2(ctx, path, ""), name)
+		assert.ErrorIs(t, s.FPutObject(ctx, path), enum.ErrSkipTransfer)
+	}
+
+	objectInfo, err := s.Backend.StatObject(ctx, "backup/app.log")
+	assert.NoError(t, err)
+	assert.Less(t, objectInfo.Size, int64(len(data)/10))
+	assert.Equal(t, map[string]string{
+		metaCompression:  enum.CompressZstd,
+		metaOriginalSize: "41000",
+		metaOriginalMd5:  helper.StringMd5(string(data)),
+	}, objectInfo.Metadata)
+	// 不匹配规则或压缩后没有变小的文件按原内容上传
+	for _, name := range []string{"skip.log", "a.txt", "random.log"} {
+		objectInfo, err = s.Backend.StatObject(ctx, "backup/"+name)
+		assert.NoError(t, err)
+		assert.Nil(t, objectInfo.Metadata, name)
+	}
+
+	// 修改后按原始MD5判断不一致
+	data[0] = '3'
+	assert.NoError(t, os.WriteFile(logPath, data, 0644))
+	assert.Equal(t, enum.DiffOriginalMd5, s.diff(ctx, logPath, ""))
+	assert.NoError(t, os.WriteFile(logPath, data[:100], 0644))
+	assert.Equal(t, enum.DiffOriginalMd5, s.diff(ctx, logPath, ""))
+
+	// 重命名后服务端复制保留元数据
+	assert.NoError(t, os.WriteFile(logPath, data, 0644))
+	assert.NoError(t, s.FPutObject(ctx, logPath))
+	assert.NoError(t, os.Rename(logPath, filepath.Join(localDir, "renamed.log")))
+	copied, err := s.FCopyObject(ctx, logPath, filepath.Join(localDir, "renamed.log"))
+	assert.NoError(t, err)
+	assert.True(t, copied)
+	assert.True(t, s.IsSameV2(ctx, filepath.Join(localDir, "renamed.log"), ""))
+}
+
+// TestRestore_Run_Compress 测试恢复时解压压缩上传的对象
+func TestRestore_Run_Compress(t *testing.T) {
+	ctx := context.Background()
+	localDir, remoteDir := t.TempDir(), t.TempDir()
+	data := bytes.Repeat([]byte("id,name,value\n1,a,100\n"), 1000)
+	assert.NoError(t, os.MkdirAll(filepath.Join(localDir, "sub"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "sub", "data.csv"), data, 0644))
+	s := newLocalStorage(t, localDir, remoteDir, withCompress())
+	s.Compress.Algorithm = enum.CompressGzip
+	assert.NoError(t, s.FPutObject(ctx, filepath.Join(localDir, "sub", "data.csv")))
+
+	// 恢复时不需要启用压缩
+	restoreDir := t.TempDir()
+	target := newLocalStorage(t, restoreDir, remoteDir)
+	r := &Restore{
+		LocalPrefix:   restoreDir,
+		SymLink:       enum.SymlinkAddr,
+		IgnoreMatcher: helper.NewIgnoreMatcher(nil),
+		Storage:       target,
+	}
+	assert.NoError(t, r.Run(ctx))
+	restored := filepath.Join(restoreDir, "sub", "data.csv")
+	content, err := os.ReadFile(restored)
+	assert.NoError(t, err)
+	assert.Equal(t, data, content)
+	assert.True(t, target.IsSameV2(ctx, restored, ""))
+	assert.NoFileExists(t, restored+downloadPartSuffix)
+
+	// 本地一致时跳过，原始MD5不一致时不替换本地文件
+	assert.NoError(t, r.Run(ctx))
+	assert.NoError(t, os.WriteFile(restored, []byte("local"), 0644))
+	backend := target.Backend.(*LocalBackend)
+	_, err = backend.PutObjectWithMetadata(ctx, "backup/sub/data.csv", bytes.NewReader(nil), 0, map[string]string{
+		metaCompression:  enum.CompressGzip,
+		metaOriginalSize: "5",
+		metaOriginalMd5:  helper.StringMd5("other"),
+	})
+	assert.NoError(t, err)
+	assert.Error(t, r.Run(ctx))
+	content, err = os.ReadFile(restored)
+	assert.NoError(t, err)
+	assert.Equal(t, "local", string(content))
+}
+
+// TestS3Backend_Metadata 测试S3后端写入用户元数据，读取时key统一为小写
+func TestS3Backend_Metadata(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(mocks.MockObjectStorageClient)
+	metadata := map[string]string{metaCompression: enum.CompressZstd}
+	mockClient.On("PutObject", ctx, "test-bucket", "remote/a.log", mock.Anything, int64(10),
+		minio.PutObjectOptions{UserMetadata: metadata}).Return(minio.UploadInfo{Size: 10, ETag: "abc"}, nil)
+	mockClient.On("StatObject", ctx, "test-bucket", "remote/a.log", minio.StatObjectOptions{}).
+		Return(minio.ObjectInfo{Key: "remote/a.log", UserMetadata: minio.StringMap{"Ros-Compression": enum.CompressZstd}}, nil)
+
+	b := NewS3Backend(mockClient, "test-bucket")
+	_, err := b.PutObjectWithMetadata(ctx, "remote/a.log", bytes.NewReader(nil), 10, metadata)
+	assert.NoError(t, err)
+	objectInfo, err := b.StatObject(ctx, "remote/a.log")
+	assert.NoError(t, err)
+	assert.Equal(t, metadata, objectInfo.Metadata)
+	mockClient.AssertExpectations(t)
+}
@@ -7,39 +7,107 @@ import (
 	"github.com/jorben/rsync-object-storage/helper"
 	"github.com/jorben/rsync-object-storage/log"
 	conf "github.com/ldigit/config"
+	"math/bits"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-// SyncConfig 同步配置
+// RemoteConfig 远端对象存储配置
+type RemoteConfig struct {
+	Ref       string `yaml:"ref,omitempty"` // 引用 remotes 中定义的远端，未配置的字段使用被引用远端的值
+	Type      string `yaml:"type"`          // 存储后端类型(s3|local|webdav)，默认为s3，local时bucket为本地或挂载目录，webdav时bucket为endpoint下的目录
+	Endpoint  string `yaml:"endpoint"`
+	UseSSL    bool   `yaml:"use_ssl"`
+	SecretId  string `yaml:"secret_id"`
+	SecretKey string `yaml:"secret_key"`
+	Bucket    string `yaml:"bucket"`
+	Region    string `yaml:"region"`
+	Path      string `yaml:"path"`
+}
+
+// TransferConfig 传输配置，对全部同步任务生效
+type TransferConfig struct {
+	Workers        int   `yaml:"workers"`         // 并发传输的worker总数，多个远端平分，默认8
+	PartSize       int64 `yaml:"part_size"`       // 分片上传的分片大小(MiB)，为0时按文件大小自动计算（最小16MiB）
+	ParallelParts  int   `yaml:"parallel_parts"`  // 单个文件同时上传的分片数，默认4
+	BandwidthLimit int64 `yaml:"bandwidth_limit"` // 全局上传带宽上限(bytes/s)，为0时不限制
+	Retry          struct {
+		Attempts  int `yaml:"attempts"`   // 每个路径的最大尝试次数（含首次），默认5，为1时不重试
+		BaseDelay int `yaml:"base_delay"` // 首次重试前的等待秒数，之后每次翻倍，默认1
+		MaxDelay  int `yaml:"max_delay"`  // 重试等待秒数的上限，默认60
+	} `yaml:"retry"`
+	Schedule struct {
+		SmallFile    int64 `yaml:"small_file"`    // 小文件阈值(MiB)，小于该大小的文件与实时变更一起先于对账积压的任务，默认8
+		LargeFile    int64 `yaml:"large_file"`    // 大文件阈值(MiB)，不小于该大小的文件只由部分worker上传，默认1024
+		LargeWorkers int   `yaml:"large_workers"` // 同时上传大文件的worker总数，多个远端平分，默认为workers的1/4
+	} `yaml:"schedule"`
+}
+
+// SyncConfig 同步配置，未配置 jobs 时顶层的 local/remote/sync 即为唯一的同步任务
 type SyncConfig struct {
+	Name  string `yaml:"name,omitempty"` // 同步任务名称，仅在 jobs 中使用
 	Local struct {
 		Path string `yaml:"path"`
 	} `yaml:"local"`
-	Remote struct {
-		Endpoint  string `yaml:"endpoint"`
-		UseSSL    bool   `yaml:"use_ssl"`
-		SecretId  string `yaml:"secret_id"`
-		SecretKey string `yaml:"secret_key"`
-		Bucket    string `yaml:"bucket"`
-		Region    string `yaml:"region"`
-		Path      string `yaml:"path"`
-	} `yaml:"remote"`
-	Sync struct {
+	Remote   RemoteConfig   `yaml:"remote"`
+	Replicas []RemoteConfig `yaml:"replicas,omitempty"` // 同时复制到的其他远端，每个远端独立同步
+	Sync     struct {
 		RealTime struct {
-			Enable   bool `yaml:"enable"`
-			HotDelay int  `yaml:"hot_delay"`
+			Enable      bool `yaml:"enable"`
+			HotDelay    int  `yaml:"hot_delay"`
+			DeleteDelay int  `yaml:"delete_delay"`
 		} `yaml:"real_time"`
 		CheckJob struct {
-			Enable   bool   `yaml:"enable"`
-			Interval int    `yaml:"interval"`
-			StartAt  string `yaml:"start_at"`
+			Enable        bool   `yaml:"enable"`
+			Interval      int    `yaml:"interval"`
+			StartAt       string `yaml:"start_at"`
+			DeleteOrphans bool   `yaml:"delete_orphans"`
 		} `yaml:"check_job"`
-		Symlink string   `yaml:"symlink"`
-		Ignore  []string `yaml:"ignore,omitempty"`
+		Bidirectional struct {
+			Enable   bool `yaml:"enable"`
+			Interval int  `yaml:"interval"`
+		} `yaml:"bidirectional"`
+		Snapshot struct {
+			Enable bool `yaml:"enable"`
+		} `yaml:"snapshot"`
+		CAS struct {
+			Enable bool `yaml:"enable"` // 内容寻址存储，相同内容只存储一次，路径记录在远端的清单中
+		} `yaml:"cas"`
+		Chunk struct {
+			Enable    bool  `yaml:"enable"`     // 大文件按内容分块上传，只上传新增的块
+			FileSize  int64 `yaml:"file_size"`  // 分块上传的文件大小阈值(MiB)，默认64
+			ChunkSize int   `yaml:"chunk_size"` // 平均块大小(KiB)，按2的幂向下取整，默认1024
+		} `yaml:"chunk"`
+		Compress struct {
+			Enable    bool     `yaml:"enable"`             // 上传前压缩，原始大小和MD5记录在对象的用户元数据中
+			Algorithm string   `yaml:"algorithm"`          // 压缩算法(zstd|gzip)，默认zstd
+			Patterns  []string `yaml:"patterns,omitempty"` // 只压缩匹配的文件，规则与ignore相同，为空时压缩全部文件
+			Skip      []string `yaml:"skip,omitempty"`     // 不压缩的文件，如已压缩的 *.jpg、*.zip
+		} `yaml:"compress"`
+		Encrypt struct {
+			Enable      bool     `yaml:"enable"`                  // 上传前使用AES-256-GCM加密，每个对象使用独立的数据密钥
+			KeyFile     string   `yaml:"key_file"`                // 主密钥文件，内容为32字节密钥的hex或base64编码
+			KeyEnv      string   `yaml:"key_env"`                 // 未配置key_file时从该环境变量读取主密钥，默认ROS_MASTER_KEY
+			OldKeyFiles []string `yaml:"old_key_files,omitempty"` // 轮换前的主密钥文件，用于解密和重新包装数据密钥
+		} `yaml:"encrypt"`
+		Trash struct {
+			Enable    bool `yaml:"enable"`
+			Retention int  `yaml:"retention"`
+		} `yaml:"trash"`
+		Symlink       string   `yaml:"symlink"`
+		Upload        string   `yaml:"upload"`   // 上传方式(stream|copy)，默认stream
+		TempDir       string   `yaml:"temp_dir"` // 上传前临时文件的目录，建议与local.path在同一文件系统以便使用reflink，默认为工作目录
+		Ignore        []string `yaml:"ignore,omitempty"`
+		PriorityPaths []string `yaml:"priority_paths,omitempty"` // 优先同步的路径前缀，相对路径基于local.path
+		Checksum      bool     `yaml:"checksum"`
+		DataDir       string   `yaml:"data_dir"`
 	} `yaml:"sync"`
-	Log []log.OutputConfig `yaml:"log"`
+	Jobs     []*SyncConfig           `yaml:"jobs,omitempty"`
+	Remotes  map[string]RemoteConfig `yaml:"remotes,omitempty"`
+	Transfer TransferConfig          `yaml:"transfer"`
+	DryRun   bool                    `yaml:"dry_run"`
+	Log      []log.OutputConfig      `yaml:"log"`
 }
 
 // GetConfig 获取解析好的配置
@@ -48,75 +116,547 @@ func GetConfig(path string) (*SyncConfig, error) {
 	if raw != nil {
 		return raw.(*SyncConfig), nil
 	}
-	cfg := loadConfig(path)
-	if cfg == nil {
-		return nil, errors.New("configuration is empty, please check the config file path")
+	return LoadConfig(path, nil)
+}
+
+// GetJobs 获取同步任务列表
+func (c *SyncConfig) GetJobs() []*SyncConfig {
+	if len(c.Jobs) == 0 {
+		return []*SyncConfig{c}
 	}
-	return cfg, nil
+	return c.Jobs
+}
+
+// GetDestinations 获取同步任务的全部远端，第一个为主远端，其余为 replicas，每个远端以独立的任务配置返回
+func (c *SyncConfig) GetDestinations() []*SyncConfig {
+	destinations := []*SyncConfig{c}
+	for _, replica := range c.Replicas {
+		destination := *c
+		destination.Remote = replica
+		destination.Replicas = nil
+		destinations = append(destinations, &destination)
+	}
+	return destinations
+}
+
+// StatePath 获取同步任务的本地状态文件路径，多个任务时按任务名称区分目录
+func (c *SyncConfig) StatePath() string {
+	if c.Name == "" {
+		return filepath.Join(c.Sync.DataDir, "state.json")
+	}
+	return filepath.Join(c.Sync.DataDir, c.Name, "state.json")
+}
+
+// UploadsPath 获取远端的分片上传记录文件路径，每个远端独立记录
+func (c *SyncConfig) UploadsPath() string {
+	name := helper.StringMd5(c.Remote.GetName())[:16] + ".json"
+	if c.Name == "" {
+		return filepath.Join(c.Sync.DataDir, "uploads", name)
+	}
+	return filepath.Join(c.Sync.DataDir, c.Name, "uploads", name)
+}
+
+// QueuePath 获取同步任务的持久化变更队列文件路径，多个任务时按任务名称区分目录
+func (c *SyncConfig) QueuePath() string {
+	if c.Name == "" {
+		return filepath.Join(c.Sync.DataDir, "queue.db")
+	}
+	return filepath.Join(c.Sync.DataDir, c.Name, "queue.db")
+}
+
+// DeadLetterPath 获取同步任务的死信列表文件路径，多个任务时按任务名称区分目录
+func (c *SyncConfig) DeadLetterPath() string {
+	if c.Name == "" {
+		return filepath.Join(c.Sync.DataDir, "deadletter.json")
+	}
+	return filepath.Join(c.Sync.DataDir, c.Name, "deadletter.json")
 }
 
 // GetString 格式化配置成字符串
 func (c *SyncConfig) GetString() string {
 	s := fmt.Sprintln("****************** ROS *******************")
-	s += fmt.Sprintln("Local: -----------------------------------")
+	for _, job := range c.GetJobs() {
+		if job.Name != "" {
+			s += fmt.Sprintf("Job: %s ---------------------------------\n", job.Name)
+		}
+		s += job.getJobString()
+	}
+	s += fmt.Sprintf("Workers:\t| %d\n", c.Transfer.Workers)
+	if c.Transfer.PartSize > 0 {
+		s += fmt.Sprintf("Part-size:\t| %d MiB\n", c.Transfer.PartSize)
+	} else {
+		s += "Part-size:\t| auto\n"
+	}
+	s += fmt.Sprintf("Parallel-parts:\t| %d\n", c.Transfer.ParallelParts)
+	if c.Transfer.BandwidthLimit > 0 {
+		s += fmt.Sprintf("Bandwidth:\t| %s/s\n", helper.ByteFormat(c.Transfer.BandwidthLimit))
+	} else {
+		s += "Bandwidth:\t| unlimited\n"
+	}
+	s += fmt.Sprintf("Retry:\t\t| %d attempts, %ds-%ds backoff\n", c.Transfer.Retry.Attempts,
+		c.Transfer.Retry.BaseDelay, c.Transfer.Retry.MaxDelay)
+	s += fmt.Sprintf("Schedule:\t| small < %d MiB, large >= %d MiB on %d worker(s)\n", c.Transfer.Schedule.SmallFile,
+		c.Transfer.Schedule.LargeFile, c.Transfer.Schedule.LargeWorkers)
+	s += fmt.Sprintf("Dry-run:\t| %t\n", c.DryRun)
+	s += "******************************************"
+	return s
+}
+
+// getJobString 格式化单个同步任务的配置
+func (c *SyncConfig) getJobString() string {
+	s := fmt.Sprintln("Local: -----------------------------------")
 	s += fmt.Sprintf("  Path:\t\t| %s\n", c.Local.Path)
 	s += fmt.Sprintln("Remote: ----------------------------------")
+	s += fmt.Sprintf("  Type:\t\t| %s\n", c.Remote.Type)
 	s += fmt.Sprintf("  Endpoint:\t| %s\n", c.Remote.Endpoint)
 	s += fmt.Sprintf("  SecretId:\t| %s\n", helper.HideSecret(c.Remote.SecretId, 12))
 	s += fmt.Sprintf("  SecretKey:\t| %s\n", helper.HideSecret(c.Remote.SecretKey, 12))
 	s += fmt.Sprintf("  Bucket:\t| %s\n", c.Remote.Bucket)
 	s += fmt.Sprintf("  Region:\t| %s\n", c.Remote.Region)
 	s += fmt.Sprintf("  Path:\t\t| %s\n", c.Remote.Path)
+	for _, replica := range c.Replicas {
+		s += fmt.Sprintln("Replica: ---------------------------------")
+		s += fmt.Sprintf("  Type:\t\t| %s\n", replica.Type)
+		s += fmt.Sprintf("  Endpoint:\t| %s\n", replica.Endpoint)
+		s += fmt.Sprintf("  SecretId:\t| %s\n", helper.HideSecret(replica.SecretId, 12))
+		s += fmt.Sprintf("  SecretKey:\t| %s\n", helper.HideSecret(replica.SecretKey, 12))
+		s += fmt.Sprintf("  Bucket:\t| %s\n", replica.Bucket)
+		s += fmt.Sprintf("  Region:\t| %s\n", replica.Region)
+		s += fmt.Sprintf("  Path:\t\t| %s\n", replica.Path)
+	}
 	s += fmt.Sprintln("Sync: -----------------------------------")
 	s += fmt.Sprintln("  Real-time:")
 	s += fmt.Sprintf("    Enable:\t| %t\n", c.Sync.RealTime.Enable)
 	s += fmt.Sprintf("    HotDelay:\t| %d minute\n", c.Sync.RealTime.HotDelay)
+	s += fmt.Sprintf("    DeleteDelay:\t| %d second\n", c.Sync.RealTime.DeleteDelay)
 	s += fmt.Sprintln("  Check-job:")
 	s += fmt.Sprintf("    Enable:\t| %t\n", c.Sync.CheckJob.Enable)
 	s += fmt.Sprintf("    Interval:\t| %d hour\n", c.Sync.CheckJob.Interval)
 	s += fmt.Sprintf("    Start-at:\t| %s\n", c.Sync.CheckJob.StartAt)
+	s += fmt.Sprintf("    Del-orphan:\t| %t\n", c.Sync.CheckJob.DeleteOrphans)
+	s += fmt.Sprintln("  Bidirectional:")
+	s += fmt.Sprintf("    Enable:\t| %t\n", c.Sync.Bidirectional.Enable)
+	s += fmt.Sprintf("    Interval:\t| %d second\n", c.Sync.Bidirectional.Interval)
+	s += fmt.Sprintf("  Snapshot:\t| %t\n", c.Sync.Snapshot.Enable)
+	s += fmt.Sprintf("  CAS:\t\t| %t\n", c.Sync.CAS.Enable)
+	if c.Sync.Chunk.Enable {
+		s += fmt.Sprintf("  Chunk:\t| files >= %d MiB, %d KiB average\n", c.Sync.Chunk.FileSize, c.Sync.Chunk.ChunkSize)
+	} else {
+		s += "  Chunk:\t| false\n"
+	}
+	if c.Sync.Compress.Enable {
+		s += fmt.Sprintf("  Compress:\t| %s, patterns: %v, skip: %v\n", c.Sync.Compress.Algorithm,
+			c.Sync.Compress.Patterns, c.Sync.Compress.Skip)
+	} else {
+		s += "  Compress:\t| false\n"
+	}
+	if c.Sync.Encrypt.Enable && c.Sync.Encrypt.KeyFile != "" {
+		s += fmt.Sprintf("  Encrypt:\t| key file %s, old keys: %d\n", c.Sync.Encrypt.KeyFile, len(c.Sync.Encrypt.OldKeyFiles))
+	} else if c.Sync.Encrypt.Enable {
+		s += fmt.Sprintf("  Encrypt:\t| key env %s, old keys: %d\n", c.Sync.Encrypt.KeyEnv, len(c.Sync.Encrypt.OldKeyFiles))
+	} else {
+		s += "  Encrypt:\t| false\n"
+	}
+	s += fmt.Sprintln("  Trash:")
+	s += fmt.Sprintf("    Enable:\t| %t\n", c.Sync.Trash.Enable)
+	s += fmt.Sprintf("    Retention:\t| %d day\n", c.Sync.Trash.Retention)
 	s += fmt.Sprintf("  Symlink:\t| %s\n", c.Sync.Symlink)
+	s += fmt.Sprintf("  Upload:\t| %s\n", c.Sync.Upload)
+	s += fmt.Sprintf("  Temp-dir:\t| %s\n", c.Sync.TempDir)
 	s += fmt.Sprintf("  Ignore:\t| %v\n", c.Sync.Ignore)
-	s += "******************************************"
+	s += fmt.Sprintf("  Priority:\t| %v\n", c.Sync.PriorityPaths)
+	s += fmt.Sprintf("  Checksum:\t| %t\n", c.Sync.Checksum)
+	s += fmt.Sprintf("  Data-dir:\t| %s\n", c.Sync.DataDir)
 	return s
 }
 
-func loadConfig(path string) *SyncConfig {
+// LoadConfig 加载并校验配置文件，override 在规范化和校验之前修改配置，用于命令行参数覆盖配置文件
+// 配置了多个同步任务时不调用 override
+func LoadConfig(path string, override func(c *SyncConfig) error) (*SyncConfig, error) {
 	cfg := &SyncConfig{}
 	if err := conf.LoadAndDecode(path, cfg); err != nil {
+		return nil, errors.New("configuration is empty, please check the config file path")
+	}
+
+	cfg.Transfer.normalize()
+	if len(cfg.Jobs) == 0 {
+		if err := cfg.resolveRemotes(cfg.Remotes); err != nil {
+			return nil, err
+		}
+		if override != nil {
+			if err := override(cfg); err != nil {
+				return nil, err
+			}
+		}
+		cfg.normalize()
+		if err := cfg.checkTempDir(); err != nil {
+			return nil, err
+		}
+		if err := cfg.checkModes(); err != nil {
+			return nil, err
+		}
+		return cfg, nil
+	}
+
+	names := make(map[string]struct{}, len(cfg.Jobs))
+	for i, job := range cfg.Jobs {
+		if job == nil {
+			return nil, fmt.Errorf("job %d is empty", i+1)
+		}
+		if job.Name == "" {
+			job.Name = fmt.Sprintf("job-%d", i+1)
+		}
+		// 任务名称用于区分本地状态目录
+		if strings.ContainsAny(job.Name, `/\`) || job.Name == "." || job.Name == ".." {
+			return nil, fmt.Errorf("job name %q is invalid", job.Name)
+		}
+		if _, ok := names[job.Name]; ok {
+			return nil, fmt.Errorf("job name %q is duplicated", job.Name)
+		}
+		names[job.Name] = struct{}{}
+		if err := job.resolveRemotes(cfg.Remotes); err != nil {
+			return nil, fmt.Errorf("job %s: %s", job.Name, err.Error())
+		}
+		job.DryRun = cfg.DryRun
+		job.Transfer = cfg.Transfer
+		job.Log = cfg.Log
+		job.normalize()
+		if err := job.checkTempDir(); err != nil {
+			return nil, fmt.Errorf("job %s: %s", job.Name, err.Error())
+		}
+		if err := job.checkModes(); err != nil {
+			return nil, fmt.Errorf("job %s: %s", job.Name, err.Error())
+		}
+	}
+
+	// 本地路径不能重叠，否则同一个文件会被多个任务同步
+	for i := 0; i < len(cfg.Jobs); i++ {
+		for j := i + 1; j < len(cfg.Jobs); j++ {
+			if isOverlap(cfg.Jobs[i].Local.Path, cfg.Jobs[j].Local.Path) {
+				return nil, fmt.Errorf("local path of job %s overlaps job %s", cfg.Jobs[j].Name, cfg.Jobs[i].Name)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// resolveRemotes 解析主远端与 replicas 中引用的命名远端
+func (c *SyncConfig) resolveRemotes(remotes map[string]RemoteConfig) error {
+	if err := c.Remote.resolve(remotes); err != nil {
+		return err
+	}
+	if err := c.Remote.normalize(); err != nil {
+		return err
+	}
+	for i := range c.Replicas {
+		if err := c.Replicas[i].resolve(remotes); err != nil {
+			return err
+		}
+		if err := c.Replicas[i].normalize(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetName 获取远端名称，用于区分多个远端的日志和输出
+func (r *RemoteConfig) GetName() string {
+	if r.Type == enum.BackendLocal {
+		return "file://" + r.Bucket
+	}
+	if r.Type == enum.BackendWebDAV && r.Bucket == "" {
+		return r.Endpoint
+	}
+	return r.Endpoint + "/" + r.Bucket
+}
+
+// normalize 规范化远端存储后端类型，本地目录转换为绝对路径，WebDAV地址补全协议
+func (r *RemoteConfig) normalize() error {
+	r.Type = strings.ToLower(r.Type)
+	switch r.Type {
+	case "":
+		r.Type = enum.BackendS3
+	case enum.BackendS3:
+	case enum.BackendWebDAV:
+		if r.Endpoint == "" {
+			return errors.New("endpoint of webdav remote is empty")
+		}
+		// 未指定协议时按 use_ssl 补全
+		if !strings.Contains(r.Endpoint, "://") {
+			scheme := "http://"
+			if r.UseSSL {
+				scheme = "https://"
+			}
+			r.Endpoint = scheme + r.Endpoint
+		}
+		r.Endpoint = strings.TrimRight(r.Endpoint, "/")
+		r.Bucket = strings.Trim(r.Bucket, "/")
+	case enum.BackendLocal:
+		if r.Bucket == "" {
+			return errors.New("bucket of local remote is empty, it should be the target directory")
+		}
+		if strings.HasPrefix(r.Bucket, "~") {
+			homeDir, _ := os.UserHomeDir()
+			r.Bucket = strings.Replace(r.Bucket, "~", homeDir, 1)
+		}
+		r.Bucket, _ = filepath.Abs(r.Bucket)
+	default:
+		return fmt.Errorf("remote type %q is not supported", r.Type)
+	}
+	return nil
+}
+
+// resolve 用 remotes 中引用的远端补全未配置的字段
+func (r *RemoteConfig) resolve(remotes map[string]RemoteConfig) error {
+	if r.Ref == "" {
 		return nil
 	}
+	named, ok := remotes[r.Ref]
+	if !ok {
+		return fmt.Errorf("remote %q is not defined", r.Ref)
+	}
+	for _, field := range []struct{ value, fallback *string }{
+		{&r.Type, &named.Type},
+		{&r.Endpoint, &named.Endpoint},
+		{&r.SecretId, &named.SecretId},
+		{&r.SecretKey, &named.SecretKey},
+		{&r.Bucket, &named.Bucket},
+		{&r.Region, &named.Region},
+		{&r.Path, &named.Path},
+	} {
+		if *field.value == "" {
+			*field.value = *field.fallback
+		}
+	}
+	r.UseSSL = r.UseSSL || named.UseSSL
+	return nil
+}
+
+// isOverlap 判断两个本地路径是否相同或存在包含关系
+func isOverlap(a, b string) bool {
+	a, b = filepath.Clean(a), filepath.Clean(b)
+	return a == b || strings.HasPrefix(a, b+string(filepath.Separator)) ||
+		strings.HasPrefix(b, a+string(filepath.Separator))
+}
 
+// normalize 规范化同步任务配置
+func (c *SyncConfig) normalize() {
 	// 处理local.path为相对路径的情况，替换为绝对路径
-	if len(cfg.Local.Path) > 0 && "./" == cfg.Local.Path[0:2] {
-		cfg.Local.Path, _ = filepath.Abs(cfg.Local.Path)
+	if len(c.Local.Path) > 0 && "./" == c.Local.Path[0:2] {
+		c.Local.Path, _ = filepath.Abs(c.Local.Path)
 	}
 
 	// 处理local.path中带有~的情况，替换为绝对路径
-	if len(cfg.Local.Path) > 0 && "~" == cfg.Local.Path[0:1] {
+	if len(c.Local.Path) > 0 && "~" == c.Local.Path[0:1] {
 		homeDir, _ := os.UserHomeDir()
-		cfg.Local.Path = strings.Replace(cfg.Local.Path, "~", homeDir, 1)
+		c.Local.Path = strings.Replace(c.Local.Path, "~", homeDir, 1)
 	}
 
 	// 处理remote.path中的前导/
-	if len(cfg.Remote.Path) > 0 && "/" == cfg.Remote.Path[0:1] {
-		cfg.Remote.Path = strings.TrimLeft(cfg.Remote.Path, "/")
+	if len(c.Remote.Path) > 0 && "/" == c.Remote.Path[0:1] {
+		c.Remote.Path = strings.TrimLeft(c.Remote.Path, "/")
+	}
+	for i := range c.Replicas {
+		c.Replicas[i].Path = strings.TrimLeft(c.Replicas[i].Path, "/")
 	}
 
 	// 处理Hot delay，最小1分钟，最大60分钟
-	if cfg.Sync.RealTime.HotDelay < 1 {
-		cfg.Sync.RealTime.HotDelay = 1
-	} else if cfg.Sync.RealTime.HotDelay > 60 {
-		cfg.Sync.RealTime.HotDelay = 60
+	if c.Sync.RealTime.HotDelay < 1 {
+		c.Sync.RealTime.HotDelay = 1
+	} else if c.Sync.RealTime.HotDelay > 60 {
+		c.Sync.RealTime.HotDelay = 60
+	}
+
+	// 处理删除宽限期，单位秒（0-3600），0表示立即删除
+	if c.Sync.RealTime.DeleteDelay < 0 {
+		c.Sync.RealTime.DeleteDelay = 0
+	} else if c.Sync.RealTime.DeleteDelay > 3600 {
+		c.Sync.RealTime.DeleteDelay = 3600
+	}
+
+	// 处理双向同步轮询间隔，默认60秒，最小10秒
+	if c.Sync.Bidirectional.Interval < 1 {
+		c.Sync.Bidirectional.Interval = 60
+	} else if c.Sync.Bidirectional.Interval < 10 {
+		c.Sync.Bidirectional.Interval = 10
+	}
+
+	// 处理回收站保留天数，默认30天，最小1天
+	if c.Sync.Trash.Retention < 1 {
+		c.Sync.Trash.Retention = 30
+	}
+
+	// 快照模式按定时对账的周期生成快照，远端路径只存放快照，不再实时同步
+	if c.Sync.Snapshot.Enable {
+		c.Sync.CheckJob.Enable = true
+		c.Sync.RealTime.Enable = false
+	}
+
+	// 处理分块阈值，默认64MiB；平均块大小默认1MiB，取值64KiB-16MiB，按2的幂向下取整
+	if c.Sync.Chunk.FileSize < 1 {
+		c.Sync.Chunk.FileSize = 64
+	}
+	if c.Sync.Chunk.ChunkSize < 1 {
+		c.Sync.Chunk.ChunkSize = 1024
+	}
+	c.Sync.Chunk.ChunkSize = 1 << (bits.Len(uint(min(max(c.Sync.Chunk.ChunkSize, 64), 16384))) - 1)
+
+	// 处理压缩算法，默认zstd
+	c.Sync.Compress.Algorithm = strings.ToLower(c.Sync.Compress.Algorithm)
+	if c.Sync.Compress.Algorithm != enum.CompressGzip {
+		c.Sync.Compress.Algorithm = enum.CompressZstd
+	}
+
+	// 未配置主密钥文件时从环境变量读取，默认ROS_MASTER_KEY
+	if c.Sync.Encrypt.KeyEnv == "" {
+		c.Sync.Encrypt.KeyEnv = "ROS_MASTER_KEY"
+	}
+
+	// 处理本地状态数据目录，默认为./.ros
+	if c.Sync.DataDir == "" {
+		c.Sync.DataDir = "./.ros"
 	}
 
 	// 处理symlink策略
-	cfg.Sync.Symlink = strings.ToLower(cfg.Sync.Symlink)
-	if cfg.Sync.Symlink != enum.SymlinkSkip &&
-		cfg.Sync.Symlink != enum.SymlinkAddr &&
-		cfg.Sync.Symlink != enum.SymlinkFile {
-		cfg.Sync.Symlink = enum.SymlinkSkip
+	c.Sync.Symlink = strings.ToLower(c.Sync.Symlink)
+	if c.Sync.Symlink != enum.SymlinkSkip &&
+		c.Sync.Symlink != enum.SymlinkAddr &&
+		c.Sync.Symlink != enum.SymlinkFile {
+		c.Sync.Symlink = enum.SymlinkSkip
+	}
+
+	// 处理上传方式，默认直接从源文件流式上传
+	c.Sync.Upload = strings.ToLower(c.Sync.Upload)
+	if c.Sync.Upload != enum.UploadCopy {
+		c.Sync.Upload = enum.UploadStream
+	}
+
+	// 处理临时文件目录，默认为工作目录
+	if c.Sync.TempDir == "" {
+		c.Sync.TempDir = "."
 	}
+	if strings.HasPrefix(c.Sync.TempDir, "~") {
+		homeDir, _ := os.UserHomeDir()
+		c.Sync.TempDir = strings.Replace(c.Sync.TempDir, "~", homeDir, 1)
+	}
+	c.Sync.TempDir, _ = filepath.Abs(c.Sync.TempDir)
+
+	// 处理优先路径，相对路径基于local.path
+	for i, path := range c.Sync.PriorityPaths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(c.Local.Path, path)
+		}
+		c.Sync.PriorityPaths[i] = filepath.Clean(path)
+	}
+}
 
-	return cfg
+// normalize 规范化传输配置
+func (t *TransferConfig) normalize() {
+	// 处理worker总数，默认8
+	if t.Workers < 1 {
+		t.Workers = 8
+	}
+	// 处理分片大小，S3要求除最后一个分片外不小于5MiB，不大于5GiB
+	if t.PartSize < 0 {
+		t.PartSize = 0
+	} else if t.PartSize > 0 && t.PartSize < 5 {
+		t.PartSize = 5
+	} else if t.PartSize > 5120 {
+		t.PartSize = 5120
+	}
+	// 处理单个文件同时上传的分片数，默认4，最多16
+	if t.ParallelParts < 1 {
+		t.ParallelParts = 4
+	} else if t.ParallelParts > 16 {
+		t.ParallelParts = 16
+	}
+	if t.BandwidthLimit < 0 {
+		t.BandwidthLimit = 0
+	}
+	// 处理失败重试，默认最多尝试5次，等待1秒起每次翻倍，最长60秒
+	if t.Retry.Attempts < 1 {
+		t.Retry.Attempts = 5
+	}
+	if t.Retry.BaseDelay < 1 {
+		t.Retry.BaseDelay = 1
+	}
+	if t.Retry.MaxDelay < 1 {
+		t.Retry.MaxDelay = 60
+	}
+	if t.Retry.MaxDelay < t.Retry.BaseDelay {
+		t.Retry.MaxDelay = t.Retry.BaseDelay
+	}
+	// 处理优先级调度，小文件默认8MiB，大文件默认1GiB，大文件worker默认为总数的1/4且不超过总数
+	if t.Schedule.SmallFile < 1 {
+		t.Schedule.SmallFile = 8
+	}
+	if t.Schedule.LargeFile < 1 {
+		t.Schedule.LargeFile = 1024
+	}
+	if t.Schedule.LargeWorkers < 1 {
+		t.Schedule.LargeWorkers = max(1, t.Workers/4)
+	} else if t.Schedule.LargeWorkers > t.Workers {
+		t.Schedule.LargeWorkers = t.Workers
+	}
+}
+
+// checkTempDir 临时文件目录不能在本地路径内，否则临时文件会被监听并同步
+func (c *SyncConfig) checkTempDir() error {
+	if c.Local.Path == "" {
+		return nil
+	}
+	if tempDir := filepath.Clean(c.Sync.TempDir); tempDir == filepath.Clean(c.Local.Path) ||
+		strings.HasPrefix(tempDir, filepath.Clean(c.Local.Path)+string(filepath.Separator)) {
+		return fmt.Errorf("sync.temp_dir %s must not be inside local.path", c.Sync.TempDir)
+	}
+	return nil
+}
+
+// checkModes 快照、CAS和分块模式使用不同的远端布局，不能同时启用
+// CAS和分块模式直接上传数据块，不经过加密，不能与加密同时启用；CAS模式的内容对象不压缩，不能与压缩同时启用
+// 这些模式下远端对象不是本地文件的镜像，双向同步无法拉取，不能与双向同步同时启用
+// CAS模式删除只从清单中移除路径，内容对象保留到清理孤儿对象时，不能与回收站同时启用
+func (c *SyncConfig) checkModes() error {
+	var modes []string
+	for _, mode := range []struct {
+		name   string
+		enable bool
+	}{
+		{"sync.snapshot", c.Sync.Snapshot.Enable},
+		{"sync.cas", c.Sync.CAS.Enable},
+		{"sync.chunk", c.Sync.Chunk.Enable},
+	} {
+		if mode.enable {
+			modes = append(modes, mode.name)
+		}
+	}
+	if len(modes) > 1 {
+		return fmt.Errorf("%s can not be enabled at the same time", strings.Join(modes, " and "))
+	}
+	if c.Sync.Encrypt.Enable && (c.Sync.CAS.Enable || c.Sync.Chunk.Enable) {
+		return errors.New("sync.encrypt can not be enabled with sync.cas or sync.chunk")
+	}
+	if c.Sync.Compress.Enable && c.Sync.CAS.Enable {
+		return errors.New("sync.compress can not be enabled with sync.cas")
+	}
+	if c.Sync.Bidirectional.Enable {
+		for _, mode := range []struct {
+			name   string
+			enable bool
+		}{
+			{"sync.snapshot", c.Sync.Snapshot.Enable},
+			{"sync.cas", c.Sync.CAS.Enable},
+			{"sync.chunk", c.Sync.Chunk.Enable},
+			{"sync.compress", c.Sync.Compress.Enable},
+			{"sync.encrypt", c.Sync.Encrypt.Enable},
+		} {
+			if mode.enable {
+				return fmt.Errorf("sync.bidirectional can not be enabled with %s", mode.name)
+			}
+		}
+	}
+	if c.Sync.Trash.Enable && c.Sync.CAS.Enable {
+		return errors.New("sync.trash can not be enabled with sync.cas")
+	}
+	return nil
 }
@@ -46,6 +46,7 @@ sync:
   ignore:
     - .git
     - node_modules
+dry_run: true
 `
 		configPath := createTempConfig(t, configContent)
 		cfg, err := GetConfig(configPath)
@@ -61,6 +62,8 @@ sync:
 		assert.True(t, cfg.Sync.CheckJob.Enable)
 		assert.Equal(t, "skip", cfg.Sync.Symlink)
 		assert.Contains(t, cfg.Sync.Ignore, ".git")
+		assert.False(t, cfg.Sync.CheckJob.DeleteOrphans)
+		assert.True(t, cfg.DryRun)
 	})
 
 	t.Run("配置文件不存在", func(t *testing.T) {
@@ -173,6 +176,604 @@ sync:
 	}
 }
 
+// TestLoadConfig_DeleteDelayBounds 测试删除宽限期边界值
+func TestLoadConfig_DeleteDelayBounds(t *testing.T) {
+	tests := []struct {
+		name        string
+		deleteDelay int
+		expected    int
+	}{
+		{"小于最小值", -1, 0},
+		{"立即删除", 0, 0},
+		{"正常值", 30, 30},
+		{"大于最大值", 7200, 3600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configContent := fmt.Sprintf(`
+local:
+  path: /data
+remote:
+  endpoint: s3.example.com
+  bucket: bucket
+sync:
+  real_time:
+    enable: true
+    delete_delay: %d
+`, tt.deleteDelay)
+			configPath := createTempConfig(t, configContent)
+			cfg, err := GetConfig(configPath)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, cfg.Sync.RealTime.DeleteDelay)
+		})
+	}
+}
+
+// TestLoadConfig_Bidirectional 测试双向同步配置规范化
+func TestLoadConfig_Bidirectional(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval int
+		expected int
+	}{
+		{"未配置使用默认值", 0, 60},
+		{"小于最小值", 5, 10},
+		{"正常值", 300, 300},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configContent := fmt.Sprintf(`
+local:
+  path: /data
+remote:
+  endpoint: s3.example.com
+  bucket: bucket
+sync:
+  bidirectional:
+    enable: true
+    interval: %d
+`, tt.interval)
+			configPath := createTempConfig(t, configContent)
+			cfg, err := GetConfig(configPath)
+
+			assert.NoError(t, err)
+			assert.True(t, cfg.Sync.Bidirectional.Enable)
+			assert.Equal(t, tt.expected, cfg.Sync.Bidirectional.Interval)
+			assert.Equal(t, "./.ros", cfg.Sync.DataDir)
+		})
+	}
+}
+
+// TestLoadConfig_Jobs 测试多个同步任务与命名远端
+func TestLoadConfig_Jobs(t *testing.T) {
+	configContent := `
+remotes:
+  backup:
+    endpoint: s3.example.com
+    use_ssl: true
+    secret_id: AKID
+    secret_key: SECRET
+    bucket: shared
+    region: us-east-1
+jobs:
+  - name: docs
+    local:
+      path: /data/docs
+    remote:
+      ref: backup
+      path: /docs
+    sync:
+      real_time:
+        enable: true
+        hot_delay: 5
+      symlink: addr
+      ignore:
+        - .git
+  - local:
+      path: /data/photos
+    remote:
+      ref: backup
+      bucket: photos
+    sync:
+      check_job:
+        enable: true
+dry_run: true
+`
+	configPath := createTempConfig(t, configContent)
+	cfg, err := GetConfig(configPath)
+	assert.NoError(t, err)
+
+	jobs := cfg.GetJobs()
+	assert.Len(t, jobs, 2)
+
+	assert.Equal(t, "docs", jobs[0].Name)
+	assert.Equal(t, "s3.example.com", jobs[0].Remote.Endpoint)
+	assert.True(t, jobs[0].Remote.UseSSL)
+	assert.Equal(t, "shared", jobs[0].Remote.Bucket)
+	assert.Equal(t, "docs", jobs[0].Remote.Path)
+	assert.Equal(t, 5, jobs[0].Sync.RealTime.HotDelay)
+	assert.Equal(t, enum.SymlinkAddr, jobs[0].Sync.Symlink)
+	assert.Equal(t, []string{".git"}, jobs[0].Sync.Ignore)
+	assert.True(t, jobs[0].DryRun)
+	assert.Equal(t, filepath.Join(".ros", "docs", "state.json"), jobs[0].StatePath())
+	assert.Equal(t, filepath.Join(".ros", "docs", "queue.db"), jobs[0].QueuePath())
+
+	// 未命名的任务按序号命名
+	assert.Equal(t, "job-2", jobs[1].Name)
+	assert.Equal(t, "photos", jobs[1].Remote.Bucket)
+	assert.Equal(t, "AKID", jobs[1].Remote.SecretId)
+	assert.Equal(t, 1, jobs[1].Sync.RealTime.HotDelay)
+	assert.Equal(t, enum.SymlinkSkip, jobs[1].Sync.Symlink)
+	assert.True(t, jobs[1].Sync.CheckJob.Enable)
+
+	output := cfg.GetString()
+	assert.Contains(t, output, "Job: docs")
+	assert.Contains(t, output, "Job: job-2")
+	assert.NotContains(t, output, "SECRET")
+}
+
+// TestLoadConfig_JobsInvalid 测试多个同步任务的配置校验
+func TestLoadConfig_JobsInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"引用未定义的远端", `
+jobs:
+  - local:
+      path: /data/docs
+    remote:
+      ref: missing
+`},
+		{"任务名称重复", `
+jobs:
+  - name: docs
+    local:
+      path: /data/a
+  - name: docs
+    local:
+      path: /data/b
+`},
+		{"本地路径重叠", `
+jobs:
+  - local:
+      path: /data
+  - local:
+      path: /data/photos
+`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := createTempConfig(t, tt.content)
+			_, err := GetConfig(configPath)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// TestLoadConfig_RemoteRef 测试单任务配置引用命名远端
+func TestLoadConfig_RemoteRef(t *testing.T) {
+	configContent := `
+remotes:
+  backup:
+    endpoint: s3.example.com
+    bucket: bucket
+local:
+  path: /data
+remote:
+  ref: backup
+  path: backup
+`
+	configPath := createTempConfig(t, configContent)
+	cfg, err := GetConfig(configPath)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "s3.example.com", cfg.Remote.Endpoint)
+	assert.Equal(t, "bucket", cfg.Remote.Bucket)
+	assert.Equal(t, "backup", cfg.Remote.Path)
+	assert.Equal(t, filepath.Join(".ros", "queue.db"), cfg.QueuePath())
+	assert.Equal(t, filepath.Join(".ros", "state.json"), cfg.StatePath())
+	assert.Len(t, cfg.GetJobs(), 1)
+}
+
+// TestLoadConfig_RemoteType 测试远端存储后端类型
+func TestLoadConfig_RemoteType(t *testing.T) {
+	t.Run("默认s3", func(t *testing.T) {
+		configPath := createTempConfig(t, `
+local:
+  path: /data
+remote:
+  endpoint: s3.example.com
+  bucket: bucket
+`)
+		cfg, err := GetConfig(configPath)
+
+		assert.NoError(t, err)
+		assert.Equal(t, enum.BackendS3, cfg.Remote.Type)
+		assert.Equal(t, "s3.example.com/bucket", cfg.Remote.GetName())
+	})
+
+	t.Run("本地目录", func(t *testing.T) {
+		configPath := createTempConfig(t, `
+local:
+  path: /data
+remote:
+  type: LOCAL
+  bucket: ./nas
+  path: backup
+`)
+		cfg, err := GetConfig(configPath)
+
+		assert.NoError(t, err)
+		assert.Equal(t, enum.BackendLocal, cfg.Remote.Type)
+		abs, _ := filepath.Abs("./nas")
+		assert.Equal(t, abs, cfg.Remote.Bucket)
+		assert.Equal(t, "file://"+abs, cfg.Remote.GetName())
+	})
+
+	t.Run("WebDAV", func(t *testing.T) {
+		configPath := createTempConfig(t, `
+local:
+  path: /data
+remote:
+  type: webdav
+  endpoint: dav.example.com/remote.php/dav/files/alice/
+  use_ssl: true
+  bucket: /backup/
+`)
+		cfg, err := GetConfig(configPath)
+
+		assert.NoError(t, err)
+		assert.Equal(t, enum.BackendWebDAV, cfg.Remote.Type)
+		assert.Equal(t, "https://dav.example.com/remote.php/dav/files/alice", cfg.Remote.Endpoint)
+		assert.Equal(t, "backup", cfg.Remote.Bucket)
+		assert.Equal(t, "https://dav.example.com/remote.php/dav/files/alice/backup", cfg.Remote.GetName())
+	})
+
+	t.Run("WebDAV地址为空", func(t *testing.T) {
+		configPath := createTempConfig(t, `
+local:
+  path: /data
+remote:
+  type: webdav
+`)
+		_, err := GetConfig(configPath)
+		assert.Error(t, err)
+	})
+
+	t.Run("不支持的类型", func(t *testing.T) {
+		configPath := createTempConfig(t, `
+local:
+  path: /data
+remote:
+  type: ftp
+  bucket: bucket
+`)
+		_, err := GetConfig(configPath)
+		assert.Error(t, err)
+	})
+
+	t.Run("本地目录为空", func(t *testing.T) {
+		configPath := createTempConfig(t, `
+local:
+  path: /data
+remote:
+  type: local
+`)
+		_, err := GetConfig(configPath)
+		assert.Error(t, err)
+	})
+}
+
+// TestLoadConfig_Replicas 测试复制到多个远端
+func TestLoadConfig_Replicas(t *testing.T) {
+	configContent := `
+remotes:
+  minio:
+    endpoint: minio.example.com
+    secret_id: MINIO
+    bucket: dr
+local:
+  path: /data
+remote:
+  endpoint: s3.example.com
+  bucket: bucket
+  path: /backup
+replicas:
+  - ref: minio
+    path: /backup
+  - endpoint: oss.example.com
+    bucket: another
+sync:
+  symlink: addr
+`
+	configPath := createTempConfig(t, configContent)
+	cfg, err := GetConfig(configPath)
+	assert.NoError(t, err)
+
+	destinations := cfg.GetDestinations()
+	assert.Len(t, destinations, 3)
+	assert.Equal(t, "s3.example.com", destinations[0].Remote.Endpoint)
+	assert.Equal(t, "minio.example.com", destinations[1].Remote.Endpoint)
+	assert.Equal(t, "dr", destinations[1].Remote.Bucket)
+	assert.Equal(t, "backup", destinations[1].Remote.Path)
+	assert.Equal(t, "oss.example.com", destinations[2].Remote.Endpoint)
+	for _, destination := range destinations[1:] {
+		assert.Equal(t, "/data", destination.Local.Path)
+		assert.Equal(t, enum.SymlinkAddr, destination.Sync.Symlink)
+		assert.Empty(t, destination.Replicas)
+	}
+	// 每个远端独立记录分片上传，同一任务的远端共享死信列表
+	assert.Equal(t, filepath.Join(".ros", "uploads"), filepath.Dir(destinations[0].UploadsPath()))
+	assert.NotEqual(t, destinations[0].UploadsPath(), destinations[1].UploadsPath())
+	assert.Equal(t, destinations[0].DeadLetterPath(), destinations[1].DeadLetterPath())
+	assert.Contains(t, cfg.GetString(), "minio.example.com")
+}
+
+// TestLoadConfig_Snapshot 测试快照模式开启定时对账并关闭实时同步
+func TestLoadConfig_Snapshot(t *testing.T) {
+	configContent := `
+local:
+  path: /data
+remote:
+  endpoint: s3.example.com
+  bucket: bucket
+sync:
+  real_time:
+    enable: true
+  check_job:
+    enable: false
+  snapshot:
+    enable: true
+`
+	configPath := createTempConfig(t, configContent)
+	cfg, err := GetConfig(configPath)
+
+	assert.NoError(t, err)
+	assert.True(t, cfg.Sync.Snapshot.Enable)
+	assert.True(t, cfg.Sync.CheckJob.Enable)
+	assert.False(t, cfg.Sync.RealTime.Enable)
+}
+
+// TestLoadConfig_CAS 测试CAS模式不能与回收站、快照模式同时启用
+func TestLoadConfig_CAS(t *testing.T) {
+	t.Run("与回收站同时启用", func(t *testing.T) {
+		configPath := createTempConfig(t, `
+local:
+  path: /data
+remote:
+  endpoint: s3.example.com
+  bucket: bucket
+sync:
+  trash:
+    enable: true
+  cas:
+    enable: true
+`)
+		_, err := GetConfig(configPath)
+		assert.EqualError(t, err, "sync.trash can not be enabled with sync.cas")
+	})
+
+	t.Run("与快照模式同时启用", func(t *testing.T) {
+		configPath := createTempConfig(t, `
+local:
+  path: /data
+remote:
+  endpoint: s3.example.com
+  bucket: bucket
+sync:
+  snapshot:
+    enable: true
+  cas:
+    enable: true
+`)
+		_, err := GetConfig(configPath)
+		assert.Error(t, err)
+	})
+}
+
+// TestLoadConfig_Chunk 测试分块模式的默认值、平均块大小取值，以及与其他存储模式互斥
+func TestLoadConfig_Chunk(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunkSize int
+		expected  int
+	}{
+		{"未配置默认1MiB", 0, 1024},
+		{"按2的幂向下取整", 1000, 512},
+		{"最小64KiB", 10, 64},
+		{"最大16MiB", 100000, 16384},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := createTempConfig(t, fmt.Sprintf(`
+local:
+  path: /data
+remote:
+  bucket: bucket
+sync:
+  chunk:
+    enable: true
+    chunk_size: %d
+`, tt.chunkSize))
+			cfg, err := GetConfig(configPath)
+
+			assert.NoError(t, err)
+			assert.Equal(t, int64(64), cfg.Sync.Chunk.FileSize)
+			assert.Equal(t, tt.expected, cfg.Sync.Chunk.ChunkSize)
+		})
+	}
+
+	t.Run("与CAS模式同时启用", func(t *testing.T) {
+		configPath := createTempConfig(t, `
+local:
+  path: /data
+remote:
+  bucket: bucket
+sync:
+  cas:
+    enable: true
+  chunk:
+    enable: true
+`)
+		_, err := GetConfig(configPath)
+		assert.EqualError(t, err, "sync.cas and sync.chunk can not be enabled at the same time")
+	})
+}
+
+// TestLoadConfig_Compress 测试压缩的算法取值和匹配规则
+func TestLoadConfig_Compress(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+		expected  string
+	}{
+		{"未配置默认zstd", "", enum.CompressZstd},
+		{"gzip", "GZIP", enum.CompressGzip},
+		{"无效值使用zstd", "lz4", enum.CompressZstd},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := createTempConfig(t, fmt.Sprintf(`
+local:
+  path: /data
+remote:
+  bucket: bucket
+sync:
+  compress:
+    enable: true
+    algorithm: %s
+    patterns: ["*.log", "*.csv"]
+    skip: ["*.jpg", "*.zip"]
+`, tt.algorithm))
+			cfg, err := GetConfig(configPath)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, cfg.Sync.Compress.Algorithm)
+			assert.Equal(t, []string{"*.log", "*.csv"}, cfg.Sync.Compress.Patterns)
+			assert.Equal(t, []string{"*.jpg", "*.zip"}, cfg.Sync.Compress.Skip)
+		})
+	}
+}
+
+// TestLoadConfig_CompressCAS 测试压缩不能与CAS模式同时启用
+func TestLoadConfig_CompressCAS(t *testing.T) {
+	configPath := createTempConfig(t, `
+local:
+  path: /data
+remote:
+  bucket: bucket
+sync:
+  cas:
+    enable: true
+  compress:
+    enable: true
+`)
+	_, err := GetConfig(configPath)
+	assert.EqualError(t, err, "sync.compress can not be enabled with sync.cas")
+}
+
+// TestLoadConfig_Encrypt 测试加密的主密钥配置，以及不能与CAS、分块模式同时启用
+func TestLoadConfig_Encrypt(t *testing.T) {
+	configPath := createTempConfig(t, `
+local:
+  path: /data
+remote:
+  bucket: bucket
+sync:
+  encrypt:
+    enable: true
+    key_file: /etc/ros/master.key
+    old_key_files: ["/etc/ros/old.key"]
+`)
+	cfg, err := GetConfig(configPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "/etc/ros/master.key", cfg.Sync.Encrypt.KeyFile)
+	assert.Equal(t, "ROS_MASTER_KEY", cfg.Sync.Encrypt.KeyEnv)
+	assert.Equal(t, []string{"/etc/ros/old.key"}, cfg.Sync.Encrypt.OldKeyFiles)
+	assert.Contains(t, cfg.GetString(), "key file /etc/ros/master.key, old keys: 1")
+
+	for _, mode := range []string{"cas", "chunk"} {
+		t.Run("与"+mode+"同时启用", func(t *testing.T) {
+			configPath := createTempConfig(t, fmt.Sprintf(`
+local:
+  path: /data
+remote:
+  bucket: bucket
+sync:
+  %s:
+    enable: true
+  encrypt:
+    enable: true
+`, mode))
+			_, err := GetConfig(configPath)
+			assert.EqualError(t, err, "sync.encrypt can not be enabled with sync.cas or sync.chunk")
+		})
+	}
+}
+
+// TestLoadConfig_BidirectionalModes 测试远端不是本地镜像的模式不能与双向同步同时启用
+func TestLoadConfig_BidirectionalModes(t *testing.T) {
+	for _, mode := range []string{"snapshot", "cas", "chunk", "compress", "encrypt"} {
+		t.Run(mode, func(t *testing.T) {
+			configPath := createTempConfig(t, fmt.Sprintf(`
+local:
+  path: /data
+remote:
+  bucket: bucket
+sync:
+  bidirectional:
+    enable: true
+  %s:
+    enable: true
+`, mode))
+			_, err := GetConfig(configPath)
+			assert.EqualError(t, err, "sync.bidirectional can not be enabled with sync."+mode)
+		})
+	}
+}
+
+// TestLoadConfig_Trash 测试回收站保留天数默认值
+func TestLoadConfig_Trash(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention int
+		expected  int
+	}{
+		{"指定天数", 7, 7},
+		{"未配置默认30天", 0, 30},
+		{"负数默认30天", -1, 30},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configContent := fmt.Sprintf(`
+local:
+  path: /data
+remote:
+  endpoint: s3.example.com
+  bucket: bucket
+sync:
+  trash:
+    enable: true
+    retention: %d
+`, tt.retention)
+			configPath := createTempConfig(t, configContent)
+			cfg, err := GetConfig(configPath)
+
+			assert.NoError(t, err)
+			assert.True(t, cfg.Sync.Trash.Enable)
+			assert.Equal(t, tt.expected, cfg.Sync.Trash.Retention)
+		})
+	}
+}
+
 // TestLoadConfig_SymlinkStrategy 测试 Symlink 策略规范化
 func TestLoadConfig_SymlinkStrategy(t *testing.T) {
 	tests := []struct {
@@ -308,3 +909,184 @@ sync:
 	assert.NotNil(t, cfg)
 	assert.Equal(t, "/tmp", cfg.Local.Path)
 }
+
+// TestLoadConfig_Upload 测试上传方式，默认流式上传
+func TestLoadConfig_Upload(t *testing.T) {
+	tests := []struct {
+		name     string
+		upload   string
+		expected string
+	}{
+		{name: "默认", upload: "", expected: enum.UploadStream},
+		{name: "先复制", upload: "COPY", expected: enum.UploadCopy},
+		{name: "无效值", upload: "unknown", expected: enum.UploadStream},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := createTempConfig(t, `
+local:
+  path: /data
+remote:
+  bucket: bucket
+sync:
+  upload: "`+tt.upload+`"
+`)
+			cfg, err := GetConfig(configPath)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, cfg.Sync.Upload)
+		})
+	}
+}
+
+// TestLoadConfig_TempDir 测试临时文件目录，默认为工作目录，不能在本地路径内
+func TestLoadConfig_TempDir(t *testing.T) {
+	t.Run("默认工作目录", func(t *testing.T) {
+		configPath := createTempConfig(t, `
+local:
+  path: /data
+remote:
+  bucket: bucket
+`)
+		cfg, err := GetConfig(configPath)
+
+		assert.NoError(t, err)
+		wd, _ := os.Getwd()
+		assert.Equal(t, wd, cfg.Sync.TempDir)
+	})
+
+	t.Run("同一文件系统的目录", func(t *testing.T) {
+		configPath := createTempConfig(t, `
+local:
+  path: /data/docs
+remote:
+  bucket: bucket
+sync:
+  temp_dir: /data/.ros-tmp
+`)
+		cfg, err := GetConfig(configPath)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "/data/.ros-tmp", cfg.Sync.TempDir)
+	})
+
+	t.Run("在本地路径内", func(t *testing.T) {
+		configPath := createTempConfig(t, `
+local:
+  path: /data/docs
+remote:
+  bucket: bucket
+sync:
+  temp_dir: /data/docs/.tmp
+`)
+		_, err := GetConfig(configPath)
+		assert.Error(t, err)
+	})
+}
+
+// TestLoadConfig_Transfer 测试传输配置的默认值、取值范围，以及多个任务共享
+func TestLoadConfig_Transfer(t *testing.T) {
+	t.Run("默认值", func(t *testing.T) {
+		configPath := createTempConfig(t, `
+local:
+  path: /data
+remote:
+  bucket: bucket
+`)
+		cfg, err := GetConfig(configPath)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 8, cfg.Transfer.Workers)
+		assert.Equal(t, int64(0), cfg.Transfer.PartSize)
+		assert.Equal(t, 4, cfg.Transfer.ParallelParts)
+		assert.Equal(t, int64(0), cfg.Transfer.BandwidthLimit)
+		assert.Equal(t, 5, cfg.Transfer.Retry.Attempts)
+		assert.Equal(t, 1, cfg.Transfer.Retry.BaseDelay)
+		assert.Equal(t, 60, cfg.Transfer.Retry.MaxDelay)
+		assert.Equal(t, int64(8), cfg.Transfer.Schedule.SmallFile)
+		assert.Equal(t, int64(1024), cfg.Transfer.Schedule.LargeFile)
+		assert.Equal(t, 2, cfg.Transfer.Schedule.LargeWorkers)
+		assert.Contains(t, cfg.GetString(), "unlimited")
+	})
+
+	t.Run("超出范围", func(t *testing.T) {
+		configPath := createTempConfig(t, `
+local:
+  path: /data
+remote:
+  bucket: bucket
+transfer:
+  workers: -1
+  part_size: 1
+  parallel_parts: 100
+  bandwidth_limit: -5
+  retry:
+    attempts: -1
+    base_delay: 30
+    max_delay: 10
+  schedule:
+    small_file: -1
+    large_file: -1
+    large_workers: 100
+`)
+		cfg, err := GetConfig(configPath)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 8, cfg.Transfer.Workers)
+		assert.Equal(t, int64(5), cfg.Transfer.PartSize)
+		assert.Equal(t, 16, cfg.Transfer.ParallelParts)
+		assert.Equal(t, int64(0), cfg.Transfer.BandwidthLimit)
+		assert.Equal(t, 5, cfg.Transfer.Retry.Attempts)
+		assert.Equal(t, 30, cfg.Transfer.Retry.MaxDelay)
+		assert.Equal(t, int64(8), cfg.Transfer.Schedule.SmallFile)
+		assert.Equal(t, int64(1024), cfg.Transfer.Schedule.LargeFile)
+		assert.Equal(t, 8, cfg.Transfer.Schedule.LargeWorkers)
+	})
+
+	t.Run("多个任务共享", func(t *testing.T) {
+		configPath := createTempConfig(t, `
+transfer:
+  workers: 2
+  part_size: 64
+  parallel_parts: 2
+  bandwidth_limit: 1048576
+jobs:
+  - local:
+      path: /data/docs
+    remote:
+      bucket: docs
+  - local:
+      path: /data/photos
+    remote:
+      bucket: photos
+`)
+		cfg, err := GetConfig(configPath)
+
+		assert.NoError(t, err)
+		for _, job := range cfg.GetJobs() {
+			assert.Equal(t, cfg.Transfer, job.Transfer)
+		}
+		assert.Equal(t, int64(64), cfg.Jobs[1].Transfer.PartSize)
+		assert.Contains(t, cfg.GetString(), "64 MiB")
+	})
+}
+
+// TestLoadConfig_PriorityPaths 测试优先路径的规范化
+func TestLoadConfig_PriorityPaths(t *testing.T) {
+	configPath := createTempConfig(t, `
+local:
+  path: /data
+remote:
+  bucket: bucket
+sync:
+  priority_paths:
+    - docs/
+    - ./inbox
+    - /data/urgent
+`)
+	cfg, err := GetConfig(configPath)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/data/docs", "/data/inbox", "/data/urgent"}, cfg.Sync.PriorityPaths)
+	assert.Contains(t, cfg.GetString(), "/data/inbox")
+}
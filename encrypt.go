@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os/signal"
+	"syscall"
+
+	"github.com/jorben/rsync-object-storage/config"
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/jorben/rsync-object-storage/log"
+)
+
+const (
+	// metaEncryption 对象用户元数据，记录加密算法
+	metaEncryption = "ros-encryption"
+	// metaKeyID 对象用户元数据，记录包装数据密钥的主密钥标识
+	metaKeyID = "ros-key-id"
+	// metaWrappedKey 对象用户元数据，记录主密钥加密后的数据密钥
+	metaWrappedKey = "ros-wrapped-key"
+)
+
+// Encryption 客户端加密，每个对象使用随机的数据密钥加密，数据密钥由主密钥包装后写入对象的用户元数据
+type Encryption struct {
+	KeyID   string
+	Key     []byte            // 当前主密钥，用于包装新的数据密钥
+	OldKeys map[string][]byte // 轮换前的主密钥，按标识索引，只用于解包数据密钥
+}
+
+// NewEncryption 读取主密钥创建加密实例，主密钥优先从文件读取，未配置文件时从环境变量读取
+func NewEncryption(keyFile, keyEnv string, oldKeyFiles []string) (*Encryption, error) {
+	key, err := helper.LoadKey(keyFile, keyEnv)
+	if err != nil {
+		return nil, fmt.Errorf("load master key err: %s", err.Error())
+	}
+	e := &Encryption{KeyID: helper.KeyID(key), Key: key, OldKeys: make(map[string][]byte, len(oldKeyFiles))}
+	for _, file := range oldKeyFiles {
+		oldKey, err := helper.LoadKey(file, "")
+		if err != nil {
+			return nil, fmt.Errorf("load old master key %s err: %s", file, err.Error())
+		}
+		e.OldKeys[helper.KeyID(oldKey)] = oldKey
+	}
+	return e, nil
+}
+
+// newDataKey 生成对象的数据密钥，包装后的数据密钥和主密钥标识写入metadata
+func (e *Encryption) newDataKey(metadata map[string]string) ([]byte, error) {
+	dataKey, err := helper.NewDataKey()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := helper.WrapKey(e.Key, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	metadata[metaEncryption] = enum.EncryptAES256GCM
+	metadata[metaKeyID] = e.KeyID
+	metadata[metaWrappedKey] = wrapped
+	return dataKey, nil
+}
+
+// dataKey 按元数据记录的主密钥标识解包对象的数据密钥
+func (e *Encryption) dataKey(metadata map[string]string) ([]byte, error) {
+	if metadata[metaEncryption] != enum.EncryptAES256GCM {
+		return nil, fmt.Errorf("unsupported encryption %s", metadata[metaEncryption])
+	}
+	if e == nil {
+		return nil, errors.New("object is encrypted but sync.encrypt is not enabled")
+	}
+	masterKey := e.Key
+	if keyID := metadata[metaKeyID]; keyID != e.KeyID {
+		if masterKey = e.OldKeys[keyID]; masterKey == nil {
+			return nil, fmt.Errorf("master key %s is not configured", keyID)
+		}
+	}
+	return helper.UnwrapKey(masterKey, metadata[metaWrappedKey])
+}
+
+// RotateKeys 使用当前主密钥重新包装远端对象的数据密钥，对象内容不重新加密和上传，返回重新包装的对象数量
+// 包括回收站和快照中的对象，数据密钥由其他主密钥包装时需要在 old_key_files 中配置
+func (s *Storage) RotateKeys(ctx context.Context) (int, error) {
+	backend, ok := s.Backend.(MetadataBackend)
+	if !ok || s.Encrypt == nil {
+		return 0, errors.New("sync.encrypt is not enabled")
+	}
+	var rotated int
+	var someError error
+	for object := range s.ListObjects(ctx) {
+		if object.Err != nil {
+			someError = object.Err
+			continue
+		}
+		// 列举结果可能不包含用户元数据
+		metadata := object.Metadata
+		if metadata == nil {
+			objectInfo, err := s.Backend.StatObject(ctx, object.Key)
+			if err != nil {
+				log.Errorf("StatObject err: %s, path: %s", err.Error(), object.Key)
+				someError = err
+				continue
+			}
+			metadata = objectInfo.Metadata
+		}
+		if metadata[metaEncryption] == "" || metadata[metaKeyID] == s.Encrypt.KeyID {
+			continue
+		}
+		dataKey, err := s.Encrypt.dataKey(metadata)
+		if err != nil {
+			log.Errorf("Unwrap data key err: %s, path: %s", err.Error(), object.Key)
+			someError = err
+			continue
+		}
+		wrapped, err := helper.WrapKey(s.Encrypt.Key, dataKey)
+		if err != nil {
+			return rotated, err
+		}
+		if s.DryRun {
+			log.Infof("Dry-run: would rewrap %s, key: %s -> %s", object.Key, metadata[metaKeyID], s.Encrypt.KeyID)
+			rotated++
+			continue
+		}
+		replaced := maps.Clone(metadata)
+		replaced[metaKeyID] = s.Encrypt.KeyID
+		replaced[metaWrappedKey] = wrapped
+		if err = backend.ReplaceMetadata(ctx, object.Key, object.Size, replaced); err != nil {
+			log.Errorf("ReplaceMetadata err: %s, path: %s", err.Error(), object.Key)
+			someError = err
+			continue
+		}
+		log.Infof("Rewrap data key %s, key: %s -> %s", object.Key, metadata[metaKeyID], s.Encrypt.KeyID)
+		rotated++
+	}
+	return rotated, someError
+}
+
+// keyCommand key 子命令入口，返回进程退出码
+// 用法：ros key rotate [-c config] [-job name]
+func keyCommand(args []string) int {
+	usage := "Usage: ros key rotate [-c config] [-job name]"
+	if len(args) == 0 || args[0] != "rotate" {
+		fmt.Println(usage)
+		return 2
+	}
+	flags := flag.NewFlagSet("key rotate", flag.ExitOnError)
+	configPath := flags.String("c", "./config.yaml", "Path to the configuration file")
+	jobName := flags.String("job", "", "Only handle the sync job with this `NAME`")
+	_ = flags.Parse(args[1:])
+
+	c, err := config.GetConfig(*configPath)
+	if err != nil {
+		fmt.Printf("Load config err: %s\n", err.Error())
+		return 1
+	}
+
+	// 初始化日志
+	log.InitLogger(c.Log)
+	defer log.GetLogger().Sync()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	code := 0
+	for _, job := range c.GetJobs() {
+		if (*jobName != "" && job.Name != *jobName) || !job.Sync.Encrypt.Enable {
+			continue
+		}
+		for _, destination := range job.GetDestinations() {
+			s, err := NewStorage(destination)
+			if err != nil {
+				log.Errorf("NewStorage err: %s", err.Error())
+				return 1
+			}
+			rotated, err := s.RotateKeys(ctx)
+			if err != nil {
+				log.Errorf("Rotate keys err: %s, remote: %s", err.Error(), s.Name)
+				code = 1
+			}
+			log.Infof("Rotate keys ends, remote: %s, rewrapped: %d", s.Name, rotated)
+		}
+	}
+	return code
+}
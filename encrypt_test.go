@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/jorben/rsync-object-storage/mocks"
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// writeKeyFile 生成随机的主密钥并以hex编码写入文件
+func writeKeyFile(t *testing.T) string {
+	t.Helper()
+	key, err := helper.NewDataKey()
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "master.key")
+	assert.NoError(t, os.WriteFile(path, []byte(hex.EncodeToString(key)), 0600))
+	return path
+}
+
+// TestEncrypt_FPutObject 测试加密上传，按元数据中的原始MD5比较，启用加密前上传的对象重新上传
+func TestEncrypt_FPutObject(t *testing.T) {
+	ctx := context.Background()
+	localDir, remoteDir := t.TempDir(), t.TempDir()
+	path := filepath.Join(localDir, "secret.txt")
+	data := bytes.Repeat([]byte("confidential "), 10000)
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+
+	// 启用加密前上传的对象
+	plain := newLocalStorage(t, localDir, remoteDir)
+	assert.NoError(t, plain.FPutObject(ctx, path))
+	s := newLocalStorage(t, localDir, remoteDir, withEncrypt(writeKeyFile(t)))
+	assert.Equal(t, enum.DiffNotEncrypted, s.diff(ctx, path, ""))
+
+	assert.NoError(t, s.FPutObject(ctx, path))
+	assert.True(t, s.IsSameV2(ctx, path, ""))
+	assert.ErrorIs(t, s.FPutObject(ctx, path), enum.ErrSkipTransfer)
+	objectInfo, err := s.Backend.StatObject(ctx, "backup/secret.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, enum.EncryptAES256GCM, objectInfo.Metadata[metaEncryption])
+	assert.Equal(t, s.Encrypt.KeyID, objectInfo.Metadata[metaKeyID])
+	assert.NotEmpty(t, objectInfo.Metadata[metaWrappedKey])
+	assert.Equal(t, helper.StringMd5(string(data)), objectInfo.Metadata[metaOriginalMd5])
+	assert.Empty(t, objectInfo.Metadata[metaCompression])
+	content, err := os.ReadFile(filepath.Join(remoteDir, "backup", "secret.txt"))
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(content, []byte("confidential")))
+
+	// 修改后按原始MD5判断不一致
+	assert.NoError(t, os.WriteFile(path, []byte("changed"), 0644))
+	assert.Equal(t, enum.DiffOriginalMd5, s.diff(ctx, path, ""))
+
+	// 符号链接地址不加密
+	assert.NoError(t, os.Symlink(path, filepath.Join(localDir, "link")))
+	assert.NoError(t, s.FPutObject(ctx, filepath.Join(localDir, "link")))
+	assert.True(t, s.IsSameV2(ctx, filepath.Join(localDir, "link"), ""))
+}
+
+// TestEncrypt_Compress 测试先压缩后加密，压缩后没有变小的文件只加密
+func TestEncrypt_Compress(t *testing.T) {
+	ctx := context.Background()
+	localDir, remoteDir := t.TempDir(), t.TempDir()
+	data := bytes.Repeat([]byte("2026-10-16 08:00:00 INFO request handled\n"), 1000)
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "app.log"), data, 0644))
+	writeRandomFile(t, filepath.Join(localDir, "random.log"), 4096, 1)
+
+	s := newLocalStorage(t, localDir, remoteDir, withEncrypt(writeKeyFile(t)), withCompress())
+	for _, name := range []string{"app.log", "random.log"} {
+		path := filepath.Join(localDir, name)
+		assert.NoError(t, s.FPutObject(ctx, path))
+		assert.True(t, s.IsSameV2(ctx, path, ""), name)
+	}
+	objectInfo, err := s.Backend.StatObject(ctx, "backup/app.log")
+	assert.NoError(t, err)
+	assert.Less(t, objectInfo.Size, int64(len(data)/10))
+	assert.Equal(t, enum.CompressZstd, objectInfo.Metadata[metaCompression])
+	assert.Equal(t, enum.EncryptAES256GCM, objectInfo.Metadata[metaEncryption])
+	objectInfo, err = s.Backend.StatObject(ctx, "backup/random.log")
+	assert.NoError(t, err)
+	assert.Empty(t, objectInfo.Metadata[metaCompression])
+	assert.Equal(t, enum.EncryptAES256GCM, objectInfo.Metadata[metaEncryption])
+
+	restoreDir := t.TempDir()
+	target := newLocalStorage(t, restoreDir, remoteDir)
+	target.Encrypt = s.Encrypt
+	r := &Restore{LocalPrefix: restoreDir, SymLink: enum.SymlinkAddr, IgnoreMatcher: helper.NewIgnoreMatcher(nil), Storage: target}
+	assert.NoError(t, r.Run(ctx))
+	content, err := os.ReadFile(filepath.Join(restoreDir, "app.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, data, content)
+	assert.True(t, target.IsSameV2(ctx, filepath.Join(restoreDir, "random.log"), ""))
+}
+
+// TestEncrypt_RotateKeys 测试轮换主密钥只重新包装数据密钥，轮换后只用新主密钥即可恢复
+func TestEncrypt_RotateKeys(t *testing.T) {
+	ctx := context.Background()
+	localDir, remoteDir := t.TempDir(), t.TempDir()
+	data := bytes.Repeat([]byte("confidential "), 100)
+	assert.NoError(t, os.MkdirAll(filepath.Join(localDir, "docs"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "docs", "a.txt"), data, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "b.txt"), []byte("b"), 0644))
+	oldKeyFile, newKeyFile := writeKeyFile(t), writeKeyFile(t)
+	s := newLocalStorage(t, localDir, remoteDir, withEncrypt(oldKeyFile))
+	assert.NoError(t, s.FPutObject(ctx, filepath.Join(localDir, "docs", "a.txt")))
+	assert.NoError(t, s.FPutObject(ctx, filepath.Join(localDir, "b.txt")))
+	before, err := s.Backend.StatObject(ctx, "backup/docs/a.txt")
+	assert.NoError(t, err)
+
+	// 未配置原主密钥时无法解包
+	rotated := newLocalStorage(t, localDir, remoteDir, withEncrypt(newKeyFile))
+	n, err := rotated.RotateKeys(ctx)
+	assert.Error(t, err)
+	assert.Equal(t, 0, n)
+
+	rotated = newLocalStorage(t, localDir, remoteDir, withEncrypt(newKeyFile, oldKeyFile))
+	rotated.DryRun = true
+	n, err = rotated.RotateKeys(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	rotated.DryRun = false
+	n, err = rotated.RotateKeys(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = rotated.RotateKeys(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	after, err := s.Backend.StatObject(ctx, "backup/docs/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, before.ETag, after.ETag)
+	assert.Equal(t, rotated.Encrypt.KeyID, after.Metadata[metaKeyID])
+	assert.NotEqual(t, before.Metadata[metaWrappedKey], after.Metadata[metaWrappedKey])
+	assert.True(t, rotated.IsSameV2(ctx, filepath.Join(localDir, "docs", "a.txt"), ""))
+
+	// 只使用新主密钥恢复，未启用加密时恢复失败
+	restoreDir := t.TempDir()
+	target := newLocalStorage(t, restoreDir, remoteDir, withEncrypt(newKeyFile))
+	r := &Restore{LocalPrefix: restoreDir, SymLink: enum.SymlinkAddr, IgnoreMatcher: helper.NewIgnoreMatcher(nil), Storage: target}
+	assert.NoError(t, r.Run(ctx))
+	content, err := os.ReadFile(filepath.Join(restoreDir, "docs", "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, data, content)
+
+	target = newLocalStorage(t, t.TempDir(), remoteDir)
+	r = &Restore{LocalPrefix: target.LocalPrefix, SymLink: enum.SymlinkAddr, IgnoreMatcher: helper.NewIgnoreMatcher(nil), Storage: target}
+	assert.Error(t, r.Run(ctx))
+	assert.NoFileExists(t, filepath.Join(target.LocalPrefix, "b.txt"))
+}
+
+// TestS3Backend_ReplaceMetadata 测试S3后端通过复制到自身替换用户元数据
+func TestS3Backend_ReplaceMetadata(t *testing.T) {
+	ctx := context.Background()
+	mockClient := new(mocks.MockObjectStorageClient)
+	metadata := map[string]string{metaKeyID: "new"}
+	mockClient.On("CopyObject", ctx, minio.CopyDestOptions{Bucket: "test-bucket", Object: "remote/a.txt",
+		ReplaceMetadata: true, UserMetadata: metadata}, minio.CopySrcOptions{Bucket: "test-bucket", Object: "remote/a.txt"}).
+		Return(minio.UploadInfo{}, nil)
+	mockClient.On("ComposeObject", ctx, minio.CopyDestOptions{Bucket: "test-bucket", Object: "remote/big.bin",
+		ReplaceMetadata: true, UserMetadata: metadata}, mock.Anything).Return(minio.UploadInfo{}, nil)
+
+	b := NewS3Backend(mockClient, "test-bucket")
+	assert.NoError(t, b.ReplaceMetadata(ctx, "remote/a.txt", 10, metadata))
+	assert.NoError(t, b.ReplaceMetadata(ctx, "remote/big.bin", maxCopySize+1, metadata))
+	mockClient.AssertExpectations(t)
+}
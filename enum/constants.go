@@ -9,3 +9,69 @@ const (
 	// SymlinkFile 复制目标文件
 	SymlinkFile string = "file"
 )
+
+// Upload 上传方式
+const (
+	// UploadStream 直接从源文件流式上传，上传前后校验文件未发生变化
+	UploadStream string = "stream"
+	// UploadCopy 先复制到临时文件再上传
+	UploadCopy string = "copy"
+)
+
+// Compress 压缩算法
+const (
+	// CompressZstd zstd压缩，速度和压缩率较好
+	CompressZstd string = "zstd"
+	// CompressGzip gzip压缩，兼容性更好，可直接用常见工具解压
+	CompressGzip string = "gzip"
+)
+
+// Encrypt 加密算法
+const (
+	// EncryptAES256GCM 按64KiB分段的AES-256-GCM流式加密
+	EncryptAES256GCM string = "aes-256-gcm"
+)
+
+// Diff 本地与远端内容不一致的原因
+const (
+	// DiffNew 远端不存在
+	DiffNew string = "new"
+	// DiffETag 内容MD5与远端ETag不一致
+	DiffETag string = "changed ETag"
+	// DiffMultipart 分片对象的大小或修改时间不一致
+	DiffMultipart string = "multipart size/mtime mismatch"
+	// DiffChecksum 分片对象按分片规则计算的ETag不一致
+	DiffChecksum string = "multipart checksum mismatch"
+	// DiffModTime ETag不是内容MD5的存储后端（如WebDAV），文件大小或修改时间不一致
+	DiffModTime string = "size/mtime mismatch"
+	// DiffManifest CAS模式下大小、修改时间、权限或链接地址与清单不一致
+	DiffManifest string = "manifest mismatch"
+	// DiffSha256 CAS模式下内容SHA256与清单不一致
+	DiffSha256 string = "changed SHA256"
+	// DiffChunks 分块模式下按内容切分的数据块与远端块列表不一致
+	DiffChunks string = "changed chunks"
+	// DiffOriginalMd5 压缩或加密上传的对象，元数据记录的原始内容MD5不一致
+	DiffOriginalMd5 string = "changed original MD5"
+	// DiffNotEncrypted 启用加密后，远端对象未加密
+	DiffNotEncrypted string = "not encrypted"
+)
+
+// Backend 远端存储后端类型
+const (
+	// BackendS3 S3兼容对象存储
+	BackendS3 string = "s3"
+	// BackendLocal 本地或挂载目录
+	BackendLocal string = "local"
+	// BackendWebDAV WebDAV服务（如Nextcloud）
+	BackendWebDAV string = "webdav"
+)
+
+// Op 传输操作类型
+const (
+	// OpPut 上传
+	OpPut string = "put"
+	// OpDelete 删除
+	OpDelete string = "delete"
+	// OpRename 重命名，服务端复制到新路径后删除旧路径
+	OpRename string = "rename"
+)
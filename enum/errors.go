@@ -3,3 +3,6 @@ package enum
 import "errors"
 
 var ErrSkipTransfer = errors.New("skipped, it's not a error")
+
+// ErrDryRun 演练模式下仅记录将要执行的操作，并未实际执行
+var ErrDryRun = errors.New("dry-run, it's not a error")
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/log"
+)
+
+// FanOut 把同一个本地路径的变更分发给多个远端
+// 每个远端有独立的持久化队列，慢或失败的远端不会阻塞其他远端，本地变更按实时任务优先处理
+type FanOut struct {
+	PutChan    chan string
+	DeleteChan chan string
+	RenameChan chan Rename // 本地重命名，为nil时不处理
+	Targets    []*Transfer
+}
+
+// NewFanOut 创建变更分发实例
+func NewFanOut(putCh chan string, deleteCh chan string, targets ...*Transfer) *FanOut {
+	return &FanOut{
+		PutChan:    putCh,
+		DeleteChan: deleteCh,
+		Targets:    targets,
+	}
+}
+
+// Run 把变更写入每个远端的队列，直到输入队列全部关闭
+// 退出时不响应context取消，由调用方在变更来源全部退出后关闭输入队列，保证已产生的变更都被持久化
+func (f *FanOut) Run() {
+	putCh, deleteCh, renameCh := f.PutChan, f.DeleteChan, f.RenameChan
+	for putCh != nil || deleteCh != nil || renameCh != nil {
+		select {
+		case path, ok := <-putCh:
+			if !ok {
+				putCh = nil
+				continue
+			}
+			f.dispatch(enum.OpPut, path)
+		case path, ok := <-deleteCh:
+			if !ok {
+				deleteCh = nil
+				continue
+			}
+			f.dispatch(enum.OpDelete, path)
+		case rename, ok := <-renameCh:
+			if !ok {
+				renameCh = nil
+				continue
+			}
+			f.dispatchRename(rename)
+		}
+	}
+	log.Debug("FanOut inputs closed, exiting...")
+}
+
+// dispatch 把变更写入每个远端的队列
+func (f *FanOut) dispatch(op, path string) {
+	for _, target := range f.Targets {
+		if err := target.Scheduler.Push(op, true, path); err != nil {
+			log.Errorf("Push queue err: %s, op: %s, path: %s, remote: %s", err.Error(), op, path, target.Name)
+		}
+	}
+}
+
+// dispatchRename 把重命名写入每个远端的队列
+func (f *FanOut) dispatchRename(rename Rename) {
+	for _, target := range f.Targets {
+		if err := target.Scheduler.PushRename(rename.From, rename.To); err != nil {
+			log.Errorf("Push queue err: %s, op: %s, path: %s -> %s, remote: %s", err.Error(), enum.OpRename,
+				rename.From, rename.To, target.Name)
+		}
+	}
+}
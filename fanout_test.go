@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/state"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestFanOut_Run 测试变更分发到每个远端
+func TestFanOut_Run(t *testing.T) {
+	putCh := make(chan string)
+	deleteCh := make(chan string)
+	first := &Transfer{Name: "first", Scheduler: newTestScheduler(t)}
+	second := &Transfer{Name: "second", Scheduler: newTestScheduler(t)}
+
+	renameCh := make(chan Rename)
+	f := NewFanOut(putCh, deleteCh, first, second)
+	f.RenameChan = renameCh
+	done := make(chan struct{})
+	go func() {
+		f.Run()
+		close(done)
+	}()
+
+	putCh <- "/data/a.txt"
+	putCh <- "/data/b.txt"
+	deleteCh <- "/data/c.txt"
+	renameCh <- Rename{From: "/data/d", To: "/data/e"}
+
+	// 输入队列全部关闭后退出
+	close(putCh)
+	select {
+	case <-done:
+		t.Fatal("FanOut 在输入队列未全部关闭时退出")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(deleteCh)
+	close(renameCh)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("FanOut 未能在输入关闭后退出")
+	}
+
+	// 每个远端按顺序收到全部变更，本地变更按实时任务调度
+	want := []state.QueueItem{
+		{Op: enum.OpPut, Path: "/data/a.txt", Priority: PriorityRealtime},
+		{Op: enum.OpPut, Path: "/data/b.txt", Priority: PriorityRealtime},
+		{Op: enum.OpDelete, Path: "/data/c.txt", Priority: PriorityRealtime},
+		{Op: enum.OpRename, Path: "/data/e", From: "/data/d", Priority: PriorityRealtime},
+	}
+	assert.Equal(t, want, queueItems(t, first.Scheduler))
+	assert.Equal(t, want, queueItems(t, second.Scheduler))
+}
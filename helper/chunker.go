@@ -0,0 +1,258 @@
+package helper
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gearTable FastCDC 滚动哈希使用的随机表，由固定种子生成，切分边界在不同版本之间保持一致
+var gearTable = func() (table [256]uint64) {
+	// splitmix64
+	seed := uint64(0x726f732d63646300)
+	for i := range table {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// Chunk 按内容切分的数据块
+type Chunk struct {
+	Hash string `json:"hash"` // 内容的SHA256
+	Size int64  `json:"size"`
+}
+
+// Chunker 基于 FastCDC 的内容定义分块，块的边界由内容决定，文件中间插入或删除数据只影响附近的块
+// 块大小在平均大小的1/4到4倍之间，使用归一化分块使块大小集中在平均大小附近
+type Chunker struct {
+	reader  io.Reader
+	buf     []byte
+	start   int
+	end     int
+	eof     bool
+	minSize int
+	avgSize int
+	maxSize int
+	maskS   uint64 // 未达到平均大小时使用更多的位，降低切分概率
+	maskL   uint64 // 超过平均大小后使用更少的位，提高切分概率
+}
+
+// NewChunker 创建分块实例，avgSize 为平均块大小，需为2的幂
+func NewChunker(reader io.Reader, avgSize int) (*Chunker, error) {
+	if avgSize < 256 || avgSize&(avgSize-1) != 0 {
+		return nil, errors.New("average chunk size must be a power of two and at least 256")
+	}
+	n := bits.TrailingZeros(uint(avgSize))
+	return &Chunker{
+		reader:  reader,
+		buf:     make([]byte, avgSize*8),
+		minSize: avgSize / 4,
+		avgSize: avgSize,
+		maxSize: avgSize * 4,
+		// 滚动哈希每次左移一位，高位包含更多字节的信息，使用高位判断边界
+		maskS: ^uint64(0) << (64 - n - 1),
+		maskL: ^uint64(0) << (64 - n + 1),
+	}, nil
+}
+
+// Next 返回下一个块，内容在下次调用前有效，读取完毕时返回 io.EOF
+func (c *Chunker) Next() ([]byte, error) {
+	// 剩余数据不足一个最大块时再读取，减少数据移动
+	if c.end-c.start < c.maxSize && !c.eof {
+		n := copy(c.buf, c.buf[c.start:c.end])
+		c.start, c.end = 0, n
+		for c.end < len(c.buf) && !c.eof {
+			m, err := c.reader.Read(c.buf[c.end:])
+			c.end += m
+			if err == io.EOF {
+				c.eof = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// cut 计算数据中第一个块的长度
+func (c *Chunker) cut(data []byte) int {
+	n := min(len(data), c.maxSize)
+	if n <= c.minSize {
+		return n
+	}
+	normal := min(n, c.avgSize)
+	var fp uint64
+	i := c.minSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gearTable[data[i]]
+		if fp&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// FileChunks 按内容切分文件，返回各块的SHA256和大小
+func FileChunks(path string, avgSize int) ([]Chunk, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	chunker, err := NewChunker(file, avgSize)
+	if err != nil {
+		return nil, err
+	}
+	var chunks []Chunk
+	for {
+		data, err := chunker.Next()
+		if err == io.EOF {
+			return chunks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, NewChunk(data))
+	}
+}
+
+// NewChunk 计算数据块的SHA256和大小
+func NewChunk(data []byte) Chunk {
+	sum := sha256.Sum256(data)
+	return Chunk{Hash: hex.EncodeToString(sum[:]), Size: int64(len(data))}
+}
+
+// chunkCacheSize 分块结果缓存的文件数上限，超过后淘汰最久未使用的文件
+const chunkCacheSize = 64
+
+// chunkCacheEntry 分块结果缓存条目
+type chunkCacheEntry struct {
+	path    string
+	chunks  []Chunk
+	avgSize int
+	modTime time.Time
+	size    int64
+	inode   uint64
+}
+
+// chunkLRU 按路径记录分块结果的LRU缓存，常驻进程监听大量大文件时内存有上限
+type chunkLRU struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // 最近使用的在前，元素为 *chunkCacheEntry
+	entries map[string]*list.Element
+}
+
+// newChunkLRU 创建最多记录size个文件的分块结果缓存
+func newChunkLRU(size int) *chunkLRU {
+	return &chunkLRU{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get 获取路径的缓存条目并标记为最近使用
+func (c *chunkLRU) get(path string) (*chunkCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[path]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*chunkCacheEntry), true
+}
+
+// set 记录路径的缓存条目，超过上限时淘汰最久未使用的条目
+func (c *chunkLRU) set(entry *chunkCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[entry.path]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[entry.path] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*chunkCacheEntry).path)
+	}
+}
+
+// remove 删除路径及其子路径的缓存条目
+func (c *chunkLRU) remove(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prefix := strings.TrimSuffix(path, string(filepath.Separator)) + string(filepath.Separator)
+	for name, element := range c.entries {
+		if name == path || strings.HasPrefix(name, prefix) {
+			c.order.Remove(element)
+			delete(c.entries, name)
+		}
+	}
+}
+
+// 全局分块结果缓存，按路径记录，文件的修改时间、大小和inode不变时复用
+var chunkCache = newChunkLRU(chunkCacheSize)
+
+// GetCachedFileChunks 获取带缓存的文件分块结果，比较与上传前后各需要一次时避免重复读取大文件
+func GetCachedFileChunks(path string, avgSize int) ([]Chunk, error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		chunkCache.remove(path)
+		return nil, err
+	}
+	if entry, ok := chunkCache.get(path); ok {
+		if entry.avgSize == avgSize && entry.modTime.Equal(fileInfo.ModTime()) && entry.size == fileInfo.Size() &&
+			entry.inode == Inode(fileInfo) {
+			return entry.chunks, nil
+		}
+		// 文件已被改写，先丢弃旧的分块结果，重新计算失败时也不再保留
+		chunkCache.remove(path)
+	}
+	chunks, err := FileChunks(path, avgSize)
+	if err != nil {
+		return nil, err
+	}
+	SetCachedFileChunks(path, avgSize, fileInfo, chunks)
+	return chunks, nil
+}
+
+// SetCachedFileChunks 记录上传时计算的分块结果，fileInfo 为计算前的文件信息
+func SetCachedFileChunks(path string, avgSize int, fileInfo os.FileInfo, chunks []Chunk) {
+	chunkCache.set(&chunkCacheEntry{
+		path:    path,
+		chunks:  chunks,
+		avgSize: avgSize,
+		modTime: fileInfo.ModTime(),
+		size:    fileInfo.Size(),
+		inode:   Inode(fileInfo),
+	})
+}
+
+// InvalidateFileChunks 删除文件或目录下全部文件的分块结果缓存，用于本地删除后释放内存
+func InvalidateFileChunks(path string) {
+	chunkCache.remove(path)
+}
@@ -0,0 +1,125 @@
+package helper
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// chunkAll 切分数据，返回各块的副本
+func chunkAll(t *testing.T, data []byte, avgSize int) [][]byte {
+	t.Helper()
+	chunker, err := NewChunker(bytes.NewReader(data), avgSize)
+	assert.NoError(t, err)
+	var chunks [][]byte
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		assert.NoError(t, err)
+		chunks = append(chunks, bytes.Clone(chunk))
+	}
+}
+
+// TestChunker 测试块大小范围、拼接后与原数据一致，以及插入数据只影响附近的块
+func TestChunker(t *testing.T) {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	avgSize := 8 * 1024
+
+	chunks := chunkAll(t, data, avgSize)
+	assert.Greater(t, len(chunks), 1<<20/avgSize/4)
+	assert.Equal(t, data, bytes.Join(chunks, nil))
+	for i, chunk := range chunks {
+		assert.LessOrEqual(t, len(chunk), avgSize*4)
+		if i < len(chunks)-1 {
+			assert.Greater(t, len(chunk), avgSize/4)
+		}
+	}
+
+	// 在中间插入数据，插入位置之外的块保持不变
+	modified := append(append(bytes.Clone(data[:500000]), []byte("inserted")...), data[500000:]...)
+	seen := make(map[string]bool, len(chunks))
+	for _, chunk := range chunks {
+		seen[string(chunk)] = true
+	}
+	var changed int
+	for _, chunk := range chunkAll(t, modified, avgSize) {
+		if !seen[string(chunk)] {
+			changed++
+		}
+	}
+	assert.LessOrEqual(t, changed, 3)
+
+	_, err := NewChunker(bytes.NewReader(data), 1000)
+	assert.Error(t, err)
+}
+
+// TestGetCachedFileChunks 测试文件分块结果与缓存
+func TestGetCachedFileChunks(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "data.bin")
+	data := make([]byte, 256*1024)
+	rand.New(rand.NewSource(2)).Read(data)
+	assert.NoError(t, os.WriteFile(file, data, 0644))
+
+	chunks, err := GetCachedFileChunks(file, 4096)
+	assert.NoError(t, err)
+	var size int64
+	for _, chunk := range chunks {
+		assert.Len(t, chunk.Hash, 64)
+		size += chunk.Size
+	}
+	assert.Equal(t, int64(len(data)), size)
+
+	cached, err := GetCachedFileChunks(file, 4096)
+	assert.NoError(t, err)
+	assert.Equal(t, chunks, cached)
+
+	// 平均块大小不同时重新计算
+	other, err := GetCachedFileChunks(file, 8192)
+	assert.NoError(t, err)
+	assert.NotEqual(t, len(chunks), len(other))
+
+	// 文件删除后不再保留缓存
+	InvalidateFileChunks(filepath.Dir(file))
+	_, ok := chunkCache.get(file)
+	assert.False(t, ok)
+}
+
+// TestChunkLRU 测试分块结果缓存超过上限时淘汰最久未使用的文件，删除目录时清理子路径
+func TestChunkLRU(t *testing.T) {
+	cache := newChunkLRU(2)
+	dir := filepath.Join("data", "dir")
+	for _, path := range []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")} {
+		cache.set(&chunkCacheEntry{path: path})
+	}
+	_, ok := cache.get(filepath.Join(dir, "a"))
+	assert.True(t, ok)
+	// b 最久未使用，被淘汰
+	cache.set(&chunkCacheEntry{path: filepath.Join("data", "c")})
+	_, ok = cache.get(filepath.Join(dir, "b"))
+	assert.False(t, ok)
+	assert.Equal(t, 2, cache.order.Len())
+
+	// 更新已有条目不增加数量
+	cache.set(&chunkCacheEntry{path: filepath.Join("data", "c"), avgSize: 1024})
+	entry, ok := cache.get(filepath.Join("data", "c"))
+	assert.True(t, ok)
+	assert.Equal(t, 1024, entry.avgSize)
+	assert.Equal(t, 2, cache.order.Len())
+
+	// 删除目录时清理子路径，不影响前缀相同的其他路径
+	cache.set(&chunkCacheEntry{path: dir + "x"})
+	cache.remove(dir)
+	_, ok = cache.get(filepath.Join(dir, "a"))
+	assert.False(t, ok)
+	_, ok = cache.get(dir + "x")
+	assert.True(t, ok)
+	assert.Len(t, cache.entries, cache.order.Len())
+}
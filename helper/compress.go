@@ -0,0 +1,38 @@
+package helper
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/klauspost/compress/zstd"
+)
+
+// NewCompressWriter 创建压缩写入器，写入的内容压缩后写入w，调用方需要Close以写入剩余的数据
+func NewCompressWriter(algorithm string, w io.Writer) (io.WriteCloser, error) {
+	switch algorithm {
+	case enum.CompressZstd:
+		return zstd.NewWriter(w)
+	case enum.CompressGzip:
+		return gzip.NewWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %s", algorithm)
+	}
+}
+
+// NewDecompressReader 创建解压读取器，调用方负责关闭
+func NewDecompressReader(algorithm string, r io.Reader) (io.ReadCloser, error) {
+	switch algorithm {
+	case enum.CompressZstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case enum.CompressGzip:
+		return gzip.NewReader(r)
+	default:
+		return nil, fmt.Errorf("unsupported compression %s", algorithm)
+	}
+}
@@ -0,0 +1,38 @@
+package helper
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCompress 测试各压缩算法压缩后能解压还原
+func TestCompress(t *testing.T) {
+	data := bytes.Repeat([]byte("2026-10-16 08:00:00 INFO request handled\n"), 1000)
+	for _, algorithm := range []string{enum.CompressZstd, enum.CompressGzip} {
+		t.Run(algorithm, func(t *testing.T) {
+			var buf bytes.Buffer
+			writer, err := NewCompressWriter(algorithm, &buf)
+			assert.NoError(t, err)
+			_, err = writer.Write(data)
+			assert.NoError(t, err)
+			assert.NoError(t, writer.Close())
+			assert.Less(t, buf.Len(), len(data)/10)
+
+			reader, err := NewDecompressReader(algorithm, &buf)
+			assert.NoError(t, err)
+			decompressed, err := io.ReadAll(reader)
+			assert.NoError(t, err)
+			assert.NoError(t, reader.Close())
+			assert.Equal(t, data, decompressed)
+		})
+	}
+
+	_, err := NewCompressWriter("lz4", io.Discard)
+	assert.Error(t, err)
+	_, err = NewDecompressReader("lz4", bytes.NewReader(nil))
+	assert.Error(t, err)
+}
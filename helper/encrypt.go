@@ -0,0 +1,237 @@
+package helper
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// KeySize 主密钥和数据密钥的长度，使用AES-256
+	KeySize = 32
+	// encryptSegmentSize 流式加密的明文分段大小，每段单独加密并带有认证标签
+	encryptSegmentSize = 64 * 1024
+)
+
+// LoadKey 从文件或环境变量读取主密钥，优先使用文件
+// 密钥为32字节，使用hex或base64编码，如 openssl rand -hex 32 生成的内容
+func LoadKey(file, env string) ([]byte, error) {
+	var text string
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	} else {
+		text = os.Getenv(env)
+		if text == "" {
+			return nil, fmt.Errorf("environment variable %s is empty", env)
+		}
+	}
+	text = strings.TrimSpace(text)
+	if key, err := hex.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, errors.New("master key must be 32 bytes encoded as hex or base64")
+}
+
+// KeyID 获取主密钥的标识，为密钥SHA256的前8字节，用于区分轮换前后的主密钥
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// NewDataKey 生成随机的数据密钥
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// WrapKey 使用主密钥加密数据密钥，返回随机nonce与密文拼接后的base64编码
+func WrapKey(masterKey, dataKey []byte) (string, error) {
+	aead, err := newGCM(masterKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, dataKey, nil)), nil
+}
+
+// UnwrapKey 使用主密钥解密 WrapKey 加密的数据密钥
+func UnwrapKey(masterKey []byte, wrapped string) ([]byte, error) {
+	aead, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil || len(data) < aead.NonceSize() {
+		return nil, errors.New("invalid wrapped key")
+	}
+	dataKey, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("unwrap data key failed, master key mismatch")
+	}
+	return dataKey, nil
+}
+
+// encryptWriter 按固定大小分段加密写入，每个数据密钥只用于一个对象，nonce由分段序号和是否最后一段组成
+type encryptWriter struct {
+	aead    cipher.AEAD
+	w       io.Writer
+	buf     []byte
+	sealed  []byte
+	counter uint64
+}
+
+// NewEncryptWriter 创建加密写入器，写入的内容加密后写入w，调用方需要Close以写入最后一段
+func NewEncryptWriter(key []byte, w io.Writer) (io.WriteCloser, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		aead:   aead,
+		w:      w,
+		buf:    make([]byte, 0, encryptSegmentSize),
+		sealed: make([]byte, 0, encryptSegmentSize+aead.Overhead()),
+	}, nil
+}
+
+// Write 缓存到一个完整的分段，有后续数据时才加密写出，保证最后一段在Close时标记
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(e.buf) == encryptSegmentSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):encryptSegmentSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close 加密写出最后一段，空内容也会写出一个只有认证标签的分段
+func (e *encryptWriter) Close() error {
+	return e.seal(true)
+}
+
+// seal 加密当前分段并写出
+func (e *encryptWriter) seal(last bool) error {
+	e.sealed = e.aead.Seal(e.sealed[:0], segmentNonce(e.counter, last), e.buf, nil)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(e.sealed)
+	return err
+}
+
+// decryptReader 按分段解密读取，分段被篡改、重排或截断时返回错误
+type decryptReader struct {
+	aead    cipher.AEAD
+	r       *bufio.Reader
+	segment []byte
+	buf     []byte
+	counter uint64
+	done    bool
+}
+
+// NewDecryptReader 创建解密读取器，读取 NewEncryptWriter 写入的内容
+func NewDecryptReader(key []byte, r io.Reader) (io.Reader, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{
+		aead:    aead,
+		r:       bufio.NewReader(r),
+		segment: make([]byte, encryptSegmentSize+aead.Overhead()),
+	}, nil
+}
+
+// Read 读取解密后的内容
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// next 读取并解密下一个分段，不足一个分段或之后没有数据的是最后一段
+func (d *decryptReader) next() error {
+	n, err := io.ReadFull(d.r, d.segment)
+	last := false
+	switch {
+	case err == io.EOF:
+		return errors.New("encrypted content is truncated")
+	case err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		if _, err = d.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	plaintext, err := d.aead.Open(d.segment[:0], segmentNonce(d.counter, last), d.segment[:n], nil)
+	if err != nil {
+		return fmt.Errorf("decrypt segment %d failed, content is corrupted or truncated", d.counter)
+	}
+	d.counter++
+	d.buf = plaintext
+	d.done = last
+	return nil
+}
+
+// segmentNonce 分段的nonce，前8字节为分段序号，最后1字节标记是否最后一段，防止截断
+func segmentNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce, counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// newGCM 创建AES-256-GCM实例
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
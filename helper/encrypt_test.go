@@ -0,0 +1,130 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// encrypt 加密数据，返回密文
+func encrypt(t *testing.T, key, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer, err := NewEncryptWriter(key, &buf)
+	assert.NoError(t, err)
+	// 分多次写入，覆盖跨分段的写入
+	for len(data) > 0 {
+		n := min(len(data), 10000)
+		_, err = writer.Write(data[:n])
+		assert.NoError(t, err)
+		data = data[n:]
+	}
+	assert.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+// decrypt 解密数据
+func decrypt(key, data []byte) ([]byte, error) {
+	reader, err := NewDecryptReader(key, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+// TestEncrypt 测试分段加密后能解密还原，密钥错误、内容被篡改或截断时解密失败
+func TestEncrypt(t *testing.T) {
+	key, err := NewDataKey()
+	assert.NoError(t, err)
+	for _, size := range []int{0, 1, encryptSegmentSize, encryptSegmentSize + 1, 3*encryptSegmentSize + 100} {
+		data := make([]byte, size)
+		rand.New(rand.NewSource(int64(size))).Read(data)
+		encrypted := encrypt(t, key, data)
+		segments := max(1, (size+encryptSegmentSize-1)/encryptSegmentSize)
+		assert.Len(t, encrypted, size+segments*16)
+		decrypted, err := decrypt(key, encrypted)
+		assert.NoError(t, err)
+		assert.Equal(t, data, decrypted)
+	}
+
+	data := make([]byte, 2*encryptSegmentSize+100)
+	rand.New(rand.NewSource(1)).Read(data)
+	encrypted := encrypt(t, key, data)
+	other, err := NewDataKey()
+	assert.NoError(t, err)
+	_, err = decrypt(other, encrypted)
+	assert.Error(t, err)
+
+	tampered := bytes.Clone(encrypted)
+	tampered[100] ^= 1
+	_, err = decrypt(key, tampered)
+	assert.Error(t, err)
+
+	// 去掉最后一段，或在分段边界截断
+	_, err = decrypt(key, encrypted[:2*(encryptSegmentSize+16)])
+	assert.Error(t, err)
+	_, err = decrypt(key, encrypted[:encryptSegmentSize+16])
+	assert.Error(t, err)
+	_, err = decrypt(key, nil)
+	assert.Error(t, err)
+
+	_, err = NewEncryptWriter(key[:16], io.Discard)
+	assert.Error(t, err)
+}
+
+// TestWrapKey 测试主密钥包装数据密钥
+func TestWrapKey(t *testing.T) {
+	masterKey, err := NewDataKey()
+	assert.NoError(t, err)
+	dataKey, err := NewDataKey()
+	assert.NoError(t, err)
+
+	wrapped, err := WrapKey(masterKey, dataKey)
+	assert.NoError(t, err)
+	unwrapped, err := UnwrapKey(masterKey, wrapped)
+	assert.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	other, err := NewDataKey()
+	assert.NoError(t, err)
+	_, err = UnwrapKey(other, wrapped)
+	assert.Error(t, err)
+	_, err = UnwrapKey(masterKey, "invalid")
+	assert.Error(t, err)
+	assert.Len(t, KeyID(masterKey), 16)
+	assert.NotEqual(t, KeyID(masterKey), KeyID(other))
+}
+
+// TestLoadKey 测试从文件和环境变量读取主密钥
+func TestLoadKey(t *testing.T) {
+	key, err := NewDataKey()
+	assert.NoError(t, err)
+	dir := t.TempDir()
+
+	hexFile := filepath.Join(dir, "hex.key")
+	assert.NoError(t, os.WriteFile(hexFile, []byte(hex.EncodeToString(key)+"\n"), 0600))
+	loaded, err := LoadKey(hexFile, "")
+	assert.NoError(t, err)
+	assert.Equal(t, key, loaded)
+
+	t.Setenv("ROS_TEST_KEY", base64.StdEncoding.EncodeToString(key))
+	loaded, err = LoadKey("", "ROS_TEST_KEY")
+	assert.NoError(t, err)
+	assert.Equal(t, key, loaded)
+
+	_, err = LoadKey("", "ROS_TEST_KEY_MISSING")
+	assert.Error(t, err)
+	_, err = LoadKey(filepath.Join(dir, "missing.key"), "")
+	assert.Error(t, err)
+	shortFile := filepath.Join(dir, "short.key")
+	assert.NoError(t, os.WriteFile(shortFile, []byte(hex.EncodeToString(key[:16])), 0600))
+	_, err = LoadKey(shortFile, "")
+	assert.Error(t, err)
+}
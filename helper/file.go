@@ -4,6 +4,7 @@ import (
 	"crypto/md5"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -82,6 +83,9 @@ func IsIgnore(path string, ignoreList []string) bool {
 	return false
 }
 
+// ErrReflinkUnsupported 当前平台不支持reflink
+var ErrReflinkUnsupported = errors.New("reflink is not supported on this platform")
+
 // Copy 拷贝文件
 func Copy(src, dst string) (written int64, err error) {
 	srcFile, err := os.Open(src)
@@ -102,6 +106,20 @@ func Copy(src, dst string) (written int64, err error) {
 	return written, nil
 }
 
+// CloneOrCopy 优先通过reflink克隆文件，不支持时（文件系统不支持或不在同一文件系统）回退为逐字节复制
+// 返回文件大小以及是否通过reflink完成
+func CloneOrCopy(src, dst string) (written int64, cloned bool, err error) {
+	if err = Reflink(src, dst); err == nil {
+		fileInfo, err := os.Stat(dst)
+		if err != nil {
+			return 0, true, err
+		}
+		return fileInfo.Size(), true, nil
+	}
+	written, err = Copy(src, dst)
+	return written, false, err
+}
+
 // FileMd5 计算文件的MD5
 func FileMd5(path string) (string, error) {
 	// 打开文件
@@ -150,6 +168,39 @@ func FileSha256(path string) (string, error) {
 	return fmt.Sprintf("%x", sum), nil
 }
 
+// FileMultipartMd5 按分片上传的规则计算文件的ETag
+// 即各分片MD5值拼接后再计算MD5，并以"-分片数量"结尾
+func FileMultipartMd5(path string, partSize int64) (string, error) {
+	if partSize <= 0 {
+		return "", fmt.Errorf("invalid part size %d", partSize)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var sums []byte
+	parts := 0
+	for {
+		hash := md5.New()
+		written, err := io.CopyN(hash, file, partSize)
+		if written > 0 {
+			sums = append(sums, hash.Sum(nil)...)
+			parts++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	sum := md5.Sum(sums)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), parts), nil
+}
+
 // ByteFormat 将字节为单位的大小转换为易读的字符串格式
 func ByteFormat(b int64) string {
 	const unit = 1024
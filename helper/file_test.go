@@ -1,8 +1,11 @@
 package helper
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -234,6 +237,44 @@ func TestFileMd5(t *testing.T) {
 	})
 }
 
+// TestFileMultipartMd5 测试按分片规则计算 ETag
+func TestFileMultipartMd5(t *testing.T) {
+	tmpDir := t.TempDir()
+	file := filepath.Join(tmpDir, "test.txt")
+	err := os.WriteFile(file, []byte("hello world"), 0644)
+	assert.NoError(t, err)
+
+	t.Run("多个分片", func(t *testing.T) {
+		etag, err := FileMultipartMd5(file, 5)
+		assert.NoError(t, err)
+		// 分片 "hello", " worl", "d" 的 MD5 拼接后再计算 MD5
+		sums := md5.Sum([]byte("hello"))
+		parts := append([]byte{}, sums[:]...)
+		sums = md5.Sum([]byte(" worl"))
+		parts = append(parts, sums[:]...)
+		sums = md5.Sum([]byte("d"))
+		parts = append(parts, sums[:]...)
+		expected := md5.Sum(parts)
+		assert.Equal(t, hex.EncodeToString(expected[:])+"-3", etag)
+	})
+
+	t.Run("文件大小是分片大小的整数倍", func(t *testing.T) {
+		etag, err := FileMultipartMd5(file, 11)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasSuffix(etag, "-1"))
+	})
+
+	t.Run("分片大小无效", func(t *testing.T) {
+		_, err := FileMultipartMd5(file, 0)
+		assert.Error(t, err)
+	})
+
+	t.Run("文件不存在", func(t *testing.T) {
+		_, err := FileMultipartMd5(filepath.Join(tmpDir, "nonexistent.txt"), 5)
+		assert.Error(t, err)
+	})
+}
+
 // TestFileSha256 测试文件 SHA256 计算
 func TestFileSha256(t *testing.T) {
 	tmpDir := t.TempDir()
@@ -277,3 +318,28 @@ func TestByteFormat(t *testing.T) {
 		})
 	}
 }
+
+// TestCloneOrCopy 测试克隆或复制文件，不支持reflink的文件系统回退为逐字节复制
+func TestCloneOrCopy(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "src.bin")
+	dst := filepath.Join(tmpDir, "dst.bin")
+	assert.NoError(t, os.WriteFile(src, []byte("clone me"), 0644))
+
+	written, _, err := CloneOrCopy(src, dst)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), written)
+	content, err := os.ReadFile(dst)
+	assert.NoError(t, err)
+	assert.Equal(t, "clone me", string(content))
+
+	// 修改副本不影响源文件
+	assert.NoError(t, os.WriteFile(dst, []byte("changed"), 0644))
+	content, err = os.ReadFile(src)
+	assert.NoError(t, err)
+	assert.Equal(t, "clone me", string(content))
+
+	_, _, err = CloneOrCopy(filepath.Join(tmpDir, "missing"), filepath.Join(tmpDir, "out"))
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(tmpDir, "out"))
+}
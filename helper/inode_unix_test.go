@@ -0,0 +1,33 @@
+//go:build !windows
+
+package helper
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestInode 测试重命名覆盖后inode发生变化
+func TestInode(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "a.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("a"), 0644))
+	before, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.NotZero(t, Inode(before))
+
+	again, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, Inode(before), Inode(again))
+
+	// 编辑器保存时常见的先写临时文件再重命名
+	tmp := filepath.Join(tmpDir, "a.txt.tmp")
+	assert.NoError(t, os.WriteFile(tmp, []byte("b"), 0644))
+	assert.NoError(t, os.Rename(tmp, path))
+	after, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.NotEqual(t, Inode(before), Inode(after))
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package helper
+
+import "os"
+
+// Inode Windows下 os.FileInfo 不包含文件编号，始终返回0，只比较大小和修改时间
+func Inode(fileInfo os.FileInfo) uint64 {
+	return 0
+}
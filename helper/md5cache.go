@@ -11,6 +11,14 @@ type md5CacheEntry struct {
 	md5     string
 	modTime time.Time
 	size    int64
+	inode   uint64
+}
+
+// Md5Entry 缓存中记录的文件摘要，用于按内容查找被重命名的文件
+type Md5Entry struct {
+	Md5   string
+	Size  int64
+	Inode uint64 // 无法获取时为0
 }
 
 // MD5Cache 基于文件路径、修改时间和大小的 MD5 缓存
@@ -29,6 +37,11 @@ func GetCachedFileMd5(path string) (string, error) {
 	return globalMD5Cache.GetMd5(path)
 }
 
+// LookupMd5Cache 获取指定路径在全局缓存中的记录，不访问文件
+func LookupMd5Cache(path string) (Md5Entry, bool) {
+	return globalMD5Cache.Lookup(path)
+}
+
 // InvalidateMd5Cache 使指定路径的 MD5 缓存失效
 func InvalidateMd5Cache(path string) {
 	globalMD5Cache.Invalidate(path)
@@ -65,11 +78,22 @@ func (c *MD5Cache) GetMd5(path string) (string, error) {
 		md5:     md5,
 		modTime: modTime,
 		size:    size,
+		inode:   Inode(fileInfo),
 	})
 
 	return md5, nil
 }
 
+// Lookup 获取指定路径上次计算时记录的摘要，不访问文件，文件已被删除或重命名时仍可按旧路径查找
+func (c *MD5Cache) Lookup(path string) (Md5Entry, bool) {
+	value, ok := c.cache.Load(path)
+	if !ok {
+		return Md5Entry{}, false
+	}
+	entry := value.(*md5CacheEntry)
+	return Md5Entry{Md5: entry.md5, Size: entry.size, Inode: entry.inode}, true
+}
+
 // Invalidate 使指定路径的缓存失效
 func (c *MD5Cache) Invalidate(path string) {
 	c.cache.Delete(path)
@@ -140,6 +140,28 @@ func TestMD5Cache_Invalidate(t *testing.T) {
 	cache.Invalidate("nonexistent")
 }
 
+// TestMD5Cache_Lookup 测试文件重命名后按旧路径查找缓存的摘要
+func TestMD5Cache_Lookup(t *testing.T) {
+	cache := &MD5Cache{}
+	tmpDir := t.TempDir()
+
+	file := filepath.Join(tmpDir, "file.txt")
+	err := os.WriteFile(file, []byte("hello"), 0644)
+	assert.NoError(t, err)
+	_, ok := cache.Lookup(file)
+	assert.False(t, ok)
+
+	_, err = cache.GetMd5(file)
+	assert.NoError(t, err)
+	fileInfo, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Rename(file, filepath.Join(tmpDir, "renamed.txt")))
+
+	entry, ok := cache.Lookup(file)
+	assert.True(t, ok)
+	assert.Equal(t, Md5Entry{Md5: "5d41402abc4b2a76b9719d911017c592", Size: 5, Inode: Inode(fileInfo)}, entry)
+}
+
 // TestMD5Cache_Clear 测试清空所有缓存
 func TestMD5Cache_Clear(t *testing.T) {
 	cache := &MD5Cache{}
@@ -0,0 +1,47 @@
+package helper
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// maxRateBurst 令牌桶的最大突发字节数，每次读取不超过该大小，避免带宽出现尖峰
+const maxRateBurst = 64 * 1024
+
+// NewRateLimiter 创建按字节计数的令牌桶限速器，bytesPerSecond 不大于0时返回nil表示不限速
+func NewRateLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(min(bytesPerSecond, maxRateBurst)))
+}
+
+// rateLimitedReader 从令牌桶取得与读取字节数相同的令牌后才返回
+type rateLimitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *rate.Limiter
+}
+
+// NewRateLimitedReader 创建限速的Reader，多个Reader共享同一个限速器时限制的是总带宽，limiter 为nil时不限速
+func NewRateLimitedReader(ctx context.Context, reader io.Reader, limiter *rate.Limiter) io.Reader {
+	if limiter == nil {
+		return reader
+	}
+	return &rateLimitedReader{ctx: ctx, reader: reader, limiter: limiter}
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > r.limiter.Burst() {
+		p = p[:r.limiter.Burst()]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,49 @@
+package helper
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNewRateLimiter 测试创建限速器
+func TestNewRateLimiter(t *testing.T) {
+	assert.Nil(t, NewRateLimiter(0))
+	assert.Nil(t, NewRateLimiter(-1))
+	assert.Equal(t, 1000, NewRateLimiter(1000).Burst())
+	assert.Equal(t, maxRateBurst, NewRateLimiter(100*1024*1024).Burst())
+}
+
+// TestNewRateLimitedReader 测试限速读取
+func TestNewRateLimitedReader(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("不限速时返回原Reader", func(t *testing.T) {
+		reader := strings.NewReader("data")
+		assert.Same(t, reader, NewRateLimitedReader(ctx, reader, nil))
+	})
+
+	t.Run("按速率读取", func(t *testing.T) {
+		// 桶内初始有1000个令牌，读取1500字节需要再等待约0.5秒
+		limiter := NewRateLimiter(1000)
+		data := bytes.Repeat([]byte("a"), 1500)
+		start := time.Now()
+		buf, err := io.ReadAll(NewRateLimitedReader(ctx, bytes.NewReader(data), limiter))
+		assert.NoError(t, err)
+		assert.Equal(t, data, buf)
+		assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+	})
+
+	t.Run("取消后返回错误", func(t *testing.T) {
+		limiter := NewRateLimiter(10)
+		cancelCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := io.ReadAll(NewRateLimitedReader(cancelCtx, strings.NewReader(strings.Repeat("a", 100)), limiter))
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
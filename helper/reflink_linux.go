@@ -0,0 +1,30 @@
+//go:build linux
+
+package helper
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Reflink 通过 FICLONE 创建写时复制的克隆文件，只复制元数据不复制数据块
+// 需要文件系统支持（如btrfs、XFS）且源文件和目标文件在同一文件系统，失败时不保留目标文件
+func Reflink(src, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err = unix.IoctlFileClone(int(dstFile.Fd()), int(srcFile.Fd())); err != nil {
+		_ = dstFile.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	return dstFile.Close()
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package helper
+
+// Reflink 当前平台不支持 FICLONE
+func Reflink(src, dst string) error {
+	return ErrReflinkUnsupported
+}
@@ -10,14 +10,34 @@ import (
 	"syscall"
 
 	"github.com/jorben/rsync-object-storage/config"
+	"github.com/jorben/rsync-object-storage/helper"
 	"github.com/jorben/rsync-object-storage/kv"
 	"github.com/jorben/rsync-object-storage/log"
+	"github.com/jorben/rsync-object-storage/state"
 )
 
 //const VERSION = "1.0.0"
 
 func main() {
 
+	// 子命令分发，未指定子命令时以常驻进程方式运行同步服务
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "restore":
+			os.Exit(restoreCommand(os.Args[2:]))
+		case "sync":
+			os.Exit(syncCommand(os.Args[2:]))
+		case "snapshots":
+			os.Exit(snapshotsCommand(os.Args[2:]))
+		case "trash":
+			os.Exit(trashCommand(os.Args[2:]))
+		case "deadletter":
+			os.Exit(deadletterCommand(os.Args[2:]))
+		case "key":
+			os.Exit(keyCommand(os.Args[2:]))
+		}
+	}
+
 	configPath := flag.String("c", "./config.yaml", "Path to the configuration file")
 	flag.Parse()
 
@@ -38,63 +58,182 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Channel 缓冲大小优化：根据 Worker 数量调整，避免生产者阻塞
-	// PutChan: 8 Workers * 32 = 256，足够处理批量文件变更
-	// DeleteChan: 删除操作较少但可能批量，设置为 64
-	PutChan := make(chan string, 256)
-	DeleteChan := make(chan string, 64)
+	// 使用WaitGroup等待所有goroutine退出，变更来源（Watcher、Puller、CheckJob）先于消费者退出
+	var producers sync.WaitGroup
+	var wg sync.WaitGroup
 
-	// 检查本地路径可读性
-	if _, err = os.ReadDir(c.Local.Path); err != nil {
-		log.Fatalf("ReadDir err: %s", err.Error())
+	// 每个远端使用独立的队列和Transfer worker，多个远端平分worker数量
+	jobs := c.GetJobs()
+	destinationCount := 0
+	for _, job := range jobs {
+		destinationCount += len(job.GetDestinations())
 	}
+	workers := max(1, c.Transfer.Workers/destinationCount)
+	largeWorkers := min(workers, max(1, c.Transfer.Schedule.LargeWorkers/destinationCount))
+	// 全部远端共享上传限速器，限制的是总带宽
+	limiter := helper.NewRateLimiter(c.Transfer.BandwidthLimit)
 
-	// 检查对象存储桶是否存在
-	s, err := NewStorage(c)
-	if err != nil {
-		log.Fatalf("NewStorage err: %s", err.Error())
-	}
+	var watchers []*Watcher
+	var stores []*state.Store
+	var journals []*state.Journal
+	var inputs []chan string
+	var renames []chan Rename
+	var transfers []*Transfer
+	var manifests []*Storage
+	for _, job := range jobs {
+		// 检查本地路径可读性
+		if _, err = os.ReadDir(job.Local.Path); err != nil {
+			log.Fatalf("ReadDir err: %s", err.Error())
+		}
 
-	if err = s.BucketExists(ctx); err != nil {
-		log.Fatalf("BucketExist err: %s", err.Error())
-	}
+		// 本地变更的输入队列，由FanOut写入每个远端的持久化队列
+		// Channel 缓冲大小优化：根据 Worker 数量调整，避免生产者阻塞
+		// PutChan: 每个 Worker 32，默认 8 Workers * 32 = 256，足够处理批量文件变更
+		// DeleteChan: 删除操作较少但可能批量，每个 Worker 8，默认为 64
+		// RenameChan: 配对成功的重命名，与删除相同
+		PutChan := make(chan string, c.Transfer.Workers*32)
+		DeleteChan := make(chan string, c.Transfer.Workers*8)
+		RenameChan := make(chan Rename, c.Transfer.Workers*8)
+		inputs = append(inputs, PutChan, DeleteChan)
+		renames = append(renames, RenameChan)
 
-	// 创建Watcher实例
-	w, err := NewWatcher(c, PutChan, DeleteChan)
-	if err != nil {
-		log.Fatalf("NewWatcher err: %s", err.Error())
-	}
+		// 每个任务一个持久化的队列文件，上次退出时未处理完的变更在启动后继续处理，演练模式使用临时文件
+		var journal *state.Journal
+		if c.DryRun {
+			journal, err = state.OpenTempJournal(job.Sync.TempDir)
+		} else {
+			journal, err = state.OpenJournal(job.QueuePath())
+		}
+		if err != nil {
+			log.Fatalf("Open queue err: %s", err.Error())
+		}
+		journals = append(journals, journal)
 
-	// 使用WaitGroup等待所有goroutine退出
-	var wg sync.WaitGroup
+		// 死信列表按任务存放，记录中区分远端，演练模式不记录
+		var deadLetters *state.DeadLetters
+		if !c.DryRun {
+			if deadLetters, err = state.OpenDeadLetters(job.DeadLetterPath()); err != nil {
+				log.Fatalf("Open dead letters err: %s", err.Error())
+			}
+		}
+
+		var primary *Storage
+		var targets []*Transfer
+		for _, destination := range job.GetDestinations() {
+			// 检查对象存储桶是否存在
+			s, err := NewStorage(destination)
+			if err != nil {
+				log.Fatalf("NewStorage err: %s", err.Error())
+			}
+			if err = s.BucketExists(ctx); err != nil {
+				log.Fatalf("BucketExist err: %s", err.Error())
+			}
+			s.Limiter = limiter
+			if primary == nil {
+				primary = s
+			}
+
+			queue, err := journal.Queue(destination.Remote.GetName())
+			if err != nil {
+				log.Fatalf("Open queue err: %s", err.Error())
+			}
+			if n, _ := queue.Len(); n > 0 {
+				log.Infof("Replay %d queued change(s), remote: %s", n, destination.Remote.GetName())
+			}
+			scheduler := NewScheduler(destination, queue)
+			scheduler.SetLargeWorkers(largeWorkers)
+
+			t := NewTransfer(destination, scheduler, s)
+			t.DeadLetters = deadLetters
+			targets = append(targets, t)
+			// 异步处理变更事件
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					t.Run(ctx)
+				}()
+			}
+
+			// 创建CheckJob实例，每个远端独立对账
+			j := NewCheckJob(destination, scheduler, s)
+			// 异步处理定期对账任务
+			producers.Add(1)
+			go func() {
+				defer producers.Done()
+				j.Run(ctx)
+			}()
+
+			// CAS模式定期写回清单
+			if s.CAS != nil {
+				manifests = append(manifests, s)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					s.RunCAS(ctx)
+				}()
+			}
+
+			// 回收站定期清理过期对象
+			if destination.Sync.Trash.Enable {
+				trash := NewTrash(destination, s)
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					trash.Run(ctx)
+				}()
+			}
+		}
+		transfers = append(transfers, targets...)
 
-	// 创建CheckJob实例
-	j := NewCheckJob(c, PutChan, s)
-	// 异步处理定期对账任务
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		j.Run(ctx)
-	}()
-
-	// 异步处理变更事件
-	t := NewTransfer(c, PutChan, DeleteChan, s)
-	for i := 0; i < 8; i++ {
+		// 本地变更分发到每个远端
+		f := NewFanOut(PutChan, DeleteChan, targets...)
+		f.RenameChan = RenameChan
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			t.Run(ctx)
+			f.Run()
 		}()
-	}
 
-	// 异步监听本地路径
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := w.Watch(ctx); err != nil {
-			log.Errorf("Watch err: %s", err.Error())
+		// 创建Watcher实例
+		w, err := NewWatcher(job, PutChan, DeleteChan)
+		if err != nil {
+			log.Fatalf("NewWatcher err: %s", err.Error())
+		}
+		w.RenameChan = RenameChan
+		watchers = append(watchers, w)
+
+		// 双向同步，加载同步状态并异步轮询主远端的变更
+		if job.Sync.Bidirectional.Enable && c.DryRun {
+			// 拉取会修改本地文件，演练模式下不启用
+			log.Warn("The bidirectional sync is disabled in dry-run mode")
+		} else if job.Sync.Bidirectional.Enable {
+			store, err := state.Open(job.StatePath())
+			if err != nil {
+				log.Fatalf("Open state err: %s", err.Error())
+			}
+			stores = append(stores, store)
+			primary.State = store
+			p := NewPuller(job, PutChan, primary, store)
+			producers.Add(1)
+			go func() {
+				defer producers.Done()
+				p.Run(ctx)
+			}()
 		}
-	}()
+
+		// 异步监听本地路径
+		producers.Add(1)
+		go func() {
+			defer producers.Done()
+			if err := w.Watch(ctx); err != nil {
+				log.Errorf("Watch err: %s", err.Error())
+			}
+		}()
+	}
+	if c.DryRun {
+		log.Warn("Dry-run mode is enabled, objects will not be uploaded or deleted")
+	}
 
 	// 监听系统信号，实现优雅退出
 	sigCh := make(chan os.Signal, 1)
@@ -107,16 +246,49 @@ func main() {
 	cancel()
 
 	// 关闭Watcher
-	w.Close()
+	for _, w := range watchers {
+		w.Close()
+	}
 
 	// 停止kv清理协程
 	kv.Stop()
 
-	// 关闭channel，通知Transfer退出
-	close(PutChan)
-	close(DeleteChan)
+	// 等待变更来源退出后再关闭输入队列，FanOut把剩余的变更写入持久化队列后退出
+	producers.Wait()
+	for _, ch := range inputs {
+		close(ch)
+	}
+	for _, ch := range renames {
+		close(ch)
+	}
 
 	// 等待所有goroutine退出
 	wg.Wait()
+
+	// 写回CAS模式的清单，此时Transfer已全部退出，清单不再变化
+	for _, s := range manifests {
+		if err := s.FlushCAS(context.Background()); err != nil {
+			log.Errorf("Save manifest err: %s, remote: %s", err.Error(), s.Name)
+		}
+	}
+
+	// 输出每个远端的同步结果
+	for _, t := range transfers {
+		log.Infof("Remote %s, uploaded: %d, copied: %d, removed: %d, skipped: %d, failed: %d", t.Name, t.Stats.Uploaded.Load(),
+			t.Stats.Copied.Load(), t.Stats.Removed.Load(), t.Stats.Skipped.Load(), t.Stats.Failed.Load())
+	}
+
+	// 持久化双向同步状态
+	for _, store := range stores {
+		if err := store.Save(); err != nil {
+			log.Errorf("Save state err: %s", err.Error())
+		}
+	}
+	// 关闭持久化队列，未处理完的变更在下次启动时继续处理
+	for _, journal := range journals {
+		if err := journal.Close(); err != nil {
+			log.Errorf("Close queue err: %s", err.Error())
+		}
+	}
 	log.Info("All workers stopped, shutdown complete")
 }
@@ -2,6 +2,7 @@ package mocks
 
 import (
 	"context"
+	"io"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/stretchr/testify/mock"
@@ -33,12 +34,45 @@ func (m *MockObjectStorageClient) StatObject(ctx context.Context, bucketName, ob
 	return args.Get(0).(minio.ObjectInfo), args.Error(1)
 }
 
+// GetObject Mock 实现
+func (m *MockObjectStorageClient) GetObject(ctx context.Context, bucketName, objectName string, opts minio.GetObjectOptions) (*minio.Object, error) {
+	args := m.Called(ctx, bucketName, objectName, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*minio.Object), args.Error(1)
+}
+
+// FGetObject Mock 实现
+func (m *MockObjectStorageClient) FGetObject(ctx context.Context, bucketName, objectName, filePath string, opts minio.GetObjectOptions) error {
+	args := m.Called(ctx, bucketName, objectName, filePath, opts)
+	return args.Error(0)
+}
+
 // FPutObject Mock 实现
 func (m *MockObjectStorageClient) FPutObject(ctx context.Context, bucketName, objectName, filePath string, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
 	args := m.Called(ctx, bucketName, objectName, filePath, opts)
 	return args.Get(0).(minio.UploadInfo), args.Error(1)
 }
 
+// PutObject Mock 实现
+func (m *MockObjectStorageClient) PutObject(ctx context.Context, bucketName, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	args := m.Called(ctx, bucketName, objectName, reader, objectSize, opts)
+	return args.Get(0).(minio.UploadInfo), args.Error(1)
+}
+
+// CopyObject Mock 实现
+func (m *MockObjectStorageClient) CopyObject(ctx context.Context, dst minio.CopyDestOptions, src minio.CopySrcOptions) (minio.UploadInfo, error) {
+	args := m.Called(ctx, dst, src)
+	return args.Get(0).(minio.UploadInfo), args.Error(1)
+}
+
+// ComposeObject Mock 实现
+func (m *MockObjectStorageClient) ComposeObject(ctx context.Context, dst minio.CopyDestOptions, srcs ...minio.CopySrcOptions) (minio.UploadInfo, error) {
+	args := m.Called(ctx, dst, srcs)
+	return args.Get(0).(minio.UploadInfo), args.Error(1)
+}
+
 // RemoveObject Mock 实现
 func (m *MockObjectStorageClient) RemoveObject(ctx context.Context, bucketName, objectName string, opts minio.RemoveObjectOptions) error {
 	args := m.Called(ctx, bucketName, objectName, opts)
@@ -66,3 +100,44 @@ func (m *MockObjectStorageClient) RemoveObjects(ctx context.Context, bucketName
 	}
 	return args.Get(0).(<-chan minio.RemoveObjectError)
 }
+
+// MockMultipartClient 是 MultipartClient 接口的 Mock 实现
+type MockMultipartClient struct {
+	mock.Mock
+}
+
+// NewMultipartUpload Mock 实现
+func (m *MockMultipartClient) NewMultipartUpload(ctx context.Context, bucket, object string, opts minio.PutObjectOptions) (string, error) {
+	args := m.Called(ctx, bucket, object, opts)
+	return args.String(0), args.Error(1)
+}
+
+// PutObjectPart Mock 实现
+func (m *MockMultipartClient) PutObjectPart(ctx context.Context, bucket, object, uploadID string, partID int, data io.Reader, size int64, opts minio.PutObjectPartOptions) (minio.ObjectPart, error) {
+	args := m.Called(ctx, bucket, object, uploadID, partID, data, size, opts)
+	return args.Get(0).(minio.ObjectPart), args.Error(1)
+}
+
+// ListObjectParts Mock 实现
+func (m *MockMultipartClient) ListObjectParts(ctx context.Context, bucket, object, uploadID string, partNumberMarker, maxParts int) (minio.ListObjectPartsResult, error) {
+	args := m.Called(ctx, bucket, object, uploadID, partNumberMarker, maxParts)
+	return args.Get(0).(minio.ListObjectPartsResult), args.Error(1)
+}
+
+// CompleteMultipartUpload Mock 实现
+func (m *MockMultipartClient) CompleteMultipartUpload(ctx context.Context, bucket, object, uploadID string, parts []minio.CompletePart, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	args := m.Called(ctx, bucket, object, uploadID, parts, opts)
+	return args.Get(0).(minio.UploadInfo), args.Error(1)
+}
+
+// AbortMultipartUpload Mock 实现
+func (m *MockMultipartClient) AbortMultipartUpload(ctx context.Context, bucket, object, uploadID string) error {
+	args := m.Called(ctx, bucket, object, uploadID)
+	return args.Error(0)
+}
+
+// ListMultipartUploads Mock 实现
+func (m *MockMultipartClient) ListMultipartUploads(ctx context.Context, bucket, prefix, keyMarker, uploadIDMarker, delimiter string, maxUploads int) (minio.ListMultipartUploadsResult, error) {
+	args := m.Called(ctx, bucket, prefix, keyMarker, uploadIDMarker, delimiter, maxUploads)
+	return args.Get(0).(minio.ListMultipartUploadsResult), args.Error(1)
+}
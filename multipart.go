@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/jorben/rsync-object-storage/log"
+	"github.com/jorben/rsync-object-storage/state"
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// multipartThreshold 使用分片上传的最小文件大小，与minio客户端保持一致
+	multipartThreshold = 16 * 1024 * 1024
+	// staleUploadAge 本地没有记录的未完成分片上传超过该时长后视为遗留并取消
+	staleUploadAge = 24 * time.Hour
+)
+
+// isResumable 判断文件是否使用可断点续传的分片上传，配置了分片大小时不小于一个分片的文件都分片上传
+func (s *Storage) isResumable(size int64) bool {
+	threshold := int64(multipartThreshold)
+	if s.PartSize > 0 {
+		threshold = s.PartSize
+	}
+	return s.Uploads != nil && size >= threshold
+}
+
+// partSize 计算文件的分片大小，未配置分片大小或分片数量超过上限时与minio客户端的规则一致
+func (s *Storage) partSize(size int64) (int64, error) {
+	if s.PartSize > 0 {
+		if _, partSize, _, err := minio.OptimalPartInfo(size, uint64(s.PartSize)); err == nil {
+			return partSize, nil
+		}
+	}
+	_, partSize, _, err := minio.OptimalPartInfo(size, 0)
+	return partSize, err
+}
+
+// encodedFile 压缩、加密后待上传的临时文件
+type encodedFile struct {
+	Path     string
+	Size     int64
+	Metadata map[string]string // 对象的用户元数据
+}
+
+// multipartUpload 分片上传文件，最多同时上传 ParallelParts 个分片，每个分片完成后立即记录进度
+// 源文件自上次上传以来未发生变化时只上传剩余的分片，file 为读取内容的文件（copy模式下为临时文件）
+// 压缩、加密上传时 encoded 为编码后的临时文件，file 读取该临时文件，否则为nil
+func (s *Storage) multipartUpload(ctx context.Context, objectName, sourcePath string, sourceInfo os.FileInfo,
+	file io.ReaderAt, encoded *encodedFile) (ObjectInfo, error) {
+	backend := s.Backend.(MultipartBackend)
+	upload, err := s.resumeUpload(ctx, backend, objectName, sourcePath, sourceInfo, encoded)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	contentSize := upload.Size
+	if upload.Encoded != "" {
+		contentSize = upload.EncodedSize
+	}
+	totalParts := int((contentSize + upload.PartSize - 1) / upload.PartSize)
+	uploaded := make(map[int]bool, len(upload.Parts))
+	for _, part := range upload.Parts {
+		uploaded[part.Number] = true
+	}
+	if len(uploaded) > 0 {
+		log.Infof("Resume multipart upload %s, parts: %d/%d", objectName, len(uploaded), totalParts)
+	}
+
+	var mu sync.Mutex
+	var uploadErr error
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return uploadErr != nil
+	}
+	numbers := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < max(1, s.ParallelParts); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for number := range numbers {
+				// 已有分片失败时不再上传，保留上传记录，下次只上传剩余的分片
+				if failed() {
+					continue
+				}
+				offset := int64(number-1) * upload.PartSize
+				size := min(upload.PartSize, contentSize-offset)
+				reader := helper.NewRateLimitedReader(ctx, io.NewSectionReader(file, offset, size), s.Limiter)
+				etag, err := backend.PutObjectPart(ctx, objectName, upload.UploadID, number, reader, size)
+				mu.Lock()
+				if err != nil {
+					if uploadErr == nil {
+						uploadErr = err
+					}
+				} else {
+					upload.Parts = append(upload.Parts, state.Part{Number: number, ETag: etag, Size: size})
+					if err := s.Uploads.Set(objectName, upload); err != nil {
+						log.Errorf("Save upload err: %s", err.Error())
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for number := 1; number <= totalParts && !failed(); number++ {
+		if !uploaded[number] {
+			numbers <- number
+		}
+	}
+	close(numbers)
+	wg.Wait()
+	if uploadErr != nil {
+		return ObjectInfo{}, uploadErr
+	}
+
+	parts := make([]UploadPart, 0, len(upload.Parts))
+	for _, part := range upload.Parts {
+		parts = append(parts, UploadPart{Number: part.Number, ETag: part.ETag, Size: part.Size})
+	}
+	uploadInfo, err := backend.CompleteMultipartUpload(ctx, objectName, upload.UploadID, parts)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if err := s.Uploads.Delete(objectName); err != nil {
+		log.Errorf("Save upload err: %s", err.Error())
+	}
+	return uploadInfo, nil
+}
+
+// resumeUpload 获取可以继续的分片上传，只保留远端仍存在且ETag一致的分片
+// 源文件已变化或远端上传已失效时取消旧的上传，创建新的分片上传
+func (s *Storage) resumeUpload(ctx context.Context, backend MultipartBackend, objectName, sourcePath string,
+	sourceInfo os.FileInfo, encoded *encodedFile) (state.Upload, error) {
+	size, encodedPath := sourceInfo.Size(), ""
+	var metadata map[string]string
+	if encoded != nil {
+		size, encodedPath, metadata = encoded.Size, encoded.Path, encoded.Metadata
+	}
+	// checksum比较时按相同的分片大小重新计算ETag
+	partSize, err := s.partSize(size)
+	if err != nil {
+		return state.Upload{}, err
+	}
+	if upload, ok := s.Uploads.Get(objectName); ok {
+		if upload.Source == sourcePath && upload.PartSize == partSize && isSameSource(upload, sourceInfo) &&
+			upload.Encoded == encodedPath {
+			remoteParts, err := backend.ListObjectParts(ctx, objectName, upload.UploadID)
+			if err == nil {
+				upload.Parts = matchParts(upload.Parts, remoteParts)
+				return upload, nil
+			}
+			log.Warnf("ListObjectParts err: %s, restart upload %s", err.Error(), objectName)
+		} else {
+			log.Infof("File changed since last upload, restart upload %s", objectName)
+		}
+		s.abortUpload(ctx, backend, objectName, upload.UploadID)
+		if upload.Encoded != encodedPath {
+			removeEncoded(upload)
+		}
+	}
+
+	uploadID, err := backend.NewMultipartUpload(ctx, objectName, metadata)
+	if err != nil {
+		return state.Upload{}, err
+	}
+	upload := state.Upload{
+		UploadID:  uploadID,
+		Source:    sourcePath,
+		Size:      sourceInfo.Size(),
+		ModTime:   sourceInfo.ModTime(),
+		PartSize:  partSize,
+		CreatedAt: time.Now(),
+		Encoded:   encodedPath,
+		Metadata:  metadata,
+	}
+	if encoded != nil {
+		upload.EncodedSize = encoded.Size
+	}
+	if err := s.Uploads.Set(objectName, upload); err != nil {
+		log.Errorf("Save upload err: %s", err.Error())
+	}
+	log.Debugf("New multipart upload %s, size: %s", objectName, helper.ByteFormat(size))
+	return upload, nil
+}
+
+// matchParts 筛选出远端仍存在且ETag、大小一致的已上传分片
+func matchParts(parts []state.Part, remoteParts []UploadPart) []state.Part {
+	remote := make(map[int]UploadPart, len(remoteParts))
+	for _, part := range remoteParts {
+		remote[part.Number] = part
+	}
+	var matched []state.Part
+	for _, part := range parts {
+		if remotePart, ok := remote[part.Number]; ok && remotePart.ETag == part.ETag && remotePart.Size == part.Size {
+			matched = append(matched, part)
+		}
+	}
+	return matched
+}
+
+// resumableEncoded 获取可以继续上传的编码后临时文件，源文件自开始上传以来未变化且临时文件完整时返回
+// 加密使用随机的数据密钥，重新编码的内容与已上传的分片不一致，断点续传需要使用同一个临时文件
+func (s *Storage) resumableEncoded(objectName, sourcePath string, sourceInfo os.FileInfo) *encodedFile {
+	if s.Uploads == nil {
+		return nil
+	}
+	upload, ok := s.Uploads.Get(objectName)
+	if !ok || upload.Encoded == "" || upload.Source != sourcePath || !isSameSource(upload, sourceInfo) {
+		return nil
+	}
+	if fileInfo, err := os.Stat(upload.Encoded); err != nil || fileInfo.Size() != upload.EncodedSize {
+		return nil
+	}
+	return &encodedFile{Path: upload.Encoded, Size: upload.EncodedSize, Metadata: upload.Metadata}
+}
+
+// removeEncoded 删除不再继续上传的编码后临时文件
+func removeEncoded(upload state.Upload) {
+	if upload.Encoded == "" {
+		return
+	}
+	if err := os.Remove(upload.Encoded); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Remove encoded file err: %s", err.Error())
+	}
+}
+
+// isSameSource 判断源文件的大小和修改时间与开始上传时是否一致
+func isSameSource(upload state.Upload, fileInfo os.FileInfo) bool {
+	return upload.Size == fileInfo.Size() && upload.ModTime.Equal(fileInfo.ModTime())
+}
+
+// abortUpload 取消分片上传并删除上传记录
+func (s *Storage) abortUpload(ctx context.Context, backend MultipartBackend, objectName, uploadID string) {
+	if err := backend.AbortMultipartUpload(ctx, objectName, uploadID); err != nil {
+		log.Warnf("AbortMultipartUpload err: %s, object: %s", err.Error(), objectName)
+	}
+	if upload, ok := s.Uploads.Get(objectName); ok && upload.UploadID == uploadID {
+		if err := s.Uploads.Delete(objectName); err != nil {
+			log.Errorf("Save upload err: %s", err.Error())
+		}
+	}
+}
+
+// AbortStaleUploads 取消远端路径下遗留的未完成分片上传
+// 本地有记录的上传在源文件被删除或修改后取消，没有记录的上传（如其他主机或已删除的数据目录遗留）超过 staleUploadAge 后取消
+func (s *Storage) AbortStaleUploads(ctx context.Context) {
+	if s.Uploads == nil {
+		return
+	}
+	backend := s.Backend.(MultipartBackend)
+	listedAt := time.Now()
+	remoteUploads, err := backend.ListMultipartUploads(ctx, s.GetRemoteRoot())
+	if err != nil {
+		log.Errorf("ListMultipartUploads err: %s", err.Error())
+		return
+	}
+
+	active := make(map[string]bool)
+	for _, remote := range remoteUploads {
+		if upload, ok := s.Uploads.Get(remote.Key); ok && upload.UploadID == remote.UploadID {
+			// 快照等对象名与源文件不对应的上传无法再继续
+			fileInfo, err := os.Stat(upload.Source)
+			if err == nil && isSameSource(upload, fileInfo) && remote.Key == s.GetRemotePath(upload.Source) {
+				active[remote.Key] = true
+				continue
+			}
+		} else if listedAt.Sub(remote.Initiated) < staleUploadAge {
+			continue
+		}
+		log.Infof("Abort stale multipart upload %s, initiated at %s", remote.Key,
+			remote.Initiated.Local().Format("2006-01-02 15:04:05"))
+		if upload, ok := s.Uploads.Get(remote.Key); ok && upload.UploadID == remote.UploadID {
+			removeEncoded(upload)
+		}
+		s.abortUpload(ctx, backend, remote.Key, remote.UploadID)
+	}
+
+	// 远端已不存在的上传无法继续，删除记录（列举之后新建的上传除外）
+	for _, key := range s.Uploads.Keys() {
+		if upload, ok := s.Uploads.Get(key); ok && !active[key] && upload.CreatedAt.Before(listedAt) {
+			removeEncoded(upload)
+			if err := s.Uploads.Delete(key); err != nil {
+				log.Errorf("Save upload err: %s", err.Error())
+			}
+		}
+	}
+}
+
+// PendingUploads 获取有未完成分片上传的本地文件
+func (s *Storage) PendingUploads() []string {
+	if s.Uploads == nil {
+		return nil
+	}
+	var paths []string
+	for _, key := range s.Uploads.Keys() {
+		if upload, ok := s.Uploads.Get(key); ok {
+			paths = append(paths, upload.Source)
+		}
+	}
+	return paths
+}
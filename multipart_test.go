@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/jorben/rsync-object-storage/mocks"
+	"github.com/jorben/rsync-object-storage/state"
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+const partSize = 16 * 1024 * 1024
+
+// newMultipartStorage 创建启用分片上传记录的Storage，本地路径下有一个40MiB的稀疏文件（16MiB + 16MiB + 8MiB 三个分片）
+func newMultipartStorage(t *testing.T, client *mocks.MockObjectStorageClient, core *mocks.MockMultipartClient) (*Storage, string) {
+	t.Helper()
+	localDir := t.TempDir()
+	bigFile := filepath.Join(localDir, "big.bin")
+	assert.NoError(t, os.WriteFile(bigFile, nil, 0644))
+	assert.NoError(t, os.Truncate(bigFile, 40*1024*1024))
+	uploads, err := state.OpenUploads(filepath.Join(t.TempDir(), "uploads.json"))
+	assert.NoError(t, err)
+
+	backend := NewS3Backend(client, "test-bucket")
+	backend.Core = core
+	return &Storage{
+		Backend:      backend,
+		LocalPrefix:  localDir,
+		RemotePrefix: "remote",
+		SymLink:      enum.SymlinkSkip,
+		Uploads:      uploads,
+	}, bigFile
+}
+
+// partNumbers 获取 PutObjectPart 被调用的分片序号
+func partNumbers(core *mocks.MockMultipartClient) []int {
+	var numbers []int
+	for _, call := range core.Calls {
+		if call.Method == "PutObjectPart" {
+			numbers = append(numbers, call.Arguments.Int(4))
+		}
+	}
+	return numbers
+}
+
+// TestFPutObject_Multipart 测试大文件分片上传与断点续传
+func TestFPutObject_Multipart(t *testing.T) {
+	ctx := context.Background()
+	notFound := func(client *mocks.MockObjectStorageClient) {
+		client.On("StatObject", ctx, "test-bucket", "remote/big.bin", minio.StatObjectOptions{}).
+			Return(minio.ObjectInfo{}, errors.New("key not found"))
+	}
+	putPart := func(core *mocks.MockMultipartClient, uploadID string) {
+		core.On("PutObjectPart", ctx, "test-bucket", "remote/big.bin", uploadID, mock.Anything, mock.Anything, mock.Anything, minio.PutObjectPartOptions{}).
+			Return(minio.ObjectPart{ETag: "\"etag\""}, nil)
+	}
+
+	t.Run("新上传", func(t *testing.T) {
+		client, core := new(mocks.MockObjectStorageClient), new(mocks.MockMultipartClient)
+		s, bigFile := newMultipartStorage(t, client, core)
+		notFound(client)
+		core.On("NewMultipartUpload", ctx, "test-bucket", "remote/big.bin", minio.PutObjectOptions{}).Return("upload-1", nil)
+		putPart(core, "upload-1")
+		core.On("CompleteMultipartUpload", ctx, "test-bucket", "remote/big.bin", "upload-1",
+			[]minio.CompletePart{{PartNumber: 1, ETag: "etag"}, {PartNumber: 2, ETag: "etag"}, {PartNumber: 3, ETag: "etag"}},
+			minio.PutObjectOptions{}).Return(minio.UploadInfo{ETag: "abc-3"}, nil)
+
+		assert.NoError(t, s.FPutObject(ctx, bigFile))
+		assert.Equal(t, []int{1, 2, 3}, partNumbers(core))
+		assert.Empty(t, s.Uploads.Keys())
+		client.AssertNotCalled(t, "PutObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("中断后只上传剩余分片", func(t *testing.T) {
+		client, core := new(mocks.MockObjectStorageClient), new(mocks.MockMultipartClient)
+		s, bigFile := newMultipartStorage(t, client, core)
+		notFound(client)
+		core.On("NewMultipartUpload", ctx, "test-bucket", "remote/big.bin", minio.PutObjectOptions{}).Return("upload-1", nil)
+		core.On("PutObjectPart", ctx, "test-bucket", "remote/big.bin", "upload-1", 1, mock.Anything, int64(partSize), minio.PutObjectPartOptions{}).
+			Return(minio.ObjectPart{PartNumber: 1, ETag: "etag-1"}, nil).Once()
+		core.On("PutObjectPart", ctx, "test-bucket", "remote/big.bin", "upload-1", 2, mock.Anything, int64(partSize), minio.PutObjectPartOptions{}).
+			Return(minio.ObjectPart{}, errors.New("connection reset")).Once()
+
+		assert.Error(t, s.FPutObject(ctx, bigFile))
+		upload, ok := s.Uploads.Get("remote/big.bin")
+		assert.True(t, ok)
+		assert.Equal(t, []state.Part{{Number: 1, ETag: "etag-1", Size: partSize}}, upload.Parts)
+
+		// 重启后（重新加载记录）远端仍保留分片1
+		s.Uploads, _ = state.OpenUploads(filepath.Join(t.TempDir(), "unused.json"))
+		assert.NoError(t, s.Uploads.Set("remote/big.bin", upload))
+		core.On("ListObjectParts", ctx, "test-bucket", "remote/big.bin", "upload-1", 0, 1000).
+			Return(minio.ListObjectPartsResult{ObjectParts: []minio.ObjectPart{{PartNumber: 1, ETag: "\"etag-1\"", Size: partSize}}}, nil)
+		putPart(core, "upload-1")
+		core.On("CompleteMultipartUpload", ctx, "test-bucket", "remote/big.bin", "upload-1",
+			[]minio.CompletePart{{PartNumber: 1, ETag: "etag-1"}, {PartNumber: 2, ETag: "etag"}, {PartNumber: 3, ETag: "etag"}},
+			minio.PutObjectOptions{}).Return(minio.UploadInfo{ETag: "abc-3"}, nil)
+
+		assert.NoError(t, s.FPutObject(ctx, bigFile))
+		assert.Equal(t, []int{1, 2, 2, 3}, partNumbers(core))
+		core.AssertNumberOfCalls(t, "NewMultipartUpload", 1)
+		assert.Empty(t, s.Uploads.Keys())
+	})
+
+	t.Run("配置分片大小并行上传", func(t *testing.T) {
+		client, core := new(mocks.MockObjectStorageClient), new(mocks.MockMultipartClient)
+		s, bigFile := newMultipartStorage(t, client, core)
+		s.PartSize = 8 * 1024 * 1024
+		s.ParallelParts = 3
+		s.Limiter = helper.NewRateLimiter(1024 * 1024 * 1024)
+		notFound(client)
+		core.On("NewMultipartUpload", ctx, "test-bucket", "remote/big.bin", minio.PutObjectOptions{}).Return("upload-1", nil)
+		putPart(core, "upload-1")
+		core.On("CompleteMultipartUpload", ctx, "test-bucket", "remote/big.bin", "upload-1", mock.Anything, minio.PutObjectOptions{}).
+			Return(minio.UploadInfo{ETag: "abc-5"}, nil)
+
+		assert.NoError(t, s.FPutObject(ctx, bigFile))
+		assert.ElementsMatch(t, []int{1, 2, 3, 4, 5}, partNumbers(core))
+		core.AssertCalled(t, "CompleteMultipartUpload", ctx, "test-bucket", "remote/big.bin", "upload-1",
+			[]minio.CompletePart{{PartNumber: 1, ETag: "etag"}, {PartNumber: 2, ETag: "etag"}, {PartNumber: 3, ETag: "etag"},
+				{PartNumber: 4, ETag: "etag"}, {PartNumber: 5, ETag: "etag"}}, minio.PutObjectOptions{})
+	})
+
+	t.Run("文件变化后重新上传", func(t *testing.T) {
+		client, core := new(mocks.MockObjectStorageClient), new(mocks.MockMultipartClient)
+		s, bigFile := newMultipartStorage(t, client, core)
+		notFound(client)
+		assert.NoError(t, s.Uploads.Set("remote/big.bin", state.Upload{
+			UploadID: "upload-old",
+			Source:   bigFile,
+			Size:     40 * 1024 * 1024,
+			ModTime:  time.Now().Add(-time.Hour),
+			PartSize: partSize,
+			Parts:    []state.Part{{Number: 1, ETag: "etag-1", Size: partSize}},
+		}))
+		core.On("AbortMultipartUpload", ctx, "test-bucket", "remote/big.bin", "upload-old").Return(nil)
+		core.On("NewMultipartUpload", ctx, "test-bucket", "remote/big.bin", minio.PutObjectOptions{}).Return("upload-new", nil)
+		putPart(core, "upload-new")
+		core.On("CompleteMultipartUpload", ctx, "test-bucket", "remote/big.bin", "upload-new", mock.Anything, minio.PutObjectOptions{}).
+			Return(minio.UploadInfo{ETag: "abc-3"}, nil)
+
+		assert.NoError(t, s.FPutObject(ctx, bigFile))
+		core.AssertCalled(t, "AbortMultipartUpload", ctx, "test-bucket", "remote/big.bin", "upload-old")
+		assert.Equal(t, []int{1, 2, 3}, partNumbers(core))
+	})
+}
+
+// TestFPutObject_EncryptedMultipart 测试加密后的大文件分片上传，元数据在创建上传时写入，中断后使用同一个临时文件续传
+func TestFPutObject_EncryptedMultipart(t *testing.T) {
+	ctx := context.Background()
+	client, core := new(mocks.MockObjectStorageClient), new(mocks.MockMultipartClient)
+	s, bigFile := newMultipartStorage(t, client, core)
+	encrypt, err := NewEncryption(writeKeyFile(t), "", nil)
+	assert.NoError(t, err)
+	s.Encrypt = encrypt
+	s.TempDir = t.TempDir()
+	client.On("StatObject", ctx, "test-bucket", "remote/big.bin", minio.StatObjectOptions{}).
+		Return(minio.ObjectInfo{}, errors.New("key not found"))
+	encrypted := mock.MatchedBy(func(opts minio.PutObjectOptions) bool {
+		return opts.UserMetadata[metaEncryption] == enum.EncryptAES256GCM && opts.UserMetadata[metaOriginalMd5] != ""
+	})
+	core.On("NewMultipartUpload", ctx, "test-bucket", "remote/big.bin", encrypted).Return("upload-1", nil)
+	core.On("PutObjectPart", ctx, "test-bucket", "remote/big.bin", "upload-1", 1, mock.Anything, int64(partSize), minio.PutObjectPartOptions{}).
+		Return(minio.ObjectPart{PartNumber: 1, ETag: "etag-1"}, nil).Once()
+	core.On("PutObjectPart", ctx, "test-bucket", "remote/big.bin", "upload-1", 2, mock.Anything, int64(partSize), minio.PutObjectPartOptions{}).
+		Return(minio.ObjectPart{}, errors.New("connection reset")).Once()
+
+	assert.Error(t, s.FPutObject(ctx, bigFile))
+	upload, ok := s.Uploads.Get("remote/big.bin")
+	assert.True(t, ok)
+	assert.Equal(t, s.TempDir, filepath.Dir(upload.Encoded))
+	assert.FileExists(t, upload.Encoded)
+	assert.Greater(t, upload.EncodedSize, upload.Size)
+
+	// 续传时不重新加密，只上传剩余的分片
+	core.On("ListObjectParts", ctx, "test-bucket", "remote/big.bin", "upload-1", 0, 1000).
+		Return(minio.ListObjectPartsResult{ObjectParts: []minio.ObjectPart{{PartNumber: 1, ETag: "\"etag-1\"", Size: partSize}}}, nil)
+	core.On("PutObjectPart", ctx, "test-bucket", "remote/big.bin", "upload-1", mock.Anything, mock.Anything, mock.Anything, minio.PutObjectPartOptions{}).
+		Return(minio.ObjectPart{ETag: "\"etag\""}, nil)
+	core.On("CompleteMultipartUpload", ctx, "test-bucket", "remote/big.bin", "upload-1",
+		[]minio.CompletePart{{PartNumber: 1, ETag: "etag-1"}, {PartNumber: 2, ETag: "etag"}, {PartNumber: 3, ETag: "etag"}},
+		minio.PutObjectOptions{}).Return(minio.UploadInfo{ETag: "abc-3"}, nil)
+
+	assert.NoError(t, s.FPutObject(ctx, bigFile))
+	assert.Equal(t, []int{1, 2, 2, 3}, partNumbers(core))
+	core.AssertNumberOfCalls(t, "NewMultipartUpload", 1)
+	assert.Empty(t, s.Uploads.Keys())
+	assert.NoFileExists(t, upload.Encoded)
+	client.AssertNotCalled(t, "PutObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// TestStorage_PartSize 测试分片大小与分片上传的阈值
+func TestStorage_PartSize(t *testing.T) {
+	s := &Storage{Uploads: &state.Uploads{}}
+	partSize, err := s.partSize(40 * 1024 * 1024)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(16*1024*1024), partSize)
+	assert.False(t, s.isResumable(8*1024*1024))
+
+	s.PartSize = 8 * 1024 * 1024
+	partSize, err = s.partSize(40 * 1024 * 1024)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8*1024*1024), partSize)
+	assert.True(t, s.isResumable(8*1024*1024))
+
+	// 分片数量超过上限时按文件大小自动计算
+	partSize, err = s.partSize(100 * 1024 * 1024 * 1024)
+	assert.NoError(t, err)
+	assert.Greater(t, partSize, int64(8*1024*1024))
+
+	s.Uploads = nil
+	assert.False(t, s.isResumable(40*1024*1024))
+}
+
+// TestAbortStaleUploads 测试清理远端路径下遗留的分片上传
+func TestAbortStaleUploads(t *testing.T) {
+	ctx := context.Background()
+	client, core := new(mocks.MockObjectStorageClient), new(mocks.MockMultipartClient)
+	s, bigFile := newMultipartStorage(t, client, core)
+	fileInfo, err := os.Stat(bigFile)
+	assert.NoError(t, err)
+
+	// 本地有记录且源文件未变化，可以继续
+	assert.NoError(t, s.Uploads.Set("remote/big.bin", state.Upload{UploadID: "active", Source: bigFile,
+		Size: fileInfo.Size(), ModTime: fileInfo.ModTime(), PartSize: partSize}))
+	// 源文件已删除
+	assert.NoError(t, s.Uploads.Set("remote/deleted.bin", state.Upload{UploadID: "deleted",
+		Source: filepath.Join(s.LocalPrefix, "deleted.bin"), Size: 1}))
+	// 远端上传已不存在
+	assert.NoError(t, s.Uploads.Set("remote/expired.bin", state.Upload{UploadID: "expired", Source: bigFile,
+		Size: fileInfo.Size(), ModTime: fileInfo.ModTime()}))
+
+	now := time.Now()
+	core.On("ListMultipartUploads", ctx, "test-bucket", "remote/", "", "", "", 1000).
+		Return(minio.ListMultipartUploadsResult{Uploads: []minio.ObjectMultipartInfo{
+			{Key: "remote/big.bin", UploadID: "active", Initiated: now.Add(-48 * time.Hour)},
+			{Key: "remote/deleted.bin", UploadID: "deleted", Initiated: now},
+			{Key: "remote/other.bin", UploadID: "orphan", Initiated: now.Add(-48 * time.Hour)},
+			{Key: "remote/recent.bin", UploadID: "recent", Initiated: now.Add(-time.Hour)},
+		}}, nil)
+	core.On("AbortMultipartUpload", ctx, "test-bucket", mock.Anything, mock.Anything).Return(nil)
+
+	s.AbortStaleUploads(ctx)
+	core.AssertNumberOfCalls(t, "AbortMultipartUpload", 2)
+	core.AssertCalled(t, "AbortMultipartUpload", ctx, "test-bucket", "remote/deleted.bin", "deleted")
+	core.AssertCalled(t, "AbortMultipartUpload", ctx, "test-bucket", "remote/other.bin", "orphan")
+	assert.Equal(t, []string{"remote/big.bin"}, s.Uploads.Keys())
+	assert.Equal(t, []string{bigFile}, s.PendingUploads())
+
+	// 未启用分片上传记录时不访问远端
+	s.Uploads = nil
+	s.AbortStaleUploads(ctx)
+	core.AssertNumberOfCalls(t, "ListMultipartUploads", 1)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/jorben/rsync-object-storage/config"
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/jorben/rsync-object-storage/log"
+	"github.com/jorben/rsync-object-storage/state"
+)
+
+// 一次性同步的进程退出码
+const (
+	// exitOK 同步完成
+	exitOK = 0
+	// exitFailed 存在同步失败的文件或同步被中断
+	exitFailed = 1
+	// exitUsage 参数或配置错误、本地路径或远端不可用
+	exitUsage = 2
+)
+
+// stringList 可重复指定的字符串参数
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// syncCommand sync 子命令入口，执行一次对账同步，等待队列清空后退出，返回进程退出码
+// 用法：ros sync [flags] [<local> [<remote>]]，命令行参数优先于配置文件
+func syncCommand(args []string) int {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	configPath := flags.String("c", "./config.yaml", "Path to the configuration file")
+	var excludes stringList
+	flags.Var(&excludes, "exclude", "Exclude files matching `PATTERN`, can be repeated")
+	deleteOrphans := flags.Bool("delete", false, "Delete remote objects whose local source no longer exists")
+	dryRun := flags.Bool("dry-run", false, "Only log what would be uploaded or deleted")
+	checksum := flags.Bool("checksum", false, "Compare multipart objects by checksum instead of size and mtime")
+	bwLimit := flags.Int64("bwlimit", 0, "Limit the total upload bandwidth to `BYTES` per second")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: ros sync [flags] [<local> [[s3://]<bucket>[/<path>] | file://<dir>]]")
+		flags.PrintDefaults()
+	}
+
+	positional, err := parseArgs(flags, args)
+	if err != nil {
+		return exitUsage
+	}
+	if len(positional) > 2 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	// 命令行参数在规范化和校验之前覆盖配置文件，临时目录检查、相对的优先路径都基于参数指定的路径
+	c, err := config.LoadConfig(*configPath, func(c *config.SyncConfig) error {
+		return applySyncArgs(c, positional)
+	})
+	if err != nil {
+		fmt.Printf("Load config err: %s\n", err.Error())
+		return exitUsage
+	}
+
+	// 配置了多个同步任务时不支持指定路径
+	if len(positional) > 0 && len(c.Jobs) > 0 {
+		fmt.Println("Local and remote arguments are not supported when jobs are configured")
+		return exitUsage
+	}
+	c.DryRun = c.DryRun || *dryRun
+	if *bwLimit > 0 {
+		c.Transfer.BandwidthLimit = *bwLimit
+	}
+	jobs := c.GetJobs()
+	for _, job := range jobs {
+		job.Sync.Ignore = append(job.Sync.Ignore, excludes...)
+		job.Sync.CheckJob.DeleteOrphans = job.Sync.CheckJob.DeleteOrphans || *deleteOrphans
+		job.Sync.Checksum = job.Sync.Checksum || *checksum
+		job.DryRun = c.DryRun
+	}
+
+	// 初始化日志
+	log.InitLogger(c.Log)
+	defer log.GetLogger().Sync()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// 每个远端使用独立的队列和Transfer worker，多个远端平分worker数量
+	destinationCount := 0
+	for _, job := range jobs {
+		destinationCount += len(job.GetDestinations())
+	}
+	workers := max(1, c.Transfer.Workers/destinationCount)
+	largeWorkers := min(workers, max(1, c.Transfer.Schedule.LargeWorkers/destinationCount))
+	// 全部远端共享上传限速器，限制的是总带宽
+	limiter := helper.NewRateLimiter(c.Transfer.BandwidthLimit)
+
+	var transfers []*Transfer
+	var checkJobs []*CheckJob
+	var journals []*state.Journal
+	defer func() {
+		for _, journal := range journals {
+			_ = journal.Close()
+		}
+	}()
+	for _, job := range jobs {
+		// 检查本地路径可读性
+		if _, err = os.ReadDir(job.Local.Path); err != nil {
+			log.Errorf("ReadDir err: %s", err.Error())
+			return exitUsage
+		}
+
+		// 一次性同步每次重新对账，变更队列使用临时文件
+		journal, err := state.OpenTempJournal(job.Sync.TempDir)
+		if err != nil {
+			log.Errorf("Open queue err: %s", err.Error())
+			return exitUsage
+		}
+		journals = append(journals, journal)
+
+		// 演练模式不记录死信
+		var deadLetters *state.DeadLetters
+		if !c.DryRun {
+			if deadLetters, err = state.OpenDeadLetters(job.DeadLetterPath()); err != nil {
+				log.Errorf("Open dead letters err: %s", err.Error())
+				return exitUsage
+			}
+		}
+
+		for _, destination := range job.GetDestinations() {
+			s, err := NewStorage(destination)
+			if err != nil {
+				log.Errorf("NewStorage err: %s", err.Error())
+				return exitUsage
+			}
+			if err = s.BucketExists(ctx); err != nil {
+				log.Errorf("BucketExist err: %s", err.Error())
+				return exitUsage
+			}
+			s.Limiter = limiter
+			queue, err := journal.Queue(destination.Remote.GetName())
+			if err != nil {
+				log.Errorf("Open queue err: %s", err.Error())
+				return exitUsage
+			}
+			scheduler := NewScheduler(destination, queue)
+			scheduler.SetLargeWorkers(largeWorkers)
+			t := NewTransfer(destination, scheduler, s)
+			t.DeadLetters = deadLetters
+			transfers = append(transfers, t)
+			checkJobs = append(checkJobs, NewCheckJob(destination, scheduler, s))
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, t := range transfers {
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(t *Transfer) {
+				defer wg.Done()
+				t.Run(ctx)
+			}(t)
+		}
+	}
+
+	// 每个远端独立执行一次对账，关闭队列后Transfer处理完剩余的任务退出
+	var walks sync.WaitGroup
+	var runFailed atomic.Bool
+	for i, j := range checkJobs {
+		walks.Add(1)
+		go func(j *CheckJob, t *Transfer) {
+			defer walks.Done()
+			// 快照失败、遍历本地或列举远端失败时按失败退出
+			if err := j.RunOnce(ctx); err != nil {
+				runFailed.Store(true)
+			}
+			t.Scheduler.Close()
+		}(j, transfers[i])
+	}
+	walks.Wait()
+	wg.Wait()
+
+	// 写回CAS模式的清单，写回失败时本次上传的内容在下次同步时不再重复上传，但仍按失败退出
+	failed := runFailed.Load()
+	for _, t := range transfers {
+		if t.Storage.CAS == nil {
+			continue
+		}
+		if err := t.Storage.FlushCAS(context.Background()); err != nil {
+			log.Errorf("Save manifest err: %s, remote: %s", err.Error(), t.Name)
+			failed = true
+		}
+	}
+
+	action := "Sync ends"
+	if c.DryRun {
+		action = "Dry-run ends"
+	}
+	for _, t := range transfers {
+		log.Infof("%s, remote: %s, uploaded: %d, removed: %d, skipped: %d, failed: %d", action, t.Name,
+			t.Stats.Uploaded.Load(), t.Stats.Removed.Load(), t.Stats.Skipped.Load(), t.Stats.Failed.Load())
+		failed = failed || t.Stats.Failed.Load() > 0
+	}
+
+	if ctx.Err() != nil || failed {
+		return exitFailed
+	}
+	return exitOK
+}
+
+// applySyncArgs 使用位置参数覆盖配置文件的本地路径和远端
+// 指定远端时只同步到该远端，不再同步到配置的 replicas
+func applySyncArgs(c *config.SyncConfig, positional []string) error {
+	var err error
+	if len(positional) > 0 {
+		if c.Local.Path, err = filepath.Abs(positional[0]); err != nil {
+			return fmt.Errorf("local path err: %s", err.Error())
+		}
+	}
+	if len(positional) > 1 {
+		if dir, ok := strings.CutPrefix(positional[1], "file://"); ok {
+			// 同步到本地或挂载目录
+			c.Remote.Type, c.Remote.Path = enum.BackendLocal, ""
+			if c.Remote.Bucket, err = filepath.Abs(dir); err != nil {
+				return fmt.Errorf("remote path err: %s", err.Error())
+			}
+		} else if c.Remote.Bucket, c.Remote.Path, err = parseRemote(positional[1]); err != nil {
+			return fmt.Errorf("remote path err: %s", err.Error())
+		} else {
+			c.Remote.Type = enum.BackendS3
+		}
+		c.Replicas = nil
+	}
+	return nil
+}
+
+// parseArgs 解析参数，允许标志与位置参数交错出现，返回位置参数
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// parseRemote 解析远端路径参数，格式为 [s3://]bucket[/path]
+func parseRemote(remote string) (bucket string, path string, err error) {
+	remote = strings.TrimPrefix(remote, "s3://")
+	bucket, path, _ = strings.Cut(strings.Trim(remote, "/"), "/")
+	if bucket == "" {
+		return "", "", errors.New("bucket is empty")
+	}
+	return bucket, path, nil
+}
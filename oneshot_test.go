@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jorben/rsync-object-storage/config"
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseRemote 测试远端路径参数解析
+func TestParseRemote(t *testing.T) {
+	tests := []struct {
+		name   string
+		remote string
+		bucket string
+		path   string
+		hasErr bool
+	}{
+		{"只有Bucket", "my-bucket", "my-bucket", "", false},
+		{"Bucket和路径", "my-bucket/backup/photos", "my-bucket", "backup/photos", false},
+		{"s3协议前缀", "s3://my-bucket/backup/", "my-bucket", "backup", false},
+		{"Bucket为空", "s3://", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket, path, err := parseRemote(tt.remote)
+			if tt.hasErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.bucket, bucket)
+			assert.Equal(t, tt.path, path)
+		})
+	}
+}
+
+// TestParseArgs 测试标志与位置参数交错解析
+func TestParseArgs(t *testing.T) {
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "")
+	var excludes stringList
+	flags.Var(&excludes, "exclude", "")
+
+	positional, err := parseArgs(flags, []string{"--exclude", "*.tmp", "/data", "--dry-run", "bucket/path", "--exclude=.git"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/data", "bucket/path"}, positional)
+	assert.True(t, *dryRun)
+	assert.Equal(t, stringList{"*.tmp", ".git"}, excludes)
+}
+
+// TestApplySyncArgs 测试位置参数在校验之前覆盖配置文件，指定远端时不再同步到 replicas
+func TestApplySyncArgs(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yaml")
+	err := os.WriteFile(configPath, []byte(`
+local:
+  path: /config/docs
+remote:
+  endpoint: s3.example.com
+  bucket: config-bucket
+  path: backup
+replicas:
+  - type: local
+    bucket: /mnt/replica
+sync:
+  temp_dir: /data/.tmp
+  priority_paths:
+    - projects
+`), 0644)
+	assert.NoError(t, err)
+	load := func(positional ...string) (*config.SyncConfig, error) {
+		return config.LoadConfig(configPath, func(c *config.SyncConfig) error {
+			return applySyncArgs(c, positional)
+		})
+	}
+
+	t.Run("指定本地路径和S3远端", func(t *testing.T) {
+		c, err := load("/work", "s3://my-bucket/photos")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "/work", c.Local.Path)
+		assert.Equal(t, enum.BackendS3, c.Remote.Type)
+		assert.Equal(t, "s3.example.com", c.Remote.Endpoint)
+		assert.Equal(t, "my-bucket", c.Remote.Bucket)
+		assert.Equal(t, "photos", c.Remote.Path)
+		assert.Empty(t, c.Replicas)
+		assert.Equal(t, []string{"/work/projects"}, c.Sync.PriorityPaths)
+	})
+
+	t.Run("指定本地目录远端", func(t *testing.T) {
+		c, err := load("/work", "file:///mnt/backup")
+
+		assert.NoError(t, err)
+		assert.Equal(t, enum.BackendLocal, c.Remote.Type)
+		assert.Equal(t, "/mnt/backup", c.Remote.Bucket)
+		assert.Empty(t, c.Remote.Path)
+		assert.Empty(t, c.Replicas)
+	})
+
+	t.Run("只指定本地路径时保留远端配置", func(t *testing.T) {
+		c, err := load("/work")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "config-bucket", c.Remote.Bucket)
+		assert.Len(t, c.Replicas, 1)
+	})
+
+	t.Run("临时目录在参数指定的本地路径内", func(t *testing.T) {
+		_, err := load("/data", "my-bucket")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jorben/rsync-object-storage/config"
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/jorben/rsync-object-storage/log"
+	"github.com/jorben/rsync-object-storage/state"
+)
+
+// Puller 双向同步的远端拉取任务，定期轮询远端路径，把远端变更下载到本地
+// 本地变更仍由 Watcher/Transfer 推送，双方都发生变更时保留两份副本
+type Puller struct {
+	Enable        bool
+	Interval      time.Duration
+	LocalPrefix   string
+	SymLink       string
+	Hostname      string
+	IgnoreMatcher *helper.IgnoreMatcher
+	PutChan       chan string
+	Storage       *Storage
+	State         *state.Store
+}
+
+// NewPuller 创建远端拉取任务实例
+func NewPuller(c *config.SyncConfig, putCh chan string, storage *Storage, store *state.Store) *Puller {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Errorf("Hostname err: %s", err.Error())
+		hostname = "unknown"
+	}
+	return &Puller{
+		Enable:        c.Sync.Bidirectional.Enable,
+		Interval:      time.Duration(c.Sync.Bidirectional.Interval) * time.Second,
+		LocalPrefix:   c.Local.Path,
+		SymLink:       c.Sync.Symlink,
+		Hostname:      strings.ReplaceAll(hostname, "/", "_"),
+		IgnoreMatcher: helper.NewIgnoreMatcher(c.Sync.Ignore),
+		PutChan:       putCh,
+		Storage:       storage,
+		State:         store,
+	}
+}
+
+// Run 远端拉取任务启动入口
+// 支持通过context取消实现优雅退出
+func (p *Puller) Run(ctx context.Context) {
+	if !p.Enable {
+		log.Debug("The bidirectional sync is disabled")
+		<-ctx.Done()
+		return
+	}
+
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.Poll(ctx)
+		select {
+		case <-ctx.Done():
+			log.Debug("Puller received shutdown signal, exiting...")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll 执行一次远端轮询，下载远端变更并处理远端删除
+func (p *Puller) Poll(ctx context.Context) {
+	log.Debug("Poll remote begin")
+	// 晚于本次列举开始时间记录的状态以本地为准，避免把刚上传的文件误判为远端删除
+	listAt := time.Now()
+	complete := true
+	seen := make(map[string]struct{})
+	for object := range p.Storage.ListObjects(ctx) {
+		if object.Err != nil {
+			log.Errorf("ListObjects err: %s", object.Err.Error())
+			complete = false
+			continue
+		}
+		// 仅同步普通文件，空目录和符号链接由本地推送，回收站和快照不同步
+		if p.Storage.IsTrash(object.Key) || p.Storage.IsSnapshot(object.Key) || strings.HasSuffix(object.Key, "/") || (strings.HasSuffix(object.Key, "/.keep") && object.Size == 0) ||
+			(p.SymLink != enum.SymlinkSkip && strings.HasSuffix(object.Key, ".link")) {
+			continue
+		}
+		localPath, err := p.Storage.GetLocalPath(object.Key)
+		if err != nil {
+			log.Errorf("Pull err: %s, object: %s", err.Error(), object.Key)
+			continue
+		}
+		if p.IgnoreMatcher.Match(localPath) {
+			continue
+		}
+		seen[localPath] = struct{}{}
+		if err := p.pullObject(ctx, localPath, object, listAt); err != nil {
+			log.Errorf("Pull err: %s, object: %s", err.Error(), object.Key)
+		}
+	}
+
+	// 列举不完整时无法判断远端删除
+	if complete && ctx.Err() == nil {
+		for _, localPath := range p.State.Keys() {
+			if _, ok := seen[localPath]; !ok {
+				p.removeLocal(ctx, localPath, listAt)
+			}
+		}
+	}
+
+	if err := p.State.Save(); err != nil {
+		log.Errorf("Save state err: %s", err.Error())
+	}
+	log.Debug("Poll remote ends")
+}
+
+// pullObject 对比远端对象与同步状态，下载远端变更或处理冲突
+func (p *Puller) pullObject(ctx context.Context, localPath string, object ObjectInfo, listAt time.Time) error {
+	if err := p.Storage.checkLocalParents(localPath); err != nil {
+		return err
+	}
+	entry, synced := p.State.Get(localPath)
+	if synced && (entry.ETag == object.ETag || entry.SyncedAt.After(listAt)) {
+		// 远端未变更
+		return nil
+	}
+
+	fileInfo, err := os.Lstat(localPath)
+	if os.IsNotExist(err) {
+		log.Infof("Remote changed %s", localPath)
+		return p.download(ctx, localPath, object)
+	}
+	if err != nil {
+		return err
+	}
+	if !fileInfo.Mode().IsRegular() {
+		log.Debugf("Skip pull, local is not a regular file %s", localPath)
+		return nil
+	}
+
+	// 本地自上次同步后未变更，直接以远端为准
+	if synced && fileInfo.Size() == entry.Size && fileInfo.ModTime().Equal(entry.ModTime) {
+		log.Infof("Remote changed %s", localPath)
+		return p.download(ctx, localPath, object)
+	}
+
+	// 本地与远端内容一致（例如首次同步或另一端上传了相同内容），只更新状态
+	if p.Storage.isSameObject(localPath, object) {
+		p.State.Set(localPath, state.Entry{
+			ETag:     object.ETag,
+			Size:     fileInfo.Size(),
+			ModTime:  fileInfo.ModTime(),
+			SyncedAt: time.Now(),
+		})
+		return nil
+	}
+
+	// 双方都发生了变更，远端版本另存为冲突副本，本地版本保留原名并推送到远端
+	conflictPath := fmt.Sprintf("%s.conflict-%s-%s", localPath, p.Hostname, time.Now().Format("20060102T150405"))
+	log.Warnf("Conflict detected %s, remote version saved as %s", localPath, conflictPath)
+	if err := p.Storage.FGetObject(ctx, object.Key, conflictPath); err != nil {
+		return err
+	}
+	p.State.Set(localPath, state.Entry{
+		ETag:     object.ETag,
+		Size:     fileInfo.Size(),
+		ModTime:  fileInfo.ModTime(),
+		SyncedAt: time.Now(),
+	})
+	for _, path := range []string{localPath, conflictPath} {
+		select {
+		case p.PutChan <- path:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// download 下载远端对象覆盖本地文件，并记录同步状态
+func (p *Puller) download(ctx context.Context, localPath string, object ObjectInfo) error {
+	if err := p.Storage.FGetObject(ctx, object.Key, localPath); err != nil {
+		return err
+	}
+	// 使用远端修改时间作为本地修改时间，避免分片对象被判定为本地较新而重复上传
+	if err := os.Chtimes(localPath, object.LastModified, object.LastModified); err != nil {
+		log.Errorf("Chtimes err: %s, path: %s", err.Error(), localPath)
+	}
+	fileInfo, err := os.Stat(localPath)
+	if err != nil {
+		return err
+	}
+	p.State.Set(localPath, state.Entry{
+		ETag:     object.ETag,
+		Size:     fileInfo.Size(),
+		ModTime:  fileInfo.ModTime(),
+		SyncedAt: time.Now(),
+	})
+	log.Infof("Pull success, path: %s", localPath)
+	return nil
+}
+
+// removeLocal 处理远端已删除的路径，本地未变更时删除本地文件，否则重新推送本地版本
+func (p *Puller) removeLocal(ctx context.Context, localPath string, listAt time.Time) {
+	entry, ok := p.State.Get(localPath)
+	if !ok || entry.SyncedAt.After(listAt) {
+		return
+	}
+	if err := p.Storage.checkLocalParents(localPath); err != nil {
+		log.Errorf("Remove err: %s", err.Error())
+		return
+	}
+	fileInfo, err := os.Lstat(localPath)
+	if err != nil {
+		// 双方都已删除
+		p.State.Delete(localPath)
+		return
+	}
+	p.State.Delete(localPath)
+	if fileInfo.Mode().IsRegular() && fileInfo.Size() == entry.Size && fileInfo.ModTime().Equal(entry.ModTime) {
+		if err := os.Remove(localPath); err != nil {
+			log.Errorf("Remove err: %s, path: %s", err.Error(), localPath)
+			return
+		}
+		log.Infof("Remote removed, local removed %s", localPath)
+		return
+	}
+	// 本地在远端删除后发生了变更，保留本地版本
+	log.Warnf("Remote removed but local changed, keep local %s", localPath)
+	select {
+	case p.PutChan <- localPath:
+	case <-ctx.Done():
+	}
+}
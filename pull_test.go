@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/jorben/rsync-object-storage/log"
+	"github.com/jorben/rsync-object-storage/mocks"
+	"github.com/jorben/rsync-object-storage/state"
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func init() {
+	// 初始化一个空的 logger 用于测试
+	log.InitNopLogger()
+}
+
+// newTestPuller 创建测试用的 Puller 实例
+func newTestPuller(t *testing.T, mockClient *mocks.MockObjectStorageClient, localPrefix string) *Puller {
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+	assert.NoError(t, err)
+	storage := &Storage{
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
+		LocalPrefix:  localPrefix,
+		RemotePrefix: "remote",
+		SymLink:      enum.SymlinkSkip,
+		State:        store,
+	}
+	return &Puller{
+		Enable:        true,
+		Interval:      time.Minute,
+		LocalPrefix:   localPrefix,
+		SymLink:       enum.SymlinkSkip,
+		Hostname:      "host",
+		IgnoreMatcher: helper.NewIgnoreMatcher(nil),
+		PutChan:       make(chan string, 10),
+		Storage:       storage,
+		State:         store,
+	}
+}
+
+// writeOnDownload 模拟 FGetObject 下载写入文件内容
+func writeOnDownload(content string) func(args mock.Arguments) {
+	return func(args mock.Arguments) {
+		_ = os.WriteFile(args.String(3), []byte(content), 0644)
+	}
+}
+
+// TestPuller_Poll_NewRemoteFile 测试远端新增文件被下载到本地
+func TestPuller_Poll_NewRemoteFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	localPath := filepath.Join(tmpDir, "new.txt")
+	lastModified := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket", mock.Anything).
+		Return(objectsChan(minio.ObjectInfo{Key: "remote/new.txt", ETag: "etag1", LastModified: lastModified}))
+	mockClient.On("FGetObject", mock.Anything, "test-bucket", "remote/new.txt", localPath, minio.GetObjectOptions{}).
+		Run(writeOnDownload("remote content")).Return(nil)
+
+	p := newTestPuller(t, mockClient, tmpDir)
+	p.Poll(context.Background())
+
+	content, _ := os.ReadFile(localPath)
+	assert.Equal(t, "remote content", string(content))
+	entry, ok := p.State.Get(localPath)
+	assert.True(t, ok)
+	assert.Equal(t, "etag1", entry.ETag)
+	assert.True(t, entry.ModTime.Equal(lastModified))
+	mockClient.AssertExpectations(t)
+}
+
+// TestPuller_Poll_RemoteChanged 测试本地未变更时以远端为准
+func TestPuller_Poll_RemoteChanged(t *testing.T) {
+	tmpDir := t.TempDir()
+	localPath := filepath.Join(tmpDir, "file.txt")
+	err := os.WriteFile(localPath, []byte("old content"), 0644)
+	assert.NoError(t, err)
+	fileInfo, _ := os.Stat(localPath)
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket", mock.Anything).
+		Return(objectsChan(minio.ObjectInfo{Key: "remote/file.txt", ETag: "etag2", LastModified: time.Now()}))
+	mockClient.On("FGetObject", mock.Anything, "test-bucket", "remote/file.txt", localPath, minio.GetObjectOptions{}).
+		Run(writeOnDownload("new content")).Return(nil)
+
+	p := newTestPuller(t, mockClient, tmpDir)
+	p.State.Set(localPath, state.Entry{ETag: "etag1", Size: fileInfo.Size(), ModTime: fileInfo.ModTime()})
+	p.Poll(context.Background())
+
+	content, _ := os.ReadFile(localPath)
+	assert.Equal(t, "new content", string(content))
+	entry, _ := p.State.Get(localPath)
+	assert.Equal(t, "etag2", entry.ETag)
+	mockClient.AssertExpectations(t)
+}
+
+// TestPuller_Poll_RemoteUnchanged 测试远端未变更时不下载
+func TestPuller_Poll_RemoteUnchanged(t *testing.T) {
+	tmpDir := t.TempDir()
+	localPath := filepath.Join(tmpDir, "file.txt")
+	err := os.WriteFile(localPath, []byte("local edit"), 0644)
+	assert.NoError(t, err)
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket", mock.Anything).
+		Return(objectsChan(minio.ObjectInfo{Key: "remote/file.txt", ETag: "etag1"}))
+
+	p := newTestPuller(t, mockClient, tmpDir)
+	p.State.Set(localPath, state.Entry{ETag: "etag1", Size: 1, ModTime: time.Now().Add(-time.Hour)})
+	p.Poll(context.Background())
+
+	content, _ := os.ReadFile(localPath)
+	assert.Equal(t, "local edit", string(content))
+	mockClient.AssertNotCalled(t, "FGetObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// TestPuller_Poll_Conflict 测试双方都发生变更时保留两份副本
+func TestPuller_Poll_Conflict(t *testing.T) {
+	tmpDir := t.TempDir()
+	localPath := filepath.Join(tmpDir, "file.txt")
+	err := os.WriteFile(localPath, []byte("local edit"), 0644)
+	assert.NoError(t, err)
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket", mock.Anything).
+		Return(objectsChan(minio.ObjectInfo{Key: "remote/file.txt", ETag: "etag2"}))
+	mockClient.On("FGetObject", mock.Anything, "test-bucket", "remote/file.txt",
+		mock.MatchedBy(func(path string) bool {
+			return strings.HasPrefix(path, localPath+".conflict-host-")
+		}), minio.GetObjectOptions{}).
+		Run(writeOnDownload("remote edit")).Return(nil)
+
+	p := newTestPuller(t, mockClient, tmpDir)
+	// 上次同步后本地又发生了修改
+	p.State.Set(localPath, state.Entry{ETag: "etag1", Size: 1, ModTime: time.Now().Add(-time.Hour)})
+	p.Poll(context.Background())
+
+	// 本地版本保持原名
+	content, _ := os.ReadFile(localPath)
+	assert.Equal(t, "local edit", string(content))
+	// 远端版本保存为冲突副本
+	matches, _ := filepath.Glob(localPath + ".conflict-host-*")
+	assert.Len(t, matches, 1)
+	conflict, _ := os.ReadFile(matches[0])
+	assert.Equal(t, "remote edit", string(conflict))
+	// 两份副本都会被推送
+	assert.Equal(t, localPath, <-p.PutChan)
+	assert.Equal(t, matches[0], <-p.PutChan)
+	mockClient.AssertExpectations(t)
+}
+
+// TestPuller_Poll_SameContent 测试首次同步内容一致时只记录状态
+func TestPuller_Poll_SameContent(t *testing.T) {
+	tmpDir := t.TempDir()
+	localPath := filepath.Join(tmpDir, "file.txt")
+	err := os.WriteFile(localPath, []byte("hello world"), 0644)
+	assert.NoError(t, err)
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket", mock.Anything).
+		Return(objectsChan(minio.ObjectInfo{Key: "remote/file.txt", ETag: "5eb63bbbe01eeed093cb22bb8f5acdc3"}))
+
+	p := newTestPuller(t, mockClient, tmpDir)
+	p.Poll(context.Background())
+
+	entry, ok := p.State.Get(localPath)
+	assert.True(t, ok)
+	assert.Equal(t, "5eb63bbbe01eeed093cb22bb8f5acdc3", entry.ETag)
+	mockClient.AssertNotCalled(t, "FGetObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// TestPuller_Poll_RemoteRemoved 测试远端删除的处理
+func TestPuller_Poll_RemoteRemoved(t *testing.T) {
+	tmpDir := t.TempDir()
+	unchanged := filepath.Join(tmpDir, "unchanged.txt")
+	changed := filepath.Join(tmpDir, "changed.txt")
+	assert.NoError(t, os.WriteFile(unchanged, []byte("content"), 0644))
+	assert.NoError(t, os.WriteFile(changed, []byte("content"), 0644))
+	unchangedInfo, _ := os.Stat(unchanged)
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket", mock.Anything).Return(objectsChan())
+
+	p := newTestPuller(t, mockClient, tmpDir)
+	syncedAt := time.Now().Add(-time.Minute)
+	p.State.Set(unchanged, state.Entry{ETag: "etag", Size: unchangedInfo.Size(), ModTime: unchangedInfo.ModTime(), SyncedAt: syncedAt})
+	p.State.Set(changed, state.Entry{ETag: "etag", Size: 1, ModTime: time.Now().Add(-time.Hour), SyncedAt: syncedAt})
+	// 列举开始后才上传完成的文件不应被删除
+	recent := filepath.Join(tmpDir, "recent.txt")
+	assert.NoError(t, os.WriteFile(recent, []byte("content"), 0644))
+	p.State.Set(recent, state.Entry{ETag: "etag", SyncedAt: time.Now().Add(time.Minute)})
+
+	p.Poll(context.Background())
+
+	// 本地未变更，随远端删除
+	isExist, _ := helper.IsExist(unchanged)
+	assert.False(t, isExist)
+	// 本地已变更，保留并重新推送
+	isExist, _ = helper.IsExist(changed)
+	assert.True(t, isExist)
+	assert.Equal(t, changed, <-p.PutChan)
+	isExist, _ = helper.IsExist(recent)
+	assert.True(t, isExist)
+	assert.Equal(t, []string{recent}, p.State.Keys())
+}
+
+// TestPuller_Poll_SymlinkParent 测试本地上级目录是符号链接时不经由链接下载或删除本地路径之外的文件
+func TestPuller_Poll_SymlinkParent(t *testing.T) {
+	tmpDir, outsideDir := t.TempDir(), t.TempDir()
+	assert.NoError(t, os.Symlink(outsideDir, filepath.Join(tmpDir, "x")))
+	outsideFile := filepath.Join(outsideDir, "old.txt")
+	assert.NoError(t, os.WriteFile(outsideFile, []byte("content"), 0644))
+	fileInfo, _ := os.Stat(outsideFile)
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket", mock.Anything).
+		Return(objectsChan(minio.ObjectInfo{Key: "remote/x/job", ETag: "etag1"}))
+
+	p := newTestPuller(t, mockClient, tmpDir)
+	localPath := filepath.Join(tmpDir, "x", "old.txt")
+	p.State.Set(localPath, state.Entry{ETag: "etag", Size: fileInfo.Size(), ModTime: fileInfo.ModTime(),
+		SyncedAt: time.Now().Add(-time.Minute)})
+	p.Poll(context.Background())
+
+	assert.NoFileExists(t, filepath.Join(outsideDir, "job"))
+	assert.FileExists(t, outsideFile)
+	mockClient.AssertNotCalled(t, "FGetObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// TestPuller_Poll_ListError 测试列举失败时不处理远端删除
+func TestPuller_Poll_ListError(t *testing.T) {
+	tmpDir := t.TempDir()
+	localPath := filepath.Join(tmpDir, "file.txt")
+	assert.NoError(t, os.WriteFile(localPath, []byte("content"), 0644))
+	fileInfo, _ := os.Stat(localPath)
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket", mock.Anything).
+		Return(objectsChan(minio.ObjectInfo{Err: assert.AnError}))
+
+	p := newTestPuller(t, mockClient, tmpDir)
+	p.State.Set(localPath, state.Entry{ETag: "etag", Size: fileInfo.Size(), ModTime: fileInfo.ModTime()})
+	p.Poll(context.Background())
+
+	isExist, _ := helper.IsExist(localPath)
+	assert.True(t, isExist)
+}
+
+// TestPuller_Run_Disabled 测试未启用时等待退出
+func TestPuller_Run_Disabled(t *testing.T) {
+	p := &Puller{Enable: false}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Puller 未能响应 Context 取消")
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/jorben/rsync-object-storage/config"
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/jorben/rsync-object-storage/log"
+)
+
+// Restore 恢复任务，把远端路径下的对象拉取回本地路径
+type Restore struct {
+	LocalPrefix   string
+	SymLink       string
+	IgnoreMatcher *helper.IgnoreMatcher
+	Storage       *Storage
+}
+
+// NewRestore 创建恢复任务实例
+func NewRestore(c *config.SyncConfig, storage *Storage) *Restore {
+	return &Restore{
+		LocalPrefix:   c.Local.Path,
+		SymLink:       c.Sync.Symlink,
+		IgnoreMatcher: helper.NewIgnoreMatcher(c.Sync.Ignore),
+		Storage:       storage,
+	}
+}
+
+// Run 遍历远端对象并恢复到本地，存在失败的对象时返回错误
+func (r *Restore) Run(ctx context.Context) error {
+	if r.Storage.CAS != nil {
+		return r.runManifest(ctx)
+	}
+	log.Info("Restore begin")
+	var restored, skipped, failed int
+	for object := range r.Storage.ListObjects(ctx) {
+		if object.Err != nil {
+			log.Errorf("ListObjects err: %s", object.Err.Error())
+			failed++
+			continue
+		}
+		if r.Storage.IsTrash(object.Key) || r.Storage.IsChunkStore(object.Key) || r.Storage.IsSnapshot(object.Key) {
+			continue
+		}
+		err := r.restoreObject(ctx, object)
+		if err == nil {
+			restored++
+		} else if errors.Is(err, enum.ErrSkipTransfer) {
+			skipped++
+		} else {
+			failed++
+			log.Errorf("Restore err: %s, object: %s", err.Error(), object.Key)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	log.Infof("Restore ends, restored: %d, skipped: %d, failed: %d", restored, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d object(s) failed to restore", failed)
+	}
+	return nil
+}
+
+// restoreObject 按对象类型（空目录、符号链接、普通文件）恢复单个对象
+func (r *Restore) restoreObject(ctx context.Context, object ObjectInfo) error {
+	key := object.Key
+	switch {
+	case strings.HasSuffix(key, "/"):
+		// 其他工具创建的目录占位对象
+		localPath, err := r.Storage.GetLocalPath(key)
+		if err != nil {
+			return err
+		}
+		return r.restoreDir(localPath)
+	case strings.HasSuffix(key, "/.keep") && object.Size == 0:
+		// 空目录用.keep文件构建，有内容的 .keep 是真实文件
+		localPath, err := r.Storage.GetLocalPath(strings.TrimSuffix(key, "/.keep"))
+		if err != nil {
+			return err
+		}
+		return r.restoreDir(localPath)
+	case r.SymLink != enum.SymlinkSkip && strings.HasSuffix(key, ".link"):
+		// addr策略上传的符号链接，内容为链接指向的地址
+		return r.restoreSymlink(ctx, object)
+	case strings.HasSuffix(key, chunkListSuffix):
+		// 分块上传的文件，按块列表拼接数据块
+		return r.restoreChunked(ctx, object)
+	default:
+		return r.restoreFile(ctx, object)
+	}
+}
+
+// restoreDir 恢复空目录
+func (r *Restore) restoreDir(localPath string) error {
+	if r.IgnoreMatcher.Match(localPath) {
+		return enum.ErrSkipTransfer
+	}
+	if err := r.Storage.checkLocalParents(localPath); err != nil {
+		return err
+	}
+	if isDir, _ := helper.IsDir(localPath); isDir {
+		return enum.ErrSkipTransfer
+	}
+	if err := os.MkdirAll(localPath, 0755); err != nil {
+		return err
+	}
+	log.Infof("Restore dir success, path: %s", localPath)
+	return nil
+}
+
+// restoreSymlink 恢复符号链接
+func (r *Restore) restoreSymlink(ctx context.Context, object ObjectInfo) error {
+	localPath, err := r.Storage.GetLocalPath(strings.TrimSuffix(object.Key, ".link"))
+	if err != nil {
+		return err
+	}
+	if r.IgnoreMatcher.Match(localPath) {
+		return enum.ErrSkipTransfer
+	}
+
+	// 下载链接地址到临时文件
+	randomString, err := helper.RandomString(32)
+	if err != nil {
+		log.Errorf("RandomString err: %s", err.Error())
+		randomString = "tmp_link_content"
+	}
+	tmp := r.Storage.tempPath("." + randomString)
+	if err := r.Storage.FGetObject(ctx, object.Key, tmp); err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	content, err := os.ReadFile(tmp)
+	if err != nil {
+		return err
+	}
+	target := string(content)
+
+	return r.restoreLink(localPath, target)
+}
+
+// restoreFile 恢复普通文件，本地内容与远端一致时跳过
+func (r *Restore) restoreFile(ctx context.Context, object ObjectInfo) error {
+	localPath, err := r.Storage.GetLocalPath(object.Key)
+	if err != nil {
+		return err
+	}
+	if r.IgnoreMatcher.Match(localPath) {
+		return enum.ErrSkipTransfer
+	}
+	if err := r.Storage.checkLocalParents(localPath); err != nil {
+		return err
+	}
+	if r.Storage.isSameObject(localPath, object) {
+		log.Debugf("Skip restore, local is same %s", localPath)
+		return enum.ErrSkipTransfer
+	}
+	// 列举结果可能不包含用户元数据，下载前获取一次以识别压缩上传的对象
+	if _, ok := r.Storage.Backend.(MetadataBackend); ok && object.Metadata == nil {
+		objectInfo, err := r.Storage.Backend.StatObject(ctx, object.Key)
+		if err != nil {
+			return err
+		}
+		object.Metadata = objectInfo.Metadata
+		if object.Metadata != nil && r.Storage.isSameObject(localPath, object) {
+			log.Debugf("Skip restore, local is same %s", localPath)
+			return enum.ErrSkipTransfer
+		}
+	}
+	if err := r.Storage.downloadObject(ctx, object, localPath); err != nil {
+		return err
+	}
+	// 使用远端修改时间作为本地修改时间，避免分片对象在下次对账时被判定为本地较新
+	if err := os.Chtimes(localPath, object.LastModified, object.LastModified); err != nil {
+		log.Errorf("Chtimes err: %s, path: %s", err.Error(), localPath)
+	}
+	log.Infof("Restore success, path: %s, size: %s", localPath, helper.ByteFormat(object.Size))
+	return nil
+}
+
+// restoreChunked 按块列表依次下载数据块恢复文件，校验每个数据块的SHA256后使用块列表记录的修改时间
+// 本地文件的分块结果与块列表一致时跳过
+func (r *Restore) restoreChunked(ctx context.Context, object ObjectInfo) error {
+	localPath, err := r.Storage.GetLocalPath(strings.TrimSuffix(object.Key, chunkListSuffix))
+	if err != nil {
+		return err
+	}
+	if r.IgnoreMatcher.Match(localPath) {
+		return enum.ErrSkipTransfer
+	}
+	if err := r.Storage.checkLocalParents(localPath); err != nil {
+		return err
+	}
+	list, err := r.Storage.loadChunkList(ctx, object.Key)
+	if err != nil {
+		return err
+	}
+	if fileInfo, err := os.Lstat(localPath); err == nil && fileInfo.Mode().IsRegular() && fileInfo.Size() == list.Size {
+		if chunks, _ := helper.GetCachedFileChunks(localPath, r.Storage.ChunkSize); isSameChunks(chunks, list.Chunks) {
+			log.Debugf("Skip restore, local is same %s", localPath)
+			return enum.ErrSkipTransfer
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	// 先写入临时文件，全部数据块校验通过后再替换本地文件
+	tmp := localPath + downloadPartSuffix
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	for _, chunk := range list.Chunks {
+		if err = r.downloadChunk(ctx, file, chunk); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, localPath); err != nil {
+		return err
+	}
+	if err := os.Chtimes(localPath, list.ModTime, list.ModTime); err != nil {
+		log.Errorf("Chtimes err: %s, path: %s", err.Error(), localPath)
+	}
+	log.Infof("Restore success, path: %s, size: %s, chunks: %d", localPath, helper.ByteFormat(list.Size), len(list.Chunks))
+	return nil
+}
+
+// downloadChunk 下载数据块并追加写入文件，内容的SHA256与块列表不一致时返回错误
+func (r *Restore) downloadChunk(ctx context.Context, file *os.File, chunk helper.Chunk) error {
+	reader, err := r.Storage.GetObject(ctx, r.Storage.chunkName(chunk.Hash))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(io.LimitReader(reader, chunk.Size+1))
+	if err != nil {
+		return err
+	}
+	if actual := helper.NewChunk(data); actual != chunk {
+		return fmt.Errorf("chunk SHA256 mismatch, expected %s, got %s", chunk.Hash, actual.Hash)
+	}
+	_, err = file.Write(data)
+	return err
+}
+
+// runManifest CAS模式下按清单重建本地路径，存在失败的路径时返回错误
+func (r *Restore) runManifest(ctx context.Context) error {
+	log.Info("Restore from manifest begin")
+	entries, err := r.Storage.CASEntries(ctx)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	// 上级目录先于子路径创建
+	sort.Strings(keys)
+	var restored, skipped, failed int
+	var dirs []string
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		entry := entries[key]
+		localPath, err := r.Storage.joinLocalPath(key)
+		if err != nil {
+			failed++
+			log.Errorf("Restore err: %s", err.Error())
+			continue
+		}
+		if r.IgnoreMatcher.Match(localPath) {
+			skipped++
+			continue
+		}
+		switch {
+		case entry.Mode.IsDir():
+			err = r.restoreDir(localPath)
+			dirs = append(dirs, key)
+		case entry.Link != "":
+			err = r.restoreLink(localPath, entry.Link)
+		default:
+			err = r.restoreBlob(ctx, localPath, entry)
+		}
+		if err == nil {
+			restored++
+		} else if errors.Is(err, enum.ErrSkipTransfer) {
+			skipped++
+		} else {
+			failed++
+			log.Errorf("Restore err: %s, path: %s", err.Error(), localPath)
+		}
+	}
+	// 目录的权限在子路径恢复后设置，避免只读目录下无法创建文件，Chmod会跟随符号链接，跳过已被替换为链接的目录
+	for i := len(dirs) - 1; i >= 0; i-- {
+		localPath, _ := r.Storage.joinLocalPath(dirs[i])
+		if fileInfo, err := os.Lstat(localPath); err != nil || !fileInfo.IsDir() || r.Storage.checkLocalParents(localPath) != nil {
+			continue
+		}
+		if err := os.Chmod(localPath, entries[dirs[i]].Mode.Perm()); err != nil {
+			log.Errorf("Chmod err: %s, path: %s", err.Error(), localPath)
+		}
+	}
+	log.Infof("Restore ends, restored: %d, skipped: %d, failed: %d", restored, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d path(s) failed to restore", failed)
+	}
+	return nil
+}
+
+// restoreLink 按地址恢复符号链接，本地已经是指向相同地址的符号链接时跳过
+func (r *Restore) restoreLink(localPath, target string) error {
+	if err := r.Storage.checkLocalParents(localPath); err != nil {
+		return err
+	}
+	if isLink, _ := helper.IsSymlink(localPath); isLink {
+		if current, _ := helper.GetSymlinkTarget(localPath); current == target {
+			return enum.ErrSkipTransfer
+		}
+	}
+	if err := os.Remove(localPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	if err := os.Symlink(target, localPath); err != nil {
+		return err
+	}
+	log.Infof("Restore symlink success, path: %s -> %s", localPath, target)
+	return nil
+}
+
+// restoreBlob 下载清单中记录的内容对象恢复普通文件，校验内容的SHA256后设置权限和修改时间
+// 本地文件内容与清单一致时跳过
+func (r *Restore) restoreBlob(ctx context.Context, localPath string, entry CASEntry) error {
+	if err := r.Storage.checkLocalParents(localPath); err != nil {
+		return err
+	}
+	if fileInfo, err := os.Lstat(localPath); err == nil && fileInfo.Mode().IsRegular() && fileInfo.Size() == entry.Size {
+		if hash, _ := helper.FileSha256(localPath); hash == entry.Hash {
+			log.Debugf("Skip restore, local is same %s", localPath)
+			return enum.ErrSkipTransfer
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	// 先下载到临时文件，校验通过后再替换本地文件
+	tmp := localPath + downloadPartSuffix
+	if err := r.Storage.FGetObject(ctx, r.Storage.casBlobName(entry.Hash), tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	hash, err := helper.FileSha256(tmp)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if hash != entry.Hash {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("content SHA256 mismatch, expected %s, got %s", entry.Hash, hash)
+	}
+	if err := os.Rename(tmp, localPath); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(localPath, entry.Mode.Perm()); err != nil {
+		log.Errorf("Chmod err: %s, path: %s", err.Error(), localPath)
+	}
+	if err := os.Chtimes(localPath, entry.ModTime, entry.ModTime); err != nil {
+		log.Errorf("Chtimes err: %s, path: %s", err.Error(), localPath)
+	}
+	log.Infof("Restore success, path: %s, size: %s", localPath, helper.ByteFormat(entry.Size))
+	return nil
+}
+
+// restoreCommand restore 子命令入口，把远端路径恢复到 local.path，返回进程退出码
+// 配置了多个同步任务时依次恢复全部任务，可通过 -job 指定只恢复其中一个，通过 -snapshot 指定从快照恢复
+func restoreCommand(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	configPath := flags.String("c", "./config.yaml", "Path to the configuration file")
+	jobName := flags.String("job", "", "Only restore the sync job with this `NAME`")
+	snapshotID := flags.String("snapshot", "", "Restore from the snapshot with this `ID` instead of the live mirror")
+	_ = flags.Parse(args)
+
+	c, err := config.GetConfig(*configPath)
+	if err != nil {
+		fmt.Printf("Load config err: %s\n", err.Error())
+		return 1
+	}
+
+	// 初始化日志
+	log.InitLogger(c.Log)
+	defer log.GetLogger().Sync()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	found := false
+	for _, job := range c.GetJobs() {
+		if *jobName != "" && job.Name != *jobName {
+			continue
+		}
+		found = true
+		s, err := NewStorage(job)
+		if err != nil {
+			log.Errorf("NewStorage err: %s", err.Error())
+			return 1
+		}
+		if err = s.BucketExists(ctx); err != nil {
+			log.Errorf("BucketExist err: %s", err.Error())
+			return 1
+		}
+		if *snapshotID != "" {
+			s = NewSnapshot(job, s).snapshotStorage(*snapshotID)
+		}
+		if err = os.MkdirAll(job.Local.Path, 0755); err != nil {
+			log.Errorf("MkdirAll err: %s", err.Error())
+			return 1
+		}
+
+		if err = NewRestore(job, s).Run(ctx); err != nil {
+			log.Errorf("Restore err: %s", err.Error())
+			return 1
+		}
+	}
+	if !found {
+		log.Errorf("Sync job %s is not found", *jobName)
+		return 1
+	}
+	return 0
+}
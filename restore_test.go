@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/jorben/rsync-object-storage/log"
+	"github.com/jorben/rsync-object-storage/mocks"
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func init() {
+	// 初始化一个空的 logger 用于测试
+	log.InitNopLogger()
+}
+
+// objectsChan 构造 ListObjects 返回的对象通道
+func objectsChan(objects ...minio.ObjectInfo) <-chan minio.ObjectInfo {
+	ch := make(chan minio.ObjectInfo, len(objects))
+	for _, object := range objects {
+		ch <- object
+	}
+	close(ch)
+	return ch
+}
+
+// newTestRestore 创建测试用的 Restore 实例
+func newTestRestore(mockClient *mocks.MockObjectStorageClient, localPrefix, symlink string) *Restore {
+	storage := &Storage{
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
+		LocalPrefix:  localPrefix,
+		RemotePrefix: "remote",
+		SymLink:      symlink,
+	}
+	return &Restore{
+		LocalPrefix:   localPrefix,
+		SymLink:       symlink,
+		IgnoreMatcher: helper.NewIgnoreMatcher([]string{".git"}),
+		Storage:       storage,
+	}
+}
+
+// TestRestore_Run_File 测试恢复普通文件
+func TestRestore_Run_File(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	lastModified := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket",
+		minio.ListObjectsOptions{Prefix: "remote/", Recursive: true}).
+		Return(objectsChan(minio.ObjectInfo{
+			Key:          "remote/sub/file.txt",
+			ETag:         "5eb63bbbe01eeed093cb22bb8f5acdc3",
+			Size:         11,
+			LastModified: lastModified,
+		}, minio.ObjectInfo{
+			// 快照中的对象不恢复到本地路径
+			Key:          "remote/.snapshots/2026-10-15T04:00:00Z/sub/file.txt",
+			ETag:         "5eb63bbbe01eeed093cb22bb8f5acdc3",
+			Size:         11,
+			LastModified: lastModified,
+		}))
+	mockClient.On("StatObject", mock.Anything, "test-bucket", "remote/sub/file.txt", minio.StatObjectOptions{}).
+		Return(minio.ObjectInfo{
+			Key:          "remote/sub/file.txt",
+			ETag:         "5eb63bbbe01eeed093cb22bb8f5acdc3",
+			Size:         11,
+			LastModified: lastModified,
+		}, nil)
+	mockClient.On("FGetObject", mock.Anything, "test-bucket", "remote/sub/file.txt",
+		filepath.Join(tmpDir, "sub", "file.txt"), minio.GetObjectOptions{}).
+		Run(func(args mock.Arguments) {
+			_ = os.WriteFile(args.String(3), []byte("hello world"), 0644)
+		}).Return(nil)
+
+	r := newTestRestore(mockClient, tmpDir, enum.SymlinkSkip)
+	err := r.Run(ctx)
+
+	assert.NoError(t, err)
+	content, err := os.ReadFile(filepath.Join(tmpDir, "sub", "file.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(content))
+	// 本地修改时间应与远端一致
+	info, _ := os.Stat(filepath.Join(tmpDir, "sub", "file.txt"))
+	assert.True(t, info.ModTime().Equal(lastModified))
+	mockClient.AssertExpectations(t)
+}
+
+// TestRestore_Run_SkipSameFile 测试本地 MD5 与 ETag 一致时跳过下载
+func TestRestore_Run_SkipSameFile(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(tmpDir, "file.txt"), []byte("hello world"), 0644)
+	assert.NoError(t, err)
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket", mock.Anything).
+		Return(objectsChan(minio.ObjectInfo{
+			Key:  "remote/file.txt",
+			ETag: "5eb63bbbe01eeed093cb22bb8f5acdc3",
+			Size: 11,
+		}))
+
+	r := newTestRestore(mockClient, tmpDir, enum.SymlinkSkip)
+	err = r.Run(ctx)
+
+	assert.NoError(t, err)
+	mockClient.AssertNotCalled(t, "FGetObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// TestRestore_Run_EmptyDir 测试通过 .keep 对象恢复空目录
+func TestRestore_Run_EmptyDir(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket", mock.Anything).
+		Return(objectsChan(minio.ObjectInfo{
+			Key:  "remote/empty/nested/.keep",
+			ETag: "d41d8cd98f00b204e9800998ecf8427e",
+		}))
+
+	r := newTestRestore(mockClient, tmpDir, enum.SymlinkSkip)
+	err := r.Run(ctx)
+
+	assert.NoError(t, err)
+	isDir, _ := helper.IsDir(filepath.Join(tmpDir, "empty", "nested"))
+	assert.True(t, isDir)
+	isExist, _ := helper.IsExist(filepath.Join(tmpDir, "empty", "nested", ".keep"))
+	assert.False(t, isExist)
+	mockClient.AssertNotCalled(t, "FGetObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// TestRestore_Run_Symlink 测试 .link 对象恢复为符号链接
+func TestRestore_Run_Symlink(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+
+	t.Run("addr 策略恢复符号链接", func(t *testing.T) {
+		mockClient := new(mocks.MockObjectStorageClient)
+		mockClient.On("ListObjects", mock.Anything, "test-bucket", mock.Anything).
+			Return(objectsChan(minio.ObjectInfo{Key: "remote/link.txt.link"}))
+		mockClient.On("FGetObject", mock.Anything, "test-bucket", "remote/link.txt.link", mock.Anything, minio.GetObjectOptions{}).
+			Run(func(args mock.Arguments) {
+				_ = os.WriteFile(args.String(3), []byte("/target/file.txt"), 0644)
+			}).Return(nil)
+
+		r := newTestRestore(mockClient, tmpDir, enum.SymlinkAddr)
+		err := r.Run(ctx)
+
+		assert.NoError(t, err)
+		target, err := helper.GetSymlinkTarget(filepath.Join(tmpDir, "link.txt"))
+		assert.NoError(t, err)
+		assert.Equal(t, "/target/file.txt", target)
+		mockClient.AssertExpectations(t)
+	})
+
+	t.Run("skip 策略按普通文件恢复", func(t *testing.T) {
+		mockClient := new(mocks.MockObjectStorageClient)
+		mockClient.On("ListObjects", mock.Anything, "test-bucket", mock.Anything).
+			Return(objectsChan(minio.ObjectInfo{Key: "remote/notes.link"}))
+		mockClient.On("StatObject", mock.Anything, "test-bucket", "remote/notes.link", minio.StatObjectOptions{}).
+			Return(minio.ObjectInfo{Key: "remote/notes.link"}, nil)
+		mockClient.On("FGetObject", mock.Anything, "test-bucket", "remote/notes.link",
+			filepath.Join(tmpDir, "notes.link"), minio.GetObjectOptions{}).
+			Run(func(args mock.Arguments) {
+				_ = os.WriteFile(args.String(3), []byte("plain"), 0644)
+			}).Return(nil)
+
+		r := newTestRestore(mockClient, tmpDir, enum.SymlinkSkip)
+		err := r.Run(ctx)
+
+		assert.NoError(t, err)
+		isLink, _ := helper.IsSymlink(filepath.Join(tmpDir, "notes.link"))
+		assert.False(t, isLink)
+		mockClient.AssertExpectations(t)
+	})
+}
+
+// TestRestore_Run_Ignore 测试忽略规则中的路径不会被恢复
+func TestRestore_Run_Ignore(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket", mock.Anything).
+		Return(objectsChan(minio.ObjectInfo{Key: "remote/.git/config", ETag: "abc"}))
+
+	r := newTestRestore(mockClient, tmpDir, enum.SymlinkSkip)
+	err := r.Run(ctx)
+
+	assert.NoError(t, err)
+	mockClient.AssertNotCalled(t, "FGetObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// TestRestore_Run_Failed 测试下载失败时返回错误
+func TestRestore_Run_Failed(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket", mock.Anything).
+		Return(objectsChan(minio.ObjectInfo{Key: "remote/file.txt", ETag: "abc"}))
+	mockClient.On("StatObject", mock.Anything, "test-bucket", "remote/file.txt", minio.StatObjectOptions{}).
+		Return(minio.ObjectInfo{Key: "remote/file.txt", ETag: "abc"}, nil)
+	mockClient.On("FGetObject", mock.Anything, "test-bucket", "remote/file.txt", mock.Anything, minio.GetObjectOptions{}).
+		Return(assert.AnError)
+
+	r := newTestRestore(mockClient, tmpDir, enum.SymlinkSkip)
+	err := r.Run(ctx)
+
+	assert.Error(t, err)
+	mockClient.AssertExpectations(t)
+}
+
+// TestRestore_Run_OutsideLocalPath 测试对象名映射到本地路径之外时不下载
+func TestRestore_Run_OutsideLocalPath(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	localDir := filepath.Join(tmpDir, "local")
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket", mock.Anything).
+		Return(objectsChan(minio.ObjectInfo{Key: "remote/../escape.txt", ETag: "abc", Size: 3}))
+
+	r := newTestRestore(mockClient, localDir, enum.SymlinkSkip)
+	err := r.Run(ctx)
+
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(tmpDir, "escape.txt"))
+	mockClient.AssertNotCalled(t, "FGetObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// TestRestore_Run_SymlinkParent 测试对象的上级目录已被恢复为符号链接时不经由链接写到本地路径之外
+func TestRestore_Run_SymlinkParent(t *testing.T) {
+	ctx := context.Background()
+	tmpDir, outsideDir := t.TempDir(), t.TempDir()
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket", mock.Anything).
+		Return(objectsChan(
+			minio.ObjectInfo{Key: "remote/x.link"},
+			minio.ObjectInfo{Key: "remote/x/cron.d/job", ETag: "abc", Size: 3},
+			minio.ObjectInfo{Key: "remote/x/empty/.keep", ETag: "d41d8cd98f00b204e9800998ecf8427e"},
+		))
+	mockClient.On("FGetObject", mock.Anything, "test-bucket", "remote/x.link", mock.Anything, minio.GetObjectOptions{}).
+		Run(func(args mock.Arguments) {
+			_ = os.WriteFile(args.String(3), []byte(outsideDir), 0644)
+		}).Return(nil)
+
+	r := newTestRestore(mockClient, tmpDir, enum.SymlinkAddr)
+	err := r.Run(ctx)
+
+	assert.Error(t, err)
+	target, err := helper.GetSymlinkTarget(filepath.Join(tmpDir, "x"))
+	assert.NoError(t, err)
+	assert.Equal(t, outsideDir, target)
+	entries, err := os.ReadDir(outsideDir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	mockClient.AssertNotCalled(t, "FGetObject", mock.Anything, "test-bucket", "remote/x/cron.d/job", mock.Anything, mock.Anything)
+}
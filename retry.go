@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jorben/rsync-object-storage/config"
+	"github.com/jorben/rsync-object-storage/log"
+	"github.com/jorben/rsync-object-storage/state"
+	"github.com/minio/minio-go/v7"
+)
+
+// permanentErrorCodes 重试也不会成功的对象存储错误码
+var permanentErrorCodes = map[string]bool{
+	"AccessDenied":            true,
+	"AccountProblem":          true,
+	"AllAccessDisabled":       true,
+	"EntityTooLarge":          true,
+	"InvalidAccessKeyId":      true,
+	"InvalidArgument":         true,
+	"InvalidBucketName":       true,
+	"InvalidObjectName":       true,
+	"XMinioInvalidObjectName": true,
+	"KeyTooLongError":         true,
+	"MethodNotAllowed":        true,
+	"NoSuchBucket":            true,
+	"NotImplemented":          true,
+	"SignatureDoesNotMatch":   true,
+}
+
+// transientErrorCodes 稍后重试可能成功的对象存储错误码
+var transientErrorCodes = map[string]bool{
+	"InternalError":              true,
+	"NoSuchUpload":               true, // 分片上传被中止，重试时重新创建
+	"OperationAborted":           true,
+	"RequestTimeout":             true,
+	"ServiceUnavailable":         true,
+	"SlowDown":                   true,
+	"XMinioServerNotInitialized": true,
+}
+
+// isTransientError 判断错误是否为临时错误（5xx、超时、限流、网络中断等），临时错误重试，永久错误直接进入死信列表
+// 无法识别的错误按临时错误处理
+func isTransientError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var resp minio.ErrorResponse
+	if errors.As(err, &resp) && (resp.Code != "" || resp.StatusCode != 0) {
+		if permanentErrorCodes[resp.Code] {
+			return false
+		}
+		if transientErrorCodes[resp.Code] {
+			return true
+		}
+		return !isPermanentStatus(resp.StatusCode)
+	}
+	var davErr *WebDAVError
+	if errors.As(err, &davErr) {
+		return !isPermanentStatus(davErr.StatusCode)
+	}
+	// 本地文件或本地目录后端的权限、空间不足等错误
+	if errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrNotExist) ||
+		errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EROFS) {
+		return false
+	}
+	return true
+}
+
+// isPermanentStatus 判断HTTP状态码是否为重试也不会成功的客户端错误，超时、冲突、锁定和限流除外
+func isPermanentStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusLocked, http.StatusTooManyRequests:
+		return false
+	}
+	return code >= 400 && code < 500
+}
+
+// RetryPolicy 失败重试策略，按指数退避并加入随机抖动
+type RetryPolicy struct {
+	Attempts  int // 最大尝试次数（含首次）
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// NewRetryPolicy 根据传输配置创建重试策略
+func NewRetryPolicy(c *config.SyncConfig) RetryPolicy {
+	return RetryPolicy{
+		Attempts:  c.Transfer.Retry.Attempts,
+		BaseDelay: time.Duration(c.Transfer.Retry.BaseDelay) * time.Second,
+		MaxDelay:  time.Duration(c.Transfer.Retry.MaxDelay) * time.Second,
+	}
+}
+
+// Backoff 计算第attempt次失败后的等待时间，在指数退避时长的[1/2, 1]范围内随机，避免多个worker同时重试
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.MaxDelay
+	if attempt < 32 && p.BaseDelay<<(attempt-1) < p.MaxDelay {
+		delay = p.BaseDelay << (attempt - 1)
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// deadletterCommand deadletter 子命令入口，返回进程退出码
+// 用法：ros deadletter list|retry|drop [-c config] [-job name] [path...]
+func deadletterCommand(args []string) int {
+	usage := "Usage: ros deadletter list [-c config] [-job name]\n" +
+		"       ros deadletter retry [-c config] [-job name] [path...]\n" +
+		"       ros deadletter drop [-c config] [-job name] <path>..."
+	if len(args) == 0 || (args[0] != "list" && args[0] != "retry" && args[0] != "drop") {
+		fmt.Println(usage)
+		return 2
+	}
+	action := args[0]
+	flags := flag.NewFlagSet("deadletter "+action, flag.ExitOnError)
+	configPath := flags.String("c", "./config.yaml", "Path to the configuration file")
+	jobName := flags.String("job", "", "Only handle the sync job with this `NAME`")
+	positional, err := parseArgs(flags, args[1:])
+	if err != nil {
+		return 2
+	}
+	if action == "drop" && len(positional) == 0 {
+		fmt.Println(usage)
+		return 2
+	}
+
+	c, err := config.GetConfig(*configPath)
+	if err != nil {
+		fmt.Printf("Load config err: %s\n", err.Error())
+		return 1
+	}
+
+	// 初始化日志
+	log.InitLogger(c.Log)
+	defer log.GetLogger().Sync()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if action == "list" {
+		fmt.Fprintln(w, "JOB\tREMOTE\tOP\tPATH\tATTEMPTS\tFAILED AT\tERROR")
+	}
+	exitCode := 0
+	for _, job := range c.GetJobs() {
+		if *jobName != "" && job.Name != *jobName {
+			continue
+		}
+		deadLetters, err := state.OpenDeadLetters(job.DeadLetterPath())
+		if err != nil {
+			log.Errorf("Open dead letters err: %s", err.Error())
+			return 1
+		}
+		// 相对路径按 local.path 解析
+		var paths []string
+		for _, path := range positional {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(job.Local.Path, path)
+			}
+			paths = append(paths, filepath.Clean(path))
+		}
+
+		switch action {
+		case "list":
+			items, err := deadLetters.List()
+			if err != nil {
+				log.Errorf("List dead letters err: %s", err.Error())
+				return 1
+			}
+			for _, item := range items {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", job.Name, item.Remote, item.Op, item.Path,
+					item.Attempts, item.FailedAt.Local().Format(time.DateTime), item.Error)
+			}
+		case "drop":
+			items, err := deadLetters.List()
+			if err != nil {
+				log.Errorf("List dead letters err: %s", err.Error())
+				return 1
+			}
+			for _, item := range items {
+				if !matchPaths(paths, item.Path) {
+					continue
+				}
+				if err := deadLetters.Remove(item.Remote, item.Op, item.Path); err != nil {
+					log.Errorf("Remove dead letter err: %s", err.Error())
+					return 1
+				}
+				log.Infof("Dropped dead letter, remote: %s, op: %s, path: %s", item.Remote, item.Op, item.Path)
+			}
+		case "retry":
+			for _, destination := range job.GetDestinations() {
+				s, err := NewStorage(destination)
+				if err != nil {
+					log.Errorf("NewStorage err: %s", err.Error())
+					return 1
+				}
+				if err = s.BucketExists(ctx); err != nil {
+					log.Errorf("BucketExist err: %s", err.Error())
+					return 1
+				}
+				t := NewTransfer(destination, nil, s)
+				t.DeadLetters = deadLetters
+				retried, failed, err := t.RetryDeadLetters(ctx, paths)
+				if err != nil {
+					log.Errorf("Retry dead letters err: %s, remote: %s", err.Error(), t.Name)
+					return 1
+				}
+				// CAS模式下上传和删除只修改内存中的清单，需要写回后才算完成
+				if s.CAS != nil {
+					if err := s.FlushCAS(context.Background()); err != nil {
+						log.Errorf("Save manifest err: %s, remote: %s", err.Error(), t.Name)
+						return 1
+					}
+				}
+				log.Infof("Retry dead letters ends, remote: %s, retried: %d, failed: %d", t.Name, retried, failed)
+				if failed > 0 {
+					exitCode = 1
+				}
+			}
+		}
+	}
+	_ = w.Flush()
+	return exitCode
+}
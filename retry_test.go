@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIsTransientError 测试临时错误与永久错误的区分
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"限流", minio.ErrorResponse{Code: "SlowDown", StatusCode: http.StatusServiceUnavailable}, true},
+		{"服务端错误", minio.ErrorResponse{Code: "InternalError", StatusCode: http.StatusInternalServerError}, true},
+		{"未知5xx", minio.ErrorResponse{StatusCode: http.StatusBadGateway}, true},
+		{"请求超时", minio.ErrorResponse{Code: "RequestTimeout", StatusCode: http.StatusBadRequest}, true},
+		{"包装后的限流", fmt.Errorf("upload: %w", minio.ErrorResponse{Code: "SlowDown"}), true},
+		{"无权限", minio.ErrorResponse{Code: "AccessDenied", StatusCode: http.StatusForbidden}, false},
+		{"桶不存在", minio.ErrorResponse{Code: "NoSuchBucket", StatusCode: http.StatusNotFound}, false},
+		{"对象名非法", minio.ErrorResponse{Code: "InvalidObjectName", StatusCode: http.StatusBadRequest}, false},
+		{"未知4xx", minio.ErrorResponse{StatusCode: http.StatusUnprocessableEntity}, false},
+		{"WebDAV锁定", &WebDAVError{Method: "PUT", StatusCode: http.StatusLocked}, true},
+		{"WebDAV服务端错误", &WebDAVError{Method: "PUT", StatusCode: http.StatusBadGateway}, true},
+		{"WebDAV无权限", &WebDAVError{Method: "PUT", StatusCode: http.StatusForbidden}, false},
+		{"本地无权限", &fs.PathError{Op: "open", Path: "/data", Err: fs.ErrPermission}, false},
+		{"网络超时", &net.OpError{Op: "dial", Err: context.DeadlineExceeded}, true},
+		{"未知错误", errors.New("connection reset by peer"), true},
+		{"取消", context.Canceled, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isTransientError(tt.err))
+		})
+	}
+}
+
+// TestRetryPolicy_Backoff 测试指数退避与随机抖动
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{Attempts: 5, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	for i := 0; i < 100; i++ {
+		for attempt, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second,
+			4: 8 * time.Second, 5: 10 * time.Second, 100: 10 * time.Second} {
+			delay := p.Backoff(attempt)
+			assert.GreaterOrEqual(t, delay, want/2)
+			assert.LessOrEqual(t, delay, want)
+		}
+	}
+	assert.Equal(t, time.Duration(0), RetryPolicy{}.Backoff(1))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/jorben/rsync-object-storage/config"
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/state"
+)
+
+// 变更任务的优先级，数值越小越先处理
+const (
+	PriorityHigh     uint8 = iota // 配置的优先路径，先于其他全部任务
+	PriorityRealtime              // 实时变更和小文件
+	PriorityBacklog               // 定时对账发现的积压任务
+)
+
+// Scheduler 变更任务调度，按优先级写入远端的持久化队列，并限制同时上传大文件的worker数量
+type Scheduler struct {
+	Queue         *state.Queue
+	PriorityPaths []string // 优先处理的路径前缀
+	SmallFileSize int64    // 小于该大小的文件按实时变更的优先级处理
+	LargeFileSize int64    // 不小于该大小的文件为大文件，只由部分worker上传
+}
+
+// NewScheduler 创建调度实例
+func NewScheduler(c *config.SyncConfig, queue *state.Queue) *Scheduler {
+	return &Scheduler{
+		Queue:         queue,
+		PriorityPaths: c.Sync.PriorityPaths,
+		SmallFileSize: c.Transfer.Schedule.SmallFile * 1024 * 1024,
+		LargeFileSize: c.Transfer.Schedule.LargeFile * 1024 * 1024,
+	}
+}
+
+// Push 按路径和来源计算优先级后写入队列，realtime表示实时变更，否则为定时对账的积压任务
+func (s *Scheduler) Push(op string, realtime bool, paths ...string) error {
+	items := make([]state.QueueItem, 0, len(paths))
+	for _, path := range paths {
+		items = append(items, s.classify(op, realtime, path))
+	}
+	return s.Queue.Push(items...)
+}
+
+// classify 计算任务的优先级，文件大小以入队时为准
+func (s *Scheduler) classify(op string, realtime bool, path string) state.QueueItem {
+	item := state.QueueItem{Op: op, Path: path, Priority: PriorityBacklog}
+	// 只有上传普通文件需要区分大小，目录和删除按来源处理
+	size := int64(-1)
+	if info, err := os.Lstat(path); err == nil && info.Mode().IsRegular() && op != enum.OpDelete {
+		size = info.Size()
+	}
+	item.Large = size >= 0 && s.LargeFileSize > 0 && size >= s.LargeFileSize
+	switch {
+	case len(s.PriorityPaths) > 0 && matchPaths(s.PriorityPaths, path):
+		item.Priority = PriorityHigh
+	case realtime || (size >= 0 && size < s.SmallFileSize):
+		item.Priority = PriorityRealtime
+	}
+	return item
+}
+
+// PushRename 写入重命名任务，按新路径的实时变更计算优先级
+func (s *Scheduler) PushRename(from, to string) error {
+	item := s.classify(enum.OpRename, true, to)
+	item.From = from
+	return s.Queue.Push(item)
+}
+
+// SetLargeWorkers 设置同时上传大文件的worker数量
+func (s *Scheduler) SetLargeWorkers(n int) {
+	s.Queue.SetLargeLimit(n)
+}
+
+// Pop 取出优先级最高的任务
+func (s *Scheduler) Pop(ctx context.Context) (state.QueueItem, error) {
+	return s.Queue.Pop(ctx)
+}
+
+// Ack 确认任务已处理完成
+func (s *Scheduler) Ack(item state.QueueItem) error {
+	return s.Queue.Ack(item)
+}
+
+// Len 等待处理的任务数量
+func (s *Scheduler) Len() (int, error) {
+	return s.Queue.Len()
+}
+
+// Close 关闭队列，剩余的任务处理完成后Pop返回state.ErrQueueClosed
+func (s *Scheduler) Close() {
+	s.Queue.Close()
+}
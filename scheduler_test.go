@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/state"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestScheduler_Push 测试按来源、文件大小和优先路径计算优先级
+func TestScheduler_Push(t *testing.T) {
+	dir := t.TempDir()
+	small := filepath.Join(dir, "small.txt")
+	medium := filepath.Join(dir, "medium.bin")
+	large := filepath.Join(dir, "large.iso")
+	urgent := filepath.Join(dir, "urgent", "big.iso")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(urgent), 0755))
+	assert.NoError(t, os.WriteFile(small, make([]byte, 10), 0644))
+	assert.NoError(t, os.WriteFile(medium, make([]byte, 100), 0644))
+	assert.NoError(t, os.WriteFile(large, make([]byte, 1000), 0644))
+	assert.NoError(t, os.WriteFile(urgent, make([]byte, 1000), 0644))
+
+	scheduler := newTestScheduler(t)
+	scheduler.SmallFileSize = 50
+	scheduler.LargeFileSize = 1000
+	scheduler.PriorityPaths = []string{filepath.Join(dir, "urgent")}
+
+	tests := []struct {
+		name     string
+		op       string
+		realtime bool
+		path     string
+		want     state.QueueItem
+	}{
+		{"对账发现的小文件", enum.OpPut, false, small, state.QueueItem{Priority: PriorityRealtime}},
+		{"对账发现的普通文件", enum.OpPut, false, medium, state.QueueItem{Priority: PriorityBacklog}},
+		{"实时变更的普通文件", enum.OpPut, true, medium, state.QueueItem{Priority: PriorityRealtime}},
+		{"大文件", enum.OpPut, true, large, state.QueueItem{Priority: PriorityRealtime, Large: true}},
+		{"优先路径下的大文件", enum.OpPut, false, urgent, state.QueueItem{Priority: PriorityHigh, Large: true}},
+		{"目录按来源处理", enum.OpPut, false, dir, state.QueueItem{Priority: PriorityBacklog}},
+		{"删除不区分大小", enum.OpDelete, false, large, state.QueueItem{Priority: PriorityBacklog}},
+		{"优先路径下的删除", enum.OpDelete, false, filepath.Join(dir, "urgent"), state.QueueItem{Priority: PriorityHigh}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.want.Op, tt.want.Path = tt.op, tt.path
+			assert.Equal(t, tt.want, scheduler.classify(tt.op, tt.realtime, tt.path))
+		})
+	}
+}
+
+// TestScheduler_Pop 测试优先路径和实时变更先于对账积压的任务取出
+func TestScheduler_Pop(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range []string{"backlog.bin", "event.bin", "urgent.bin"} {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, make([]byte, 100), 0644))
+		paths = append(paths, path)
+	}
+	scheduler := newTestScheduler(t)
+	scheduler.SmallFileSize = 50
+	scheduler.PriorityPaths = []string{paths[2]}
+
+	assert.NoError(t, scheduler.Push(enum.OpPut, false, paths[0], paths[2]))
+	assert.NoError(t, scheduler.Push(enum.OpPut, true, paths[1]))
+	assert.Equal(t, []string{paths[2], paths[1], paths[0]}, drainQueue(t, scheduler, enum.OpPut))
+}
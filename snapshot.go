@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jorben/rsync-object-storage/config"
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/jorben/rsync-object-storage/log"
+)
+
+const (
+	// snapshotDir 快照在远端路径下的目录
+	snapshotDir = ".snapshots/"
+	// snapshotIDFormat 快照ID格式，UTC时间
+	snapshotIDFormat = "2006-01-02T15:04:05Z"
+	// manifestSuffix 快照清单对象的后缀，清单与快照目录同级，写入清单表示快照已完成
+	manifestSuffix = ".manifest.json"
+)
+
+// Manifest 快照清单，记录快照中的文件，用于列出快照和判断下一个快照中哪些文件可以复用
+type Manifest struct {
+	ID        string                   `json:"id"`
+	CreatedAt time.Time                `json:"created_at"`
+	Failed    int                      `json:"failed"`
+	Files     map[string]ManifestEntry `json:"files"`
+}
+
+// ManifestEntry 快照中的普通文件，key为相对本地路径
+type ManifestEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Snapshot 快照任务，每次执行把本地路径完整写入远端路径下新的时间点目录
+// 与上一个快照相比未变更的文件通过服务端复制复用，不重新上传
+type Snapshot struct {
+	LocalPrefix   string
+	IgnoreMatcher *helper.IgnoreMatcher
+	Workers       int
+	Storage       *Storage
+}
+
+// NewSnapshot 创建快照任务实例
+func NewSnapshot(c *config.SyncConfig, storage *Storage) *Snapshot {
+	return &Snapshot{
+		LocalPrefix:   c.Local.Path,
+		IgnoreMatcher: helper.NewIgnoreMatcher(c.Sync.Ignore),
+		Workers:       max(1, c.Transfer.Workers),
+		Storage:       storage,
+	}
+}
+
+// Take 生成一个新的快照，存在失败的文件时返回错误
+func (s *Snapshot) Take(ctx context.Context) error {
+	id := time.Now().UTC().Format(snapshotIDFormat)
+	log.Infof("Snapshot %s begin", id)
+
+	previous, err := s.Latest(ctx)
+	if err != nil {
+		return err
+	}
+	target := s.snapshotStorage(id)
+	current := &Manifest{ID: id, CreatedAt: time.Now().UTC(), Files: make(map[string]ManifestEntry)}
+
+	var mu sync.Mutex
+	var uploaded, copied, failed int
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < s.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				entry, reused, err := s.takeFile(ctx, previous, target, path)
+				mu.Lock()
+				switch {
+				case err == nil || errors.Is(err, enum.ErrDryRun):
+					if reused {
+						copied++
+					} else {
+						uploaded++
+					}
+					if entry != nil {
+						current.Files[s.relativePath(path)] = *entry
+					}
+				case errors.Is(err, enum.ErrSkipTransfer):
+				default:
+					failed++
+					log.Errorf("Snapshot err: %s, path: %s", err.Error(), path)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	walkErr := filepath.WalkDir(s.LocalPrefix, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Errorf("WalkDir err: %s, skipping %s", err.Error(), path)
+			return filepath.SkipDir
+		}
+		if s.IgnoreMatcher.Match(path) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		select {
+		case paths <- path:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return nil
+	})
+	close(paths)
+	wg.Wait()
+	if walkErr != nil {
+		return walkErr
+	}
+
+	current.Failed = failed
+	if s.Storage.DryRun {
+		log.Infof("Dry-run: snapshot %s would upload %d and copy %d file(s)", id, uploaded, copied)
+		return nil
+	}
+	// 写入清单后快照才可见，失败的文件不记录在清单中，下一个快照会重新上传
+	data, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	if err = s.Storage.PutObject(ctx, s.manifestName(id), data); err != nil {
+		return err
+	}
+	log.Infof("Snapshot %s ends, uploaded: %d, copied: %d, failed: %d", id, uploaded, copied, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d file(s) failed in snapshot %s", failed, id)
+	}
+	return nil
+}
+
+// takeFile 把单个本地路径写入快照，普通文件未变更时从上一个快照服务端复制
+// 返回需要记录到清单中的文件信息，以及是否复用了上一个快照
+func (s *Snapshot) takeFile(ctx context.Context, previous *Manifest, target *Storage, path string) (*ManifestEntry, bool, error) {
+	fileInfo, err := os.Lstat(path)
+	if err != nil {
+		return nil, false, err
+	}
+	if !fileInfo.Mode().IsRegular() {
+		// 目录与符号链接按同步策略处理
+		return nil, false, target.FPutObject(ctx, path)
+	}
+
+	entry := &ManifestEntry{Size: fileInfo.Size(), ModTime: fileInfo.ModTime()}
+	rel := s.relativePath(path)
+	if previous != nil {
+		if last, ok := previous.Files[rel]; ok && last.Size == entry.Size && last.ModTime.Equal(entry.ModTime) {
+			srcName := s.snapshotStorage(previous.ID).GetRemotePath(path)
+			dstName := target.GetRemotePath(path)
+			if target.DryRun {
+				log.Infof("Dry-run: would copy %s -> %s", srcName, dstName)
+				return entry, true, enum.ErrDryRun
+			}
+			if err := s.Storage.CopyObject(ctx, srcName, dstName, fileInfo.Size()); err != nil {
+				return nil, true, err
+			}
+			return entry, true, nil
+		}
+	}
+
+	err = target.FPutObject(ctx, path)
+	if errors.Is(err, enum.ErrSkipTransfer) {
+		// 快照目录中已存在相同内容
+		return entry, false, nil
+	}
+	if err != nil && !errors.Is(err, enum.ErrDryRun) {
+		return nil, false, err
+	}
+	return entry, false, err
+}
+
+// List 列出已完成的快照，按时间从旧到新排序
+func (s *Snapshot) List(ctx context.Context) ([]*Manifest, error) {
+	var manifests []*Manifest
+	for object := range s.Storage.ListPrefix(ctx, s.Storage.GetRemoteRoot()+snapshotDir, false) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		if !strings.HasSuffix(object.Key, manifestSuffix) {
+			continue
+		}
+		manifest, err := s.loadManifest(ctx, object.Key)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, manifest)
+	}
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].ID < manifests[j].ID
+	})
+	return manifests, nil
+}
+
+// Latest 获取最近一个已完成的快照，不存在时返回nil
+func (s *Snapshot) Latest(ctx context.Context) (*Manifest, error) {
+	manifests, err := s.List(ctx)
+	if err != nil || len(manifests) == 0 {
+		return nil, err
+	}
+	return manifests[len(manifests)-1], nil
+}
+
+// loadManifest 下载并解析快照清单
+func (s *Snapshot) loadManifest(ctx context.Context, objectName string) (*Manifest, error) {
+	// 下载到 sync.temp_dir 下的临时文件
+	randomString, err := helper.RandomString(32)
+	if err != nil {
+		log.Errorf("RandomString err: %s", err.Error())
+		randomString = "tmp_snapshot_manifest"
+	}
+	tmp := s.Storage.tempPath(".manifest-" + randomString)
+	defer os.Remove(tmp)
+	if err = s.Storage.FGetObject(ctx, objectName, tmp); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(tmp)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	if err = json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %s", objectName, err.Error())
+	}
+	return manifest, nil
+}
+
+// snapshotStorage 获取写入指定快照目录的存储实例
+func (s *Snapshot) snapshotStorage(id string) *Storage {
+	target := *s.Storage
+	target.RemotePrefix = s.Storage.GetRemoteRoot() + snapshotDir + id
+	// 快照不参与双向同步
+	target.State = nil
+	return &target
+}
+
+// IsSnapshot 判断对象是否在快照目录中，快照不对应本地路径
+func (s *Storage) IsSnapshot(objectName string) bool {
+	return strings.HasPrefix(objectName, s.GetRemoteRoot()+snapshotDir)
+}
+
+// manifestName 获取快照清单的对象名
+func (s *Snapshot) manifestName(id string) string {
+	return s.Storage.GetRemoteRoot() + snapshotDir + id + manifestSuffix
+}
+
+// relativePath 获取相对本地路径的路径，作为清单中的key
+func (s *Snapshot) relativePath(path string) string {
+	rel, err := filepath.Rel(s.LocalPrefix, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// snapshotsCommand snapshots 子命令入口，返回进程退出码
+// 用法：ros snapshots list [-c config] [-job name]
+func snapshotsCommand(args []string) int {
+	if len(args) == 0 || args[0] != "list" {
+		fmt.Println("Usage: ros snapshots list [-c config] [-job name]")
+		return 2
+	}
+	flags := flag.NewFlagSet("snapshots list", flag.ExitOnError)
+	configPath := flags.String("c", "./config.yaml", "Path to the configuration file")
+	jobName := flags.String("job", "", "Only list snapshots of the sync job with this `NAME`")
+	_ = flags.Parse(args[1:])
+
+	c, err := config.GetConfig(*configPath)
+	if err != nil {
+		fmt.Printf("Load config err: %s\n", err.Error())
+		return 1
+	}
+
+	// 初始化日志
+	log.InitLogger(c.Log)
+	defer log.GetLogger().Sync()
+
+	ctx := context.Background()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "JOB\tREMOTE\tSNAPSHOT\tFILES\tSIZE\tFAILED")
+	for _, job := range c.GetJobs() {
+		if *jobName != "" && job.Name != *jobName {
+			continue
+		}
+		for _, destination := range job.GetDestinations() {
+			s, err := NewStorage(destination)
+			if err != nil {
+				log.Errorf("NewStorage err: %s", err.Error())
+				return 1
+			}
+			manifests, err := NewSnapshot(destination, s).List(ctx)
+			if err != nil {
+				log.Errorf("List snapshots err: %s", err.Error())
+				return 1
+			}
+			for _, manifest := range manifests {
+				var size int64
+				for _, entry := range manifest.Files {
+					size += entry.Size
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%d\n", job.Name,
+					destination.Remote.GetName(),
+					manifest.ID, len(manifest.Files), helper.ByteFormat(size), manifest.Failed)
+			}
+		}
+	}
+	_ = w.Flush()
+	return 0
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/jorben/rsync-object-storage/mocks"
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// newTestSnapshot 创建测试用的 Snapshot 实例
+func newTestSnapshot(mockClient *mocks.MockObjectStorageClient, localPrefix string) *Snapshot {
+	return &Snapshot{
+		LocalPrefix:   localPrefix,
+		IgnoreMatcher: helper.NewIgnoreMatcher([]string{"*.tmp"}),
+		Workers:       2,
+		Storage: &Storage{
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
+			LocalPrefix:  localPrefix,
+			RemotePrefix: "remote",
+			SymLink:      enum.SymlinkSkip,
+		},
+	}
+}
+
+// TestNewSnapshot 测试快照的并发数使用 transfer.workers
+func TestNewSnapshot(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Transfer.Workers = 3
+	s := NewSnapshot(cfg, &Storage{})
+	assert.Equal(t, 3, s.Workers)
+
+	cfg.Transfer.Workers = 0
+	assert.Equal(t, 1, NewSnapshot(cfg, &Storage{}).Workers)
+}
+
+// captureManifest 解析上传的快照清单
+func captureManifest(manifest *Manifest) func(args mock.Arguments) {
+	return func(args mock.Arguments) {
+		data, _ := io.ReadAll(args.Get(3).(io.Reader))
+		_ = json.Unmarshal(data, manifest)
+	}
+}
+
+// inSnapshot 匹配快照目录下的对象
+func inSnapshot(name string) interface{} {
+	return mock.MatchedBy(func(key string) bool {
+		return strings.HasPrefix(key, "remote/.snapshots/") && strings.HasSuffix(key, "/"+name)
+	})
+}
+
+// TestSnapshot_Take_First 测试首个快照上传全部文件
+func TestSnapshot_Take_First(t *testing.T) {
+	tmpDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("a"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "skip.tmp"), []byte("tmp"), 0644))
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket",
+		minio.ListObjectsOptions{Prefix: "remote/.snapshots/"}).Return(objectsChan())
+	mockClient.On("StatObject", mock.Anything, "test-bucket", mock.Anything, minio.StatObjectOptions{}).
+		Return(minio.ObjectInfo{}, assert.AnError)
+	mockClient.On("PutObject", mock.Anything, "test-bucket", inSnapshot("a.txt"), mock.Anything, mock.Anything, minio.PutObjectOptions{}).
+		Return(minio.UploadInfo{}, nil)
+	manifest := &Manifest{}
+	mockClient.On("PutObject", mock.Anything, "test-bucket",
+		mock.MatchedBy(func(key string) bool {
+			return strings.HasPrefix(key, "remote/.snapshots/") && strings.HasSuffix(key, manifestSuffix)
+		}), mock.Anything, mock.Anything, minio.PutObjectOptions{}).
+		Run(captureManifest(manifest)).Return(minio.UploadInfo{}, nil)
+
+	s := newTestSnapshot(mockClient, tmpDir)
+	err := s.Take(context.Background())
+
+	assert.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	assert.Contains(t, manifest.Files, "a.txt")
+	assert.NotContains(t, manifest.Files, "skip.tmp")
+	_, err = time.Parse(snapshotIDFormat, manifest.ID)
+	assert.NoError(t, err)
+}
+
+// TestSnapshot_Take_Reuse 测试未变更的文件从上一个快照服务端复制
+func TestSnapshot_Take_Reuse(t *testing.T) {
+	tmpDir := t.TempDir()
+	unchanged := filepath.Join(tmpDir, "unchanged.txt")
+	changed := filepath.Join(tmpDir, "changed.txt")
+	assert.NoError(t, os.WriteFile(unchanged, []byte("same"), 0644))
+	assert.NoError(t, os.WriteFile(changed, []byte("new content"), 0644))
+	unchangedInfo, _ := os.Stat(unchanged)
+
+	previous := Manifest{
+		ID: "2026-10-15T04:00:00Z",
+		Files: map[string]ManifestEntry{
+			"unchanged.txt": {Size: unchangedInfo.Size(), ModTime: unchangedInfo.ModTime()},
+			"changed.txt":   {Size: 3, ModTime: time.Now().Add(-time.Hour)},
+		},
+	}
+	data, _ := json.Marshal(previous)
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket", minio.ListObjectsOptions{Prefix: "remote/.snapshots/"}).
+		Return(objectsChan(
+			minio.ObjectInfo{Key: "remote/.snapshots/2026-10-15T04:00:00Z/"},
+			minio.ObjectInfo{Key: "remote/.snapshots/2026-10-15T04:00:00Z" + manifestSuffix},
+		))
+	mockClient.On("FGetObject", mock.Anything, "test-bucket", "remote/.snapshots/2026-10-15T04:00:00Z"+manifestSuffix,
+		mock.Anything, minio.GetObjectOptions{}).Run(writeOnDownload(string(data))).Return(nil)
+	mockClient.On("CopyObject", mock.Anything,
+		mock.MatchedBy(func(dst minio.CopyDestOptions) bool {
+			return strings.HasPrefix(dst.Object, "remote/.snapshots/") && strings.HasSuffix(dst.Object, "/unchanged.txt")
+		}),
+		minio.CopySrcOptions{Bucket: "test-bucket", Object: "remote/.snapshots/2026-10-15T04:00:00Z/unchanged.txt"}).
+		Return(minio.UploadInfo{}, nil)
+	mockClient.On("StatObject", mock.Anything, "test-bucket", mock.Anything, minio.StatObjectOptions{}).
+		Return(minio.ObjectInfo{}, assert.AnError)
+	mockClient.On("PutObject", mock.Anything, "test-bucket", inSnapshot("changed.txt"), mock.Anything, mock.Anything, minio.PutObjectOptions{}).
+		Return(minio.UploadInfo{}, nil)
+	manifest := &Manifest{}
+	mockClient.On("PutObject", mock.Anything, "test-bucket", mock.Anything, mock.Anything, mock.Anything, minio.PutObjectOptions{}).
+		Run(captureManifest(manifest)).Return(minio.UploadInfo{}, nil)
+
+	s := newTestSnapshot(mockClient, tmpDir)
+	err := s.Take(context.Background())
+
+	assert.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	mockClient.AssertNotCalled(t, "PutObject", mock.Anything, "test-bucket", inSnapshot("unchanged.txt"), mock.Anything, mock.Anything, mock.Anything)
+	assert.Len(t, manifest.Files, 2)
+}
+
+// TestSnapshot_Take_DryRun 测试演练模式不写入清单
+func TestSnapshot_Take_DryRun(t *testing.T) {
+	tmpDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("a"), 0644))
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket", mock.Anything).Return(objectsChan())
+	mockClient.On("StatObject", mock.Anything, "test-bucket", mock.Anything, minio.StatObjectOptions{}).
+		Return(minio.ObjectInfo{}, assert.AnError)
+
+	s := newTestSnapshot(mockClient, tmpDir)
+	s.Storage.DryRun = true
+	err := s.Take(context.Background())
+
+	assert.NoError(t, err)
+	mockClient.AssertNotCalled(t, "PutObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// TestSnapshot_List 测试按时间排序列出已完成的快照
+func TestSnapshot_List(t *testing.T) {
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket", minio.ListObjectsOptions{Prefix: "remote/.snapshots/"}).
+		Return(objectsChan(
+			minio.ObjectInfo{Key: "remote/.snapshots/2026-10-16T04:00:00Z" + manifestSuffix},
+			minio.ObjectInfo{Key: "remote/.snapshots/2026-10-16T04:00:00Z/"},
+			// 未写入清单的快照尚未完成
+			minio.ObjectInfo{Key: "remote/.snapshots/2026-10-17T04:00:00Z/"},
+			minio.ObjectInfo{Key: "remote/.snapshots/2026-10-15T04:00:00Z" + manifestSuffix},
+		))
+	// 清单下载到 sync.temp_dir
+	tempDir := t.TempDir()
+	inTempDir := mock.MatchedBy(func(path string) bool {
+		return filepath.Dir(path) == tempDir
+	})
+	for _, id := range []string{"2026-10-15T04:00:00Z", "2026-10-16T04:00:00Z"} {
+		data, _ := json.Marshal(Manifest{ID: id})
+		mockClient.On("FGetObject", mock.Anything, "test-bucket", "remote/.snapshots/"+id+manifestSuffix,
+			inTempDir, minio.GetObjectOptions{}).Run(writeOnDownload(string(data))).Return(nil)
+	}
+
+	s := newTestSnapshot(mockClient, t.TempDir())
+	s.Storage.TempDir = tempDir
+	manifests, err := s.List(context.Background())
+
+	assert.NoError(t, err)
+	mockClient.AssertExpectations(t)
+	assert.Len(t, manifests, 2)
+	assert.Equal(t, "2026-10-15T04:00:00Z", manifests[0].ID)
+	assert.Equal(t, "2026-10-16T04:00:00Z", manifests[1].ID)
+}
@@ -0,0 +1,152 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DeadLetter 重试耗尽或遇到永久错误后仍失败的传输
+type DeadLetter struct {
+	// Op 操作类型(put|delete)
+	Op string `json:"op"`
+	// Path 本地路径
+	Path string `json:"path"`
+	// Remote 远端名称
+	Remote string `json:"remote"`
+	// Error 最后一次失败的错误信息
+	Error string `json:"error"`
+	// Attempts 已尝试的次数
+	Attempts int `json:"attempts"`
+	// FailedAt 最后一次失败的时间
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// key 同一个远端上同一路径的同一操作只保留一条
+func (d DeadLetter) key() string {
+	return d.Remote + "\n" + d.Op + "\n" + d.Path
+}
+
+// DeadLetters 死信列表，数据以JSON格式持久化到本地文件
+// 常驻进程与命令行可能同时修改，每次读写都持有 <path>.lock 的文件锁，并在修改前重新加载文件
+type DeadLetters struct {
+	path string
+	mu   sync.Mutex
+	keys map[string]struct{} // 最近一次加载时的记录，用于快速判断是否需要删除
+}
+
+// OpenDeadLetters 打开死信列表，文件不存在时创建空的列表
+func OpenDeadLetters(path string) (*DeadLetters, error) {
+	d := &DeadLetters{path: path}
+	if _, err := d.load(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// List 获取全部记录，按失败时间排序
+func (d *DeadLetters) List() ([]DeadLetter, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	unlock, err := lockFile(d.lockPath())
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	items, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].FailedAt.Before(items[j].FailedAt)
+	})
+	return items, nil
+}
+
+// Add 添加记录，已存在时更新
+func (d *DeadLetters) Add(item DeadLetter) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	unlock, err := lockFile(d.lockPath())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	items, err := d.load()
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		if items[i].key() == item.key() {
+			items[i] = item
+			return d.save(items)
+		}
+	}
+	return d.save(append(items, item))
+}
+
+// Remove 删除记录，不存在时直接返回
+func (d *DeadLetters) Remove(remote, op, path string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key := DeadLetter{Remote: remote, Op: op, Path: path}.key()
+	if _, ok := d.keys[key]; !ok {
+		return nil
+	}
+	unlock, err := lockFile(d.lockPath())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	items, err := d.load()
+	if err != nil {
+		return err
+	}
+	for i := range items {
+		if items[i].key() == key {
+			return d.save(append(items[:i], items[i+1:]...))
+		}
+	}
+	return nil
+}
+
+// lockPath 获取跨进程文件锁的路径
+func (d *DeadLetters) lockPath() string {
+	return d.path + ".lock"
+}
+
+// load 从文件加载全部记录
+func (d *DeadLetters) load() ([]DeadLetter, error) {
+	var items []DeadLetter
+	buf, err := os.ReadFile(d.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(buf, &items); err != nil {
+			return nil, err
+		}
+	}
+	d.keys = make(map[string]struct{}, len(items))
+	for _, item := range items {
+		d.keys[item.key()] = struct{}{}
+	}
+	return items, nil
+}
+
+// save 写入全部记录
+func (d *DeadLetters) save(items []DeadLetter) error {
+	if items == nil {
+		items = []DeadLetter{}
+	}
+	if err := writeJSON(d.path, items); err != nil {
+		return err
+	}
+	d.keys = make(map[string]struct{}, len(items))
+	for _, item := range items {
+		d.keys[item.key()] = struct{}{}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDeadLetters 测试死信列表的增删查与持久化
+func TestDeadLetters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job", "deadletter.json")
+	d, err := OpenDeadLetters(path)
+	assert.NoError(t, err)
+	items, err := d.List()
+	assert.NoError(t, err)
+	assert.Empty(t, items)
+
+	now := time.Now()
+	assert.NoError(t, d.Add(DeadLetter{Op: "put", Path: "/data/b.txt", Remote: "r1", Error: "AccessDenied", Attempts: 1, FailedAt: now}))
+	assert.NoError(t, d.Add(DeadLetter{Op: "put", Path: "/data/a.txt", Remote: "r1", Error: "SlowDown", Attempts: 5, FailedAt: now.Add(-time.Minute)}))
+	// 同一远端同一路径同一操作只保留最新的一条
+	assert.NoError(t, d.Add(DeadLetter{Op: "put", Path: "/data/b.txt", Remote: "r1", Error: "timeout", Attempts: 5, FailedAt: now.Add(time.Minute)}))
+	assert.NoError(t, d.Add(DeadLetter{Op: "delete", Path: "/data/b.txt", Remote: "r1", FailedAt: now}))
+
+	// 其他进程打开后可以读取
+	other, err := OpenDeadLetters(path)
+	assert.NoError(t, err)
+	items, err = other.List()
+	assert.NoError(t, err)
+	assert.Len(t, items, 3)
+	assert.Equal(t, "/data/a.txt", items[0].Path)
+	assert.Equal(t, "timeout", items[2].Error)
+
+	// 其他进程删除的记录不会被覆盖回来
+	assert.NoError(t, other.Remove("r1", "put", "/data/a.txt"))
+	assert.NoError(t, d.Add(DeadLetter{Op: "put", Path: "/data/c.txt", Remote: "r1", FailedAt: now}))
+	items, err = d.List()
+	assert.NoError(t, err)
+	assert.Len(t, items, 3)
+	for _, item := range items {
+		assert.NotEqual(t, "/data/a.txt", item.Path)
+	}
+
+	assert.NoError(t, d.Remove("r1", "put", "/data/missing.txt"))
+	assert.NoError(t, d.Remove("r2", "put", "/data/c.txt"))
+	items, err = d.List()
+	assert.NoError(t, err)
+	assert.Len(t, items, 3)
+}
+
+// TestDeadLetters_Concurrent 测试多个进程同时修改时通过文件锁保留全部记录
+func TestDeadLetters_Concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deadletter.json")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		d, err := OpenDeadLetters(path)
+		assert.NoError(t, err)
+		wg.Add(1)
+		go func(i int, d *DeadLetters) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				assert.NoError(t, d.Add(DeadLetter{Op: "put", Path: fmt.Sprintf("/data/%d-%d.txt", i, j), Remote: "r1"}))
+			}
+		}(i, d)
+	}
+	wg.Wait()
+
+	d, err := OpenDeadLetters(path)
+	assert.NoError(t, err)
+	items, err := d.List()
+	assert.NoError(t, err)
+	assert.Len(t, items, 80)
+}
+
+// TestOpenDeadLetters_Invalid 测试文件格式错误
+func TestOpenDeadLetters_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deadletter.json")
+	assert.NoError(t, os.WriteFile(path, []byte("not json"), 0644))
+	_, err := OpenDeadLetters(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockFile 对锁文件加排他锁，其他进程持有锁时阻塞等待，返回释放锁的函数
+func lockFile(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return func() {
+		_ = syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		_ = file.Close()
+	}, nil
+}
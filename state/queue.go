@@ -0,0 +1,437 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jorben/rsync-object-storage/enum"
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrQueueClosed 队列已关闭且没有待取出的任务
+var ErrQueueClosed = errors.New("queue closed")
+
+// errItemNotFound 任务不存在（已确认或被合并）
+var errItemNotFound = errors.New("queue item not found")
+
+// QueueItem 队列中的任务
+type QueueItem struct {
+	// Seq 入队序号，确认时使用
+	Seq uint64 `json:"-"`
+	// Op 操作类型(put|delete|rename)
+	Op string `json:"op"`
+	// Path 本地路径，重命名时为新路径
+	Path string `json:"path"`
+	// From 重命名前的旧路径
+	From string `json:"from,omitempty"`
+	// Priority 优先级，数值越小越先取出
+	Priority uint8 `json:"priority"`
+	// Large 是否大文件上传，同时处理的大文件上传数量受限
+	Large bool `json:"large"`
+}
+
+var (
+	itemsBucket = []byte("items") // 优先级+大文件标记+序号 -> 任务
+	pathsBucket = []byte("paths") // 路径 -> 等待中任务的序号
+)
+
+// Journal 持久化的任务日志，基于 bbolt 存储在本地文件中，每个远端一个队列
+// 同一个文件同时只能被一个进程打开
+type Journal struct {
+	db   *bolt.DB
+	temp bool // 临时日志，关闭时删除文件
+}
+
+// OpenJournal 打开任务日志，文件不存在时创建
+func OpenJournal(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("%s is locked by another process", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{db: db}, nil
+}
+
+// OpenTempJournal 在目录下创建临时的任务日志，关闭时删除，用于不需要跨进程保留任务的场景
+func OpenTempJournal(dir string) (*Journal, error) {
+	f, err := os.CreateTemp(dir, "ros-queue-*.db")
+	if err != nil {
+		return nil, err
+	}
+	_ = f.Close()
+	j, err := OpenJournal(f.Name())
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+	j.temp = true
+	return j, nil
+}
+
+// Queue 获取指定名称的队列，不存在时创建，上次退出时未确认的任务会重新取出
+func (j *Journal) Queue(name string) (*Queue, error) {
+	err := j.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		if _, err = b.CreateBucketIfNotExists(itemsBucket); err != nil {
+			return err
+		}
+		_, err = b.CreateBucketIfNotExists(pathsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Queue{
+		db:       j.db,
+		name:     []byte(name),
+		inflight: make(map[uint64]bool),
+		changed:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Close 关闭任务日志，调用前需确保队列不再被使用
+func (j *Journal) Close() error {
+	path := j.db.Path()
+	if err := j.db.Close(); err != nil {
+		return err
+	}
+	if j.temp {
+		return os.Remove(path)
+	}
+	return nil
+}
+
+// Queue 持久化的任务队列，按优先级先进先出，以路径为键合并等待中的任务
+// 任务入队即写入磁盘，处理完成并确认后才删除，进程中断后重启时重新处理未确认的任务
+// 已取出正在处理的任务不参与合并，处理期间路径再次变更时会重新入队
+type Queue struct {
+	db            *bolt.DB
+	name          []byte
+	mu            sync.Mutex
+	inflight      map[uint64]bool // 已取出正在处理的任务序号 -> 是否大文件
+	inflightLarge int
+	largeLimit    int           // 同时处理的大文件上传数量上限，为0时不限制
+	changed       chan struct{} // 队列变化时关闭并替换，通知全部等待中的 Pop
+	done          chan struct{}
+	closeOnce     sync.Once
+}
+
+// SetLargeLimit 设置同时处理的大文件上传数量上限，避免大文件占满全部worker，为0时不限制
+func (q *Queue) SetLargeLimit(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.largeLimit = n
+	q.notify()
+}
+
+// Push 添加任务，与等待中的任务合并：
+//   - 路径已在等待相同操作时不重复入队，新任务优先级更高时提升优先级，操作不同时以最后一次为准
+//   - 上级目录已在以不低于新任务的优先级等待相同操作时不再入队，目录的处理会覆盖其下全部路径
+//   - 上传目录时合并其下优先级不高于目录的待上传路径，删除目录时合并其下全部等待中的路径
+//   - 重命名会上传新路径下的全部内容，与上传同样合并；被合并的重命名任务转为删除旧路径
+func (q *Queue) Push(items ...QueueItem) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	err := q.db.Update(func(tx *bolt.Tx) error {
+		bucket, index := q.buckets(tx)
+		for _, item := range items {
+			if err := q.push(bucket, index, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	q.notify()
+	return nil
+}
+
+// push 合并后写入单个任务
+func (q *Queue) push(items, index *bolt.Bucket, item QueueItem) error {
+	old, ok, err := q.pending(items, index, item.Path)
+	if err != nil {
+		return err
+	}
+	if ok && old.Op == enum.OpRename && item.Op == enum.OpPut {
+		// 等待中的重命名同样会上传最新的内容，保留重命名
+		item.Op, item.From = old.Op, old.From
+	}
+	if ok && old.Op == item.Op && old.From == item.From && old.Priority <= item.Priority && old.Large == item.Large {
+		return nil
+	} else if ok && old.From == item.From {
+		if err := q.remove(items, index, old); err != nil {
+			return err
+		}
+	} else if ok {
+		if err := q.discard(items, index, old); err != nil {
+			return err
+		}
+	}
+	if covered, err := q.covered(items, index, item); err != nil || covered {
+		return err
+	}
+
+	// 合并子路径
+	var absorbed []QueueItem
+	prefix := []byte(strings.TrimSuffix(item.Path, string(filepath.Separator)) + string(filepath.Separator))
+	c := index.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if q.isInflight(keySeq(v)) {
+			continue
+		}
+		child, err := getItem(items, v)
+		if err != nil {
+			return err
+		}
+		if item.Op == enum.OpDelete || (child.Op == enum.OpPut && child.Priority >= item.Priority) {
+			absorbed = append(absorbed, child)
+		}
+	}
+	for _, child := range absorbed {
+		if err := q.discard(items, index, child); err != nil {
+			return err
+		}
+	}
+
+	seq, err := items.NextSequence()
+	if err != nil {
+		return err
+	}
+	item.Seq = seq
+	buf, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	if err := items.Put(itemKey(item), buf); err != nil {
+		return err
+	}
+	return index.Put([]byte(item.Path), itemKey(item))
+}
+
+// pending 获取路径等待中的任务，已取出正在处理的任务不算
+func (q *Queue) pending(items, index *bolt.Bucket, path string) (QueueItem, bool, error) {
+	key := index.Get([]byte(path))
+	if key == nil || q.isInflight(keySeq(key)) {
+		return QueueItem{}, false, nil
+	}
+	item, err := getItem(items, key)
+	return item, err == nil, err
+}
+
+// remove 删除任务及其路径索引
+func (q *Queue) remove(items, index *bolt.Bucket, item QueueItem) error {
+	key := itemKey(item)
+	if bytes.Equal(index.Get([]byte(item.Path)), key) {
+		if err := index.Delete([]byte(item.Path)); err != nil {
+			return err
+		}
+	}
+	return items.Delete(key)
+}
+
+// covered 判断任务是否已被等待中的上级目录任务覆盖
+// 重命名还需要删除旧路径，不会被覆盖
+func (q *Queue) covered(items, index *bolt.Bucket, item QueueItem) (bool, error) {
+	if item.Op == enum.OpRename {
+		return false, nil
+	}
+	for child, dir := item.Path, filepath.Dir(item.Path); dir != child; child, dir = dir, filepath.Dir(dir) {
+		parent, ok, err := q.pending(items, index, dir)
+		if err != nil {
+			return false, err
+		}
+		if ok && parent.Priority <= item.Priority &&
+			(parent.Op == item.Op || (parent.Op == enum.OpRename && item.Op == enum.OpPut)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// discard 删除被合并的任务，重命名任务被合并后仍需要删除旧路径
+func (q *Queue) discard(items, index *bolt.Bucket, item QueueItem) error {
+	if err := q.remove(items, index, item); err != nil {
+		return err
+	}
+	if item.Op != enum.OpRename {
+		return nil
+	}
+	return q.push(items, index, QueueItem{Op: enum.OpDelete, Path: item.From, Priority: item.Priority})
+}
+
+// Pop 取出优先级最高的最早的任务，队列为空时阻塞等待
+// 处理中的大文件上传达到上限时跳过大文件任务
+// 队列关闭且没有待取出的任务时返回 ErrQueueClosed，context取消时返回其错误
+func (q *Queue) Pop(ctx context.Context) (QueueItem, error) {
+	for {
+		item, ok, changed, err := q.pop()
+		if err != nil || ok {
+			return item, err
+		}
+		select {
+		case <-ctx.Done():
+			return QueueItem{}, ctx.Err()
+		case <-q.done:
+			// 关闭前入队的任务需要先取完，等待处理中的大文件上传完成后再取剩余的大文件任务
+			if item, ok, _, err = q.pop(); err != nil || ok {
+				return item, err
+			}
+			if q.Empty() {
+				return QueueItem{}, ErrQueueClosed
+			}
+			select {
+			case <-ctx.Done():
+				return QueueItem{}, ctx.Err()
+			case <-changed:
+			}
+		case <-changed:
+		}
+	}
+}
+
+// Ack 确认任务已处理完成，从队列中删除
+func (q *Queue) Ack(item QueueItem) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if large, ok := q.inflight[item.Seq]; ok {
+		if large {
+			q.inflightLarge--
+		}
+		delete(q.inflight, item.Seq)
+		q.notify()
+	}
+	return q.db.Update(func(tx *bolt.Tx) error {
+		items, index := q.buckets(tx)
+		return q.remove(items, index, item)
+	})
+}
+
+// Len 获取未确认的任务数量，包括已取出正在处理的任务
+func (q *Queue) Len() (int, error) {
+	var n int
+	err := q.db.View(func(tx *bolt.Tx) error {
+		items, _ := q.buckets(tx)
+		n = items.Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
+// Empty 判断是否没有待取出和正在处理的任务
+func (q *Queue) Empty() bool {
+	n, err := q.Len()
+	return err == nil && n == 0
+}
+
+// Close 关闭队列，不再等待新的任务，已入队的任务取完后 Pop 返回 ErrQueueClosed
+func (q *Queue) Close() {
+	q.closeOnce.Do(func() {
+		close(q.done)
+	})
+}
+
+// pop 按优先级取出第一个未在处理中的任务，同时返回当前的变化通知
+func (q *Queue) pop() (item QueueItem, ok bool, changed chan struct{}, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	changed = q.changed
+	err = q.db.View(func(tx *bolt.Tx) error {
+		items, _ := q.buckets(tx)
+		c := items.Cursor()
+		for k, _ := c.First(); k != nil; {
+			priority, large, seq := k[0], k[1] == 1, keySeq(k)
+			if large && q.largeLimit > 0 && q.inflightLarge >= q.largeLimit {
+				// 同一优先级下大文件任务排在最后，直接跳到下一个优先级
+				if priority == 255 {
+					return nil
+				}
+				k, _ = c.Seek([]byte{priority + 1})
+				continue
+			}
+			if q.isInflight(seq) {
+				k, _ = c.Next()
+				continue
+			}
+			var err error
+			item, err = getItem(items, k)
+			ok = err == nil
+			return err
+		}
+		return nil
+	})
+	if ok {
+		q.inflight[item.Seq] = item.Large
+		if item.Large {
+			q.inflightLarge++
+		}
+	}
+	return item, ok, changed, err
+}
+
+// isInflight 判断任务是否已取出正在处理
+func (q *Queue) isInflight(seq uint64) bool {
+	_, ok := q.inflight[seq]
+	return ok
+}
+
+// buckets 获取任务和路径索引
+func (q *Queue) buckets(tx *bolt.Tx) (items, index *bolt.Bucket) {
+	b := tx.Bucket(q.name)
+	return b.Bucket(itemsBucket), b.Bucket(pathsBucket)
+}
+
+// notify 通知全部等待中的 Pop 重新检查队列，调用方需持有锁
+func (q *Queue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// getItem 读取键对应的任务
+func getItem(items *bolt.Bucket, key []byte) (QueueItem, error) {
+	var item QueueItem
+	v := items.Get(key)
+	if v == nil {
+		return item, errItemNotFound
+	}
+	if err := json.Unmarshal(v, &item); err != nil {
+		return item, err
+	}
+	item.Seq = keySeq(key)
+	return item, nil
+}
+
+// itemKey 任务的键，按优先级、大文件标记、序号排序，序号按大端编码保证同一优先级内的顺序与入队顺序一致
+func itemKey(item QueueItem) []byte {
+	key := make([]byte, 10)
+	key[0] = item.Priority
+	if item.Large {
+		key[1] = 1
+	}
+	binary.BigEndian.PutUint64(key[2:], item.Seq)
+	return key
+}
+
+// keySeq 从任务的键中取出序号
+func keySeq(key []byte) uint64 {
+	return binary.BigEndian.Uint64(key[2:])
+}
@@ -0,0 +1,300 @@
+package state
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newItems 创建相同操作的任务
+func newItems(op string, paths ...string) []QueueItem {
+	var items []QueueItem
+	for _, path := range paths {
+		items = append(items, QueueItem{Op: op, Path: path})
+	}
+	return items
+}
+
+// TestQueue 测试任务按顺序取出、确认与重启后重放
+func TestQueue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job", "queue.db")
+	journal, err := OpenJournal(path)
+	assert.NoError(t, err)
+	queue, err := journal.Queue("r1")
+	assert.NoError(t, err)
+	other, err := journal.Queue("r2")
+	assert.NoError(t, err)
+
+	ctx := context.Background()
+	assert.NoError(t, queue.Push(newItems("put", "/data/a.txt", "/data/b.txt")...))
+	assert.NoError(t, queue.Push(newItems("delete", "/data/c.txt")...))
+	n, err := queue.Len()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	// 不同远端的队列相互独立
+	n, err = other.Len()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	first, err := queue.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "put", first.Op)
+	assert.Equal(t, "/data/a.txt", first.Path)
+	second, err := queue.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "/data/b.txt", second.Path)
+	// 只确认第二个任务
+	assert.NoError(t, queue.Ack(second))
+	assert.NoError(t, journal.Close())
+
+	// 重新打开后未确认的任务重新取出
+	journal, err = OpenJournal(path)
+	assert.NoError(t, err)
+	defer journal.Close()
+	queue, err = journal.Queue("r1")
+	assert.NoError(t, err)
+	n, err = queue.Len()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	item, err := queue.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "/data/a.txt", item.Path)
+	item, err = queue.Pop(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "delete", item.Op)
+	assert.Equal(t, "/data/c.txt", item.Path)
+}
+
+// TestQueue_Pop 测试等待新任务、取消和关闭
+func TestQueue_Pop(t *testing.T) {
+	journal, err := OpenJournal(filepath.Join(t.TempDir(), "queue.db"))
+	assert.NoError(t, err)
+	defer journal.Close()
+	queue, err := journal.Queue("r1")
+	assert.NoError(t, err)
+
+	t.Run("等待新任务", func(t *testing.T) {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			_ = queue.Push(newItems("put", "/data/a.txt")...)
+		}()
+		item, err := queue.Pop(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "/data/a.txt", item.Path)
+		assert.NoError(t, queue.Ack(item))
+	})
+
+	t.Run("取消", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := queue.Pop(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("关闭后取完剩余任务", func(t *testing.T) {
+		assert.NoError(t, queue.Push(newItems("put", "/data/b.txt")...))
+		queue.Close()
+		item, err := queue.Pop(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "/data/b.txt", item.Path)
+		// 等待正在处理的任务确认后返回
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			_ = queue.Ack(item)
+		}()
+		_, err = queue.Pop(context.Background())
+		assert.ErrorIs(t, err, ErrQueueClosed)
+		assert.True(t, queue.Empty())
+	})
+}
+
+// TestQueue_Coalesce 测试合并等待中的任务
+func TestQueue_Coalesce(t *testing.T) {
+	journal, err := OpenJournal(filepath.Join(t.TempDir(), "queue.db"))
+	assert.NoError(t, err)
+	defer journal.Close()
+	ctx := context.Background()
+
+	// pendingItems 取出全部等待中的任务
+	pendingItems := func(queue *Queue) []string {
+		queue.Close()
+		var items []string
+		for {
+			item, err := queue.Pop(ctx)
+			if err != nil {
+				assert.ErrorIs(t, err, ErrQueueClosed)
+				return items
+			}
+			if item.From != "" {
+				items = append(items, item.Op+" "+item.From+" -> "+item.Path)
+			} else {
+				items = append(items, item.Op+" "+item.Path)
+			}
+			assert.NoError(t, queue.Ack(item))
+		}
+	}
+
+	t.Run("相同路径不重复入队", func(t *testing.T) {
+		queue, err := journal.Queue("same")
+		assert.NoError(t, err)
+		for i := 0; i < 200; i++ {
+			assert.NoError(t, queue.Push(newItems("put", "/data/a.txt")...))
+		}
+		assert.NoError(t, queue.Push(newItems("put", "/data/b.txt", "/data/a.txt")...))
+		assert.Equal(t, []string{"put /data/a.txt", "put /data/b.txt"}, pendingItems(queue))
+	})
+
+	t.Run("以最后一次操作为准", func(t *testing.T) {
+		queue, err := journal.Queue("last")
+		assert.NoError(t, err)
+		assert.NoError(t, queue.Push(newItems("put", "/data/a.txt", "/data/b.txt")...))
+		assert.NoError(t, queue.Push(newItems("delete", "/data/a.txt")...))
+		assert.NoError(t, queue.Push(newItems("delete", "/data/c.txt")...))
+		assert.NoError(t, queue.Push(newItems("put", "/data/c.txt")...))
+		assert.Equal(t, []string{"put /data/b.txt", "delete /data/a.txt", "put /data/c.txt"}, pendingItems(queue))
+	})
+
+	t.Run("上传目录合并子路径", func(t *testing.T) {
+		queue, err := journal.Queue("put-dir")
+		assert.NoError(t, err)
+		assert.NoError(t, queue.Push(newItems("put", "/data/dir/a.txt", "/data/dir/sub/b.txt", "/data/dir2/c.txt")...))
+		assert.NoError(t, queue.Push(newItems("delete", "/data/dir/old.txt")...))
+		assert.NoError(t, queue.Push(newItems("put", "/data/dir")...))
+		// 上级目录等待上传时子路径不再入队，删除不受影响
+		assert.NoError(t, queue.Push(newItems("put", "/data/dir/sub/d.txt")...))
+		assert.NoError(t, queue.Push(newItems("delete", "/data/dir/gone.txt")...))
+		assert.Equal(t, []string{
+			"put /data/dir2/c.txt",
+			"delete /data/dir/old.txt",
+			"put /data/dir",
+			"delete /data/dir/gone.txt",
+		}, pendingItems(queue))
+	})
+
+	t.Run("删除目录合并子路径", func(t *testing.T) {
+		queue, err := journal.Queue("delete-dir")
+		assert.NoError(t, err)
+		assert.NoError(t, queue.Push(newItems("put", "/data/dir/a.txt", "/data/dirx/b.txt")...))
+		assert.NoError(t, queue.Push(newItems("delete", "/data/dir/sub/c.txt")...))
+		assert.NoError(t, queue.Push(newItems("delete", "/data/dir")...))
+		assert.NoError(t, queue.Push(newItems("delete", "/data/dir/sub")...))
+		assert.Equal(t, []string{"put /data/dirx/b.txt", "delete /data/dir"}, pendingItems(queue))
+	})
+
+	t.Run("正在处理的任务不参与合并", func(t *testing.T) {
+		queue, err := journal.Queue("inflight")
+		assert.NoError(t, err)
+		assert.NoError(t, queue.Push(newItems("put", "/data/dir")...))
+		item, err := queue.Pop(ctx)
+		assert.NoError(t, err)
+		// 处理期间再次变更的路径重新入队
+		assert.NoError(t, queue.Push(newItems("put", "/data/dir", "/data/dir/a.txt")...))
+		n, err := queue.Len()
+		assert.NoError(t, err)
+		assert.Equal(t, 2, n)
+		// 确认旧任务后新任务仍参与合并
+		assert.NoError(t, queue.Ack(item))
+		assert.NoError(t, queue.Push(newItems("put", "/data/dir", "/data/dir/b.txt")...))
+		assert.Equal(t, []string{"put /data/dir"}, pendingItems(queue))
+	})
+
+	t.Run("重命名", func(t *testing.T) {
+		queue, err := journal.Queue("rename")
+		assert.NoError(t, err)
+		assert.NoError(t, queue.Push(newItems("put", "/data/new/a.txt")...))
+		assert.NoError(t, queue.Push(QueueItem{Op: "rename", Path: "/data/new", From: "/data/old"}))
+		// 重命名目录覆盖其下的上传，再次上传新路径时保留重命名
+		assert.NoError(t, queue.Push(newItems("put", "/data/new", "/data/new/b.txt")...))
+		assert.NoError(t, queue.Push(QueueItem{Op: "rename", Path: "/data/x/b.txt", From: "/data/y.txt"}))
+		assert.Equal(t, []string{"rename /data/old -> /data/new", "rename /data/y.txt -> /data/x/b.txt"}, pendingItems(queue))
+	})
+
+	t.Run("合并重命名时删除旧路径", func(t *testing.T) {
+		queue, err := journal.Queue("rename-discard")
+		assert.NoError(t, err)
+		assert.NoError(t, queue.Push(QueueItem{Op: "rename", Path: "/data/new", From: "/data/old"}))
+		assert.NoError(t, queue.Push(QueueItem{Op: "rename", Path: "/data/dir/b.txt", From: "/data/a.txt"}))
+		assert.NoError(t, queue.Push(newItems("delete", "/data/new", "/data/dir")...))
+		assert.Equal(t, []string{"delete /data/old", "delete /data/new", "delete /data/a.txt", "delete /data/dir"},
+			pendingItems(queue))
+	})
+}
+
+// TestQueue_Priority 测试按优先级取出与限制同时处理的大文件任务
+func TestQueue_Priority(t *testing.T) {
+	journal, err := OpenJournal(filepath.Join(t.TempDir(), "queue.db"))
+	assert.NoError(t, err)
+	defer journal.Close()
+	queue, err := journal.Queue("r1")
+	assert.NoError(t, err)
+	queue.SetLargeLimit(1)
+	ctx := context.Background()
+
+	assert.NoError(t, queue.Push(
+		QueueItem{Op: "put", Path: "/data/backlog.txt", Priority: 2},
+		QueueItem{Op: "put", Path: "/data/big1.iso", Priority: 1, Large: true},
+		QueueItem{Op: "put", Path: "/data/big2.iso", Priority: 1, Large: true},
+		QueueItem{Op: "put", Path: "/data/event.txt", Priority: 1},
+		QueueItem{Op: "put", Path: "/data/urgent.txt", Priority: 0},
+	))
+	// 已在等待的路径以更高的优先级入队时提升优先级
+	assert.NoError(t, queue.Push(QueueItem{Op: "put", Path: "/data/backlog2.txt", Priority: 2}))
+	assert.NoError(t, queue.Push(QueueItem{Op: "put", Path: "/data/backlog2.txt", Priority: 1}))
+	assert.NoError(t, queue.Push(QueueItem{Op: "put", Path: "/data/event.txt", Priority: 2}))
+
+	var popped []QueueItem
+	for _, want := range []string{"/data/urgent.txt", "/data/event.txt", "/data/backlog2.txt", "/data/big1.iso",
+		// 同时只处理一个大文件，其余的大文件排在低优先级的任务之后
+		"/data/backlog.txt"} {
+		item, err := queue.Pop(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, want, item.Path)
+		popped = append(popped, item)
+	}
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = queue.Pop(timeout)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// 大文件上传完成后取出下一个大文件
+	done := make(chan QueueItem)
+	go func() {
+		item, _ := queue.Pop(ctx)
+		done <- item
+	}()
+	assert.NoError(t, queue.Ack(popped[3]))
+	select {
+	case item := <-done:
+		assert.Equal(t, "/data/big2.iso", item.Path)
+	case <-time.After(time.Second):
+		t.Fatal("大文件任务确认后未能取出下一个大文件")
+	}
+}
+
+// TestOpenJournal_Locked 测试同一个文件不能被同时打开
+func TestOpenJournal_Locked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.db")
+	journal, err := OpenJournal(path)
+	assert.NoError(t, err)
+	defer journal.Close()
+	_, err = OpenJournal(path)
+	assert.ErrorContains(t, err, "locked")
+}
+
+// TestOpenTempJournal 测试临时任务日志关闭后删除
+func TestOpenTempJournal(t *testing.T) {
+	dir := t.TempDir()
+	journal, err := OpenTempJournal(dir)
+	assert.NoError(t, err)
+	queue, err := journal.Queue("r1")
+	assert.NoError(t, err)
+	assert.NoError(t, queue.Push(newItems("put", "/data/a.txt")...))
+	assert.NoError(t, journal.Close())
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,131 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry 路径最近一次同步完成时的状态
+type Entry struct {
+	// ETag 同步完成时远端对象的ETag
+	ETag string `json:"etag"`
+	// Size 同步完成时本地文件的大小
+	Size int64 `json:"size"`
+	// ModTime 同步完成时本地文件的修改时间
+	ModTime time.Time `json:"mod_time"`
+	// SyncedAt 记录该状态的时间
+	SyncedAt time.Time `json:"synced_at"`
+}
+
+// Store 同步状态存储，记录每个本地路径最近一次同步的远端ETag与本地修改时间
+// 用于区分"远端发生变更"与"本地发生变更"，数据以JSON格式持久化到本地文件
+type Store struct {
+	path    string
+	mu      sync.RWMutex
+	entries map[string]Entry
+	dirty   bool
+}
+
+// Open 打开状态存储，文件不存在时创建空的存储
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:    path,
+		entries: make(map[string]Entry),
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(buf, &s.entries); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get 获取路径的同步状态
+func (s *Store) Get(path string) (Entry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.entries[path]
+	return entry, ok
+}
+
+// Set 更新路径的同步状态
+func (s *Store) Set(path string, entry Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[path] = entry
+	s.dirty = true
+}
+
+// Delete 删除路径的同步状态
+func (s *Store) Delete(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[path]; ok {
+		delete(s.entries, path)
+		s.dirty = true
+	}
+}
+
+// DeletePrefix 删除路径及其子路径的同步状态
+func (s *Store) DeletePrefix(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.entries {
+		if key == path || strings.HasPrefix(key, path+"/") {
+			delete(s.entries, key)
+			s.dirty = true
+		}
+	}
+}
+
+// Keys 获取全部已记录的路径（有序）
+func (s *Store) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Save 持久化到本地文件，先写临时文件再重命名，避免写入中断导致文件损坏
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	if err := writeJSON(s.path, s.entries); err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// writeJSON 以JSON格式写入文件，先写临时文件再重命名
+func writeJSON(path string, v any) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
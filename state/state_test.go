@@ -0,0 +1,89 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestOpen 测试打开状态存储
+func TestOpen(t *testing.T) {
+	t.Run("文件不存在时创建空存储", func(t *testing.T) {
+		s, err := Open(filepath.Join(t.TempDir(), "state.json"))
+		assert.NoError(t, err)
+		assert.Empty(t, s.Keys())
+	})
+
+	t.Run("文件格式错误", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "state.json")
+		err := os.WriteFile(path, []byte("not json"), 0644)
+		assert.NoError(t, err)
+
+		_, err = Open(path)
+		assert.Error(t, err)
+	})
+}
+
+// TestStore_SetGetDelete 测试增删查
+func TestStore_SetGetDelete(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "state.json"))
+	assert.NoError(t, err)
+
+	entry := Entry{ETag: "abc", Size: 10, ModTime: time.Now()}
+	s.Set("/data/a.txt", entry)
+
+	got, ok := s.Get("/data/a.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "abc", got.ETag)
+
+	s.Delete("/data/a.txt")
+	_, ok = s.Get("/data/a.txt")
+	assert.False(t, ok)
+}
+
+// TestStore_DeletePrefix 测试按目录删除
+func TestStore_DeletePrefix(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "state.json"))
+	assert.NoError(t, err)
+
+	s.Set("/data/dir", Entry{})
+	s.Set("/data/dir/a.txt", Entry{})
+	s.Set("/data/dir/sub/b.txt", Entry{})
+	s.Set("/data/dirx.txt", Entry{})
+
+	s.DeletePrefix("/data/dir")
+
+	// 前缀相同但非子路径的记录不应被删除
+	assert.Equal(t, []string{"/data/dirx.txt"}, s.Keys())
+}
+
+// TestStore_Save 测试持久化与重新加载
+func TestStore_Save(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "state.json")
+	s, err := Open(path)
+	assert.NoError(t, err)
+
+	modTime := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.Set("/data/a.txt", Entry{ETag: "abc", Size: 10, ModTime: modTime})
+	assert.NoError(t, s.Save())
+
+	reloaded, err := Open(path)
+	assert.NoError(t, err)
+	got, ok := reloaded.Get("/data/a.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "abc", got.ETag)
+	assert.Equal(t, int64(10), got.Size)
+	assert.True(t, got.ModTime.Equal(modTime))
+
+	// 无变更时不重复写入
+	assert.NoError(t, os.Remove(path))
+	assert.NoError(t, reloaded.Save())
+	isExist := true
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		isExist = false
+	}
+	assert.False(t, isExist)
+}
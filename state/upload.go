@@ -0,0 +1,110 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Part 已上传完成的分片
+type Part struct {
+	Number int    `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+}
+
+// Upload 进行中的分片上传
+type Upload struct {
+	// UploadID 远端分片上传ID
+	UploadID string `json:"upload_id"`
+	// Source 上传的本地文件路径
+	Source string `json:"source"`
+	// Size 开始上传时本地文件的大小
+	Size int64 `json:"size"`
+	// ModTime 开始上传时本地文件的修改时间
+	ModTime time.Time `json:"mod_time"`
+	// PartSize 分片大小，最后一个分片可能更小
+	PartSize int64 `json:"part_size"`
+	// Parts 已上传完成的分片
+	Parts []Part `json:"parts"`
+	// CreatedAt 开始上传的时间
+	CreatedAt time.Time `json:"created_at"`
+	// Encoded 压缩、加密后上传的临时文件，为空时上传的是源文件
+	Encoded string `json:"encoded,omitempty"`
+	// EncodedSize 压缩、加密后临时文件的大小
+	EncodedSize int64 `json:"encoded_size,omitempty"`
+	// Metadata 创建分片上传时写入的对象用户元数据
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Uploads 分片上传记录，以对象名为键
+// 每次变更立即持久化，进程被中断后重启时可以只上传剩余的分片
+type Uploads struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]Upload
+}
+
+// OpenUploads 打开分片上传记录，文件不存在时创建空的记录
+func OpenUploads(path string) (*Uploads, error) {
+	u := &Uploads{
+		path:    path,
+		entries: make(map[string]Upload),
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return u, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(buf, &u.entries); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Get 获取对象的分片上传记录
+func (u *Uploads) Get(objectName string) (Upload, bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	upload, ok := u.entries[objectName]
+	if ok {
+		upload.Parts = append([]Part(nil), upload.Parts...)
+	}
+	return upload, ok
+}
+
+// Set 更新对象的分片上传记录并持久化
+func (u *Uploads) Set(objectName string, upload Upload) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	upload.Parts = append([]Part(nil), upload.Parts...)
+	u.entries[objectName] = upload
+	return writeJSON(u.path, u.entries)
+}
+
+// Delete 删除对象的分片上传记录并持久化
+func (u *Uploads) Delete(objectName string) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if _, ok := u.entries[objectName]; !ok {
+		return nil
+	}
+	delete(u.entries, objectName)
+	return writeJSON(u.path, u.entries)
+}
+
+// Keys 获取全部有记录的对象名（有序）
+func (u *Uploads) Keys() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	keys := make([]string, 0, len(u.entries))
+	for key := range u.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
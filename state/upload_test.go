@@ -0,0 +1,48 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestUploads 测试分片上传记录的增删查与立即持久化
+func TestUploads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "uploads", "a.json")
+	u, err := OpenUploads(path)
+	assert.NoError(t, err)
+	assert.Empty(t, u.Keys())
+
+	modTime := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	upload := Upload{UploadID: "id-1", Source: "/data/big.bin", Size: 100, ModTime: modTime, PartSize: 64}
+	assert.NoError(t, u.Set("backup/big.bin", upload))
+	upload.Parts = append(upload.Parts, Part{Number: 1, ETag: "etag-1", Size: 64})
+	assert.NoError(t, u.Set("backup/big.bin", upload))
+
+	// 无需显式保存，重新打开即可读取
+	reloaded, err := OpenUploads(path)
+	assert.NoError(t, err)
+	got, ok := reloaded.Get("backup/big.bin")
+	assert.True(t, ok)
+	assert.Equal(t, "id-1", got.UploadID)
+	assert.True(t, got.ModTime.Equal(modTime))
+	assert.Equal(t, []Part{{Number: 1, ETag: "etag-1", Size: 64}}, got.Parts)
+	assert.Equal(t, []string{"backup/big.bin"}, reloaded.Keys())
+
+	assert.NoError(t, reloaded.Delete("backup/big.bin"))
+	assert.NoError(t, reloaded.Delete("backup/missing.bin"))
+	reloaded, err = OpenUploads(path)
+	assert.NoError(t, err)
+	assert.Empty(t, reloaded.Keys())
+}
+
+// TestOpenUploads_Invalid 测试记录文件格式错误
+func TestOpenUploads_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "uploads.json")
+	assert.NoError(t, os.WriteFile(path, []byte("not json"), 0644))
+	_, err := OpenUploads(path)
+	assert.Error(t, err)
+}
@@ -1,71 +1,164 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/jorben/rsync-object-storage/config"
 	"github.com/jorben/rsync-object-storage/enum"
 	"github.com/jorben/rsync-object-storage/helper"
 	"github.com/jorben/rsync-object-storage/log"
+	"github.com/jorben/rsync-object-storage/state"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"golang.org/x/time/rate"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 )
 
+const (
+	// downloadPartSuffix 下载过程中临时文件的后缀（minio FGetObject 先写入临时文件再重命名）
+	downloadPartSuffix = ".part.minio"
+	// maxCopySize 单次服务端复制支持的最大对象大小，超过时使用分片复制
+	maxCopySize = 5 * 1024 * 1024 * 1024
+	// trashDir 回收站在远端路径下的目录
+	trashDir = ".trash/"
+	// trashDateFormat 回收站中按删除日期（UTC）分目录
+	trashDateFormat = "2006-01-02"
+	// streamUploadAttempts 流式上传过程中文件发生变化时的最大尝试次数
+	streamUploadAttempts = 3
+)
+
 type Storage struct {
-	Client       ObjectStorageClient
-	Bucket       string
-	LocalPrefix  string
-	RemotePrefix string
-	SymLink      string
+	Name          string // 远端名称，用于日志
+	Backend       StorageBackend
+	LocalPrefix   string
+	RemotePrefix  string
+	SymLink       string
+	Upload        string         // 上传方式(stream|copy)，为空时按stream处理
+	TempDir       string         // 临时文件目录，为空时使用工作目录
+	Checksum      bool           // 分片上传的对象也通过重新计算ETag来比较内容，而不是比较大小和修改时间
+	DryRun        bool           // 演练模式，只记录将要执行的上传和删除操作
+	Trash         bool           // 回收站模式，删除前先移动到远端路径下的回收站
+	State         *state.Store   // 双向同步状态，未启用双向同步时为nil
+	Uploads       *state.Uploads // 分片上传记录，存储后端不支持分片上传或演练模式时为nil
+	PartSize      int64          // 分片大小(bytes)，为0时按文件大小自动计算
+	ParallelParts int            // 单个文件同时上传的分片数，为0时逐个上传
+	Limiter       *rate.Limiter  // 上传限速器，多个远端共享以限制总带宽，为nil时不限速
+	CAS           *CAS           // 内容寻址存储模式的清单，未启用时为nil
+	ChunkFileSize int64          // 达到该大小(bytes)的文件分块上传，为0时不分块
+	ChunkSize     int            // 分块的平均大小(bytes)
+	Compress      *Compression   // 上传前压缩的规则，未启用时为nil
+	Encrypt       *Encryption    // 上传前加密的主密钥，未启用时为nil
 }
 
-// NewStorage 获取对象存储客户端实例
+// NewStorage 获取存储实例，按 remote.type 选择存储后端
 func NewStorage(c *config.SyncConfig) (*Storage, error) {
-	cli, err := minio.New(c.Remote.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(c.Remote.SecretId, c.Remote.SecretKey, ""),
-		Region: c.Remote.Region,
-		Secure: c.Remote.UseSSL,
-		// 可以跳过证书校验，可用于自签发证书的场景
-		//Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
-	})
+	backend, err := newBackend(c.Remote)
 	if err != nil {
 		return nil, err
 	}
+	if err = os.MkdirAll(c.Sync.TempDir, 0755); err != nil {
+		return nil, err
+	}
+	// 记录大文件的分片上传进度，重启后只上传剩余的分片
+	var uploads *state.Uploads
+	if _, ok := backend.(MultipartBackend); ok && !c.DryRun {
+		if uploads, err = state.OpenUploads(c.UploadsPath()); err != nil {
+			return nil, err
+		}
+	}
+	// 内容寻址存储模式的清单在首次使用时加载
+	var cas *CAS
+	if c.Sync.CAS.Enable {
+		cas = NewCAS()
+	}
+	// 大文件按内容分块上传，只上传新的数据块
+	var chunkFileSize int64
+	if c.Sync.Chunk.Enable {
+		chunkFileSize = c.Sync.Chunk.FileSize * 1024 * 1024
+	}
+	// 压缩的算法和原始内容信息记录在对象的用户元数据中
+	var compress *Compression
+	if c.Sync.Compress.Enable {
+		if _, ok := backend.(MetadataBackend); !ok {
+			return nil, fmt.Errorf("remote %s does not support object metadata required by sync.compress", c.Remote.GetName())
+		}
+		compress = NewCompression(c.Sync.Compress.Algorithm, c.Sync.Compress.Patterns, c.Sync.Compress.Skip)
+	}
+	// 包装后的数据密钥记录在对象的用户元数据中
+	var encrypt *Encryption
+	if c.Sync.Encrypt.Enable {
+		if _, ok := backend.(MetadataBackend); !ok {
+			return nil, fmt.Errorf("remote %s does not support object metadata required by sync.encrypt", c.Remote.GetName())
+		}
+		if encrypt, err = NewEncryption(c.Sync.Encrypt.KeyFile, c.Sync.Encrypt.KeyEnv, c.Sync.Encrypt.OldKeyFiles); err != nil {
+			return nil, err
+		}
+	}
 
 	return &Storage{
-		Client:       cli,
-		Bucket:       c.Remote.Bucket,
-		LocalPrefix:  c.Local.Path,
-		RemotePrefix: c.Remote.Path,
-		SymLink:      c.Sync.Symlink,
+		Name:          c.Remote.GetName(),
+		Backend:       backend,
+		LocalPrefix:   c.Local.Path,
+		RemotePrefix:  c.Remote.Path,
+		SymLink:       c.Sync.Symlink,
+		Upload:        c.Sync.Upload,
+		TempDir:       c.Sync.TempDir,
+		Checksum:      c.Sync.Checksum,
+		DryRun:        c.DryRun,
+		Trash:         c.Sync.Trash.Enable,
+		Uploads:       uploads,
+		PartSize:      c.Transfer.PartSize * 1024 * 1024,
+		ParallelParts: c.Transfer.ParallelParts,
+		CAS:           cas,
+		ChunkFileSize: chunkFileSize,
+		ChunkSize:     c.Sync.Chunk.ChunkSize * 1024,
+		Compress:      compress,
+		Encrypt:       encrypt,
 	}, nil
 }
 
+// newBackend 根据远端配置创建存储后端
+func newBackend(r config.RemoteConfig) (StorageBackend, error) {
+	switch r.Type {
+	case enum.BackendLocal:
+		return NewLocalBackend(r.Bucket), nil
+	case enum.BackendWebDAV:
+		return NewWebDAVBackend(r.Endpoint, r.Bucket, r.SecretId, r.SecretKey)
+	default:
+		cli, err := minio.New(r.Endpoint, &minio.Options{
+			Creds:  credentials.NewStaticV4(r.SecretId, r.SecretKey, ""),
+			Region: r.Region,
+			Secure: r.UseSSL,
+			// 可以跳过证书校验，可用于自签发证书的场景
+			//Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		backend := NewS3Backend(cli, r.Bucket)
+		backend.Core = &minio.Core{Client: cli}
+		return backend, nil
+	}
+}
+
 // ListBucket 列出Bucket列表
 func (s *Storage) ListBucket(ctx context.Context) ([]string, error) {
-	var bucketList []string
-	bucketInfoList, err := s.Client.ListBuckets(ctx)
-	if err != nil {
-		return bucketList, err
-	}
-	for _, bucket := range bucketInfoList {
-		bucketList = append(bucketList, bucket.Name)
-	}
-	return bucketList, nil
+	return s.Backend.ListBuckets(ctx)
 }
 
 // BucketExists 判断Bucket是否存在
 func (s *Storage) BucketExists(ctx context.Context) error {
-	exist, err := s.Client.BucketExists(ctx, s.Bucket)
+	exist, err := s.Backend.BucketExists(ctx)
 	if err != nil {
 		return err
 	}
 	if !exist {
-		return fmt.Errorf("bucket %s is not exist", s.Bucket)
+		return fmt.Errorf("bucket %s is not exist", s.Name)
 	}
 	return nil
 }
@@ -73,53 +166,138 @@ func (s *Storage) BucketExists(ctx context.Context) error {
 // RemoveObject 删除对象
 func (s *Storage) RemoveObject(ctx context.Context, objectName string) error {
 	objectName = s.GetRemotePath(objectName)
-	_, err := s.Client.StatObject(ctx, s.Bucket, objectName, minio.StatObjectOptions{})
+	objectInfo, err := s.Backend.StatObject(ctx, objectName)
 	if err != nil {
 		// 多半是Key不存在
 		log.Debugf("StatObject err: %s, path: %s", err.Error(), objectName)
 		return nil
 	}
 
-	return s.Client.RemoveObject(ctx, s.Bucket, objectName, minio.RemoveObjectOptions{})
+	if s.DryRun {
+		log.Infof("Dry-run: would delete %s, size: %s, reason: local removed", objectName, helper.ByteFormat(objectInfo.Size))
+		return enum.ErrDryRun
+	}
+	if s.Trash {
+		if err = s.moveToTrash(ctx, objectInfo, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	return s.Backend.RemoveObject(ctx, objectName)
 
 }
 
-// RemoveObjects 批量删除对象
-func (s *Storage) RemoveObjects(ctx context.Context, objectPath string) (someError error) {
-	ch := make(chan minio.ObjectInfo)
-	objectPath = s.GetRemotePath(objectPath)
+// RemoveObjects 批量删除对象，启用回收站时先移动到回收站，CAS模式下只从清单中删除
+func (s *Storage) RemoveObjects(ctx context.Context, localPath string) (someError error) {
+	if s.CAS != nil {
+		return s.casRemove(ctx, localPath)
+	}
+	if s.ChunkFileSize > 0 {
+		// 本地已删除的文件不再需要分块结果缓存
+		helper.InvalidateFileChunks(localPath)
+	}
+	ch := make(chan ObjectInfo)
+	objectPath := s.GetRemotePath(localPath)
+	deletedAt := time.Now()
+	var trashError, listError error
 	go func() {
 		defer close(ch)
-		for object := range s.Client.ListObjects(ctx, s.Bucket,
-			minio.ListObjectsOptions{Prefix: objectPath, Recursive: true}) {
+		for object := range s.Backend.ListObjects(ctx, objectPath, true) {
 			if object.Err != nil {
 				log.Errorf("ListObjects err: %s", object.Err.Error())
+				// 列举失败时可能有对象未被删除，返回错误以便重试
+				listError = object.Err
 				continue
 			}
-			if object.Key == objectPath ||
+			if s.IsTrash(object.Key) || s.IsSnapshot(object.Key) {
+				// 回收站和快照不随本地删除
+				continue
+			}
+			if object.Key == objectPath || object.Key == objectPath+".link" || object.Key == objectPath+chunkListSuffix ||
 				(len(object.Key) > len(objectPath) && objectPath+"/" == object.Key[0:len(objectPath)+1]) {
-				// 避免误删了前缀相同但非子文件，比如 abc abcd.txt
+				// 避免误删了前缀相同但非子文件，比如 abc abcd.txt，符号链接以addr策略上传时对象名带有.link后缀，
+				// 分块上传的文件对象名带有.chunklist后缀
+				if s.Trash && !s.DryRun {
+					// 移动到回收站失败的对象不删除
+					if err := s.moveToTrash(ctx, object, deletedAt); err != nil {
+						log.Errorf("Move to trash err: %s, path: %s", err.Error(), object.Key)
+						trashError = err
+						continue
+					}
+				}
 				ch <- object
-				log.Infof("Will be delete %s", object.Key)
+				if !s.DryRun {
+					log.Infof("Will be delete %s", object.Key)
+				}
 			}
 		}
 	}()
 
+	if s.DryRun {
+		for object := range ch {
+			log.Infof("Dry-run: would delete %s, size: %s, reason: local removed", object.Key, helper.ByteFormat(object.Size))
+		}
+		return enum.ErrDryRun
+	}
+
 	someError = nil
-	opts := minio.RemoveObjectsOptions{GovernanceBypass: true}
-	for err := range s.Client.RemoveObjects(ctx, s.Bucket, ch, opts) {
+	for err := range s.Backend.RemoveObjects(ctx, ch) {
 		someError = err.Err
 		log.Errorf("RemoveObjects err: %s, path: %s", err.Err.Error(), err.ObjectName)
 	}
+	if someError == nil {
+		someError = trashError
+	}
+	if someError == nil {
+		someError = listError
+	}
+	if someError == nil && s.State != nil {
+		s.State.DeletePrefix(localPath)
+	}
 	return someError
 }
 
+// GetTrashRoot 获取回收站在远端的根路径
+func (s *Storage) GetTrashRoot() string {
+	return s.GetRemoteRoot() + trashDir
+}
+
+// IsTrash 判断对象是否在回收站中
+func (s *Storage) IsTrash(objectName string) bool {
+	return strings.HasPrefix(objectName, s.GetTrashRoot())
+}
+
+// moveToTrash 把对象服务端复制到回收站中删除日期对应的目录，调用方负责删除原对象
+func (s *Storage) moveToTrash(ctx context.Context, object ObjectInfo, deletedAt time.Time) error {
+	trashName := s.GetTrashRoot() + deletedAt.UTC().Format(trashDateFormat) + "/" +
+		strings.TrimPrefix(object.Key, s.GetRemoteRoot())
+	if err := s.CopyObject(ctx, object.Key, trashName, object.Size); err != nil {
+		return err
+	}
+	log.Infof("Move to trash %s -> %s", object.Key, trashName)
+	return nil
+}
+
 // FPutObject 上传对象
 func (s *Storage) FPutObject(ctx context.Context, localPath string) error {
+	// 下载过程中的临时文件不需要上传
+	if strings.HasSuffix(localPath, downloadPartSuffix) {
+		return enum.ErrSkipTransfer
+	}
+	if s.CAS != nil {
+		return s.casPut(ctx, localPath)
+	}
 	// 文件 则需要对远端内容一致性比较，内容一致则不重复上传
-	if isSame := s.IsSameV2(ctx, localPath, ""); isSame {
+	reason := s.diff(ctx, localPath, "")
+	if reason == "" {
 		return enum.ErrSkipTransfer
 	}
+	if s.isChunkedPath(localPath) {
+		return s.chunkUpload(ctx, localPath, reason)
+	}
+	// 记录上传前的文件状态，供双向同步判断本地是否发生变更
+	sourcePath := localPath
+	sourceInfo, _ := os.Lstat(localPath)
 	objectName := localPath
 	// 判断是否符号链接
 	if isLink, _ := helper.IsSymlink(localPath); isLink {
@@ -146,27 +324,29 @@ func (s *Storage) FPutObject(ctx context.Context, localPath string) error {
 				log.Errorf("RandomString err: %s", err.Error())
 				randomString = "tmp_link_content"
 			}
-			linkContentFile, err := os.Create("./" + randomString)
+			linkContentPath := s.tempPath(randomString)
+			linkContentFile, err := os.Create(linkContentPath)
 			if err != nil {
 				return err
 			}
 			defer linkContentFile.Close()
-			defer os.Remove("./" + randomString)
+			defer os.Remove(linkContentPath)
 			_, err = io.WriteString(linkContentFile, target)
 			if err != nil {
 				return err
 			}
-			localPath = "./" + randomString
+			localPath = linkContentPath
 		default:
 			return enum.ErrSkipTransfer
 		}
 	}
 
-	if isDir, _ := helper.IsDir(localPath); isDir {
+	isDir, _ := helper.IsDir(localPath)
+	if isDir {
 		// 如果是文件夹则创建objectName/.keep文件，现有接口不支持直接创建空文件夹
 		objectName += "/.keep"
 		// 构造一个空文件用于上传
-		localPath = "./.empty"
+		localPath = s.tempPath(".empty")
 		if isExist, _ := helper.IsExist(localPath); !isExist {
 			// 创建空文件
 			emptyFile, err := os.Create(localPath)
@@ -179,16 +359,101 @@ func (s *Storage) FPutObject(ctx context.Context, localPath string) error {
 
 	objectName = s.GetRemotePath(objectName)
 
+	if s.DryRun {
+		var size int64
+		if fileInfo, err := os.Stat(localPath); err == nil {
+			size = fileInfo.Size()
+		}
+		log.Infof("Dry-run: would upload %s, size: %s, reason: %s", objectName, helper.ByteFormat(size), reason)
+		return enum.ErrDryRun
+	}
+
+	var uploadInfo ObjectInfo
+	var err error
+	if dirBackend, ok := s.Backend.(DirBackend); ok && isDir {
+		// 原生支持目录的后端直接创建空目录，不上传 .keep 文件
+		uploadInfo = ObjectInfo{Key: objectName}
+		err = dirBackend.MakeDir(ctx, strings.TrimSuffix(objectName, "/.keep"))
+	} else if localPath == sourcePath && s.shouldEncode(localPath) {
+		uploadInfo, err = s.encodeUpload(ctx, objectName, localPath)
+	} else if s.Upload == enum.UploadCopy {
+		uploadInfo, err = s.copyUpload(ctx, objectName, localPath)
+	} else {
+		uploadInfo, err = s.streamUpload(ctx, objectName, localPath)
+	}
+	if err != nil {
+		return err
+	}
+	// 文件此前分块上传（如文件缩小到阈值以下）时删除原块列表
+	if s.ChunkFileSize > 0 && sourceInfo != nil && sourceInfo.Mode().IsRegular() {
+		listName := objectName + chunkListSuffix
+		if _, err := s.Backend.StatObject(ctx, listName); err == nil {
+			if err := s.Backend.RemoveObject(ctx, listName); err != nil {
+				log.Errorf("RemoveObject err: %s, path: %s", err.Error(), listName)
+			}
+		}
+	}
+	s.recordState(sourcePath, sourceInfo, uploadInfo.ETag)
+	return nil
+}
+
+// FCopyObject 把旧路径已同步的对象在服务端复制为本地路径对应的对象，用于重命名后不重新上传
+// 只复制与本地文件内容一致的普通文件，目录、符号链接和内容不一致的文件按 FPutObject 上传，返回是否通过复制完成
+func (s *Storage) FCopyObject(ctx context.Context, oldPath, localPath string) (bool, error) {
+	if s.CAS != nil {
+		return s.casCopy(ctx, oldPath, localPath)
+	}
+	fileInfo, err := os.Lstat(localPath)
+	if err != nil || !fileInfo.Mode().IsRegular() || strings.HasSuffix(localPath, downloadPartSuffix) {
+		return false, s.FPutObject(ctx, localPath)
+	}
+	if s.diff(ctx, localPath, "") == "" {
+		return false, enum.ErrSkipTransfer
+	}
+	srcName, dstName := s.GetRemotePath(oldPath), s.GetRemotePath(localPath)
+	if reason := s.diff(ctx, localPath, srcName); reason != "" {
+		// 旧路径的对象不存在（尚未同步）或重命名后内容被修改
+		log.Debugf("Can not copy from %s, reason: %s, upload %s", srcName, reason, localPath)
+		return false, s.FPutObject(ctx, localPath)
+	}
+	size := fileInfo.Size()
+	if s.isChunkedPath(localPath) {
+		// 分块上传的文件只复制块列表，数据块按内容共享，块列表远小于单次复制的大小上限
+		srcName, dstName, size = srcName+chunkListSuffix, dstName+chunkListSuffix, 0
+	}
+
+	if s.DryRun {
+		log.Infof("Dry-run: would copy %s -> %s, size: %s, reason: renamed", srcName, dstName, helper.ByteFormat(fileInfo.Size()))
+		return true, enum.ErrDryRun
+	}
+	if err = s.CopyObject(ctx, srcName, dstName, size); err != nil {
+		return false, err
+	}
+	if s.State != nil {
+		if objectInfo, err := s.Backend.StatObject(ctx, dstName); err == nil {
+			s.recordState(localPath, fileInfo, objectInfo.ETag)
+		}
+	}
+	return true, nil
+}
+
+// copyUpload 先拷贝到临时文件再上传，避免上传过程中文件被修改
+// 临时目录与源文件在支持reflink的同一文件系统（如btrfs、XFS）时使用写时复制克隆，不复制数据
+func (s *Storage) copyUpload(ctx context.Context, objectName, localPath string) (ObjectInfo, error) {
+	sourceInfo, err := os.Stat(localPath)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
 	tmp := localPath
 	// 先拷贝 再上传
 	randomString, err := helper.RandomString(32)
 	if err != nil {
 		log.Errorf("RandomString err: %s", err.Error())
 	} else {
-		tmp = "./." + randomString
-		fileSize, err := helper.Copy(localPath, tmp)
+		tmp = s.tempPath("." + randomString)
+		fileSize, cloned, err := helper.CloneOrCopy(localPath, tmp)
 		if err == nil {
-			log.Debugf("Copy is ready, size %s", helper.ByteFormat(fileSize))
+			log.Debugf("Copy is ready, size %s, reflink: %t", helper.ByteFormat(fileSize), cloned)
 			defer os.Remove(tmp)
 		} else {
 			log.Errorf("Copy err: %s", err.Error())
@@ -196,34 +461,159 @@ func (s *Storage) FPutObject(ctx context.Context, localPath string) error {
 			tmp = localPath
 		}
 	}
+	if s.isResumable(sourceInfo.Size()) {
+		// 以源文件的大小和修改时间判断能否继续上次的分片上传
+		file, err := os.Open(tmp)
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+		defer file.Close()
+		return s.multipartUpload(ctx, objectName, localPath, sourceInfo, file, nil)
+	}
+	if s.Limiter != nil {
+		file, err := os.Open(tmp)
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+		defer file.Close()
+		return s.Backend.PutObject(ctx, objectName, helper.NewRateLimitedReader(ctx, file, s.Limiter), sourceInfo.Size())
+	}
+	return s.Backend.FPutObject(ctx, objectName, tmp)
+}
+
+// streamUpload 直接从源文件流式上传，不占用额外的磁盘空间
+// 上传前后比较文件大小、修改时间和inode，上传过程中文件被修改或替换时重新上传
+func (s *Storage) streamUpload(ctx context.Context, objectName, localPath string) (ObjectInfo, error) {
+	for attempt := 1; ; attempt++ {
+		uploadInfo, changed, err := s.streamUploadOnce(ctx, objectName, localPath)
+		if err != nil || !changed {
+			return uploadInfo, err
+		}
+		if attempt >= streamUploadAttempts {
+			return ObjectInfo{}, fmt.Errorf("file changed during upload after %d attempts: %s", attempt, localPath)
+		}
+		log.Warnf("File changed during upload, retrying %s", localPath)
+	}
+}
+
+// streamUploadOnce 上传一次，返回上传过程中文件是否发生了变化
+func (s *Storage) streamUploadOnce(ctx context.Context, objectName, localPath string) (ObjectInfo, bool, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return ObjectInfo{}, false, err
+	}
+	defer file.Close()
+	before, err := file.Stat()
+	if err != nil {
+		return ObjectInfo{}, false, err
+	}
+	var uploadInfo ObjectInfo
+	if s.isResumable(before.Size()) {
+		uploadInfo, err = s.multipartUpload(ctx, objectName, localPath, before, file, nil)
+	} else {
+		uploadInfo, err = s.Backend.PutObject(ctx, objectName, helper.NewRateLimitedReader(ctx, file, s.Limiter), before.Size())
+	}
+	after, statErr := os.Stat(localPath)
+	if statErr != nil || !isUnchangedFile(before, after) {
+		// 文件变化导致的读取错误（如文件被截断）也重新上传
+		return ObjectInfo{}, true, nil
+	}
+	return uploadInfo, false, err
+}
 
-	if _, err := s.Client.FPutObject(ctx, s.Bucket, objectName, tmp, minio.PutObjectOptions{}); err != nil {
+// isUnchangedFile 比较文件大小、修改时间和inode，判断文件是否未发生变化
+func isUnchangedFile(before, after os.FileInfo) bool {
+	return before.Size() == after.Size() && before.ModTime().Equal(after.ModTime()) &&
+		helper.Inode(before) == helper.Inode(after)
+}
+
+// recordState 记录普通文件同步完成后的状态
+func (s *Storage) recordState(localPath string, fileInfo os.FileInfo, etag string) {
+	if s.State == nil || fileInfo == nil || !fileInfo.Mode().IsRegular() {
+		return
+	}
+	s.State.Set(localPath, state.Entry{
+		ETag:     strings.Trim(etag, "\""),
+		Size:     fileInfo.Size(),
+		ModTime:  fileInfo.ModTime(),
+		SyncedAt: time.Now(),
+	})
+}
+
+// GetObject 获取对象内容，调用方负责关闭
+func (s *Storage) GetObject(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	return s.Backend.GetObject(ctx, objectName)
+}
+
+// FGetObject 下载对象到本地路径，自动创建上级目录
+func (s *Storage) FGetObject(ctx context.Context, objectName, localPath string) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
 		return err
 	}
-	return nil
+	return s.Backend.FGetObject(ctx, objectName, localPath)
+}
+
+// ListObjects 递归列出远端路径下的所有对象
+func (s *Storage) ListObjects(ctx context.Context) <-chan ObjectInfo {
+	return s.Backend.ListObjects(ctx, s.GetRemoteRoot(), true)
+}
+
+// ListPrefix 列出指定前缀下的对象，非递归时子目录以/结尾的前缀返回
+func (s *Storage) ListPrefix(ctx context.Context, prefix string, recursive bool) <-chan ObjectInfo {
+	return s.Backend.ListObjects(ctx, prefix, recursive)
+}
+
+// PutObject 上传内容到指定对象
+func (s *Storage) PutObject(ctx context.Context, objectName string, data []byte) error {
+	_, err := s.Backend.PutObject(ctx, objectName, bytes.NewReader(data), int64(len(data)))
+	return err
+}
+
+// CopyObject 在存储后端内部复制对象，不经过本地
+func (s *Storage) CopyObject(ctx context.Context, srcName, dstName string, size int64) error {
+	return s.Backend.CopyObject(ctx, srcName, dstName, size)
 }
 
 // IsSameV2 判断本地文件和远端文件内容是否一致，相较于V1新增包含了符号链接、空文件夹的判断
 func (s *Storage) IsSameV2(ctx context.Context, localPath, remotePath string) bool {
+	return s.diff(ctx, localPath, remotePath) == ""
+}
+
+// diff 比较本地文件和远端文件，返回不一致的原因，内容一致（或无需同步）时返回空字符串
+func (s *Storage) diff(ctx context.Context, localPath, remotePath string) string {
 	var err error
 	var localMd5 string
+	isAddr := false
+	if s.CAS != nil && remotePath == "" {
+		// 清单加载失败时按不一致处理，丢入变更队列后由上传重试
+		reason, err := s.casDiff(ctx, localPath)
+		if err != nil {
+			log.Errorf("Compare %s err: %s", localPath, err.Error())
+			return enum.DiffNew
+		}
+		return reason
+	}
 	if remotePath == "" {
 		remotePath = s.GetRemotePath(localPath)
 	}
+	// 分块上传的文件比较块列表
+	if s.isChunkedPath(localPath) {
+		return s.chunkDiff(ctx, localPath, remotePath+chunkListSuffix)
+	}
 	isLink, _ := helper.IsSymlink(localPath)
 	// 判断本地路径是否符号链接
 	if isLink {
 		switch s.SymLink {
 		case enum.SymlinkSkip:
 			log.Debugf("SymlinkSkip %s", localPath)
-			return true
+			return ""
 		case enum.SymlinkFile:
 			if isDir, _ := helper.IsDir(localPath); !isDir {
 				log.Debugf("SymlinkFile %s", localPath)
 				localMd5, err = helper.GetCachedFileMd5(localPath)
 				if err != nil {
 					log.Errorf("MD5 error: %s", err.Error())
-					return false
+					return enum.DiffETag
 				}
 				break
 			}
@@ -233,33 +623,69 @@ func (s *Storage) IsSameV2(ctx context.Context, localPath, remotePath string) bo
 		case enum.SymlinkAddr:
 			log.Debugf("SymlinkAddr %s", localPath)
 			remotePath += ".link"
+			isAddr = true
 			// 获取目标地址
 			target, _ := helper.GetSymlinkTarget(localPath)
 			// 计算md5值
 			localMd5 = helper.StringMd5(target)
 		default:
-			return true
+			return ""
 		}
 	}
 
 	// 非符号链接的目录
+	isEmptyDir := false
 	if isDir, _ := helper.IsDir(localPath); !isLink && isDir {
 		// 判断是否非空，非空直接过
 		if isEmpty, _ := helper.IsDirEmpty(localPath); !isEmpty {
 			log.Debugf("Skip dir, is not empty %s", localPath)
-			return true
+			return ""
 		} else {
 			// 空目录用.keep文件构建
 			remotePath += "/.keep"
 			localMd5 = "d41d8cd98f00b204e9800998ecf8427e"
+			isEmptyDir = true
 		}
 	}
 
-	objectInfo, err := s.Client.StatObject(ctx, s.Bucket, remotePath, minio.StatObjectOptions{})
+	objectInfo, err := s.Backend.StatObject(ctx, remotePath)
 	if err != nil {
 		// 多半是Key不存在
 		log.Debugf("StatObject %s, path: %s", err.Error(), remotePath)
-		return false
+		return enum.DiffNew
+	}
+	// 启用加密前上传的对象需要重新加密上传，符号链接地址和空目录不加密
+	if s.Encrypt != nil && !isAddr && objectInfo.Metadata[metaEncryption] == "" && s.shouldEncode(localPath) {
+		return enum.DiffNotEncrypted
+	}
+	// 压缩或加密上传的对象按元数据记录的原始内容比较
+	if objectInfo.Metadata[metaOriginalMd5] != "" {
+		return originalDiff(localPath, objectInfo)
+	}
+	// 存储后端的ETag不是内容MD5（如WebDAV），通过校验文件大小和修改时间来判断是否一致
+	if !s.Backend.ContentMD5() {
+		if isEmptyDir {
+			// 空目录存在即一致，真实的 .keep 文件按大小和修改时间比较
+			return ""
+		}
+		// 符号链接以addr策略上传时内容为目标地址，Lstat的大小即目标地址的长度
+		var fileInfo os.FileInfo
+		if isAddr {
+			fileInfo, err = os.Lstat(localPath)
+		} else {
+			fileInfo, err = os.Stat(localPath)
+		}
+		if err != nil {
+			log.Errorf("Stat file err: %s", err.Error())
+			return enum.DiffModTime
+		}
+		log.Debugf("Compare %s, Size: %d, ModifyTime:%s, Remote Size:%d, ModifyTime:%s",
+			localPath, fileInfo.Size(), fileInfo.ModTime().Format("2006-01-02 15:04:05"),
+			objectInfo.Size, objectInfo.LastModified.In(time.Now().Location()).Format("2006-01-02 15:04:05"))
+		if !isSameSizeModTime(fileInfo, objectInfo) {
+			return enum.DiffModTime
+		}
+		return ""
 	}
 	// 是否分片上传的文件，分片上传的Etag是各分片MD5值合并后的MD5，所以与文件MCD5不一致，且ETAG带有分片数量标识
 	// 分片场景，通过校验文件大小和修改时间来判断是否一致
@@ -267,15 +693,33 @@ func (s *Storage) IsSameV2(ctx context.Context, localPath, remotePath string) bo
 		fileInfo, err := os.Stat(localPath)
 		if err != nil {
 			log.Errorf("Stat file err: %s", err.Error())
-			return false
+			return enum.DiffMultipart
+		}
+		if s.Checksum {
+			// 按分片上传规则重新计算本地文件的ETag
+			partSize, err := s.partSize(fileInfo.Size())
+			if err != nil {
+				log.Errorf("OptimalPartInfo err: %s", err.Error())
+				return enum.DiffChecksum
+			}
+			localETag, err := helper.FileMultipartMd5(localPath, partSize)
+			if err != nil {
+				log.Errorf("MD5 error: %s", err.Error())
+				return enum.DiffChecksum
+			}
+			log.Debugf("Compare big file by checksum: %s, Local ETag: %s, Remote ETag: %s", localPath, localETag, objectInfo.ETag)
+			if !strings.EqualFold(localETag, objectInfo.ETag) {
+				return enum.DiffChecksum
+			}
+			return ""
 		}
 		log.Debugf("Compare big file: %s, Size: %d, ModifyTime:%s, Remote Size:%d, ModifyTime:%s",
 			localPath, fileInfo.Size(), fileInfo.ModTime().Format("2006-01-02 15:04:05"),
 			objectInfo.Size, objectInfo.LastModified.In(time.Now().Location()).Format("2006-01-02 15:04:05"))
 		if fileInfo.Size() != objectInfo.Size || fileInfo.ModTime().After(objectInfo.LastModified) {
-			return false
+			return enum.DiffMultipart
 		}
-		return true
+		return ""
 	}
 
 	// 计算本地文件的md5（使用缓存避免重复计算）
@@ -284,13 +728,108 @@ func (s *Storage) IsSameV2(ctx context.Context, localPath, remotePath string) bo
 	}
 	log.Debugf("Compare %s, Local Md5: %s, Remote ETag: %s", localPath, localMd5, objectInfo.ETag)
 	if strings.EqualFold(localMd5, objectInfo.ETag) {
-		return true
+		return ""
+	}
+	return enum.DiffETag
+
+}
+
+// isSameObject 判断本地文件与列举得到的远端对象是否一致，判断规则与 IsSameV2 保持一致
+func (s *Storage) isSameObject(localPath string, object ObjectInfo) bool {
+	fileInfo, err := os.Stat(localPath)
+	if err != nil || fileInfo.IsDir() {
+		return false
+	}
+	if object.Metadata[metaOriginalMd5] != "" {
+		return originalDiff(localPath, object) == ""
+	}
+	if !s.Backend.ContentMD5() {
+		return isSameSizeModTime(fileInfo, object)
+	}
+	// 分片上传的对象通过校验文件大小和修改时间来判断是否一致
+	if strings.Contains(object.ETag, "-") {
+		return fileInfo.Size() == object.Size && !fileInfo.ModTime().After(object.LastModified)
 	}
-	return false
+	localMd5, err := helper.GetCachedFileMd5(localPath)
+	if err != nil {
+		log.Errorf("MD5 error: %s", err.Error())
+		return false
+	}
+	return strings.EqualFold(localMd5, object.ETag)
+}
+
+// isSameSizeModTime 大小一致且本地修改时间不晚于远端时视为一致，HTTP的时间精度为秒，本地修改时间按秒截断后比较
+func isSameSizeModTime(fileInfo os.FileInfo, object ObjectInfo) bool {
+	return fileInfo.Size() == object.Size && !fileInfo.ModTime().Truncate(time.Second).After(object.LastModified)
+}
 
+// tempPath 获取临时文件的路径
+func (s *Storage) tempPath(name string) string {
+	if s.TempDir == "" {
+		return "./" + name
+	}
+	return filepath.Join(s.TempDir, name)
 }
 
 // GetRemotePath 把本地路径映射远端路径
 func (s *Storage) GetRemotePath(path string) string {
 	return strings.TrimLeft(strings.Replace(path, s.LocalPrefix, s.RemotePrefix, 1), "/")
 }
+
+// GetRemoteRoot 获取远端根路径，用于列举对象的前缀（非空时以/结尾，避免匹配到前缀相同的其他目录）
+func (s *Storage) GetRemoteRoot() string {
+	root := strings.Trim(s.RemotePrefix, "/")
+	if root == "" {
+		return ""
+	}
+	return root + "/"
+}
+
+// GetLocalPath 把远端路径映射回本地路径，是 GetRemotePath 的逆操作
+// 对象名包含..等映射到本地路径之外时返回错误
+func (s *Storage) GetLocalPath(objectName string) (string, error) {
+	root := s.GetRemoteRoot()
+	objectName = strings.TrimLeft(objectName, "/")
+	if objectName+"/" == root {
+		return s.LocalPrefix, nil
+	}
+	return s.joinLocalPath(strings.TrimPrefix(objectName, root))
+}
+
+// joinLocalPath 把以/分隔的相对路径拼接到本地路径下，结果不在本地路径下时返回错误
+// 避免远端对象名或清单中的..把文件写到本地路径之外
+func (s *Storage) joinLocalPath(rel string) (string, error) {
+	path := filepath.Join(s.LocalPrefix, filepath.FromSlash(rel))
+	if r, err := filepath.Rel(s.LocalPrefix, path); err != nil || r == ".." ||
+		strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %s is outside the local path %s", rel, s.LocalPrefix)
+	}
+	return path, nil
+}
+
+// checkLocalParents 检查本地路径在 local.path 下的各级上级目录都不是符号链接，不存在的上级目录由调用方创建
+// 远端的符号链接先恢复为本地链接时，链接下的对象会经由链接写到本地路径之外，写入或删除本地路径前调用
+func (s *Storage) checkLocalParents(localPath string) error {
+	rel, err := filepath.Rel(s.LocalPrefix, filepath.Dir(localPath))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("path %s is outside the local path %s", localPath, s.LocalPrefix)
+	}
+	if rel == "." {
+		return nil
+	}
+	path := s.LocalPrefix
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		path = filepath.Join(path, name)
+		fileInfo, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fileInfo.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("parent %s of %s is a symlink", path, localPath)
+		}
+	}
+	return nil
+}
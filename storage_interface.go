@@ -2,10 +2,104 @@ package main
 
 import (
 	"context"
+	"io"
+	"time"
 
 	"github.com/minio/minio-go/v7"
 )
 
+// ObjectInfo 对象元信息，与具体的存储后端无关
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+	Metadata     map[string]string // 用户元数据，key为小写且不带 x-amz-meta- 前缀，列举对象时可能不包含
+	Err          error             // 列举对象过程中的错误
+}
+
+// RemoveObjectError 批量删除对象时单个对象的错误
+type RemoveObjectError struct {
+	ObjectName string
+	Err        error
+}
+
+// StorageBackend 存储后端接口，Storage 只通过该接口读写远端
+// 对象名为以/分隔的完整路径，ETag不带引号，ContentMD5 为true时是内容MD5（S3分片上传的对象除外）
+type StorageBackend interface {
+	// ListBuckets 列出所有 Bucket
+	ListBuckets(ctx context.Context) ([]string, error)
+	// BucketExists 判断配置的 Bucket 是否存在
+	BucketExists(ctx context.Context) (bool, error)
+	// StatObject 获取对象元信息
+	StatObject(ctx context.Context, objectName string) (ObjectInfo, error)
+	// GetObject 获取对象内容，调用方负责关闭
+	GetObject(ctx context.Context, objectName string) (io.ReadCloser, error)
+	// FGetObject 下载对象到本地文件
+	FGetObject(ctx context.Context, objectName, filePath string) error
+	// FPutObject 上传本地文件，返回写入后的对象信息
+	FPutObject(ctx context.Context, objectName, filePath string) (ObjectInfo, error)
+	// PutObject 上传数据流
+	PutObject(ctx context.Context, objectName string, reader io.Reader, objectSize int64) (ObjectInfo, error)
+	// CopyObject 在后端内部复制对象，不经过本地
+	CopyObject(ctx context.Context, srcName, dstName string, size int64) error
+	// RemoveObject 删除单个对象
+	RemoveObject(ctx context.Context, objectName string) error
+	// ListObjects 列出指定前缀下的对象，非递归时子目录以/结尾的前缀返回
+	ListObjects(ctx context.Context, prefix string, recursive bool) <-chan ObjectInfo
+	// RemoveObjects 批量删除对象，返回删除失败的对象
+	RemoveObjects(ctx context.Context, objectsCh <-chan ObjectInfo) <-chan RemoveObjectError
+	// ContentMD5 ETag是否为内容MD5，为false时通过文件大小和修改时间判断一致
+	ContentMD5() bool
+}
+
+// MetadataBackend 支持对象用户元数据的存储后端，用于压缩、加密上传等需要记录原始内容信息的场景
+// 写入的元数据通过 StatObject 返回，服务端复制时随对象一起复制
+type MetadataBackend interface {
+	// PutObjectWithMetadata 上传数据流并写入用户元数据
+	PutObjectWithMetadata(ctx context.Context, objectName string, reader io.Reader, objectSize int64,
+		metadata map[string]string) (ObjectInfo, error)
+	// ReplaceMetadata 替换对象的用户元数据，不重新上传对象内容
+	ReplaceMetadata(ctx context.Context, objectName string, size int64, metadata map[string]string) error
+}
+
+// DirBackend 原生支持目录的存储后端（如WebDAV），空目录直接创建为目录而不上传 .keep 对象
+// 列举时空目录仍以 dir/.keep 返回，真实的 .keep 文件按普通文件处理
+type DirBackend interface {
+	// MakeDir 逐级创建目录，已存在时不报错
+	MakeDir(ctx context.Context, dirName string) error
+}
+
+// UploadPart 分片上传中已上传完成的分片
+type UploadPart struct {
+	Number int
+	ETag   string
+	Size   int64
+}
+
+// MultipartUpload 远端未完成的分片上传
+type MultipartUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
+
+// MultipartBackend 支持分片上传的存储后端，用于大文件断点续传
+type MultipartBackend interface {
+	// NewMultipartUpload 创建分片上传并写入对象的用户元数据，返回上传ID
+	NewMultipartUpload(ctx context.Context, objectName string, metadata map[string]string) (string, error)
+	// PutObjectPart 上传一个分片，返回分片的ETag
+	PutObjectPart(ctx context.Context, objectName, uploadID string, partNumber int, reader io.Reader, size int64) (string, error)
+	// ListObjectParts 列出远端已上传的分片
+	ListObjectParts(ctx context.Context, objectName, uploadID string) ([]UploadPart, error)
+	// CompleteMultipartUpload 合并分片，返回合并后的对象信息
+	CompleteMultipartUpload(ctx context.Context, objectName, uploadID string, parts []UploadPart) (ObjectInfo, error)
+	// AbortMultipartUpload 取消分片上传，删除已上传的分片
+	AbortMultipartUpload(ctx context.Context, objectName, uploadID string) error
+	// ListMultipartUploads 列出指定前缀下未完成的分片上传
+	ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error)
+}
+
 // ObjectStorageClient 对象存储客户端接口
 // 抽象 minio.Client 的核心方法，便于单元测试时使用 Mock 替换
 type ObjectStorageClient interface {
@@ -15,8 +109,18 @@ type ObjectStorageClient interface {
 	BucketExists(ctx context.Context, bucketName string) (bool, error)
 	// StatObject 获取对象元信息
 	StatObject(ctx context.Context, bucketName, objectName string, opts minio.StatObjectOptions) (minio.ObjectInfo, error)
+	// GetObject 获取对象内容
+	GetObject(ctx context.Context, bucketName, objectName string, opts minio.GetObjectOptions) (*minio.Object, error)
+	// FGetObject 下载对象到本地文件
+	FGetObject(ctx context.Context, bucketName, objectName, filePath string, opts minio.GetObjectOptions) error
 	// FPutObject 上传文件到对象存储
 	FPutObject(ctx context.Context, bucketName, objectName, filePath string, opts minio.PutObjectOptions) (minio.UploadInfo, error)
+	// PutObject 上传数据流到对象存储
+	PutObject(ctx context.Context, bucketName, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error)
+	// CopyObject 服务端复制对象
+	CopyObject(ctx context.Context, dst minio.CopyDestOptions, src minio.CopySrcOptions) (minio.UploadInfo, error)
+	// ComposeObject 服务端分片复制对象，用于超过单次复制上限的大对象
+	ComposeObject(ctx context.Context, dst minio.CopyDestOptions, srcs ...minio.CopySrcOptions) (minio.UploadInfo, error)
 	// RemoveObject 删除单个对象
 	RemoveObject(ctx context.Context, bucketName, objectName string, opts minio.RemoveObjectOptions) error
 	// ListObjects 列出对象
@@ -25,5 +129,37 @@ type ObjectStorageClient interface {
 	RemoveObjects(ctx context.Context, bucketName string, objectsCh <-chan minio.ObjectInfo, opts minio.RemoveObjectsOptions) <-chan minio.RemoveObjectError
 }
 
+// MultipartClient 分片上传客户端接口
+// 抽象 minio.Core 的分片上传方法，便于单元测试时使用 Mock 替换
+type MultipartClient interface {
+	// NewMultipartUpload 创建分片上传
+	NewMultipartUpload(ctx context.Context, bucket, object string, opts minio.PutObjectOptions) (string, error)
+	// PutObjectPart 上传一个分片
+	PutObjectPart(ctx context.Context, bucket, object, uploadID string, partID int, data io.Reader, size int64, opts minio.PutObjectPartOptions) (minio.ObjectPart, error)
+	// ListObjectParts 列出已上传的分片
+	ListObjectParts(ctx context.Context, bucket, object, uploadID string, partNumberMarker, maxParts int) (minio.ListObjectPartsResult, error)
+	// CompleteMultipartUpload 合并分片
+	CompleteMultipartUpload(ctx context.Context, bucket, object, uploadID string, parts []minio.CompletePart, opts minio.PutObjectOptions) (minio.UploadInfo, error)
+	// AbortMultipartUpload 取消分片上传
+	AbortMultipartUpload(ctx context.Context, bucket, object, uploadID string) error
+	// ListMultipartUploads 列出未完成的分片上传
+	ListMultipartUploads(ctx context.Context, bucket, prefix, keyMarker, uploadIDMarker, delimiter string, maxUploads int) (minio.ListMultipartUploadsResult, error)
+}
+
 // 确保 minio.Client 实现了 ObjectStorageClient 接口
 var _ ObjectStorageClient = (*minio.Client)(nil)
+
+// 确保 minio.Core 实现了 MultipartClient 接口
+var _ MultipartClient = (*minio.Core)(nil)
+
+// 确保各存储后端实现了 StorageBackend 接口
+var (
+	_ StorageBackend = (*S3Backend)(nil)
+	_ StorageBackend = (*LocalBackend)(nil)
+	_ StorageBackend = (*WebDAVBackend)(nil)
+
+	_ MultipartBackend = (*S3Backend)(nil)
+
+	_ MetadataBackend = (*S3Backend)(nil)
+	_ MetadataBackend = (*LocalBackend)(nil)
+)
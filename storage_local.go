@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jorben/rsync-object-storage/helper"
+)
+
+const (
+	// localMetaDir 本地存储后端在根目录下存放元数据和临时文件的目录，不会出现在对象列表中
+	localMetaDir = ".ros-meta"
+	// localMetaSuffix 对象元数据文件的后缀
+	localMetaSuffix = ".json"
+)
+
+// localMeta 对象的旁路元数据，记录写入时的ETag和用户元数据，文件大小或修改时间变化时重新计算ETag
+type localMeta struct {
+	ETag     string            `json:"etag"`
+	Size     int64             `json:"size"`
+	ModTime  time.Time         `json:"mod_time"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// LocalBackend 本地目录存储后端，把对象写入本地或挂载的目录（如NAS、移动硬盘）
+// 对象名按/分隔映射为根目录下的文件，ETag记录在 .ros-meta/objects/ 下的旁路元数据中
+type LocalBackend struct {
+	Root string
+}
+
+// NewLocalBackend 创建本地目录存储后端
+func NewLocalBackend(root string) *LocalBackend {
+	return &LocalBackend{
+		Root: filepath.Clean(root),
+	}
+}
+
+// ListBuckets 本地目录没有Bucket的概念，返回根目录
+func (b *LocalBackend) ListBuckets(ctx context.Context) ([]string, error) {
+	return []string{b.Root}, nil
+}
+
+// BucketExists 判断根目录是否存在
+func (b *LocalBackend) BucketExists(ctx context.Context) (bool, error) {
+	isDir, err := helper.IsDir(b.Root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return isDir, err
+}
+
+// StatObject 获取对象元信息
+func (b *LocalBackend) StatObject(ctx context.Context, objectName string) (ObjectInfo, error) {
+	path, err := b.objectPath(objectName)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if !fileInfo.Mode().IsRegular() {
+		return ObjectInfo{}, fmt.Errorf("stat %s: %w", objectName, fs.ErrNotExist)
+	}
+	meta, err := b.meta(objectName, path, fileInfo)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: objectName, Size: fileInfo.Size(), ETag: meta.ETag, LastModified: fileInfo.ModTime(),
+		Metadata: meta.Metadata}, nil
+}
+
+// GetObject 获取对象内容
+func (b *LocalBackend) GetObject(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	path, err := b.objectPath(objectName)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// FGetObject 复制对象到本地文件，先写入临时文件再重命名
+func (b *LocalBackend) FGetObject(ctx context.Context, objectName, filePath string) error {
+	reader, err := b.GetObject(ctx, objectName)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return saveToFile(ctx, reader, filePath)
+}
+
+// saveToFile 把数据流写入本地文件，先写入临时文件再重命名，避免中断时留下不完整的文件
+func saveToFile(ctx context.Context, reader io.Reader, filePath string) error {
+	tmp := filePath + downloadPartSuffix
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, &contextReader{ctx: ctx, reader: reader})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filePath)
+}
+
+// FPutObject 写入本地文件
+func (b *LocalBackend) FPutObject(ctx context.Context, objectName, filePath string) (ObjectInfo, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer file.Close()
+	return b.write(ctx, objectName, file, nil)
+}
+
+// PutObject 写入数据流
+func (b *LocalBackend) PutObject(ctx context.Context, objectName string, reader io.Reader, objectSize int64) (ObjectInfo, error) {
+	return b.write(ctx, objectName, reader, nil)
+}
+
+// PutObjectWithMetadata 写入数据流，用户元数据记录在旁路元数据中
+func (b *LocalBackend) PutObjectWithMetadata(ctx context.Context, objectName string, reader io.Reader, objectSize int64,
+	metadata map[string]string) (ObjectInfo, error) {
+	return b.write(ctx, objectName, reader, metadata)
+}
+
+// ReplaceMetadata 替换旁路元数据中的用户元数据
+func (b *LocalBackend) ReplaceMetadata(ctx context.Context, objectName string, size int64, metadata map[string]string) error {
+	path, err := b.objectPath(objectName)
+	if err != nil {
+		return err
+	}
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	meta, err := b.meta(objectName, path, fileInfo)
+	if err != nil {
+		return err
+	}
+	meta.Metadata = metadata
+	return b.writeMeta(objectName, meta)
+}
+
+// CopyObject 复制对象及其用户元数据
+func (b *LocalBackend) CopyObject(ctx context.Context, srcName, dstName string, size int64) error {
+	src, err := b.StatObject(ctx, srcName)
+	if err != nil {
+		return err
+	}
+	reader, err := b.GetObject(ctx, srcName)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, err = b.write(ctx, dstName, reader, src.Metadata)
+	return err
+}
+
+// RemoveObject 删除对象及其元数据，并清理删除后变为空的上级目录，对象不存在时不报错
+func (b *LocalBackend) RemoveObject(ctx context.Context, objectName string) error {
+	path, err := b.objectPath(objectName)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	_ = os.Remove(b.metaPath(objectName))
+	b.removeEmptyParents(filepath.Dir(path), b.Root)
+	b.removeEmptyParents(filepath.Dir(b.metaPath(objectName)), filepath.Join(b.Root, localMetaDir))
+	return nil
+}
+
+// ListObjects 按对象名顺序列出指定前缀下的对象，非递归时子目录以/结尾的前缀返回
+func (b *LocalBackend) ListObjects(ctx context.Context, prefix string, recursive bool) <-chan ObjectInfo {
+	ch := make(chan ObjectInfo)
+	go func() {
+		defer close(ch)
+		send := func(object ObjectInfo) bool {
+			select {
+			case ch <- object:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		// 从前缀中最后一个/之前的目录开始遍历
+		dir := b.Root
+		if i := strings.LastIndex(prefix, "/"); i >= 0 {
+			dir = filepath.Join(b.Root, filepath.FromSlash(prefix[:i]))
+		}
+		seen := make(map[string]struct{})
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(b.Root, path)
+			key := filepath.ToSlash(rel)
+			if d.IsDir() {
+				if key == localMetaDir {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() || !strings.HasPrefix(key, prefix) {
+				return nil
+			}
+			if !recursive {
+				if i := strings.Index(key[len(prefix):], "/"); i >= 0 {
+					commonPrefix := key[:len(prefix)+i+1]
+					if _, ok := seen[commonPrefix]; !ok {
+						seen[commonPrefix] = struct{}{}
+						if !send(ObjectInfo{Key: commonPrefix}) {
+							return ctx.Err()
+						}
+					}
+					return nil
+				}
+			}
+			fileInfo, err := d.Info()
+			if err != nil {
+				return err
+			}
+			meta, err := b.meta(key, path, fileInfo)
+			if err != nil {
+				return err
+			}
+			if !send(ObjectInfo{Key: key, Size: fileInfo.Size(), ETag: meta.ETag, LastModified: fileInfo.ModTime(),
+				Metadata: meta.Metadata}) {
+				return ctx.Err()
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, fs.ErrNotExist) && ctx.Err() == nil {
+			send(ObjectInfo{Err: err})
+		}
+	}()
+	return ch
+}
+
+// RemoveObjects 批量删除对象
+func (b *LocalBackend) RemoveObjects(ctx context.Context, objectsCh <-chan ObjectInfo) <-chan RemoveObjectError {
+	ch := make(chan RemoveObjectError)
+	go func() {
+		defer close(ch)
+		for object := range objectsCh {
+			if err := b.RemoveObject(ctx, object.Key); err != nil {
+				ch <- RemoveObjectError{ObjectName: object.Key, Err: err}
+			}
+		}
+	}()
+	return ch
+}
+
+// ContentMD5 本地目录后端的ETag为写入时计算的内容MD5
+func (b *LocalBackend) ContentMD5() bool {
+	return true
+}
+
+// write 先写入临时文件并计算MD5，再重命名为目标文件并更新元数据
+func (b *LocalBackend) write(ctx context.Context, objectName string, reader io.Reader, metadata map[string]string) (ObjectInfo, error) {
+	path, err := b.objectPath(objectName)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	tmpDir := filepath.Join(b.Root, localMetaDir, "tmp")
+	if err = os.MkdirAll(tmpDir, 0755); err != nil {
+		return ObjectInfo{}, err
+	}
+	tmp, err := os.CreateTemp(tmpDir, "object-*")
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), &contextReader{ctx: ctx, reader: reader})
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return ObjectInfo{}, err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return ObjectInfo{}, err
+	}
+
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	etag := hex.EncodeToString(hash.Sum(nil))
+	meta := localMeta{ETag: etag, Size: fileInfo.Size(), ModTime: fileInfo.ModTime(), Metadata: metadata}
+	if err = b.writeMeta(objectName, meta); err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: objectName, Size: fileInfo.Size(), ETag: etag, LastModified: fileInfo.ModTime(), Metadata: metadata}, nil
+}
+
+// meta 获取对象的元数据，元数据缺失或与文件不一致（在ros之外被修改）时重新计算ETag，不再保留用户元数据
+func (b *LocalBackend) meta(objectName, path string, fileInfo os.FileInfo) (localMeta, error) {
+	var meta localMeta
+	if data, err := os.ReadFile(b.metaPath(objectName)); err == nil && json.Unmarshal(data, &meta) == nil {
+		if meta.Size == fileInfo.Size() && meta.ModTime.Equal(fileInfo.ModTime()) {
+			return meta, nil
+		}
+	}
+	etag, err := helper.FileMd5(path)
+	if err != nil {
+		return localMeta{}, err
+	}
+	meta = localMeta{ETag: etag, Size: fileInfo.Size(), ModTime: fileInfo.ModTime()}
+	_ = b.writeMeta(objectName, meta)
+	return meta, nil
+}
+
+// writeMeta 写入对象的元数据
+func (b *LocalBackend) writeMeta(objectName string, meta localMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	metaPath := b.metaPath(objectName)
+	if err = os.MkdirAll(filepath.Dir(metaPath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(metaPath, data, 0644)
+}
+
+// objectPath 把对象名映射为根目录下的文件路径，拒绝指向根目录之外的对象名
+func (b *LocalBackend) objectPath(objectName string) (string, error) {
+	path := filepath.Join(b.Root, filepath.FromSlash(objectName))
+	if !strings.HasPrefix(path, b.Root+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid object name %s", objectName)
+	}
+	rel, _ := filepath.Rel(b.Root, path)
+	if rel == localMetaDir || strings.HasPrefix(rel, localMetaDir+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid object name %s", objectName)
+	}
+	return path, nil
+}
+
+// metaPath 获取对象元数据文件的路径
+func (b *LocalBackend) metaPath(objectName string) string {
+	return filepath.Join(b.Root, localMetaDir, "objects", filepath.FromSlash(objectName)+localMetaSuffix)
+}
+
+// removeEmptyParents 从dir开始向上删除空目录，直到stop目录（不含）
+func (b *LocalBackend) removeEmptyParents(dir, stop string) {
+	for dir != stop && strings.HasPrefix(dir, stop+string(filepath.Separator)) {
+		if err := os.Remove(dir); err != nil {
+			// 目录非空或不存在
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// contextReader 支持通过context取消的Reader
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+// Read 读取前检查context是否已取消
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/stretchr/testify/assert"
+)
+
+// collectObjects 收集列举结果
+func collectObjects(t *testing.T, ch <-chan ObjectInfo) []ObjectInfo {
+	t.Helper()
+	var objects []ObjectInfo
+	for object := range ch {
+		assert.NoError(t, object.Err)
+		objects = append(objects, object)
+	}
+	return objects
+}
+
+// objectKeys 获取对象名列表
+func objectKeys(objects []ObjectInfo) []string {
+	keys := make([]string, 0, len(objects))
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	return keys
+}
+
+// storageOption 修改测试用存储实例的配置
+type storageOption func(t *testing.T, s *Storage)
+
+// newLocalStorage 创建使用本地目录后端、远端前缀为 backup 的存储实例，按选项启用CAS、分块、压缩或加密
+func newLocalStorage(t *testing.T, localDir, remoteDir string, options ...storageOption) *Storage {
+	t.Helper()
+	s := &Storage{
+		Backend:      NewLocalBackend(remoteDir),
+		LocalPrefix:  localDir,
+		RemotePrefix: "backup",
+		SymLink:      enum.SymlinkAddr,
+		TempDir:      t.TempDir(),
+	}
+	for _, option := range options {
+		option(t, s)
+	}
+	return s
+}
+
+// withCAS 启用CAS模式
+func withCAS() storageOption {
+	return func(t *testing.T, s *Storage) {
+		s.CAS = NewCAS()
+	}
+}
+
+// withChunk 对64KiB以上的文件按4KiB平均大小分块
+func withChunk() storageOption {
+	return func(t *testing.T, s *Storage) {
+		s.ParallelParts = 2
+		s.ChunkFileSize = 64 * 1024
+		s.ChunkSize = 4096
+	}
+}
+
+// withCompress 压缩 *.log 和 *.csv 文件，skip.log 除外
+func withCompress() storageOption {
+	return func(t *testing.T, s *Storage) {
+		s.Compress = NewCompression(enum.CompressZstd, []string{"*.log", "*.csv"}, []string{"skip.log"})
+	}
+}
+
+// withEncrypt 以指定主密钥加密，oldKeyFiles 为轮换前的主密钥
+func withEncrypt(keyFile string, oldKeyFiles ...string) storageOption {
+	return func(t *testing.T, s *Storage) {
+		t.Helper()
+		encrypt, err := NewEncryption(keyFile, "", oldKeyFiles)
+		assert.NoError(t, err)
+		s.Encrypt = encrypt
+	}
+}
+
+// TestLocalBackend_PutAndStat 测试写入对象并通过旁路元数据获取ETag
+func TestLocalBackend_PutAndStat(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	b := NewLocalBackend(root)
+
+	exist, err := b.BucketExists(ctx)
+	assert.NoError(t, err)
+	assert.True(t, exist)
+
+	info, err := b.PutObject(ctx, "remote/hello.txt", strings.NewReader("hello world"), 11)
+	assert.NoError(t, err)
+	assert.Equal(t, "5eb63bbbe01eeed093cb22bb8f5acdc3", info.ETag)
+	content, err := os.ReadFile(filepath.Join(root, "remote", "hello.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello world", string(content))
+	assert.FileExists(t, filepath.Join(root, localMetaDir, "objects", "remote", "hello.txt"+localMetaSuffix))
+
+	stat, err := b.StatObject(ctx, "remote/hello.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), stat.Size)
+	assert.Equal(t, info.ETag, stat.ETag)
+
+	_, err = b.StatObject(ctx, "remote/missing.txt")
+	assert.Error(t, err)
+	_, err = b.StatObject(ctx, "remote")
+	assert.Error(t, err)
+}
+
+// TestLocalBackend_StaleMeta 测试在ros之外修改的文件重新计算ETag
+func TestLocalBackend_StaleMeta(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	b := NewLocalBackend(root)
+
+	_, err := b.PutObject(ctx, "a.txt", strings.NewReader("hello world"), 11)
+	assert.NoError(t, err)
+	path := filepath.Join(root, "a.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("changed"), 0644))
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(path, later, later))
+
+	stat, err := b.StatObject(ctx, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, helper.StringMd5("changed"), stat.ETag)
+}
+
+// TestLocalBackend_ListObjects 测试按前缀列举对象，不包含元数据目录
+func TestLocalBackend_ListObjects(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	b := NewLocalBackend(root)
+	for _, key := range []string{"remote/a.txt", "remote/sub/b.txt", "remote/sub/deep/c.txt", "remoteX/d.txt"} {
+		_, err := b.PutObject(ctx, key, strings.NewReader(key), int64(len(key)))
+		assert.NoError(t, err)
+	}
+
+	objects := collectObjects(t, b.ListObjects(ctx, "remote/", true))
+	assert.ElementsMatch(t, []string{"remote/a.txt", "remote/sub/b.txt", "remote/sub/deep/c.txt"}, objectKeys(objects))
+	for _, object := range objects {
+		assert.Equal(t, helper.StringMd5(object.Key), object.ETag)
+	}
+
+	objects = collectObjects(t, b.ListObjects(ctx, "remote/", false))
+	assert.ElementsMatch(t, []string{"remote/a.txt", "remote/sub/"}, objectKeys(objects))
+
+	objects = collectObjects(t, b.ListObjects(ctx, "", true))
+	assert.Len(t, objects, 4)
+
+	objects = collectObjects(t, b.ListObjects(ctx, "missing/", true))
+	assert.Empty(t, objects)
+}
+
+// TestLocalBackend_CopyAndRemove 测试复制对象，以及删除后清理空目录
+func TestLocalBackend_CopyAndRemove(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	b := NewLocalBackend(root)
+	_, err := b.PutObject(ctx, "remote/sub/a.txt", strings.NewReader("a"), 1)
+	assert.NoError(t, err)
+
+	assert.NoError(t, b.CopyObject(ctx, "remote/sub/a.txt", "remote/.trash/2026-10-01/sub/a.txt", 1))
+	stat, err := b.StatObject(ctx, "remote/.trash/2026-10-01/sub/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, helper.StringMd5("a"), stat.ETag)
+
+	ch := make(chan ObjectInfo, 2)
+	ch <- ObjectInfo{Key: "remote/sub/a.txt"}
+	ch <- ObjectInfo{Key: "remote/sub/missing.txt"}
+	close(ch)
+	for err := range b.RemoveObjects(ctx, ch) {
+		assert.NoError(t, err.Err)
+	}
+	assert.NoDirExists(t, filepath.Join(root, "remote", "sub"))
+	assert.NoFileExists(t, filepath.Join(root, localMetaDir, "objects", "remote", "sub", "a.txt"+localMetaSuffix))
+	assert.DirExists(t, root)
+}
+
+// TestLocalBackend_InvalidName 测试拒绝指向根目录之外或元数据目录的对象名
+func TestLocalBackend_InvalidName(t *testing.T) {
+	ctx := context.Background()
+	b := NewLocalBackend(t.TempDir())
+
+	_, err := b.PutObject(ctx, "../escape.txt", strings.NewReader("x"), 1)
+	assert.Error(t, err)
+	_, err = b.PutObject(ctx, localMetaDir+"/objects/a.txt", strings.NewReader("x"), 1)
+	assert.Error(t, err)
+}
+
+// TestStorage_LocalBackend 端到端测试：上传、比较、删除到回收站并恢复
+func TestStorage_LocalBackend(t *testing.T) {
+	ctx := context.Background()
+	localDir := t.TempDir()
+	// 上传空目录和符号链接时会在工作目录下创建临时文件
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	assert.NoError(t, os.MkdirAll(filepath.Join(localDir, "docs", "empty"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "docs", "a.txt"), []byte("a"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "b.bin"), bytes.Repeat([]byte("b"), 4096), 0644))
+
+	s := &Storage{
+		Backend:      NewLocalBackend(t.TempDir()),
+		LocalPrefix:  localDir,
+		RemotePrefix: "backup",
+		SymLink:      enum.SymlinkSkip,
+		Trash:        true,
+	}
+	for _, path := range []string{
+		filepath.Join(localDir, "docs", "a.txt"),
+		filepath.Join(localDir, "docs", "empty"),
+		filepath.Join(localDir, "b.bin"),
+	} {
+		assert.NoError(t, s.FPutObject(ctx, path))
+		assert.True(t, s.IsSameV2(ctx, path, ""))
+		assert.ErrorIs(t, s.FPutObject(ctx, path), enum.ErrSkipTransfer)
+	}
+	assert.ElementsMatch(t, []string{"backup/b.bin", "backup/docs/a.txt", "backup/docs/empty/.keep"},
+		objectKeys(collectObjects(t, s.ListObjects(ctx))))
+
+	// 本地删除目录后远端对象移入回收站
+	assert.NoError(t, os.RemoveAll(filepath.Join(localDir, "docs")))
+	assert.NoError(t, s.RemoveObjects(ctx, filepath.Join(localDir, "docs")))
+	trashRoot := "backup/.trash/" + time.Now().UTC().Format(trashDateFormat)
+	assert.ElementsMatch(t, []string{"backup/b.bin", trashRoot + "/docs/a.txt", trashRoot + "/docs/empty/.keep"},
+		objectKeys(collectObjects(t, s.ListObjects(ctx))))
+
+	trash := &Trash{
+		Enable:    true,
+		Retention: 30,
+		Storage:   s,
+		Restore: &Restore{
+			LocalPrefix:   localDir,
+			SymLink:       enum.SymlinkSkip,
+			IgnoreMatcher: helper.NewIgnoreMatcher(nil),
+			Storage:       s,
+		},
+	}
+	items, err := trash.List(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, items, 2)
+
+	restored, err := trash.RestorePath(ctx, filepath.Join(localDir, "docs"), "")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, restored)
+	content, err := os.ReadFile(filepath.Join(localDir, "docs", "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a", string(content))
+	assert.DirExists(t, filepath.Join(localDir, "docs", "empty"))
+	assert.ElementsMatch(t, []string{"backup/b.bin", "backup/docs/a.txt", "backup/docs/empty/.keep"},
+		objectKeys(collectObjects(t, s.ListObjects(ctx))))
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// S3Backend S3兼容对象存储后端，把 StorageBackend 的调用转换为 minio 客户端调用
+type S3Backend struct {
+	Client ObjectStorageClient
+	Core   MultipartClient // 分片上传客户端，用于大文件断点续传
+	Bucket string
+}
+
+// NewS3Backend 创建S3兼容对象存储后端
+func NewS3Backend(client ObjectStorageClient, bucket string) *S3Backend {
+	return &S3Backend{
+		Client: client,
+		Bucket: bucket,
+	}
+}
+
+// ListBuckets 列出所有 Bucket
+func (b *S3Backend) ListBuckets(ctx context.Context) ([]string, error) {
+	var bucketList []string
+	bucketInfoList, err := b.Client.ListBuckets(ctx)
+	if err != nil {
+		return bucketList, err
+	}
+	for _, bucket := range bucketInfoList {
+		bucketList = append(bucketList, bucket.Name)
+	}
+	return bucketList, nil
+}
+
+// BucketExists 判断 Bucket 是否存在
+func (b *S3Backend) BucketExists(ctx context.Context) (bool, error) {
+	return b.Client.BucketExists(ctx, b.Bucket)
+}
+
+// StatObject 获取对象元信息
+func (b *S3Backend) StatObject(ctx context.Context, objectName string) (ObjectInfo, error) {
+	object, err := b.Client.StatObject(ctx, b.Bucket, objectName, minio.StatObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return toObjectInfo(object), nil
+}
+
+// GetObject 获取对象内容
+func (b *S3Backend) GetObject(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	object, err := b.Client.GetObject(ctx, b.Bucket, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// FGetObject 下载对象到本地文件
+func (b *S3Backend) FGetObject(ctx context.Context, objectName, filePath string) error {
+	return b.Client.FGetObject(ctx, b.Bucket, objectName, filePath, minio.GetObjectOptions{})
+}
+
+// FPutObject 上传本地文件
+func (b *S3Backend) FPutObject(ctx context.Context, objectName, filePath string) (ObjectInfo, error) {
+	uploadInfo, err := b.Client.FPutObject(ctx, b.Bucket, objectName, filePath, minio.PutObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: objectName, Size: uploadInfo.Size, ETag: uploadInfo.ETag, LastModified: uploadInfo.LastModified}, nil
+}
+
+// PutObject 上传数据流
+func (b *S3Backend) PutObject(ctx context.Context, objectName string, reader io.Reader, objectSize int64) (ObjectInfo, error) {
+	return b.PutObjectWithMetadata(ctx, objectName, reader, objectSize, nil)
+}
+
+// PutObjectWithMetadata 上传数据流并以 x-amz-meta- 请求头写入用户元数据
+func (b *S3Backend) PutObjectWithMetadata(ctx context.Context, objectName string, reader io.Reader, objectSize int64,
+	metadata map[string]string) (ObjectInfo, error) {
+	uploadInfo, err := b.Client.PutObject(ctx, b.Bucket, objectName, reader, objectSize, minio.PutObjectOptions{UserMetadata: metadata})
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: objectName, Size: uploadInfo.Size, ETag: uploadInfo.ETag, LastModified: uploadInfo.LastModified}, nil
+}
+
+// ReplaceMetadata 服务端复制到自身并替换用户元数据，超过单次复制上限的大对象使用分片复制
+func (b *S3Backend) ReplaceMetadata(ctx context.Context, objectName string, size int64, metadata map[string]string) error {
+	dst := minio.CopyDestOptions{Bucket: b.Bucket, Object: objectName, ReplaceMetadata: true, UserMetadata: metadata}
+	src := minio.CopySrcOptions{Bucket: b.Bucket, Object: objectName}
+	var err error
+	if size > maxCopySize {
+		_, err = b.Client.ComposeObject(ctx, dst, src)
+	} else {
+		_, err = b.Client.CopyObject(ctx, dst, src)
+	}
+	return err
+}
+
+// CopyObject 在同一个Bucket内服务端复制对象，超过单次复制上限的大对象使用分片复制
+func (b *S3Backend) CopyObject(ctx context.Context, srcName, dstName string, size int64) error {
+	dst := minio.CopyDestOptions{Bucket: b.Bucket, Object: dstName}
+	src := minio.CopySrcOptions{Bucket: b.Bucket, Object: srcName}
+	var err error
+	if size > maxCopySize {
+		_, err = b.Client.ComposeObject(ctx, dst, src)
+	} else {
+		_, err = b.Client.CopyObject(ctx, dst, src)
+	}
+	return err
+}
+
+// RemoveObject 删除单个对象
+func (b *S3Backend) RemoveObject(ctx context.Context, objectName string) error {
+	return b.Client.RemoveObject(ctx, b.Bucket, objectName, minio.RemoveObjectOptions{})
+}
+
+// ListObjects 列出指定前缀下的对象
+func (b *S3Backend) ListObjects(ctx context.Context, prefix string, recursive bool) <-chan ObjectInfo {
+	ch := make(chan ObjectInfo)
+	go func() {
+		defer close(ch)
+		for object := range b.Client.ListObjects(ctx, b.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: recursive}) {
+			select {
+			case ch <- toObjectInfo(object):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// RemoveObjects 批量删除对象
+func (b *S3Backend) RemoveObjects(ctx context.Context, objectsCh <-chan ObjectInfo) <-chan RemoveObjectError {
+	in := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(in)
+		for object := range objectsCh {
+			in <- minio.ObjectInfo{Key: object.Key, Size: object.Size}
+		}
+	}()
+	ch := make(chan RemoveObjectError)
+	go func() {
+		defer close(ch)
+		opts := minio.RemoveObjectsOptions{GovernanceBypass: true}
+		for err := range b.Client.RemoveObjects(ctx, b.Bucket, in, opts) {
+			ch <- RemoveObjectError{ObjectName: err.ObjectName, Err: err.Err}
+		}
+		// 客户端提前返回时继续消费输入，避免写入方阻塞
+		for range in {
+		}
+	}()
+	return ch
+}
+
+// ContentMD5 S3的ETag为内容MD5，分片上传的对象带有分片数量标识
+func (b *S3Backend) ContentMD5() bool {
+	return true
+}
+
+// NewMultipartUpload 创建分片上传，用户元数据在合并后写入对象
+func (b *S3Backend) NewMultipartUpload(ctx context.Context, objectName string, metadata map[string]string) (string, error) {
+	return b.Core.NewMultipartUpload(ctx, b.Bucket, objectName, minio.PutObjectOptions{UserMetadata: metadata})
+}
+
+// PutObjectPart 上传一个分片
+func (b *S3Backend) PutObjectPart(ctx context.Context, objectName, uploadID string, partNumber int, reader io.Reader, size int64) (string, error) {
+	part, err := b.Core.PutObjectPart(ctx, b.Bucket, objectName, uploadID, partNumber, reader, size, minio.PutObjectPartOptions{})
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(part.ETag, "\""), nil
+}
+
+// ListObjectParts 列出远端已上传的分片，自动翻页
+func (b *S3Backend) ListObjectParts(ctx context.Context, objectName, uploadID string) ([]UploadPart, error) {
+	var parts []UploadPart
+	marker := 0
+	for {
+		result, err := b.Core.ListObjectParts(ctx, b.Bucket, objectName, uploadID, marker, 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range result.ObjectParts {
+			parts = append(parts, UploadPart{Number: part.PartNumber, ETag: strings.Trim(part.ETag, "\""), Size: part.Size})
+		}
+		if !result.IsTruncated {
+			return parts, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
+
+// CompleteMultipartUpload 按分片序号合并分片
+func (b *S3Backend) CompleteMultipartUpload(ctx context.Context, objectName, uploadID string, parts []UploadPart) (ObjectInfo, error) {
+	completeParts := make([]minio.CompletePart, 0, len(parts))
+	var size int64
+	for _, part := range parts {
+		completeParts = append(completeParts, minio.CompletePart{PartNumber: part.Number, ETag: part.ETag})
+		size += part.Size
+	}
+	sort.Slice(completeParts, func(i, j int) bool {
+		return completeParts[i].PartNumber < completeParts[j].PartNumber
+	})
+	uploadInfo, err := b.Core.CompleteMultipartUpload(ctx, b.Bucket, objectName, uploadID, completeParts, minio.PutObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: objectName, Size: size, ETag: uploadInfo.ETag, LastModified: uploadInfo.LastModified}, nil
+}
+
+// AbortMultipartUpload 取消分片上传
+func (b *S3Backend) AbortMultipartUpload(ctx context.Context, objectName, uploadID string) error {
+	return b.Core.AbortMultipartUpload(ctx, b.Bucket, objectName, uploadID)
+}
+
+// ListMultipartUploads 列出指定前缀下未完成的分片上传，自动翻页
+func (b *S3Backend) ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error) {
+	var uploads []MultipartUpload
+	keyMarker, uploadIDMarker := "", ""
+	for {
+		result, err := b.Core.ListMultipartUploads(ctx, b.Bucket, prefix, keyMarker, uploadIDMarker, "", 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, upload := range result.Uploads {
+			uploads = append(uploads, MultipartUpload{Key: upload.Key, UploadID: upload.UploadID, Initiated: upload.Initiated})
+		}
+		if !result.IsTruncated {
+			return uploads, nil
+		}
+		keyMarker, uploadIDMarker = result.NextKeyMarker, result.NextUploadIDMarker
+	}
+}
+
+// toObjectInfo 转换 minio 的对象信息，用户元数据的key统一为小写
+func toObjectInfo(object minio.ObjectInfo) ObjectInfo {
+	var metadata map[string]string
+	if len(object.UserMetadata) > 0 {
+		metadata = make(map[string]string, len(object.UserMetadata))
+		for key, value := range object.UserMetadata {
+			metadata[strings.ToLower(key)] = value
+		}
+	}
+	return ObjectInfo{
+		Key:          object.Key,
+		Size:         object.Size,
+		ETag:         object.ETag,
+		LastModified: object.LastModified,
+		Metadata:     metadata,
+		Err:          object.Err,
+	}
+}
@@ -1,16 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
 	"github.com/jorben/rsync-object-storage/log"
 	"github.com/jorben/rsync-object-storage/mocks"
+	"github.com/jorben/rsync-object-storage/state"
 	"github.com/minio/minio-go/v7"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -84,7 +88,7 @@ func TestListBucket(t *testing.T) {
 		}
 		mockClient.On("ListBuckets", ctx).Return(buckets, nil)
 
-		s := &Storage{Client: mockClient}
+		s := &Storage{Backend: NewS3Backend(mockClient, "")}
 		result, err := s.ListBucket(ctx)
 
 		assert.NoError(t, err)
@@ -96,7 +100,7 @@ func TestListBucket(t *testing.T) {
 		mockClient := new(mocks.MockObjectStorageClient)
 		mockClient.On("ListBuckets", ctx).Return(nil, errors.New("network error"))
 
-		s := &Storage{Client: mockClient}
+		s := &Storage{Backend: NewS3Backend(mockClient, "")}
 		result, err := s.ListBucket(ctx)
 
 		assert.Error(t, err)
@@ -113,7 +117,7 @@ func TestBucketExists(t *testing.T) {
 		mockClient := new(mocks.MockObjectStorageClient)
 		mockClient.On("BucketExists", ctx, "test-bucket").Return(true, nil)
 
-		s := &Storage{Client: mockClient, Bucket: "test-bucket"}
+		s := &Storage{Backend: NewS3Backend(mockClient, "test-bucket")}
 		err := s.BucketExists(ctx)
 
 		assert.NoError(t, err)
@@ -124,7 +128,7 @@ func TestBucketExists(t *testing.T) {
 		mockClient := new(mocks.MockObjectStorageClient)
 		mockClient.On("BucketExists", ctx, "non-existent").Return(false, nil)
 
-		s := &Storage{Client: mockClient, Bucket: "non-existent"}
+		s := &Storage{Backend: NewS3Backend(mockClient, "non-existent")}
 		err := s.BucketExists(ctx)
 
 		assert.Error(t, err)
@@ -136,7 +140,7 @@ func TestBucketExists(t *testing.T) {
 		mockClient := new(mocks.MockObjectStorageClient)
 		mockClient.On("BucketExists", ctx, "test-bucket").Return(false, errors.New("access denied"))
 
-		s := &Storage{Client: mockClient, Bucket: "test-bucket"}
+		s := &Storage{Backend: NewS3Backend(mockClient, "test-bucket")}
 		err := s.BucketExists(ctx)
 
 		assert.Error(t, err)
@@ -157,8 +161,7 @@ func TestRemoveObject(t *testing.T) {
 			Return(nil)
 
 		s := &Storage{
-			Client:       mockClient,
-			Bucket:       "test-bucket",
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
 			LocalPrefix:  "/data/local",
 			RemotePrefix: "remote",
 		}
@@ -174,8 +177,7 @@ func TestRemoveObject(t *testing.T) {
 			Return(minio.ObjectInfo{}, errors.New("key does not exist"))
 
 		s := &Storage{
-			Client:       mockClient,
-			Bucket:       "test-bucket",
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
 			LocalPrefix:  "/data/local",
 			RemotePrefix: "remote",
 		}
@@ -186,6 +188,87 @@ func TestRemoveObject(t *testing.T) {
 	})
 }
 
+// TestRemoveObjects 测试批量删除对象
+func TestRemoveObjects(t *testing.T) {
+	ctx := context.Background()
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket",
+		minio.ListObjectsOptions{Prefix: "remote/abc", Recursive: true}).
+		Return(objectsChan(
+			minio.ObjectInfo{Key: "remote/abc"},
+			minio.ObjectInfo{Key: "remote/abc.link"},
+			minio.ObjectInfo{Key: "remote/abc/file.txt"},
+			minio.ObjectInfo{Key: "remote/abcd.txt"},
+		))
+
+	var removed []string
+	mockClient.On("RemoveObjects", mock.Anything, "test-bucket", mock.Anything, minio.RemoveObjectsOptions{GovernanceBypass: true}).
+		Run(func(args mock.Arguments) {
+			for object := range args.Get(2).(<-chan minio.ObjectInfo) {
+				removed = append(removed, object.Key)
+			}
+		}).Return(nil)
+
+	s := &Storage{
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
+		LocalPrefix:  "/data/local",
+		RemotePrefix: "remote",
+	}
+	err := s.RemoveObjects(ctx, "/data/local/abc")
+
+	assert.NoError(t, err)
+	// 前缀相同的非子文件不应被删除
+	assert.ElementsMatch(t, []string{"remote/abc", "remote/abc.link", "remote/abc/file.txt"}, removed)
+	mockClient.AssertExpectations(t)
+}
+
+// TestRemoveObjects_Trash 测试启用回收站时先移动到回收站再删除，回收站中的对象不随本地删除
+func TestRemoveObjects_Trash(t *testing.T) {
+	ctx := context.Background()
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket",
+		minio.ListObjectsOptions{Prefix: "remote", Recursive: true}).
+		Return(objectsChan(
+			minio.ObjectInfo{Key: "remote/a.txt", Size: 1},
+			minio.ObjectInfo{Key: "remote/b.txt", Size: 2},
+			minio.ObjectInfo{Key: "remote/.trash/2026-10-01/c.txt", Size: 3},
+		))
+	trashName := func(name string) interface{} {
+		return mock.MatchedBy(func(dst minio.CopyDestOptions) bool {
+			date, key, _ := strings.Cut(strings.TrimPrefix(dst.Object, "remote/.trash/"), "/")
+			_, err := time.Parse(trashDateFormat, date)
+			return err == nil && key == name
+		})
+	}
+	mockClient.On("CopyObject", mock.Anything, trashName("a.txt"),
+		minio.CopySrcOptions{Bucket: "test-bucket", Object: "remote/a.txt"}).Return(minio.UploadInfo{}, nil)
+	mockClient.On("CopyObject", mock.Anything, trashName("b.txt"),
+		minio.CopySrcOptions{Bucket: "test-bucket", Object: "remote/b.txt"}).Return(minio.UploadInfo{}, errors.New("copy failed"))
+
+	var removed []string
+	mockClient.On("RemoveObjects", mock.Anything, "test-bucket", mock.Anything, minio.RemoveObjectsOptions{GovernanceBypass: true}).
+		Run(func(args mock.Arguments) {
+			for object := range args.Get(2).(<-chan minio.ObjectInfo) {
+				removed = append(removed, object.Key)
+			}
+		}).Return(nil)
+
+	s := &Storage{
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
+		LocalPrefix:  "/data/local",
+		RemotePrefix: "remote",
+		Trash:        true,
+	}
+	err := s.RemoveObjects(ctx, "/data/local")
+
+	// 移动到回收站失败的对象不删除
+	assert.Error(t, err)
+	assert.Equal(t, []string{"remote/a.txt"}, removed)
+	mockClient.AssertExpectations(t)
+}
+
 // TestIsSameV2_RegularFile 测试普通文件的一致性比较
 func TestIsSameV2_RegularFile(t *testing.T) {
 	ctx := context.Background()
@@ -206,8 +289,7 @@ func TestIsSameV2_RegularFile(t *testing.T) {
 			}, nil)
 
 		s := &Storage{
-			Client:       mockClient,
-			Bucket:       "test-bucket",
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
 			LocalPrefix:  tmpDir,
 			RemotePrefix: "remote",
 			SymLink:      enum.SymlinkSkip,
@@ -227,8 +309,7 @@ func TestIsSameV2_RegularFile(t *testing.T) {
 			}, nil)
 
 		s := &Storage{
-			Client:       mockClient,
-			Bucket:       "test-bucket",
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
 			LocalPrefix:  tmpDir,
 			RemotePrefix: "remote",
 			SymLink:      enum.SymlinkSkip,
@@ -245,8 +326,7 @@ func TestIsSameV2_RegularFile(t *testing.T) {
 			Return(minio.ObjectInfo{}, errors.New("key does not exist"))
 
 		s := &Storage{
-			Client:       mockClient,
-			Bucket:       "test-bucket",
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
 			LocalPrefix:  tmpDir,
 			RemotePrefix: "remote",
 			SymLink:      enum.SymlinkSkip,
@@ -278,8 +358,7 @@ func TestIsSameV2_EmptyDirectory(t *testing.T) {
 			}, nil)
 
 		s := &Storage{
-			Client:       mockClient,
-			Bucket:       "test-bucket",
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
 			LocalPrefix:  tmpDir,
 			RemotePrefix: "remote",
 			SymLink:      enum.SymlinkSkip,
@@ -307,8 +386,7 @@ func TestIsSameV2_NonEmptyDirectory(t *testing.T) {
 		mockClient := new(mocks.MockObjectStorageClient)
 
 		s := &Storage{
-			Client:       mockClient,
-			Bucket:       "test-bucket",
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
 			LocalPrefix:  tmpDir,
 			RemotePrefix: "remote",
 			SymLink:      enum.SymlinkSkip,
@@ -339,8 +417,7 @@ func TestIsSameV2_Symlink(t *testing.T) {
 		mockClient := new(mocks.MockObjectStorageClient)
 
 		s := &Storage{
-			Client:       mockClient,
-			Bucket:       "test-bucket",
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
 			LocalPrefix:  tmpDir,
 			RemotePrefix: "remote",
 			SymLink:      enum.SymlinkSkip,
@@ -375,8 +452,7 @@ func TestIsSameV2_MultipartUpload(t *testing.T) {
 			}, nil)
 
 		s := &Storage{
-			Client:       mockClient,
-			Bucket:       "test-bucket",
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
 			LocalPrefix:  tmpDir,
 			RemotePrefix: "remote",
 			SymLink:      enum.SymlinkSkip,
@@ -398,8 +474,7 @@ func TestIsSameV2_MultipartUpload(t *testing.T) {
 			}, nil)
 
 		s := &Storage{
-			Client:       mockClient,
-			Bucket:       "test-bucket",
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
 			LocalPrefix:  tmpDir,
 			RemotePrefix: "remote",
 			SymLink:      enum.SymlinkSkip,
@@ -411,6 +486,96 @@ func TestIsSameV2_MultipartUpload(t *testing.T) {
 	})
 }
 
+// TestDiff 测试内容不一致的原因
+func TestDiff(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "file.txt")
+	err := os.WriteFile(testFile, []byte("hello world"), 0644)
+	assert.NoError(t, err)
+	fileInfo, _ := os.Stat(testFile)
+
+	tests := []struct {
+		name     string
+		info     minio.ObjectInfo
+		err      error
+		expected string
+	}{
+		{"远端不存在", minio.ObjectInfo{}, errors.New("key not found"), enum.DiffNew},
+		{"ETag 不一致", minio.ObjectInfo{ETag: "different"}, nil, enum.DiffETag},
+		{"分片对象大小不一致", minio.ObjectInfo{ETag: "abc123-5", Size: fileInfo.Size() + 1, LastModified: time.Now()}, nil, enum.DiffMultipart},
+		{"内容一致", minio.ObjectInfo{ETag: "5eb63bbbe01eeed093cb22bb8f5acdc3"}, nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := new(mocks.MockObjectStorageClient)
+			mockClient.On("StatObject", ctx, "test-bucket", "remote/file.txt", minio.StatObjectOptions{}).
+				Return(tt.info, tt.err)
+
+			s := &Storage{
+				Backend:      NewS3Backend(mockClient, "test-bucket"),
+				LocalPrefix:  tmpDir,
+				RemotePrefix: "remote",
+				SymLink:      enum.SymlinkSkip,
+			}
+
+			assert.Equal(t, tt.expected, s.diff(ctx, testFile, ""))
+		})
+	}
+}
+
+// TestIsSameV2_MultipartChecksum 测试 checksum 模式下分片对象重新计算 ETag
+func TestIsSameV2_MultipartChecksum(t *testing.T) {
+	ctx := context.Background()
+
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "bigfile.bin")
+	// 小于10000字节时无法计算分片大小
+	err := os.WriteFile(testFile, bytes.Repeat([]byte("large file content"), 1000), 0644)
+	assert.NoError(t, err)
+	fileInfo, _ := os.Stat(testFile)
+	_, partSize, _, _ := minio.OptimalPartInfo(fileInfo.Size(), 0)
+	localETag, _ := helper.FileMultipartMd5(testFile, partSize)
+
+	t.Run("ETag 一致", func(t *testing.T) {
+		mockClient := new(mocks.MockObjectStorageClient)
+		mockClient.On("StatObject", ctx, "test-bucket", "remote/bigfile.bin", minio.StatObjectOptions{}).
+			Return(minio.ObjectInfo{Key: "remote/bigfile.bin", ETag: localETag, Size: fileInfo.Size()}, nil)
+
+		s := &Storage{
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
+			LocalPrefix:  tmpDir,
+			RemotePrefix: "remote",
+			SymLink:      enum.SymlinkSkip,
+			Checksum:     true,
+		}
+
+		assert.True(t, s.IsSameV2(ctx, testFile, ""))
+	})
+
+	t.Run("大小和时间一致但内容不同", func(t *testing.T) {
+		mockClient := new(mocks.MockObjectStorageClient)
+		mockClient.On("StatObject", ctx, "test-bucket", "remote/bigfile.bin", minio.StatObjectOptions{}).
+			Return(minio.ObjectInfo{
+				Key:          "remote/bigfile.bin",
+				ETag:         "abc123-1",
+				Size:         fileInfo.Size(),
+				LastModified: time.Now().Add(time.Hour),
+			}, nil)
+
+		s := &Storage{
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
+			LocalPrefix:  tmpDir,
+			RemotePrefix: "remote",
+			SymLink:      enum.SymlinkSkip,
+			Checksum:     true,
+		}
+
+		assert.False(t, s.IsSameV2(ctx, testFile, ""))
+	})
+}
+
 // TestFPutObject 测试文件上传
 func TestFPutObject(t *testing.T) {
 	ctx := context.Background()
@@ -431,8 +596,7 @@ func TestFPutObject(t *testing.T) {
 			}, nil)
 
 		s := &Storage{
-			Client:       mockClient,
-			Bucket:       "test-bucket",
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
 			LocalPrefix:  tmpDir,
 			RemotePrefix: "remote",
 			SymLink:      enum.SymlinkSkip,
@@ -450,13 +614,12 @@ func TestFPutObject(t *testing.T) {
 		mockClient.On("StatObject", ctx, "test-bucket", "remote/upload.txt", minio.StatObjectOptions{}).
 			Return(minio.ObjectInfo{}, errors.New("key not found"))
 
-		// Mock FPutObject
-		mockClient.On("FPutObject", ctx, "test-bucket", "remote/upload.txt", mock.Anything, minio.PutObjectOptions{}).
+		// Mock PutObject
+		mockClient.On("PutObject", ctx, "test-bucket", "remote/upload.txt", mock.Anything, mock.Anything, minio.PutObjectOptions{}).
 			Return(minio.UploadInfo{}, nil)
 
 		s := &Storage{
-			Client:       mockClient,
-			Bucket:       "test-bucket",
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
 			LocalPrefix:  tmpDir,
 			RemotePrefix: "remote",
 			SymLink:      enum.SymlinkSkip,
@@ -468,3 +631,253 @@ func TestFPutObject(t *testing.T) {
 		mockClient.AssertExpectations(t)
 	})
 }
+
+// TestFPutObject_Stream 测试流式上传过程中文件发生变化时重新上传
+func TestFPutObject_Stream(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "upload.txt")
+
+	t.Run("上传过程中被替换后重试", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(testFile, []byte("old"), 0644))
+		mockClient := new(mocks.MockObjectStorageClient)
+		mockClient.On("StatObject", ctx, "test-bucket", "remote/upload.txt", minio.StatObjectOptions{}).
+			Return(minio.ObjectInfo{}, errors.New("key not found"))
+		var uploaded []string
+		mockClient.On("PutObject", ctx, "test-bucket", "remote/upload.txt", mock.Anything, mock.Anything, minio.PutObjectOptions{}).
+			Run(func(args mock.Arguments) {
+				data := make([]byte, args.Get(4).(int64))
+				_, _ = args.Get(3).(*os.File).Read(data)
+				uploaded = append(uploaded, string(data))
+				if len(uploaded) == 1 {
+					// 编辑器先写临时文件再重命名覆盖
+					tmp := testFile + ".tmp"
+					_ = os.WriteFile(tmp, []byte("new content"), 0644)
+					_ = os.Rename(tmp, testFile)
+				}
+			}).Return(minio.UploadInfo{}, nil)
+
+		s := &Storage{
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
+			LocalPrefix:  tmpDir,
+			RemotePrefix: "remote",
+			SymLink:      enum.SymlinkSkip,
+			Upload:       enum.UploadStream,
+		}
+
+		assert.NoError(t, s.FPutObject(ctx, testFile))
+		assert.Equal(t, []string{"old", "new content"}, uploaded)
+	})
+
+	t.Run("持续变化时放弃", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(testFile, []byte("a"), 0644))
+		mockClient := new(mocks.MockObjectStorageClient)
+		mockClient.On("StatObject", ctx, "test-bucket", "remote/upload.txt", minio.StatObjectOptions{}).
+			Return(minio.ObjectInfo{}, errors.New("key not found"))
+		mockClient.On("PutObject", ctx, "test-bucket", "remote/upload.txt", mock.Anything, mock.Anything, minio.PutObjectOptions{}).
+			Run(func(args mock.Arguments) {
+				f, _ := os.OpenFile(testFile, os.O_APPEND|os.O_WRONLY, 0644)
+				_, _ = f.WriteString("a")
+				_ = f.Close()
+			}).Return(minio.UploadInfo{}, nil)
+
+		s := &Storage{
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
+			LocalPrefix:  tmpDir,
+			RemotePrefix: "remote",
+			SymLink:      enum.SymlinkSkip,
+		}
+
+		assert.ErrorContains(t, s.FPutObject(ctx, testFile), "file changed during upload")
+		mockClient.AssertNumberOfCalls(t, "PutObject", streamUploadAttempts)
+	})
+
+	t.Run("先复制再上传", func(t *testing.T) {
+		assert.NoError(t, os.WriteFile(testFile, []byte("copy"), 0644))
+		tempDir := t.TempDir()
+
+		mockClient := new(mocks.MockObjectStorageClient)
+		mockClient.On("StatObject", ctx, "test-bucket", "remote/upload.txt", minio.StatObjectOptions{}).
+			Return(minio.ObjectInfo{}, errors.New("key not found"))
+		// 上传的是临时目录下的副本
+		mockClient.On("FPutObject", ctx, "test-bucket", "remote/upload.txt",
+			mock.MatchedBy(func(path string) bool { return filepath.Dir(path) == tempDir }), minio.PutObjectOptions{}).
+			Run(func(args mock.Arguments) {
+				content, err := os.ReadFile(args.String(3))
+				assert.NoError(t, err)
+				assert.Equal(t, "copy", string(content))
+			}).Return(minio.UploadInfo{}, nil)
+
+		s := &Storage{
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
+			LocalPrefix:  tmpDir,
+			RemotePrefix: "remote",
+			SymLink:      enum.SymlinkSkip,
+			Upload:       enum.UploadCopy,
+			TempDir:      tempDir,
+		}
+
+		assert.NoError(t, s.FPutObject(ctx, testFile))
+		mockClient.AssertExpectations(t)
+		// 上传完成后删除临时文件
+		entries, err := os.ReadDir(tempDir)
+		assert.NoError(t, err)
+		assert.Empty(t, entries)
+		mockClient.AssertNotCalled(t, "PutObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+// TestGetLocalPath 测试远端路径映射回本地路径
+func TestGetLocalPath(t *testing.T) {
+	tests := []struct {
+		name         string
+		localPrefix  string
+		remotePrefix string
+		objectName   string
+		expected     string
+		wantErr      bool
+	}{
+		{
+			name:         "基本路径映射",
+			localPrefix:  "/data/local",
+			remotePrefix: "backup",
+			objectName:   "backup/file.txt",
+			expected:     "/data/local/file.txt",
+		},
+		{
+			name:         "空远程前缀",
+			localPrefix:  "/data/local",
+			remotePrefix: "",
+			objectName:   "subdir/file.txt",
+			expected:     "/data/local/subdir/file.txt",
+		},
+		{
+			name:         "远程前缀带斜杠",
+			localPrefix:  "/data/local",
+			remotePrefix: "/remote/path/",
+			objectName:   "remote/path/docs/report.pdf",
+			expected:     "/data/local/docs/report.pdf",
+		},
+		{
+			name:         "包含..但仍在本地路径下",
+			localPrefix:  "/data/local",
+			remotePrefix: "backup",
+			objectName:   "backup/docs/../file.txt",
+			expected:     "/data/local/file.txt",
+		},
+		{
+			name:         "映射到本地路径之外",
+			localPrefix:  "/data/local",
+			remotePrefix: "backup",
+			objectName:   "backup/../../etc/passwd",
+			wantErr:      true,
+		},
+		{
+			name:         "映射到本地路径的同级目录",
+			localPrefix:  "/data/local",
+			remotePrefix: "",
+			objectName:   "../local-other/file.txt",
+			wantErr:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Storage{
+				LocalPrefix:  tt.localPrefix,
+				RemotePrefix: tt.remotePrefix,
+			}
+			path, err := s.GetLocalPath(tt.objectName)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, path)
+		})
+	}
+}
+
+// TestFPutObject_RecordState 测试上传成功后记录双向同步状态
+func TestFPutObject_RecordState(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "upload.txt")
+	err := os.WriteFile(testFile, []byte("upload content"), 0644)
+	assert.NoError(t, err)
+
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+	assert.NoError(t, err)
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("StatObject", ctx, "test-bucket", "remote/upload.txt", minio.StatObjectOptions{}).
+		Return(minio.ObjectInfo{}, errors.New("key not found"))
+	mockClient.On("PutObject", ctx, "test-bucket", "remote/upload.txt", mock.Anything, mock.Anything, minio.PutObjectOptions{}).
+		Return(minio.UploadInfo{ETag: "48fdd6aacff4f07f4dda2524551b38df"}, nil)
+
+	s := &Storage{
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
+		LocalPrefix:  tmpDir,
+		RemotePrefix: "remote",
+		SymLink:      enum.SymlinkSkip,
+		State:        store,
+	}
+
+	err = s.FPutObject(ctx, testFile)
+
+	assert.NoError(t, err)
+	entry, ok := store.Get(testFile)
+	assert.True(t, ok)
+	assert.Equal(t, "48fdd6aacff4f07f4dda2524551b38df", entry.ETag)
+	assert.Equal(t, int64(len("upload content")), entry.Size)
+	mockClient.AssertExpectations(t)
+}
+
+// TestFPutObject_SkipDownloadPart 测试下载中的临时文件不上传
+func TestFPutObject_SkipDownloadPart(t *testing.T) {
+	mockClient := new(mocks.MockObjectStorageClient)
+	s := &Storage{Backend: NewS3Backend(mockClient, "test-bucket")}
+
+	err := s.FPutObject(context.Background(), "/data/local/file.txtabc123.part.minio")
+
+	assert.ErrorIs(t, err, enum.ErrSkipTransfer)
+	mockClient.AssertNotCalled(t, "StatObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// TestDryRun 测试演练模式不实际上传和删除
+func TestDryRun(t *testing.T) {
+	ctx := context.Background()
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "upload.txt")
+	err := os.WriteFile(testFile, []byte("upload content"), 0644)
+	assert.NoError(t, err)
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("StatObject", ctx, "test-bucket", "remote/upload.txt", minio.StatObjectOptions{}).
+		Return(minio.ObjectInfo{}, errors.New("key not found"))
+	mockClient.On("ListObjects", mock.Anything, "test-bucket", mock.Anything).
+		Return(objectsChan(minio.ObjectInfo{Key: "remote/gone.txt"}))
+
+	s := &Storage{
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
+		LocalPrefix:  tmpDir,
+		RemotePrefix: "remote",
+		SymLink:      enum.SymlinkSkip,
+		DryRun:       true,
+	}
+
+	err = s.FPutObject(ctx, testFile)
+	assert.ErrorIs(t, err, enum.ErrDryRun)
+
+	err = s.RemoveObjects(ctx, filepath.Join(tmpDir, "gone.txt"))
+	assert.ErrorIs(t, err, enum.ErrDryRun)
+
+	mockClient.On("StatObject", ctx, "test-bucket", "remote/old.txt", minio.StatObjectOptions{}).
+		Return(minio.ObjectInfo{Key: "remote/old.txt", Size: 10}, nil)
+	err = s.RemoveObject(ctx, filepath.Join(tmpDir, "old.txt"))
+	assert.ErrorIs(t, err, enum.ErrDryRun)
+
+	mockClient.AssertNotCalled(t, "PutObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	mockClient.AssertNotCalled(t, "RemoveObjects", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	mockClient.AssertNotCalled(t, "RemoveObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
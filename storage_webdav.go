@@ -0,0 +1,634 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// webdavKeepName 空目录占位对象名，WebDAV后端用目录（collection）本身表示，不写入文件
+	webdavKeepName = ".keep"
+	// webdavPropfindBody PROPFIND 请求体，只获取同步需要的属性
+	webdavPropfindBody = `<?xml version="1.0" encoding="utf-8"?>` +
+		`<d:propfind xmlns:d="DAV:"><d:prop>` +
+		`<d:resourcetype/><d:getcontentlength/><d:getlastmodified/><d:getetag/>` +
+		`</d:prop></d:propfind>`
+)
+
+// webdavMultistatus PROPFIND 的响应
+type webdavMultistatus struct {
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Propstats []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+				ContentLength int64  `xml:"DAV: getcontentlength"`
+				LastModified  string `xml:"DAV: getlastmodified"`
+				ETag          string `xml:"DAV: getetag"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// webdavEntry PROPFIND 返回的单个资源
+type webdavEntry struct {
+	Path         string // 解码后的URL路径，不以/结尾
+	Collection   bool
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// WebDAVBackend WebDAV存储后端（如Nextcloud），对象名按/分隔映射为 endpoint/bucket 下的文件
+// 目录通过 MKCOL 创建，空目录直接以目录表示而不写入 .keep 文件，同名的真实 .keep 文件按普通文件上传
+// ETag由服务端生成，不是内容MD5，一致性通过文件大小和修改时间判断
+type WebDAVBackend struct {
+	Endpoint string
+	Bucket   string // endpoint下的目录，为空时直接使用endpoint
+	Username string
+	Password string
+	Client   *http.Client
+
+	root        string   // 根目录地址，以/结尾
+	rootPath    string   // 根目录解码后的URL路径，以/结尾
+	collections sync.Map // 已确认存在的目录，避免每次上传都创建上级目录
+}
+
+// NewWebDAVBackend 创建WebDAV存储后端，用户名为空时不进行认证
+func NewWebDAVBackend(endpoint, bucket, username, password string) (*WebDAVBackend, error) {
+	root := strings.TrimRight(endpoint, "/") + "/"
+	if bucket = strings.Trim(bucket, "/"); bucket != "" {
+		root += escapeObjectName(bucket) + "/"
+	}
+	u, err := url.Parse(root)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid webdav endpoint %s", endpoint)
+	}
+	return &WebDAVBackend{
+		Endpoint: endpoint,
+		Bucket:   bucket,
+		Username: username,
+		Password: password,
+		Client:   &http.Client{},
+		root:     root,
+		rootPath: u.Path,
+	}, nil
+}
+
+// ListBuckets 列出 endpoint 下的目录
+func (b *WebDAVBackend) ListBuckets(ctx context.Context) ([]string, error) {
+	endpoint := strings.TrimRight(b.Endpoint, "/") + "/"
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := b.propfind(ctx, endpoint, "1")
+	if err != nil {
+		return nil, err
+	}
+	var bucketList []string
+	for _, entry := range entries {
+		name := strings.TrimPrefix(entry.Path, strings.TrimRight(u.Path, "/")+"/")
+		if entry.Collection && name != entry.Path && name != "" {
+			bucketList = append(bucketList, name)
+		}
+	}
+	return bucketList, nil
+}
+
+// BucketExists 判断根目录是否存在
+func (b *WebDAVBackend) BucketExists(ctx context.Context) (bool, error) {
+	entries, err := b.propfind(ctx, b.root, "0")
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(entries) > 0 && entries[0].Collection, nil
+}
+
+// StatObject 获取对象元信息，dir/.keep 不是真实文件时对应空目录本身
+func (b *WebDAVBackend) StatObject(ctx context.Context, objectName string) (ObjectInfo, error) {
+	entry, err := b.statFile(ctx, objectName)
+	if err == nil {
+		return b.toObjectInfo(objectName, entry), nil
+	}
+	if !isKeepObject(objectName) || !errors.Is(err, fs.ErrNotExist) {
+		return ObjectInfo{}, err
+	}
+
+	// 与列举一致，只有空目录以 dir/.keep 表示
+	entries, err := b.propfind(ctx, b.collectionURL(parentObject(objectName)), "1")
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if len(entries) != 1 || !entries[0].Collection {
+		return ObjectInfo{}, fmt.Errorf("stat %s: %w", objectName, fs.ErrNotExist)
+	}
+	return ObjectInfo{Key: objectName, ETag: entries[0].ETag, LastModified: entries[0].LastModified}, nil
+}
+
+// statFile 获取文件的PROPFIND结果，不存在或为目录时返回 fs.ErrNotExist
+func (b *WebDAVBackend) statFile(ctx context.Context, objectName string) (webdavEntry, error) {
+	entries, err := b.propfind(ctx, b.objectURL(objectName), "0")
+	if err != nil {
+		return webdavEntry{}, err
+	}
+	if len(entries) == 0 || entries[0].Collection {
+		return webdavEntry{}, fmt.Errorf("stat %s: %w", objectName, fs.ErrNotExist)
+	}
+	return entries[0], nil
+}
+
+// isKeepDir 判断对象是否为表示空目录的 dir/.keep，同名的真实文件返回false
+func (b *WebDAVBackend) isKeepDir(ctx context.Context, objectName string) (bool, error) {
+	if !isKeepObject(objectName) {
+		return false, nil
+	}
+	_, err := b.statFile(ctx, objectName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	return false, err
+}
+
+// GetObject 获取对象内容，表示空目录的 dir/.keep 返回空内容
+func (b *WebDAVBackend) GetObject(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	if isDir, err := b.isKeepDir(ctx, objectName); err != nil {
+		return nil, err
+	} else if isDir {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	resp, err := b.do(ctx, http.MethodGet, b.objectURL(objectName), nil, -1, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, b.statusError(http.MethodGet, objectName, resp)
+	}
+	return resp.Body, nil
+}
+
+// FGetObject 下载对象到本地文件，先写入临时文件再重命名
+func (b *WebDAVBackend) FGetObject(ctx context.Context, objectName, filePath string) error {
+	reader, err := b.GetObject(ctx, objectName)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return saveToFile(ctx, reader, filePath)
+}
+
+// FPutObject 上传本地文件
+func (b *WebDAVBackend) FPutObject(ctx context.Context, objectName, filePath string) (ObjectInfo, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return b.put(ctx, objectName, file, fileInfo.Size())
+}
+
+// PutObject 上传数据流
+func (b *WebDAVBackend) PutObject(ctx context.Context, objectName string, reader io.Reader, objectSize int64) (ObjectInfo, error) {
+	return b.put(ctx, objectName, reader, objectSize)
+}
+
+// CopyObject 服务端复制对象（COPY），表示空目录的 dir/.keep 在目标位置创建目录
+func (b *WebDAVBackend) CopyObject(ctx context.Context, srcName, dstName string, size int64) error {
+	if isDir, err := b.isKeepDir(ctx, srcName); err != nil {
+		return err
+	} else if isDir {
+		return b.mkcolAll(ctx, parentObject(dstName))
+	}
+	if err := b.mkcolAll(ctx, parentObject(dstName)); err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Destination", b.objectURL(dstName))
+	header.Set("Overwrite", "T")
+	resp, err := b.do(ctx, "COPY", b.objectURL(srcName), nil, -1, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return b.statusError("COPY", srcName, resp)
+	}
+	return nil
+}
+
+// RemoveObject 删除对象，并清理删除后变为空的上级目录，对象不存在时不报错
+func (b *WebDAVBackend) RemoveObject(ctx context.Context, objectName string) error {
+	dir, err := b.remove(ctx, objectName)
+	if err != nil {
+		return err
+	}
+	b.removeEmptyParents(ctx, dir)
+	return nil
+}
+
+// ListObjects 按对象名顺序列出指定前缀下的对象，非递归时子目录以/结尾的前缀返回
+// 逐级使用 Depth: 1 的 PROPFIND 遍历（不少服务端禁用了 Depth: infinity），递归时空目录以 dir/.keep 返回
+func (b *WebDAVBackend) ListObjects(ctx context.Context, prefix string, recursive bool) <-chan ObjectInfo {
+	ch := make(chan ObjectInfo)
+	go func() {
+		defer close(ch)
+		send := func(object ObjectInfo) bool {
+			select {
+			case ch <- object:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		var walk func(dir string) error
+		walk = func(dir string) error {
+			entries, err := b.propfind(ctx, b.collectionURL(dir), "1")
+			if err != nil {
+				return err
+			}
+			var children []webdavEntry
+			var self webdavEntry
+			for _, entry := range entries {
+				if key := b.objectKey(entry.Path); key == dir {
+					self = entry
+				} else {
+					children = append(children, entry)
+				}
+			}
+			if recursive && len(children) == 0 && dir != "" {
+				keep := dir + "/" + webdavKeepName
+				if strings.HasPrefix(keep, prefix) &&
+					!send(ObjectInfo{Key: keep, ETag: self.ETag, LastModified: self.LastModified}) {
+					return ctx.Err()
+				}
+				return nil
+			}
+			sort.Slice(children, func(i, j int) bool { return children[i].Path < children[j].Path })
+			for _, child := range children {
+				key := b.objectKey(child.Path)
+				if !child.Collection {
+					if strings.HasPrefix(key, prefix) && !send(b.toObjectInfo(key, child)) {
+						return ctx.Err()
+					}
+					continue
+				}
+				commonPrefix := key + "/"
+				if !recursive {
+					if strings.HasPrefix(commonPrefix, prefix) && !send(ObjectInfo{Key: commonPrefix}) {
+						return ctx.Err()
+					}
+					continue
+				}
+				if strings.HasPrefix(commonPrefix, prefix) || strings.HasPrefix(prefix, commonPrefix) {
+					if err = walk(key); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+
+		// 从前缀中最后一个/之前的目录开始遍历
+		dir := ""
+		if i := strings.LastIndex(prefix, "/"); i >= 0 {
+			dir = prefix[:i]
+		}
+		err := walk(dir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) && ctx.Err() == nil {
+			send(ObjectInfo{Err: err})
+		}
+	}()
+	return ch
+}
+
+// RemoveObjects 批量删除对象，全部删除后再统一清理变为空的目录
+func (b *WebDAVBackend) RemoveObjects(ctx context.Context, objectsCh <-chan ObjectInfo) <-chan RemoveObjectError {
+	ch := make(chan RemoveObjectError)
+	go func() {
+		defer close(ch)
+		dirs := make(map[string]struct{})
+		for object := range objectsCh {
+			dir, err := b.remove(ctx, object.Key)
+			if err != nil {
+				ch <- RemoveObjectError{ObjectName: object.Key, Err: err}
+				continue
+			}
+			dirs[dir] = struct{}{}
+		}
+		// 先清理层级深的目录
+		sorted := make([]string, 0, len(dirs))
+		for dir := range dirs {
+			sorted = append(sorted, dir)
+		}
+		sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+		for _, dir := range sorted {
+			b.removeEmptyParents(ctx, dir)
+		}
+	}()
+	return ch
+}
+
+// ContentMD5 WebDAV的ETag由服务端生成，不是内容MD5
+func (b *WebDAVBackend) ContentMD5() bool {
+	return false
+}
+
+// MakeDir 逐级创建目录，空目录不写入 .keep 文件
+func (b *WebDAVBackend) MakeDir(ctx context.Context, dirName string) error {
+	return b.mkcolAll(ctx, dirName)
+}
+
+// put 上传对象，先创建上级目录
+func (b *WebDAVBackend) put(ctx context.Context, objectName string, reader io.Reader, objectSize int64) (ObjectInfo, error) {
+	dir := parentObject(objectName)
+	if err := b.mkcolAll(ctx, dir); err != nil {
+		return ObjectInfo{}, err
+	}
+	resp, err := b.do(ctx, http.MethodPut, b.objectURL(objectName), reader, objectSize, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusNotFound {
+		// 上级目录在ros之外被删除，缓存已失效（不同服务端返回409或404），可重新读取时重建目录后重试一次
+		resp.Body.Close()
+		for parent := dir; parent != ""; parent = parentObject(parent) {
+			b.collections.Delete(parent)
+		}
+		seeker, ok := reader.(io.Seeker)
+		if !ok {
+			return ObjectInfo{}, b.statusError(http.MethodPut, objectName, resp)
+		}
+		if err = b.mkcolAll(ctx, dir); err != nil {
+			return ObjectInfo{}, err
+		}
+		if _, err = seeker.Seek(0, io.SeekStart); err != nil {
+			return ObjectInfo{}, err
+		}
+		if resp, err = b.do(ctx, http.MethodPut, b.objectURL(objectName), reader, objectSize, nil); err != nil {
+			return ObjectInfo{}, err
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return ObjectInfo{}, b.statusError(http.MethodPut, objectName, resp)
+	}
+	etag := trimETag(resp.Header.Get("ETag"))
+	lastModified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if etag == "" || lastModified.IsZero() {
+		// 部分服务端上传后不返回ETag或修改时间，重新获取
+		return b.StatObject(ctx, objectName)
+	}
+	return ObjectInfo{Key: objectName, Size: objectSize, ETag: etag, LastModified: lastModified}, nil
+}
+
+// remove 删除单个对象，表示空目录的 dir/.keep 在目录为空时删除目录，返回之后需要向上清理的目录
+func (b *WebDAVBackend) remove(ctx context.Context, objectName string) (string, error) {
+	dir := parentObject(objectName)
+	if isDir, err := b.isKeepDir(ctx, objectName); err != nil {
+		return "", err
+	} else if isDir {
+		if dir == "" {
+			return "", nil
+		}
+		b.removeEmptyCollection(ctx, dir)
+		return parentObject(dir), nil
+	}
+	resp, err := b.do(ctx, http.MethodDelete, b.objectURL(objectName), nil, -1, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return dir, nil
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return "", b.statusError(http.MethodDelete, objectName, resp)
+	}
+	return dir, nil
+}
+
+// removeEmptyParents 从dir开始向上删除空目录，直到根目录（不含）
+func (b *WebDAVBackend) removeEmptyParents(ctx context.Context, dir string) {
+	for dir != "" && b.removeEmptyCollection(ctx, dir) {
+		dir = parentObject(dir)
+	}
+}
+
+// removeEmptyCollection 目录为空时删除，返回是否已删除
+func (b *WebDAVBackend) removeEmptyCollection(ctx context.Context, dir string) bool {
+	entries, err := b.propfind(ctx, b.collectionURL(dir), "1")
+	if err != nil || len(entries) != 1 {
+		// 目录非空或不存在
+		return false
+	}
+	resp, err := b.do(ctx, http.MethodDelete, b.collectionURL(dir), nil, -1, nil)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	b.forgetCollections(dir)
+	return resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent
+}
+
+// mkcolAll 逐级创建目录，已存在的目录不报错
+func (b *WebDAVBackend) mkcolAll(ctx context.Context, dir string) error {
+	if dir == "" {
+		return nil
+	}
+	if _, ok := b.collections.Load(dir); ok {
+		return nil
+	}
+	if err := b.mkcolAll(ctx, parentObject(dir)); err != nil {
+		return err
+	}
+	resp, err := b.do(ctx, "MKCOL", b.collectionURL(dir), nil, -1, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// 405 表示目录已存在
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+		return b.statusError("MKCOL", dir, resp)
+	}
+	b.collections.Store(dir, struct{}{})
+	return nil
+}
+
+// forgetCollections 清除目录及其子目录的存在缓存
+func (b *WebDAVBackend) forgetCollections(dir string) {
+	b.collections.Range(func(key, _ any) bool {
+		if name := key.(string); name == dir || strings.HasPrefix(name, dir+"/") {
+			b.collections.Delete(key)
+		}
+		return true
+	})
+}
+
+// propfind 获取资源属性，depth为0时只返回资源本身，为1时还返回直接子资源，资源不存在时返回 fs.ErrNotExist
+func (b *WebDAVBackend) propfind(ctx context.Context, rawURL, depth string) ([]webdavEntry, error) {
+	header := http.Header{}
+	header.Set("Depth", depth)
+	header.Set("Content-Type", "application/xml; charset=utf-8")
+	resp, err := b.do(ctx, "PROPFIND", rawURL, strings.NewReader(webdavPropfindBody), int64(len(webdavPropfindBody)), header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("propfind %s: %w", rawURL, fs.ErrNotExist)
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, b.statusError("PROPFIND", rawURL, resp)
+	}
+
+	var multistatus webdavMultistatus
+	if err = xml.NewDecoder(resp.Body).Decode(&multistatus); err != nil {
+		return nil, fmt.Errorf("propfind %s: %w", rawURL, err)
+	}
+	entries := make([]webdavEntry, 0, len(multistatus.Responses))
+	for _, response := range multistatus.Responses {
+		href, err := url.Parse(response.Href)
+		if err != nil {
+			return nil, fmt.Errorf("propfind %s: %w", rawURL, err)
+		}
+		entry := webdavEntry{Path: strings.TrimRight(href.Path, "/")}
+		for _, propstat := range response.Propstats {
+			// 只使用成功获取到的属性
+			if !strings.Contains(propstat.Status, " 200 ") {
+				continue
+			}
+			prop := propstat.Prop
+			entry.Collection = prop.ResourceType.Collection != nil
+			entry.Size = prop.ContentLength
+			entry.ETag = trimETag(prop.ETag)
+			entry.LastModified, _ = http.ParseTime(prop.LastModified)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// do 发送请求，配置了用户名时使用Basic认证
+func (b *WebDAVBackend) do(ctx context.Context, method, rawURL string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil && size >= 0 {
+		req.ContentLength = size
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if b.Username != "" {
+		req.SetBasicAuth(b.Username, b.Password)
+	}
+	return b.Client.Do(req)
+}
+
+// WebDAVError WebDAV请求返回非预期状态的错误，404时可以通过 errors.Is(err, fs.ErrNotExist) 判断
+type WebDAVError struct {
+	Method     string
+	Name       string
+	Status     string
+	StatusCode int
+}
+
+func (e *WebDAVError) Error() string {
+	if e.StatusCode == http.StatusNotFound {
+		return fmt.Sprintf("%s %s: %s", e.Method, e.Name, fs.ErrNotExist.Error())
+	}
+	return fmt.Sprintf("%s %s: unexpected status %s", e.Method, e.Name, e.Status)
+}
+
+func (e *WebDAVError) Unwrap() error {
+	if e.StatusCode == http.StatusNotFound {
+		return fs.ErrNotExist
+	}
+	return nil
+}
+
+// statusError 构造非预期响应状态的错误
+func (b *WebDAVBackend) statusError(method, name string, resp *http.Response) error {
+	return &WebDAVError{Method: method, Name: name, Status: resp.Status, StatusCode: resp.StatusCode}
+}
+
+// objectURL 获取对象的地址
+func (b *WebDAVBackend) objectURL(objectName string) string {
+	return b.root + escapeObjectName(objectName)
+}
+
+// collectionURL 获取目录的地址，以/结尾，避免服务端重定向
+func (b *WebDAVBackend) collectionURL(dir string) string {
+	if dir == "" {
+		return b.root
+	}
+	return b.root + escapeObjectName(dir) + "/"
+}
+
+// objectKey 把PROPFIND返回的路径转换为对象名，根目录返回空字符串
+func (b *WebDAVBackend) objectKey(p string) string {
+	if p+"/" == b.rootPath {
+		return ""
+	}
+	return strings.TrimPrefix(p, b.rootPath)
+}
+
+// toObjectInfo 转换PROPFIND返回的文件属性
+func (b *WebDAVBackend) toObjectInfo(objectName string, entry webdavEntry) ObjectInfo {
+	return ObjectInfo{Key: objectName, Size: entry.Size, ETag: entry.ETag, LastModified: entry.LastModified}
+}
+
+// escapeObjectName 按/分隔逐段转义对象名
+func escapeObjectName(objectName string) string {
+	segments := strings.Split(objectName, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// isKeepObject 是否空目录占位对象
+func isKeepObject(objectName string) bool {
+	return path.Base(objectName) == webdavKeepName
+}
+
+// parentObject 获取上级目录的对象名，根目录下的对象返回空字符串
+func parentObject(objectName string) string {
+	if i := strings.LastIndex(objectName, "/"); i >= 0 {
+		return objectName[:i]
+	}
+	return ""
+}
+
+// trimETag 去掉ETag的弱校验标识和引号
+func trimETag(etag string) string {
+	return strings.Trim(strings.TrimPrefix(etag, "W/"), "\"")
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/webdav"
+)
+
+// newTestWebDAV 启动进程内的WebDAV服务，挂载在 /dav 下并要求Basic认证，返回服务地址和文件系统
+func newTestWebDAV(t *testing.T) (string, webdav.FileSystem) {
+	t.Helper()
+	fileSystem := webdav.NewMemFS()
+	assert.NoError(t, fileSystem.Mkdir(context.Background(), "/backup dir", 0755))
+	handler := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: fileSystem,
+		LockSystem: webdav.NewMemLS(),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "alice" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/dav", fileSystem
+}
+
+// newTestWebDAVBackend 创建连接到测试服务的WebDAV存储后端
+func newTestWebDAVBackend(t *testing.T) (*WebDAVBackend, webdav.FileSystem) {
+	t.Helper()
+	endpoint, fileSystem := newTestWebDAV(t)
+	b, err := NewWebDAVBackend(endpoint, "backup dir", "alice", "secret")
+	assert.NoError(t, err)
+	return b, fileSystem
+}
+
+// TestWebDAVBackend_PutAndStat 测试上传时自动创建上级目录，并通过PROPFIND获取ETag和修改时间
+func TestWebDAVBackend_PutAndStat(t *testing.T) {
+	ctx := context.Background()
+	b, fileSystem := newTestWebDAVBackend(t)
+
+	exist, err := b.BucketExists(ctx)
+	assert.NoError(t, err)
+	assert.True(t, exist)
+	buckets, err := b.ListBuckets(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"backup dir"}, buckets)
+
+	info, err := b.PutObject(ctx, "remote/sub/hello 1.txt", strings.NewReader("hello world"), 11)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, info.ETag)
+	fileInfo, err := fileSystem.Stat(ctx, "/backup dir/remote/sub/hello 1.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), fileInfo.Size())
+
+	stat, err := b.StatObject(ctx, "remote/sub/hello 1.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), stat.Size)
+	assert.Equal(t, info.ETag, stat.ETag)
+	assert.False(t, stat.LastModified.IsZero())
+
+	reader, err := b.GetObject(ctx, "remote/sub/hello 1.txt")
+	assert.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	_ = reader.Close()
+	assert.Equal(t, "hello world", string(content))
+
+	_, err = b.StatObject(ctx, "remote/missing.txt")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = b.StatObject(ctx, "remote/sub")
+	assert.Error(t, err)
+}
+
+// TestWebDAVBackend_Unauthorized 测试认证失败时返回错误
+func TestWebDAVBackend_Unauthorized(t *testing.T) {
+	endpoint, _ := newTestWebDAV(t)
+	b, err := NewWebDAVBackend(endpoint, "backup dir", "alice", "wrong")
+	assert.NoError(t, err)
+
+	_, err = b.BucketExists(context.Background())
+	assert.ErrorContains(t, err, "401")
+
+	_, err = NewWebDAVBackend("dav.example.com", "", "", "")
+	assert.Error(t, err)
+}
+
+// TestWebDAVBackend_KeepAsCollection 测试空目录通过MKCOL创建，列举时以 dir/.keep 返回
+func TestWebDAVBackend_KeepAsCollection(t *testing.T) {
+	ctx := context.Background()
+	b, fileSystem := newTestWebDAVBackend(t)
+
+	assert.NoError(t, b.MakeDir(ctx, "remote/empty"))
+	fileInfo, err := fileSystem.Stat(ctx, "/backup dir/remote/empty")
+	assert.NoError(t, err)
+	assert.True(t, fileInfo.IsDir())
+	_, err = fileSystem.Stat(ctx, "/backup dir/remote/empty/.keep")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	stat, err := b.StatObject(ctx, "remote/empty/.keep")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), stat.Size)
+	assert.Equal(t, []string{"remote/empty/.keep"}, objectKeys(collectObjects(t, b.ListObjects(ctx, "remote/", true))))
+
+	// 目录非空时不删除
+	_, err = b.PutObject(ctx, "remote/empty/a.txt", strings.NewReader("a"), 1)
+	assert.NoError(t, err)
+	_, err = b.StatObject(ctx, "remote/empty/.keep")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NoError(t, b.RemoveObject(ctx, "remote/empty/.keep"))
+	_, err = fileSystem.Stat(ctx, "/backup dir/remote/empty/a.txt")
+	assert.NoError(t, err)
+	_, err = b.StatObject(ctx, "remote/a.txt/.keep")
+	assert.Error(t, err)
+}
+
+// TestWebDAVBackend_KeepFile 测试有内容的 .keep 按普通文件上传、读取、复制和删除
+func TestWebDAVBackend_KeepFile(t *testing.T) {
+	ctx := context.Background()
+	b, fileSystem := newTestWebDAVBackend(t)
+
+	_, err := b.PutObject(ctx, "remote/log/.keep", strings.NewReader("placeholder"), 11)
+	assert.NoError(t, err)
+	fileInfo, err := fileSystem.Stat(ctx, "/backup dir/remote/log/.keep")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), fileInfo.Size())
+
+	stat, err := b.StatObject(ctx, "remote/log/.keep")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), stat.Size)
+	reader, err := b.GetObject(ctx, "remote/log/.keep")
+	assert.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	_ = reader.Close()
+	assert.Equal(t, "placeholder", string(content))
+	objects := collectObjects(t, b.ListObjects(ctx, "remote/", true))
+	assert.Equal(t, []string{"remote/log/.keep"}, objectKeys(objects))
+	assert.Equal(t, int64(11), objects[0].Size)
+
+	assert.NoError(t, b.CopyObject(ctx, "remote/log/.keep", "copy/log/.keep", 11))
+	fileInfo, err = fileSystem.Stat(ctx, "/backup dir/copy/log/.keep")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), fileInfo.Size())
+
+	// 与普通文件一样删除后清理变为空的目录
+	assert.NoError(t, b.RemoveObject(ctx, "remote/log/.keep"))
+	_, err = fileSystem.Stat(ctx, "/backup dir/remote/log")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// TestWebDAVBackend_ListObjects 测试逐级PROPFIND按前缀列举对象
+func TestWebDAVBackend_ListObjects(t *testing.T) {
+	ctx := context.Background()
+	b, _ := newTestWebDAVBackend(t)
+	for _, key := range []string{"remote/a.txt", "remote/sub/b.txt", "remote/sub/deep/c.txt", "remoteX/d.txt"} {
+		_, err := b.PutObject(ctx, key, strings.NewReader(key), int64(len(key)))
+		assert.NoError(t, err)
+	}
+
+	objects := collectObjects(t, b.ListObjects(ctx, "remote/", true))
+	assert.Equal(t, []string{"remote/a.txt", "remote/sub/b.txt", "remote/sub/deep/c.txt"}, objectKeys(objects))
+	for _, object := range objects {
+		assert.Equal(t, int64(len(object.Key)), object.Size)
+		assert.NotEmpty(t, object.ETag)
+	}
+
+	objects = collectObjects(t, b.ListObjects(ctx, "remote/", false))
+	assert.Equal(t, []string{"remote/a.txt", "remote/sub/"}, objectKeys(objects))
+
+	objects = collectObjects(t, b.ListObjects(ctx, "remote/sub", true))
+	assert.Equal(t, []string{"remote/sub/b.txt", "remote/sub/deep/c.txt"}, objectKeys(objects))
+
+	objects = collectObjects(t, b.ListObjects(ctx, "", true))
+	assert.Len(t, objects, 4)
+
+	objects = collectObjects(t, b.ListObjects(ctx, "missing/", true))
+	assert.Empty(t, objects)
+}
+
+// TestWebDAVBackend_CopyAndRemove 测试服务端复制，以及删除后清理空目录
+func TestWebDAVBackend_CopyAndRemove(t *testing.T) {
+	ctx := context.Background()
+	b, fileSystem := newTestWebDAVBackend(t)
+	_, err := b.PutObject(ctx, "remote/sub/a.txt", strings.NewReader("a"), 1)
+	assert.NoError(t, err)
+	_, err = b.PutObject(ctx, "remote/b.txt", strings.NewReader("b"), 1)
+	assert.NoError(t, err)
+
+	assert.NoError(t, b.CopyObject(ctx, "remote/sub/a.txt", "remote/.trash/2026-10-01/sub/a.txt", 1))
+	stat, err := b.StatObject(ctx, "remote/.trash/2026-10-01/sub/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), stat.Size)
+
+	ch := make(chan ObjectInfo, 3)
+	ch <- ObjectInfo{Key: "remote/sub/a.txt"}
+	ch <- ObjectInfo{Key: "remote/sub/missing.txt"}
+	ch <- ObjectInfo{Key: "remote/.trash/2026-10-01/sub/a.txt"}
+	close(ch)
+	for err := range b.RemoveObjects(ctx, ch) {
+		assert.NoError(t, err.Err)
+	}
+	_, err = fileSystem.Stat(ctx, "/backup dir/remote/sub")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = fileSystem.Stat(ctx, "/backup dir/remote/.trash")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = fileSystem.Stat(ctx, "/backup dir/remote/b.txt")
+	assert.NoError(t, err)
+
+	// 删除最后一个对象后只保留根目录
+	assert.NoError(t, b.RemoveObject(ctx, "remote/b.txt"))
+	_, err = fileSystem.Stat(ctx, "/backup dir/remote")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	exist, err := b.BucketExists(ctx)
+	assert.NoError(t, err)
+	assert.True(t, exist)
+
+	// 上级目录在ros之外被删除后重新创建
+	_, err = b.PutObject(ctx, "remote/sub/c.txt", strings.NewReader("c"), 1)
+	assert.NoError(t, err)
+	assert.NoError(t, fileSystem.RemoveAll(ctx, "/backup dir/remote"))
+	_, err = b.PutObject(ctx, "remote/sub/c.txt", strings.NewReader("c"), 1)
+	assert.NoError(t, err)
+}
+
+// TestStorage_WebDAVBackend 端到端测试：上传、按大小和修改时间比较、删除到回收站并恢复
+func TestStorage_WebDAVBackend(t *testing.T) {
+	ctx := context.Background()
+	localDir := t.TempDir()
+	// 上传空目录和符号链接时会在工作目录下创建临时文件
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(t.TempDir()))
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	past := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.MkdirAll(filepath.Join(localDir, "docs", "empty"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "docs", "a.txt"), []byte("a"), 0644))
+	// 有内容的 .keep 占位文件按普通文件上传
+	assert.NoError(t, os.MkdirAll(filepath.Join(localDir, "docs", "log"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "docs", "log", ".keep"), []byte("placeholder"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "b.bin"), []byte("bb"), 0644))
+	assert.NoError(t, os.Chtimes(filepath.Join(localDir, "b.bin"), past, past))
+	assert.NoError(t, os.Symlink("b.bin", filepath.Join(localDir, "link")))
+
+	b, fileSystem := newTestWebDAVBackend(t)
+	s := &Storage{
+		Backend:      b,
+		LocalPrefix:  localDir,
+		RemotePrefix: "backup",
+		SymLink:      enum.SymlinkAddr,
+		Trash:        true,
+	}
+	for _, path := range []string{
+		filepath.Join(localDir, "docs", "a.txt"),
+		filepath.Join(localDir, "docs", "empty"),
+		filepath.Join(localDir, "docs", "log", ".keep"),
+		filepath.Join(localDir, "b.bin"),
+		filepath.Join(localDir, "link"),
+	} {
+		assert.Equal(t, enum.DiffNew, s.diff(ctx, path, ""))
+		assert.NoError(t, s.FPutObject(ctx, path))
+		assert.True(t, s.IsSameV2(ctx, path, ""), path)
+		assert.ErrorIs(t, s.FPutObject(ctx, path), enum.ErrSkipTransfer)
+	}
+	assert.ElementsMatch(t, []string{"backup/b.bin", "backup/docs/a.txt", "backup/docs/empty/.keep", "backup/docs/log/.keep", "backup/link.link"},
+		objectKeys(collectObjects(t, s.ListObjects(ctx))))
+	fileInfo, err := fileSystem.Stat(ctx, "/backup dir/backup/docs/log/.keep")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(11), fileInfo.Size())
+	_, err = fileSystem.Stat(ctx, "/backup dir/backup/docs/empty/.keep")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// 本地修改后大小或修改时间不一致
+	future := time.Now().Add(time.Hour)
+	assert.NoError(t, os.Chtimes(filepath.Join(localDir, "b.bin"), future, future))
+	assert.Equal(t, enum.DiffModTime, s.diff(ctx, filepath.Join(localDir, "b.bin"), ""))
+	assert.NoError(t, os.WriteFile(filepath.Join(localDir, "docs", "a.txt"), []byte("abc"), 0644))
+	assert.Equal(t, enum.DiffModTime, s.diff(ctx, filepath.Join(localDir, "docs", "a.txt"), ""))
+	assert.NoError(t, s.FPutObject(ctx, filepath.Join(localDir, "docs", "a.txt")))
+
+	// 本地删除目录后远端对象移入回收站，不留下空目录
+	assert.NoError(t, os.RemoveAll(filepath.Join(localDir, "docs")))
+	assert.NoError(t, s.RemoveObjects(ctx, filepath.Join(localDir, "docs")))
+	trashRoot := "backup/.trash/" + time.Now().UTC().Format(trashDateFormat)
+	assert.ElementsMatch(t, []string{"backup/b.bin", "backup/link.link", trashRoot + "/docs/a.txt", trashRoot + "/docs/empty/.keep", trashRoot + "/docs/log/.keep"},
+		objectKeys(collectObjects(t, s.ListObjects(ctx))))
+	_, err = fileSystem.Stat(ctx, "/backup dir/backup/docs")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	trash := &Trash{
+		Enable:    true,
+		Retention: 30,
+		Storage:   s,
+		Restore: &Restore{
+			LocalPrefix:   localDir,
+			SymLink:       enum.SymlinkAddr,
+			IgnoreMatcher: helper.NewIgnoreMatcher(nil),
+			Storage:       s,
+		},
+	}
+	restored, err := trash.RestorePath(ctx, filepath.Join(localDir, "docs"), "")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, restored)
+	content, err := os.ReadFile(filepath.Join(localDir, "docs", "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", string(content))
+	assert.DirExists(t, filepath.Join(localDir, "docs", "empty"))
+	content, err = os.ReadFile(filepath.Join(localDir, "docs", "log", ".keep"))
+	assert.NoError(t, err)
+	assert.Equal(t, "placeholder", string(content))
+	assert.True(t, s.IsSameV2(ctx, filepath.Join(localDir, "docs", "a.txt"), ""))
+	assert.ElementsMatch(t, []string{"backup/b.bin", "backup/docs/a.txt", "backup/docs/empty/.keep", "backup/docs/log/.keep", "backup/link.link"},
+		objectKeys(collectObjects(t, s.ListObjects(ctx))))
+}
@@ -8,88 +8,325 @@ import (
 	"github.com/jorben/rsync-object-storage/helper"
 	"github.com/jorben/rsync-object-storage/kv"
 	"github.com/jorben/rsync-object-storage/log"
+	"github.com/jorben/rsync-object-storage/state"
 	"io/fs"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 )
 
+// TransferStats 传输结果统计，演练模式下统计的是将要执行的操作
+type TransferStats struct {
+	Uploaded atomic.Int64
+	Copied   atomic.Int64 // 重命名后在服务端复制的文件
+	Removed  atomic.Int64
+	Skipped  atomic.Int64
+	Failed   atomic.Int64
+}
+
 type Transfer struct {
+	Name         string // 远端名称，用于区分多个远端的日志
 	LocalPrefix  string
 	RemotePrefix string
 	HotDelay     time.Duration
-	PutChan      chan string
-	DeleteChan   chan string
+	Scheduler    *Scheduler // 按优先级调度的持久化变更队列，任务处理完成后才确认
 	Storage      *Storage
+	Stats        TransferStats
+	Retry        RetryPolicy        // 失败重试策略，Attempts为0时不重试
+	DeadLetters  *state.DeadLetters // 重试耗尽后仍失败的路径，为nil时不记录
 }
 
-func NewTransfer(c *config.SyncConfig, putCh chan string, deleteCh chan string, storage *Storage) *Transfer {
+func NewTransfer(c *config.SyncConfig, scheduler *Scheduler, storage *Storage) *Transfer {
 	return &Transfer{
+		Name:         c.Remote.GetName(),
 		LocalPrefix:  c.Local.Path,
 		RemotePrefix: c.Remote.Path,
 		HotDelay:     time.Duration(c.Sync.RealTime.HotDelay) * time.Minute,
-		PutChan:      putCh,
-		DeleteChan:   deleteCh,
+		Scheduler:    scheduler,
 		Storage:      storage,
+		Retry:        NewRetryPolicy(c),
 	}
 }
 
-// Run 消费队列，执行Put和Delete
-// 支持通过context取消实现优雅退出
+// Run 消费队列，执行Put和Delete，处理完成后确认任务
+// 支持通过context取消实现优雅退出，被中断的任务不确认，重启后重新执行
 func (t *Transfer) Run(ctx context.Context) {
 	for {
-		select {
-		case <-ctx.Done():
+		item, err := t.Scheduler.Pop(ctx)
+		if errors.Is(err, state.ErrQueueClosed) {
+			log.Debug("Queue closed, Transfer worker exiting...")
+			return
+		} else if ctx.Err() != nil {
 			log.Debug("Transfer worker received shutdown signal, exiting...")
 			return
-		case path, ok := <-t.PutChan:
-			if !ok {
-				log.Debug("PutChan closed, Transfer worker exiting...")
-				return
-			}
-			// 路径是否存在（有一些临时文件，创建后可能立刻被删除了）
-			if isExist, _ := helper.IsExist(path); !isExist {
-				log.Debugf("Path is not exist %s", path)
-				continue
-			}
+		} else if err != nil {
+			log.Errorf("Pop queue err: %s, remote: %s", err.Error(), t.Name)
+			return
+		}
 
-			// 是否是文件夹，文件夹需要递归其子文件（RENAME事件不会收到子文件的事件）
-			err := filepath.WalkDir(path, func(subPath string, d fs.DirEntry, err error) error {
-				// 检查是否需要退出
-				select {
-				case <-ctx.Done():
-					return ctx.Err()
-				default:
-				}
-				// 将执行Put的记录加入到kv，供热点文件发现
-				kv.Set(subPath, "", t.HotDelay)
-				if err := t.Storage.FPutObject(ctx, subPath); err == nil {
-					log.Infof("Sync success, path: %s", subPath)
-				} else if errors.Is(err, enum.ErrSkipTransfer) {
-					log.Debugf("Skipping %s", subPath)
-				} else {
-					log.Errorf("FPutObject err: %s, file: %s", err.Error(), subPath)
-				}
-				return nil
-			})
-			if err != nil && err != context.Canceled {
-				log.Errorf("WalkDir err: %s", err.Error())
-			}
-		case path, ok := <-t.DeleteChan:
-			if !ok {
-				log.Debug("DeleteChan closed, Transfer worker exiting...")
-				return
-			}
-			// 要判断路径是否存在（有一些文件修改保存策略是先删除再创建，避免串到了Create的后面，导致误删）
-			if isExist, _ := helper.IsExist(path); isExist {
-				log.Debugf("Path is still exist %s", path)
-				continue
+		switch item.Op {
+		case enum.OpPut:
+			t.handlePut(ctx, item.Path)
+		case enum.OpDelete:
+			t.handleDelete(ctx, item.Path)
+		case enum.OpRename:
+			t.handleRename(ctx, item.From, item.Path)
+		default:
+			log.Errorf("Unknown queue op %s, path: %s", item.Op, item.Path)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		// 失败的任务已进入死信列表，同样确认
+		if err := t.Scheduler.Ack(item); err != nil {
+			log.Errorf("Ack queue err: %s, path: %s, remote: %s", err.Error(), item.Path, t.Name)
+		}
+	}
+}
+
+// handlePut 处理上传任务，文件夹需要递归其子文件
+func (t *Transfer) handlePut(ctx context.Context, path string) {
+	// 路径是否存在（有一些临时文件，创建后可能立刻被删除了）
+	if isExist, _ := helper.IsExist(path); !isExist {
+		log.Debugf("Path is not exist %s", path)
+		return
+	}
+
+	// 是否是文件夹，文件夹需要递归其子文件（RENAME事件不会收到子文件的事件）
+	err := filepath.WalkDir(path, func(subPath string, d fs.DirEntry, err error) error {
+		// 检查是否需要退出
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		t.Put(ctx, subPath)
+		return nil
+	})
+	if err != nil && err != context.Canceled {
+		log.Errorf("WalkDir err: %s", err.Error())
+	}
+}
+
+// handleDelete 处理删除任务
+func (t *Transfer) handleDelete(ctx context.Context, path string) {
+	// 要判断路径是否存在（有一些文件修改保存策略是先删除再创建，避免串到了Create的后面，导致误删）
+	if isExist, _ := helper.IsExist(path); isExist {
+		log.Debugf("Path is still exist %s", path)
+		return
+	}
+	t.Remove(ctx, path)
+}
+
+// handleRename 处理重命名任务，新路径下的文件从旧路径已同步的对象在服务端复制，之后删除旧路径
+func (t *Transfer) handleRename(ctx context.Context, from, to string) {
+	if isExist, _ := helper.IsExist(to); isExist {
+		err := filepath.WalkDir(to, func(subPath string, d fs.DirEntry, err error) error {
+			// 检查是否需要退出
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
 			}
+			rel, _ := filepath.Rel(to, subPath)
+			t.Copy(ctx, filepath.Join(from, rel), subPath)
+			return nil
+		})
+		if err != nil && err != context.Canceled {
+			log.Errorf("WalkDir err: %s", err.Error())
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	t.handleDelete(ctx, from)
+}
+
+// Put 上传单个路径并统计结果，失败时按重试策略重试，返回是否成功
+func (t *Transfer) Put(ctx context.Context, path string) bool {
+	// 将执行Put的记录加入到kv，供热点文件发现
+	kv.Set(path, "", t.HotDelay)
+	err := t.retry(ctx, enum.OpPut, path, func() error {
+		return t.Storage.FPutObject(ctx, path)
+	})
+	if err == nil {
+		t.Stats.Uploaded.Add(1)
+		log.Infof("Sync success, path: %s, remote: %s", path, t.Name)
+	} else if errors.Is(err, enum.ErrSkipTransfer) {
+		t.Stats.Skipped.Add(1)
+		log.Debugf("Skipping %s", path)
+	} else if errors.Is(err, enum.ErrDryRun) {
+		t.Stats.Uploaded.Add(1)
+	} else {
+		t.Stats.Failed.Add(1)
+		log.Errorf("FPutObject err: %s, file: %s, remote: %s", err.Error(), path, t.Name)
+		return false
+	}
+	return true
+}
+
+// Copy 把旧路径已同步的对象复制为新路径的对象并统计结果，无法复制时上传，失败时按重试策略重试，返回是否成功
+func (t *Transfer) Copy(ctx context.Context, from, to string) bool {
+	var copied bool
+	err := t.retry(ctx, enum.OpPut, to, func() (err error) {
+		copied, err = t.Storage.FCopyObject(ctx, from, to)
+		return err
+	})
+	if err != nil && !errors.Is(err, enum.ErrSkipTransfer) && !errors.Is(err, enum.ErrDryRun) {
+		t.Stats.Failed.Add(1)
+		log.Errorf("FCopyObject err: %s, file: %s, remote: %s", err.Error(), to, t.Name)
+		return false
+	}
+	switch {
+	case errors.Is(err, enum.ErrSkipTransfer):
+		t.Stats.Skipped.Add(1)
+		log.Debugf("Skipping %s", to)
+	case copied:
+		t.Stats.Copied.Add(1)
+		if err == nil {
+			log.Infof("Copy success, path: %s -> %s, remote: %s", from, to, t.Name)
+		}
+	default:
+		t.Stats.Uploaded.Add(1)
+		if err == nil {
+			log.Infof("Sync success, path: %s, remote: %s", to, t.Name)
+		}
+	}
+	return true
+}
 
-			// 如果是目录，则需要遍历删除
-			if err := t.Storage.RemoveObjects(ctx, path); err != nil {
-				continue
+// Remove 删除单个路径（目录时删除其下全部对象）并统计结果，失败时按重试策略重试，返回是否成功
+func (t *Transfer) Remove(ctx context.Context, path string) bool {
+	err := t.retry(ctx, enum.OpDelete, path, func() error {
+		return t.Storage.RemoveObjects(ctx, path)
+	})
+	if errors.Is(err, enum.ErrDryRun) {
+		t.Stats.Removed.Add(1)
+		return true
+	} else if errors.Is(err, enum.ErrSkipTransfer) {
+		t.Stats.Skipped.Add(1)
+		log.Debugf("Path is still exist %s", path)
+		return true
+	} else if err != nil {
+		t.Stats.Failed.Add(1)
+		return false
+	}
+	t.Stats.Removed.Add(1)
+	log.Infof("Remove success, path: %s, remote: %s", path, t.Name)
+	return true
+}
+
+// retry 执行操作，临时错误按指数退避重试，重试耗尽或遇到永久错误时加入死信列表
+// 等待期间路径被删除（上传）或重新创建（删除）时不再重试，返回 enum.ErrSkipTransfer
+func (t *Transfer) retry(ctx context.Context, op, path string, fn func() error) error {
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		err = fn()
+		if err == nil || errors.Is(err, enum.ErrSkipTransfer) || errors.Is(err, enum.ErrDryRun) {
+			if !errors.Is(err, enum.ErrDryRun) {
+				t.clearDeadLetter(path)
 			}
-			log.Infof("Remove success, path: %s", path)
+			return err
+		}
+		if ctx.Err() != nil {
+			// 退出过程中被中断的操作由下次对账补齐，不算作失败
+			return err
+		}
+		if !isTransientError(err) || attempt >= t.Retry.Attempts {
+			break
+		}
+		delay := t.Retry.Backoff(attempt)
+		log.Warnf("%s err: %s, retry in %s (%d/%d), path: %s, remote: %s", op, err.Error(),
+			delay.Round(time.Millisecond), attempt, t.Retry.Attempts, path, t.Name)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		if isExist, _ := helper.IsExist(path); isExist != (op == enum.OpPut) {
+			log.Debugf("Path changed while waiting for retry, skipping %s", path)
+			return enum.ErrSkipTransfer
+		}
+	}
+
+	if t.DeadLetters != nil {
+		deadLetter := state.DeadLetter{
+			Op:       op,
+			Path:     path,
+			Remote:   t.Name,
+			Error:    err.Error(),
+			Attempts: attempt,
+			FailedAt: time.Now(),
+		}
+		if saveErr := t.DeadLetters.Add(deadLetter); saveErr != nil {
+			log.Errorf("Save dead letter err: %s", saveErr.Error())
+		} else {
+			log.Warnf("Moved to dead letters after %d attempt(s), op: %s, path: %s, remote: %s", attempt, op, path, t.Name)
+		}
+	}
+	return err
+}
+
+// clearDeadLetter 路径同步成功后删除其死信记录
+func (t *Transfer) clearDeadLetter(path string) {
+	if t.DeadLetters == nil {
+		return
+	}
+	for _, op := range []string{enum.OpPut, enum.OpDelete} {
+		if err := t.DeadLetters.Remove(t.Name, op, path); err != nil {
+			log.Errorf("Remove dead letter err: %s", err.Error())
+		}
+	}
+}
+
+// RetryDeadLetters 重新执行当前远端的死信记录，paths 不为空时只处理这些路径及其子路径
+// 成功的记录被删除，仍失败的记录更新错误信息，返回成功和失败的数量
+func (t *Transfer) RetryDeadLetters(ctx context.Context, paths []string) (retried int, failed int, err error) {
+	if t.DeadLetters == nil {
+		return 0, 0, nil
+	}
+	items, err := t.DeadLetters.List()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, item := range items {
+		if item.Remote != t.Name || !matchPaths(paths, item.Path) {
+			continue
+		}
+		if ctx.Err() != nil {
+			return retried, failed, ctx.Err()
+		}
+		var ok bool
+		isExist, _ := helper.IsExist(item.Path)
+		switch {
+		case item.Op == enum.OpPut && !isExist, item.Op == enum.OpDelete && isExist:
+			// 本地已经变化，记录不再需要，后续变更由实时同步或对账处理
+			log.Infof("Path changed since failure, dropping dead letter, op: %s, path: %s", item.Op, item.Path)
+			ok = t.DeadLetters.Remove(item.Remote, item.Op, item.Path) == nil
+		case item.Op == enum.OpPut:
+			ok = t.Put(ctx, item.Path)
+		default:
+			ok = t.Remove(ctx, item.Path)
+		}
+		if ok {
+			retried++
+		} else {
+			failed++
+		}
+	}
+	return retried, failed, nil
+}
+
+// matchPaths 判断路径是否在任一指定路径下，未指定时全部匹配
+func matchPaths(paths []string, path string) bool {
+	if len(paths) == 0 {
+		return true
+	}
+	for _, prefix := range paths {
+		if isOverlapPath(prefix, path) {
+			return true
 		}
 	}
+	return false
 }
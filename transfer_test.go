@@ -11,6 +11,7 @@ import (
 	"github.com/jorben/rsync-object-storage/kv"
 	"github.com/jorben/rsync-object-storage/log"
 	"github.com/jorben/rsync-object-storage/mocks"
+	"github.com/jorben/rsync-object-storage/state"
 	"github.com/minio/minio-go/v7"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -23,24 +24,23 @@ func init() {
 
 // TestNewTransfer 测试 Transfer 创建
 func TestNewTransfer(t *testing.T) {
-	putCh := make(chan string, 10)
-	deleteCh := make(chan string, 10)
+	scheduler := newTestScheduler(t)
 	mockClient := new(mocks.MockObjectStorageClient)
 
 	storage := &Storage{
-		Client:       mockClient,
-		Bucket:       "test-bucket",
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
 		LocalPrefix:  "/data/local",
 		RemotePrefix: "remote",
 	}
 
 	cfg := createTestConfig()
-	transfer := NewTransfer(cfg, putCh, deleteCh, storage)
+	transfer := NewTransfer(cfg, scheduler, storage)
 
 	assert.NotNil(t, transfer)
 	assert.Equal(t, "/data/local", transfer.LocalPrefix)
 	assert.Equal(t, "remote", transfer.RemotePrefix)
 	assert.Equal(t, 5*time.Minute, transfer.HotDelay)
+	assert.Same(t, scheduler, transfer.Scheduler)
 }
 
 // TestTransfer_Run_Put 测试 Put 操作
@@ -54,21 +54,19 @@ func TestTransfer_Run_Put(t *testing.T) {
 	err := os.WriteFile(testFile, []byte("test content"), 0644)
 	assert.NoError(t, err)
 
-	putCh := make(chan string, 10)
-	deleteCh := make(chan string, 10)
+	scheduler := newTestScheduler(t)
 	mockClient := new(mocks.MockObjectStorageClient)
 
 	// Mock StatObject 返回错误（文件不存在于远程）
 	mockClient.On("StatObject", mock.Anything, "test-bucket", mock.Anything, minio.StatObjectOptions{}).
 		Return(minio.ObjectInfo{}, assert.AnError)
 
-	// Mock FPutObject 成功
-	mockClient.On("FPutObject", mock.Anything, "test-bucket", mock.Anything, mock.Anything, minio.PutObjectOptions{}).
+	// Mock PutObject 成功
+	mockClient.On("PutObject", mock.Anything, "test-bucket", mock.Anything, mock.Anything, mock.Anything, minio.PutObjectOptions{}).
 		Return(minio.UploadInfo{}, nil)
 
 	storage := &Storage{
-		Client:       mockClient,
-		Bucket:       "test-bucket",
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
 		LocalPrefix:  tmpDir,
 		RemotePrefix: "remote",
 		SymLink:      enum.SymlinkSkip,
@@ -78,8 +76,7 @@ func TestTransfer_Run_Put(t *testing.T) {
 		LocalPrefix:  tmpDir,
 		RemotePrefix: "remote",
 		HotDelay:     5 * time.Minute,
-		PutChan:      putCh,
-		DeleteChan:   deleteCh,
+		Scheduler:    scheduler,
 		Storage:      storage,
 	}
 
@@ -93,18 +90,60 @@ func TestTransfer_Run_Put(t *testing.T) {
 	}()
 
 	// 发送 Put 任务
-	putCh <- testFile
+	assert.NoError(t, scheduler.Push(enum.OpPut, true, testFile))
 
-	// 等待处理
-	time.Sleep(100 * time.Millisecond)
-
-	// 取消并等待退出
-	cancel()
-	close(putCh)
-	close(deleteCh)
+	// 关闭队列，处理完剩余的任务后退出
+	scheduler.Close()
 	<-done
+	cancel()
 
 	mockClient.AssertExpectations(t)
+	assert.Equal(t, int64(1), transfer.Stats.Uploaded.Load())
+	assert.Equal(t, int64(0), transfer.Stats.Failed.Load())
+	// 处理完成的任务已确认
+	n, err := scheduler.Len()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+// TestTransfer_Run_Interrupted 测试退出时被中断的任务不确认
+func TestTransfer_Run_Interrupted(t *testing.T) {
+	kv.ResetForTest()
+	defer kv.Stop()
+
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	assert.NoError(t, os.WriteFile(testFile, []byte("test content"), 0644))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("StatObject", mock.Anything, "test-bucket", mock.Anything, minio.StatObjectOptions{}).
+		Return(minio.ObjectInfo{}, assert.AnError)
+	// 上传过程中收到退出信号
+	mockClient.On("PutObject", mock.Anything, "test-bucket", mock.Anything, mock.Anything, mock.Anything, minio.PutObjectOptions{}).
+		Run(func(mock.Arguments) { cancel() }).
+		Return(minio.UploadInfo{}, context.Canceled)
+
+	scheduler := newTestScheduler(t)
+	transfer := &Transfer{
+		LocalPrefix:  tmpDir,
+		RemotePrefix: "remote",
+		HotDelay:     5 * time.Minute,
+		Scheduler:    scheduler,
+		Storage: &Storage{
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
+			LocalPrefix:  tmpDir,
+			RemotePrefix: "remote",
+			SymLink:      enum.SymlinkSkip,
+		},
+	}
+	assert.NoError(t, scheduler.Push(enum.OpPut, true, testFile))
+	transfer.Run(ctx)
+
+	// 任务保留在队列中，重启后重新执行
+	n, err := scheduler.Len()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
 }
 
 // TestTransfer_Run_Delete 测试 Delete 操作
@@ -117,8 +156,7 @@ func TestTransfer_Run_Delete(t *testing.T) {
 	// 注意：删除测试时文件不应该存在
 	nonExistentFile := filepath.Join(tmpDir, "deleted.txt")
 
-	putCh := make(chan string, 10)
-	deleteCh := make(chan string, 10)
+	scheduler := newTestScheduler(t)
 	mockClient := new(mocks.MockObjectStorageClient)
 
 	// Mock ListObjects 返回空
@@ -134,8 +172,7 @@ func TestTransfer_Run_Delete(t *testing.T) {
 		Return((<-chan minio.RemoveObjectError)(removeCh))
 
 	storage := &Storage{
-		Client:       mockClient,
-		Bucket:       "test-bucket",
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
 		LocalPrefix:  tmpDir,
 		RemotePrefix: "remote",
 		SymLink:      enum.SymlinkSkip,
@@ -145,8 +182,7 @@ func TestTransfer_Run_Delete(t *testing.T) {
 		LocalPrefix:  tmpDir,
 		RemotePrefix: "remote",
 		HotDelay:     5 * time.Minute,
-		PutChan:      putCh,
-		DeleteChan:   deleteCh,
+		Scheduler:    scheduler,
 		Storage:      storage,
 	}
 
@@ -160,16 +196,12 @@ func TestTransfer_Run_Delete(t *testing.T) {
 	}()
 
 	// 发送 Delete 任务
-	deleteCh <- nonExistentFile
-
-	// 等待处理
-	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, scheduler.Push(enum.OpDelete, true, nonExistentFile))
 
-	// 取消并等待退出
-	cancel()
-	close(putCh)
-	close(deleteCh)
+	// 关闭队列，处理完剩余的任务后退出
+	scheduler.Close()
 	<-done
+	cancel()
 
 	mockClient.AssertExpectations(t)
 }
@@ -179,13 +211,11 @@ func TestTransfer_Run_ContextCancel(t *testing.T) {
 	kv.ResetForTest()
 	defer kv.Stop()
 
-	putCh := make(chan string, 10)
-	deleteCh := make(chan string, 10)
+	scheduler := newTestScheduler(t)
 	mockClient := new(mocks.MockObjectStorageClient)
 
 	storage := &Storage{
-		Client:       mockClient,
-		Bucket:       "test-bucket",
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
 		LocalPrefix:  "/data",
 		RemotePrefix: "remote",
 	}
@@ -194,8 +224,7 @@ func TestTransfer_Run_ContextCancel(t *testing.T) {
 		LocalPrefix:  "/data",
 		RemotePrefix: "remote",
 		HotDelay:     5 * time.Minute,
-		PutChan:      putCh,
-		DeleteChan:   deleteCh,
+		Scheduler:    scheduler,
 		Storage:      storage,
 	}
 
@@ -219,18 +248,16 @@ func TestTransfer_Run_ContextCancel(t *testing.T) {
 	}
 }
 
-// TestTransfer_Run_ChannelClose 测试 Channel 关闭
-func TestTransfer_Run_ChannelClose(t *testing.T) {
+// TestTransfer_Run_QueueClose 测试队列关闭
+func TestTransfer_Run_QueueClose(t *testing.T) {
 	kv.ResetForTest()
 	defer kv.Stop()
 
-	putCh := make(chan string, 10)
-	deleteCh := make(chan string, 10)
+	scheduler := newTestScheduler(t)
 	mockClient := new(mocks.MockObjectStorageClient)
 
 	storage := &Storage{
-		Client:       mockClient,
-		Bucket:       "test-bucket",
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
 		LocalPrefix:  "/data",
 		RemotePrefix: "remote",
 	}
@@ -239,8 +266,7 @@ func TestTransfer_Run_ChannelClose(t *testing.T) {
 		LocalPrefix:  "/data",
 		RemotePrefix: "remote",
 		HotDelay:     5 * time.Minute,
-		PutChan:      putCh,
-		DeleteChan:   deleteCh,
+		Scheduler:    scheduler,
 		Storage:      storage,
 	}
 
@@ -252,15 +278,15 @@ func TestTransfer_Run_ChannelClose(t *testing.T) {
 		done <- true
 	}()
 
-	// 关闭 PutChan
-	close(putCh)
+	// 关闭队列
+	scheduler.Close()
 
 	// 应该快速退出
 	select {
 	case <-done:
 		// 成功退出
 	case <-time.After(time.Second):
-		t.Fatal("Transfer 未能响应 Channel 关闭")
+		t.Fatal("Transfer 未能响应队列关闭")
 	}
 }
 
@@ -272,13 +298,11 @@ func TestTransfer_Run_SkipNonExistent(t *testing.T) {
 	tmpDir := t.TempDir()
 	nonExistentFile := filepath.Join(tmpDir, "nonexistent.txt")
 
-	putCh := make(chan string, 10)
-	deleteCh := make(chan string, 10)
+	scheduler := newTestScheduler(t)
 	mockClient := new(mocks.MockObjectStorageClient)
 
 	storage := &Storage{
-		Client:       mockClient,
-		Bucket:       "test-bucket",
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
 		LocalPrefix:  tmpDir,
 		RemotePrefix: "remote",
 		SymLink:      enum.SymlinkSkip,
@@ -288,8 +312,7 @@ func TestTransfer_Run_SkipNonExistent(t *testing.T) {
 		LocalPrefix:  tmpDir,
 		RemotePrefix: "remote",
 		HotDelay:     5 * time.Minute,
-		PutChan:      putCh,
-		DeleteChan:   deleteCh,
+		Scheduler:    scheduler,
 		Storage:      storage,
 	}
 
@@ -302,19 +325,15 @@ func TestTransfer_Run_SkipNonExistent(t *testing.T) {
 	}()
 
 	// 发送不存在的文件
-	putCh <- nonExistentFile
-
-	// 等待处理
-	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, scheduler.Push(enum.OpPut, true, nonExistentFile))
 
-	// 取消并等待退出
-	cancel()
-	close(putCh)
-	close(deleteCh)
+	// 关闭队列，处理完剩余的任务后退出
+	scheduler.Close()
 	<-done
+	cancel()
 
-	// 不存在的文件不应该调用 FPutObject
-	mockClient.AssertNotCalled(t, "FPutObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	// 不存在的文件不应该调用 PutObject
+	mockClient.AssertNotCalled(t, "PutObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 }
 
 // TestTransfer_Run_SkipExistingPath 测试删除时跳过仍存在的路径
@@ -327,13 +346,11 @@ func TestTransfer_Run_SkipExistingPath(t *testing.T) {
 	err := os.WriteFile(existingFile, []byte("content"), 0644)
 	assert.NoError(t, err)
 
-	putCh := make(chan string, 10)
-	deleteCh := make(chan string, 10)
+	scheduler := newTestScheduler(t)
 	mockClient := new(mocks.MockObjectStorageClient)
 
 	storage := &Storage{
-		Client:       mockClient,
-		Bucket:       "test-bucket",
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
 		LocalPrefix:  tmpDir,
 		RemotePrefix: "remote",
 	}
@@ -342,8 +359,7 @@ func TestTransfer_Run_SkipExistingPath(t *testing.T) {
 		LocalPrefix:  tmpDir,
 		RemotePrefix: "remote",
 		HotDelay:     5 * time.Minute,
-		PutChan:      putCh,
-		DeleteChan:   deleteCh,
+		Scheduler:    scheduler,
 		Storage:      storage,
 	}
 
@@ -356,16 +372,12 @@ func TestTransfer_Run_SkipExistingPath(t *testing.T) {
 	}()
 
 	// 发送仍存在的文件到删除队列
-	deleteCh <- existingFile
-
-	// 等待处理
-	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, scheduler.Push(enum.OpDelete, true, existingFile))
 
-	// 取消并等待退出
-	cancel()
-	close(putCh)
-	close(deleteCh)
+	// 关闭队列，处理完剩余的任务后退出
+	scheduler.Close()
 	<-done
+	cancel()
 
 	// 仍存在的路径不应该调用 RemoveObjects
 	mockClient.AssertNotCalled(t, "RemoveObjects", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
@@ -388,21 +400,19 @@ func TestTransfer_Run_DirectoryWalk(t *testing.T) {
 		assert.NoError(t, err)
 	}
 
-	putCh := make(chan string, 10)
-	deleteCh := make(chan string, 10)
+	scheduler := newTestScheduler(t)
 	mockClient := new(mocks.MockObjectStorageClient)
 
 	// Mock 所有 StatObject 调用返回错误（文件不存在于远程）
 	mockClient.On("StatObject", mock.Anything, "test-bucket", mock.Anything, minio.StatObjectOptions{}).
 		Return(minio.ObjectInfo{}, assert.AnError)
 
-	// Mock 所有 FPutObject 调用成功
-	mockClient.On("FPutObject", mock.Anything, "test-bucket", mock.Anything, mock.Anything, minio.PutObjectOptions{}).
+	// Mock 所有 PutObject 调用成功
+	mockClient.On("PutObject", mock.Anything, "test-bucket", mock.Anything, mock.Anything, mock.Anything, minio.PutObjectOptions{}).
 		Return(minio.UploadInfo{}, nil)
 
 	storage := &Storage{
-		Client:       mockClient,
-		Bucket:       "test-bucket",
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
 		LocalPrefix:  tmpDir,
 		RemotePrefix: "remote",
 		SymLink:      enum.SymlinkSkip,
@@ -412,8 +422,7 @@ func TestTransfer_Run_DirectoryWalk(t *testing.T) {
 		LocalPrefix:  tmpDir,
 		RemotePrefix: "remote",
 		HotDelay:     5 * time.Minute,
-		PutChan:      putCh,
-		DeleteChan:   deleteCh,
+		Scheduler:    scheduler,
 		Storage:      storage,
 	}
 
@@ -426,17 +435,201 @@ func TestTransfer_Run_DirectoryWalk(t *testing.T) {
 	}()
 
 	// 发送目录
-	putCh <- subDir
+	assert.NoError(t, scheduler.Push(enum.OpPut, true, subDir))
 
-	// 等待处理
-	time.Sleep(200 * time.Millisecond)
-
-	// 取消并等待退出
-	cancel()
-	close(putCh)
-	close(deleteCh)
+	// 关闭队列，处理完剩余的任务后退出
+	scheduler.Close()
 	<-done
+	cancel()
 
 	// 应该调用多次 FPutObject（目录 + 文件）
 	mockClient.AssertExpectations(t)
 }
+
+// newRetryTransfer 创建带重试策略和死信列表的 Transfer
+func newRetryTransfer(t *testing.T, mockClient *mocks.MockObjectStorageClient, localPrefix string) *Transfer {
+	deadLetters, err := state.OpenDeadLetters(filepath.Join(t.TempDir(), "deadletter.json"))
+	assert.NoError(t, err)
+	return &Transfer{
+		Name:         "test-remote",
+		LocalPrefix:  localPrefix,
+		RemotePrefix: "remote",
+		HotDelay:     5 * time.Minute,
+		Storage: &Storage{
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
+			LocalPrefix:  localPrefix,
+			RemotePrefix: "remote",
+			SymLink:      enum.SymlinkSkip,
+		},
+		Retry:       RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond, MaxDelay: 4 * time.Millisecond},
+		DeadLetters: deadLetters,
+	}
+}
+
+// TestTransfer_Put_Retry 测试失败重试与死信列表
+func TestTransfer_Put_Retry(t *testing.T) {
+	kv.ResetForTest()
+	defer kv.Stop()
+
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	assert.NoError(t, os.WriteFile(testFile, []byte("test content"), 0644))
+	ctx := context.Background()
+	slowDown := minio.ErrorResponse{Code: "SlowDown", StatusCode: 503}
+
+	t.Run("临时错误重试后成功", func(t *testing.T) {
+		mockClient := new(mocks.MockObjectStorageClient)
+		mockClient.On("StatObject", mock.Anything, "test-bucket", mock.Anything, minio.StatObjectOptions{}).
+			Return(minio.ObjectInfo{}, assert.AnError)
+		mockClient.On("PutObject", mock.Anything, "test-bucket", mock.Anything, mock.Anything, mock.Anything, minio.PutObjectOptions{}).
+			Return(minio.UploadInfo{}, slowDown).Twice()
+		mockClient.On("PutObject", mock.Anything, "test-bucket", mock.Anything, mock.Anything, mock.Anything, minio.PutObjectOptions{}).
+			Return(minio.UploadInfo{}, nil).Once()
+		transfer := newRetryTransfer(t, mockClient, tmpDir)
+		// 之前失败的记录在成功后被删除
+		assert.NoError(t, transfer.DeadLetters.Add(state.DeadLetter{Op: enum.OpPut, Path: testFile, Remote: "test-remote"}))
+
+		assert.True(t, transfer.Put(ctx, testFile))
+		mockClient.AssertNumberOfCalls(t, "PutObject", 3)
+		assert.Equal(t, int64(1), transfer.Stats.Uploaded.Load())
+		items, err := transfer.DeadLetters.List()
+		assert.NoError(t, err)
+		assert.Empty(t, items)
+	})
+
+	t.Run("重试耗尽后进入死信列表", func(t *testing.T) {
+		mockClient := new(mocks.MockObjectStorageClient)
+		mockClient.On("StatObject", mock.Anything, "test-bucket", mock.Anything, minio.StatObjectOptions{}).
+			Return(minio.ObjectInfo{}, assert.AnError)
+		mockClient.On("PutObject", mock.Anything, "test-bucket", mock.Anything, mock.Anything, mock.Anything, minio.PutObjectOptions{}).
+			Return(minio.UploadInfo{}, slowDown)
+		transfer := newRetryTransfer(t, mockClient, tmpDir)
+
+		assert.False(t, transfer.Put(ctx, testFile))
+		mockClient.AssertNumberOfCalls(t, "PutObject", 3)
+		assert.Equal(t, int64(1), transfer.Stats.Failed.Load())
+		items, err := transfer.DeadLetters.List()
+		assert.NoError(t, err)
+		assert.Len(t, items, 1)
+		assert.Equal(t, enum.OpPut, items[0].Op)
+		assert.Equal(t, testFile, items[0].Path)
+		assert.Equal(t, "test-remote", items[0].Remote)
+		assert.Equal(t, 3, items[0].Attempts)
+	})
+
+	t.Run("永久错误不重试", func(t *testing.T) {
+		mockClient := new(mocks.MockObjectStorageClient)
+		mockClient.On("StatObject", mock.Anything, "test-bucket", mock.Anything, minio.StatObjectOptions{}).
+			Return(minio.ObjectInfo{}, assert.AnError)
+		mockClient.On("PutObject", mock.Anything, "test-bucket", mock.Anything, mock.Anything, mock.Anything, minio.PutObjectOptions{}).
+			Return(minio.UploadInfo{}, minio.ErrorResponse{Code: "AccessDenied", StatusCode: 403})
+		transfer := newRetryTransfer(t, mockClient, tmpDir)
+
+		assert.False(t, transfer.Put(ctx, testFile))
+		mockClient.AssertNumberOfCalls(t, "PutObject", 1)
+		items, err := transfer.DeadLetters.List()
+		assert.NoError(t, err)
+		assert.Len(t, items, 1)
+		assert.Equal(t, 1, items[0].Attempts)
+		assert.Contains(t, items[0].Error, "Access")
+	})
+}
+
+// TestTransfer_RetryDeadLetters 测试重新执行死信记录
+func TestTransfer_RetryDeadLetters(t *testing.T) {
+	kv.ResetForTest()
+	defer kv.Stop()
+
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "test.txt")
+	assert.NoError(t, os.WriteFile(testFile, []byte("test content"), 0644))
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("StatObject", mock.Anything, "test-bucket", mock.Anything, minio.StatObjectOptions{}).
+		Return(minio.ObjectInfo{}, assert.AnError)
+	mockClient.On("PutObject", mock.Anything, "test-bucket", mock.Anything, mock.Anything, mock.Anything, minio.PutObjectOptions{}).
+		Return(minio.UploadInfo{}, nil)
+	transfer := newRetryTransfer(t, mockClient, tmpDir)
+
+	deadLetters := []state.DeadLetter{
+		{Op: enum.OpPut, Path: testFile, Remote: "test-remote"},
+		// 本地已删除的文件不再上传
+		{Op: enum.OpPut, Path: filepath.Join(tmpDir, "gone.txt"), Remote: "test-remote"},
+		// 其他远端的记录不处理
+		{Op: enum.OpPut, Path: testFile, Remote: "other-remote"},
+		// 不在指定路径下的记录不处理
+		{Op: enum.OpDelete, Path: "/elsewhere/old.txt", Remote: "test-remote"},
+	}
+	for _, item := range deadLetters {
+		assert.NoError(t, transfer.DeadLetters.Add(item))
+	}
+
+	retried, failed, err := transfer.RetryDeadLetters(context.Background(), []string{tmpDir})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, retried)
+	assert.Equal(t, 0, failed)
+	mockClient.AssertNumberOfCalls(t, "PutObject", 1)
+
+	items, err := transfer.DeadLetters.List()
+	assert.NoError(t, err)
+	assert.Len(t, items, 2)
+	for _, item := range items {
+		assert.True(t, item.Remote == "other-remote" || item.Path == "/elsewhere/old.txt")
+	}
+}
+
+// TestTransfer_Rename 测试重命名时服务端复制内容一致的文件，不一致的文件重新上传，之后删除旧路径
+func TestTransfer_Rename(t *testing.T) {
+	kv.ResetForTest()
+	defer kv.Stop()
+
+	tmpDir := t.TempDir()
+	from, to := filepath.Join(tmpDir, "photos"), filepath.Join(tmpDir, "album")
+	assert.NoError(t, os.Mkdir(to, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(to, "a.txt"), []byte("hello"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(to, "b.txt"), []byte("edited"), 0644))
+
+	ctx := context.Background()
+	mockClient := new(mocks.MockObjectStorageClient)
+	// 新路径还没有对象，旧路径的 a.txt 与本地一致，b.txt 重命名后被修改
+	for _, name := range []string{"remote/album/a.txt", "remote/album/b.txt"} {
+		mockClient.On("StatObject", ctx, "test-bucket", name, minio.StatObjectOptions{}).
+			Return(minio.ObjectInfo{}, assert.AnError)
+	}
+	mockClient.On("StatObject", ctx, "test-bucket", "remote/photos/a.txt", minio.StatObjectOptions{}).
+		Return(minio.ObjectInfo{Key: "remote/photos/a.txt", ETag: "5d41402abc4b2a76b9719d911017c592"}, nil)
+	mockClient.On("StatObject", ctx, "test-bucket", "remote/photos/b.txt", minio.StatObjectOptions{}).
+		Return(minio.ObjectInfo{Key: "remote/photos/b.txt", ETag: "d41d8cd98f00b204e9800998ecf8427e"}, nil)
+	mockClient.On("CopyObject", ctx, minio.CopyDestOptions{Bucket: "test-bucket", Object: "remote/album/a.txt"},
+		minio.CopySrcOptions{Bucket: "test-bucket", Object: "remote/photos/a.txt"}).
+		Return(minio.UploadInfo{}, nil)
+	mockClient.On("PutObject", ctx, "test-bucket", "remote/album/b.txt", mock.Anything, int64(6), minio.PutObjectOptions{}).
+		Return(minio.UploadInfo{}, nil)
+	mockClient.On("ListObjects", ctx, "test-bucket", minio.ListObjectsOptions{Prefix: "remote/photos", Recursive: true}).
+		Return(objectsChan(minio.ObjectInfo{Key: "remote/photos/a.txt"}, minio.ObjectInfo{Key: "remote/photos/b.txt"}))
+	var removed []string
+	mockClient.On("RemoveObjects", ctx, "test-bucket", mock.Anything, minio.RemoveObjectsOptions{GovernanceBypass: true}).
+		Run(func(args mock.Arguments) {
+			for object := range args.Get(2).(<-chan minio.ObjectInfo) {
+				removed = append(removed, object.Key)
+			}
+		}).Return(nil)
+
+	transfer := &Transfer{
+		Name: "test-remote",
+		Storage: &Storage{
+			Backend:      NewS3Backend(mockClient, "test-bucket"),
+			LocalPrefix:  tmpDir,
+			RemotePrefix: "remote",
+			SymLink:      enum.SymlinkSkip,
+			TempDir:      t.TempDir(),
+		},
+	}
+	transfer.handleRename(ctx, from, to)
+
+	mockClient.AssertExpectations(t)
+	assert.Equal(t, []string{"remote/photos/a.txt", "remote/photos/b.txt"}, removed)
+	assert.Equal(t, int64(1), transfer.Stats.Copied.Load())
+	assert.Equal(t, int64(1), transfer.Stats.Uploaded.Load())
+	assert.Equal(t, int64(1), transfer.Stats.Removed.Load())
+	assert.Equal(t, int64(0), transfer.Stats.Failed.Load())
+}
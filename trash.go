@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jorben/rsync-object-storage/config"
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/jorben/rsync-object-storage/log"
+)
+
+// TrashItem 回收站中的对象
+type TrashItem struct {
+	Date       string // 删除日期
+	ObjectName string // 回收站中的对象名
+	RemotePath string // 删除前的对象名
+	Size       int64
+	ETag       string
+	// LastModified 移入回收站的时间，恢复后作为本地文件的修改时间
+	LastModified time.Time
+}
+
+// Trash 回收站，定期清理超过保留天数的对象，并支持把对象恢复到原路径
+type Trash struct {
+	Enable    bool
+	Retention int
+	Storage   *Storage
+	Restore   *Restore
+}
+
+// NewTrash 创建回收站实例
+func NewTrash(c *config.SyncConfig, storage *Storage) *Trash {
+	return &Trash{
+		Enable:    c.Sync.Trash.Enable,
+		Retention: c.Sync.Trash.Retention,
+		Storage:   storage,
+		Restore:   NewRestore(c, storage),
+	}
+}
+
+// Run 回收站清理任务启动入口，启动时及之后每天清理一次过期对象
+// 支持通过context取消实现优雅退出
+func (t *Trash) Run(ctx context.Context) {
+	if !t.Enable {
+		log.Debug("The trash is disabled")
+		<-ctx.Done()
+		return
+	}
+
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+	for {
+		if err := t.Purge(ctx, time.Now()); err != nil {
+			log.Errorf("Purge trash err: %s", err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			log.Debug("Trash received shutdown signal, exiting...")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge 删除删除日期早于保留天数的回收站对象
+func (t *Trash) Purge(ctx context.Context, now time.Time) error {
+	items, err := t.List(ctx)
+	if err != nil {
+		return err
+	}
+	expireAt := now.UTC().AddDate(0, 0, -t.Retention).Format(trashDateFormat)
+	ch := make(chan ObjectInfo)
+	go func() {
+		defer close(ch)
+		for _, item := range items {
+			// 日期格式可以直接按字符串比较
+			if item.Date >= expireAt {
+				continue
+			}
+			if t.Storage.DryRun {
+				log.Infof("Dry-run: would purge %s, size: %d, reason: expired", item.ObjectName, item.Size)
+				continue
+			}
+			select {
+			case ch <- ObjectInfo{Key: item.ObjectName}:
+				log.Infof("Purge expired trash %s", item.ObjectName)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var someError error
+	for err := range t.Storage.Backend.RemoveObjects(ctx, ch) {
+		someError = err.Err
+		log.Errorf("RemoveObjects err: %s, path: %s", err.Err.Error(), err.ObjectName)
+	}
+	return someError
+}
+
+// List 列出回收站中的对象，按删除日期和路径排序
+func (t *Trash) List(ctx context.Context) ([]TrashItem, error) {
+	root := t.Storage.GetTrashRoot()
+	var items []TrashItem
+	for object := range t.Storage.ListPrefix(ctx, root, true) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		date, key, ok := strings.Cut(strings.TrimPrefix(object.Key, root), "/")
+		if !ok {
+			continue
+		}
+		if _, err := time.Parse(trashDateFormat, date); err != nil {
+			continue
+		}
+		items = append(items, TrashItem{
+			Date:         date,
+			ObjectName:   object.Key,
+			RemotePath:   t.Storage.GetRemoteRoot() + key,
+			Size:         object.Size,
+			ETag:         object.ETag,
+			LastModified: object.LastModified,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Date != items[j].Date {
+			return items[i].Date < items[j].Date
+		}
+		return items[i].ObjectName < items[j].ObjectName
+	})
+	return items, nil
+}
+
+// errTrashNotFound 回收站中不存在匹配的对象
+var errTrashNotFound = errors.New("no matching object in trash")
+
+// RestorePath 把回收站中指定本地路径（文件或目录）下的对象恢复到原路径并下载到本地
+// date为空时使用包含该路径的最近一次删除
+func (t *Trash) RestorePath(ctx context.Context, localPath, date string) (int, error) {
+	items, err := t.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	remotePath := t.Storage.GetRemotePath(localPath)
+	var matched []TrashItem
+	for _, item := range items {
+		if date != "" && item.Date != date {
+			continue
+		}
+		if item.RemotePath == remotePath || item.RemotePath == remotePath+".link" ||
+			strings.HasPrefix(item.RemotePath, strings.TrimSuffix(remotePath, "/")+"/") {
+			matched = append(matched, item)
+		}
+	}
+	if len(matched) == 0 {
+		return 0, errTrashNotFound
+	}
+	if date == "" {
+		// 列表已按日期排序，只恢复最近一次删除的版本
+		date = matched[len(matched)-1].Date
+	}
+
+	restored := 0
+	for _, item := range matched {
+		if item.Date != date {
+			continue
+		}
+		if err := t.restoreItem(ctx, item); err != nil {
+			return restored, fmt.Errorf("restore %s err: %s", item.ObjectName, err.Error())
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+// restoreItem 服务端复制回原路径，下载到本地后从回收站删除
+func (t *Trash) restoreItem(ctx context.Context, item TrashItem) error {
+	if t.Storage.DryRun {
+		log.Infof("Dry-run: would restore %s -> %s", item.ObjectName, item.RemotePath)
+		return nil
+	}
+	if err := t.Storage.CopyObject(ctx, item.ObjectName, item.RemotePath, item.Size); err != nil {
+		return err
+	}
+	// 按对象类型（空目录、符号链接、普通文件）恢复到本地
+	err := t.Restore.restoreObject(ctx, ObjectInfo{
+		Key: item.RemotePath, Size: item.Size, ETag: item.ETag, LastModified: item.LastModified})
+	if err != nil && !errors.Is(err, enum.ErrSkipTransfer) {
+		return err
+	}
+	if err = t.Storage.Backend.RemoveObject(ctx, item.ObjectName); err != nil {
+		return err
+	}
+	localPath, _ := t.Storage.GetLocalPath(item.RemotePath)
+	log.Infof("Restore from trash success, path: %s", localPath)
+	return nil
+}
+
+// trashCommand trash 子命令入口，返回进程退出码
+// 用法：ros trash list [-c config] [-job name]
+//
+//	ros trash restore [-c config] [-job name] [-date YYYY-MM-DD] <path>
+func trashCommand(args []string) int {
+	usage := "Usage: ros trash list [-c config] [-job name]\n" +
+		"       ros trash restore [-c config] [-job name] [-date YYYY-MM-DD] <path>"
+	if len(args) == 0 || (args[0] != "list" && args[0] != "restore") {
+		fmt.Println(usage)
+		return 2
+	}
+	action := args[0]
+	flags := flag.NewFlagSet("trash "+action, flag.ExitOnError)
+	configPath := flags.String("c", "./config.yaml", "Path to the configuration file")
+	jobName := flags.String("job", "", "Only handle the sync job with this `NAME`")
+	date := flags.String("date", "", "Restore the version deleted on this `DATE`, defaults to the latest")
+	positional, err := parseArgs(flags, args[1:])
+	if err != nil {
+		return 2
+	}
+	if action == "restore" && len(positional) != 1 {
+		fmt.Println(usage)
+		return 2
+	}
+
+	c, err := config.GetConfig(*configPath)
+	if err != nil {
+		fmt.Printf("Load config err: %s\n", err.Error())
+		return 1
+	}
+
+	// 初始化日志
+	log.InitLogger(c.Log)
+	defer log.GetLogger().Sync()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if action == "list" {
+		fmt.Fprintln(w, "JOB\tREMOTE\tDATE\tPATH\tSIZE")
+	}
+	for _, job := range c.GetJobs() {
+		if *jobName != "" && job.Name != *jobName {
+			continue
+		}
+		localPath := ""
+		if action == "restore" {
+			// 相对路径按 local.path 解析
+			localPath = positional[0]
+			if !filepath.IsAbs(localPath) {
+				localPath = filepath.Join(job.Local.Path, localPath)
+			}
+			if !isOverlapPath(job.Local.Path, localPath) {
+				continue
+			}
+		}
+		for _, destination := range job.GetDestinations() {
+			s, err := NewStorage(destination)
+			if err != nil {
+				log.Errorf("NewStorage err: %s", err.Error())
+				return 1
+			}
+			t := NewTrash(destination, s)
+			remote := destination.Remote.GetName()
+
+			if action == "list" {
+				items, err := t.List(ctx)
+				if err != nil {
+					log.Errorf("List trash err: %s", err.Error())
+					return 1
+				}
+				for _, item := range items {
+					localPath, err := s.GetLocalPath(item.RemotePath)
+					if err != nil {
+						// 映射到本地路径之外的对象显示远端路径
+						localPath = item.RemotePath
+					}
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", job.Name, remote, item.Date,
+						localPath, helper.ByteFormat(item.Size))
+				}
+				continue
+			}
+
+			// 从第一个存在匹配对象的远端恢复
+			restored, err := t.RestorePath(ctx, filepath.Clean(localPath), *date)
+			if errors.Is(err, errTrashNotFound) {
+				log.Infof("Nothing to restore from trash, remote: %s", remote)
+				continue
+			}
+			if err != nil {
+				log.Errorf("Restore trash err: %s, remote: %s", err.Error(), remote)
+				return 1
+			}
+			log.Infof("Restore from trash ends, remote: %s, restored: %d", remote, restored)
+			return 0
+		}
+	}
+	if action == "restore" {
+		log.Errorf("Restore trash err: %s, path: %s", errTrashNotFound.Error(), positional[0])
+		return 1
+	}
+	_ = w.Flush()
+	return 0
+}
+
+// isOverlapPath 判断路径是否在本地路径下
+func isOverlapPath(localPrefix, path string) bool {
+	localPrefix, path = filepath.Clean(localPrefix), filepath.Clean(path)
+	return path == localPrefix || strings.HasPrefix(path, localPrefix+string(filepath.Separator))
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jorben/rsync-object-storage/enum"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/jorben/rsync-object-storage/mocks"
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// newTestTrash 创建测试用的 Trash 实例
+func newTestTrash(mockClient *mocks.MockObjectStorageClient, localPrefix string) *Trash {
+	storage := &Storage{
+		Backend:      NewS3Backend(mockClient, "test-bucket"),
+		LocalPrefix:  localPrefix,
+		RemotePrefix: "remote",
+		SymLink:      enum.SymlinkSkip,
+		Trash:        true,
+	}
+	return &Trash{
+		Enable:    true,
+		Retention: 30,
+		Storage:   storage,
+		Restore: &Restore{
+			LocalPrefix:   localPrefix,
+			SymLink:       enum.SymlinkSkip,
+			IgnoreMatcher: helper.NewIgnoreMatcher(nil),
+			Storage:       storage,
+		},
+	}
+}
+
+// TestTrash_List 测试列出回收站对象，忽略不符合日期目录格式的对象
+func TestTrash_List(t *testing.T) {
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket",
+		minio.ListObjectsOptions{Prefix: "remote/.trash/", Recursive: true}).
+		Return(objectsChan(
+			minio.ObjectInfo{Key: "remote/.trash/2026-10-02/b.txt", Size: 2},
+			minio.ObjectInfo{Key: "remote/.trash/2026-10-01/a/c.txt", Size: 1},
+			minio.ObjectInfo{Key: "remote/.trash/unknown/d.txt", Size: 3},
+			minio.ObjectInfo{Key: "remote/.trash/e.txt", Size: 4},
+		))
+
+	items, err := newTestTrash(mockClient, "/data/local").List(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, []TrashItem{
+		{Date: "2026-10-01", ObjectName: "remote/.trash/2026-10-01/a/c.txt", RemotePath: "remote/a/c.txt", Size: 1},
+		{Date: "2026-10-02", ObjectName: "remote/.trash/2026-10-02/b.txt", RemotePath: "remote/b.txt", Size: 2},
+	}, items)
+}
+
+// TestTrash_Purge 测试只清理超过保留天数的对象
+func TestTrash_Purge(t *testing.T) {
+	now := time.Date(2026, 10, 31, 12, 0, 0, 0, time.UTC)
+
+	t.Run("removes expired", func(t *testing.T) {
+		mockClient := new(mocks.MockObjectStorageClient)
+		mockClient.On("ListObjects", mock.Anything, "test-bucket",
+			minio.ListObjectsOptions{Prefix: "remote/.trash/", Recursive: true}).
+			Return(objectsChan(
+				minio.ObjectInfo{Key: "remote/.trash/2026-09-30/old.txt"},
+				minio.ObjectInfo{Key: "remote/.trash/2026-10-01/keep.txt"},
+				minio.ObjectInfo{Key: "remote/.trash/2026-10-30/new.txt"},
+			))
+		var removed []string
+		mockClient.On("RemoveObjects", mock.Anything, "test-bucket", mock.Anything, minio.RemoveObjectsOptions{GovernanceBypass: true}).
+			Run(func(args mock.Arguments) {
+				for object := range args.Get(2).(<-chan minio.ObjectInfo) {
+					removed = append(removed, object.Key)
+				}
+			}).Return(nil)
+
+		err := newTestTrash(mockClient, "/data/local").Purge(context.Background(), now)
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"remote/.trash/2026-09-30/old.txt"}, removed)
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		mockClient := new(mocks.MockObjectStorageClient)
+		mockClient.On("ListObjects", mock.Anything, "test-bucket",
+			minio.ListObjectsOptions{Prefix: "remote/.trash/", Recursive: true}).
+			Return(objectsChan(minio.ObjectInfo{Key: "remote/.trash/2026-09-30/old.txt"}))
+		var removed []string
+		mockClient.On("RemoveObjects", mock.Anything, "test-bucket", mock.Anything, minio.RemoveObjectsOptions{GovernanceBypass: true}).
+			Run(func(args mock.Arguments) {
+				for object := range args.Get(2).(<-chan minio.ObjectInfo) {
+					removed = append(removed, object.Key)
+				}
+			}).Return(nil)
+
+		trash := newTestTrash(mockClient, "/data/local")
+		trash.Storage.DryRun = true
+		err := trash.Purge(context.Background(), now)
+
+		assert.NoError(t, err)
+		assert.Empty(t, removed)
+	})
+}
+
+// TestTrash_RestorePath 测试从回收站恢复目录下最近一次删除的对象
+func TestTrash_RestorePath(t *testing.T) {
+	tmpDir := t.TempDir()
+	lastModified := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket",
+		minio.ListObjectsOptions{Prefix: "remote/.trash/", Recursive: true}).
+		Return(objectsChan(
+			minio.ObjectInfo{Key: "remote/.trash/2026-10-01/docs/a.txt", Size: 1},
+			minio.ObjectInfo{Key: "remote/.trash/2026-10-02/docs/a.txt", Size: 2, LastModified: lastModified},
+			minio.ObjectInfo{Key: "remote/.trash/2026-10-02/docs2/b.txt", Size: 3},
+		))
+	mockClient.On("CopyObject", mock.Anything,
+		minio.CopyDestOptions{Bucket: "test-bucket", Object: "remote/docs/a.txt"},
+		minio.CopySrcOptions{Bucket: "test-bucket", Object: "remote/.trash/2026-10-02/docs/a.txt"}).
+		Return(minio.UploadInfo{}, nil)
+	mockClient.On("StatObject", mock.Anything, "test-bucket", "remote/docs/a.txt", minio.StatObjectOptions{}).
+		Return(minio.ObjectInfo{Key: "remote/docs/a.txt", Size: 2}, nil)
+	mockClient.On("FGetObject", mock.Anything, "test-bucket", "remote/docs/a.txt",
+		filepath.Join(tmpDir, "docs", "a.txt"), minio.GetObjectOptions{}).
+		Run(func(args mock.Arguments) {
+			_ = os.WriteFile(args.String(3), []byte("ab"), 0644)
+		}).Return(nil)
+	mockClient.On("RemoveObject", mock.Anything, "test-bucket", "remote/.trash/2026-10-02/docs/a.txt",
+		minio.RemoveObjectOptions{}).Return(nil)
+
+	restored, err := newTestTrash(mockClient, tmpDir).RestorePath(context.Background(), filepath.Join(tmpDir, "docs"), "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, restored)
+	info, err := os.Stat(filepath.Join(tmpDir, "docs", "a.txt"))
+	assert.NoError(t, err)
+	assert.True(t, info.ModTime().Equal(lastModified))
+	mockClient.AssertExpectations(t)
+}
+
+// TestTrash_RestorePath_NotFound 测试回收站中不存在匹配对象
+func TestTrash_RestorePath_NotFound(t *testing.T) {
+	mockClient := new(mocks.MockObjectStorageClient)
+	mockClient.On("ListObjects", mock.Anything, "test-bucket",
+		minio.ListObjectsOptions{Prefix: "remote/.trash/", Recursive: true}).
+		Return(objectsChan(minio.ObjectInfo{Key: "remote/.trash/2026-10-01/a.txt"}))
+
+	_, err := newTestTrash(mockClient, "/data/local").RestorePath(context.Background(), "/data/local/a.txt", "2026-10-02")
+
+	assert.ErrorIs(t, err, errTrashNotFound)
+}
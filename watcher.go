@@ -11,25 +11,54 @@ import (
 	"github.com/jorben/rsync-object-storage/kv"
 	"github.com/jorben/rsync-object-storage/log"
 	"io/fs"
+	"os"
 	"path/filepath"
 	"time"
 )
 
+// renameWindow Rename事件等待与新路径的Create事件配对的时间，超时后按删除处理
+const renameWindow = time.Second
+
 // delayItem 热点延迟项，记录首次触发时间
 type delayItem struct {
 	firstSeen time.Time
 }
 
+// deleteItem 待删除项，记录删除事件的时间
+type deleteItem struct {
+	deletedAt time.Time
+}
+
+// renameItem 等待配对的重命名旧路径，记录目录的inode或文件在MD5缓存中的inode、大小和MD5
+type renameItem struct {
+	renamedAt time.Time
+	isDir     bool
+	inode     uint64
+	size      int64
+	md5       string
+	paired    bool // 已配对，保留到配对时间结束以忽略目录自身的重复Rename事件
+}
+
+// Rename 本地路径的重命名
+type Rename struct {
+	From string // 旧路径
+	To   string // 新路径
+}
+
 type Watcher struct {
-	Enable        bool
-	Ignore        []string
-	IgnoreMatcher *helper.IgnoreMatcher // 预编译的忽略规则匹配器
-	HotDelay      time.Duration
-	LocalPrefix   string
-	Notify        *fsnotify.Watcher
-	PutChan       chan string
-	DeleteChan    chan string
-	watchListMap  sync.Map // 监听列表 Map，用于 O(1) 查找
+	Enable         bool
+	Ignore         []string
+	IgnoreMatcher  *helper.IgnoreMatcher // 预编译的忽略规则匹配器
+	HotDelay       time.Duration
+	DeleteDelay    time.Duration // 删除事件的宽限期，期间路径重新出现则取消删除
+	LocalPrefix    string
+	Notify         *fsnotify.Watcher
+	PutChan        chan string
+	DeleteChan     chan string
+	RenameChan     chan Rename // 配对成功的重命名，为nil时重命名按删除旧路径、上传新路径处理
+	watchListMap   sync.Map    // 监听列表 Map，用于 O(1) 查找 path -> inode
+	pendingDeletes sync.Map    // 宽限期内的待删除路径 path -> deleteItem{deletedAt}
+	pendingRenames sync.Map    // 等待配对的重命名旧路径 path -> renameItem
 }
 
 func NewWatcher(c *config.SyncConfig, putCh chan string, deleteCh chan string) (*Watcher, error) {
@@ -42,6 +71,7 @@ func NewWatcher(c *config.SyncConfig, putCh chan string, deleteCh chan string) (
 	return &Watcher{
 		Enable:        c.Sync.RealTime.Enable,
 		HotDelay:      time.Duration(c.Sync.RealTime.HotDelay) * time.Minute,
+		DeleteDelay:   time.Duration(c.Sync.RealTime.DeleteDelay) * time.Second,
 		Notify:        notify,
 		PutChan:       putCh,
 		DeleteChan:    deleteCh,
@@ -65,8 +95,12 @@ func (w *Watcher) Add(path string) error {
 				log.Errorf("Watch add err: %s, skipping %s", err.Error(), subPath)
 				return filepath.SkipDir
 			}
-			// 同步更新 watchListMap，用于 O(1) 查找
-			w.watchListMap.Store(subPath, struct{}{})
+			// 同步更新 watchListMap，用于 O(1) 查找，记录inode用于目录重命名的配对
+			var inode uint64
+			if info, err := d.Info(); err == nil {
+				inode = helper.Inode(info)
+			}
+			w.watchListMap.Store(subPath, inode)
 			log.Debugf("Watch add %s", subPath)
 		}
 		return nil
@@ -106,6 +140,14 @@ func (w *Watcher) Watch(ctx context.Context) error {
 		select {
 		case <-ctx.Done():
 			log.Debug("Watcher received shutdown signal, exiting...")
+			// 延迟中的热点文件在退出前丢入变更队列，持久化后重启时继续同步
+			delayKeys.Range(func(key, _ interface{}) bool {
+				w.PutChan <- key.(string)
+				return true
+			})
+			w.flushRenames(time.Now().Add(renameWindow))
+			// 宽限期内的待删除路径同样在退出前丢入删除队列，避免重启后远端删除丢失
+			w.flushDeletes(time.Now().Add(w.DeleteDelay))
 			return nil
 		case event, ok := <-w.Notify.Events:
 			if !ok || event.Has(fsnotify.Chmod) {
@@ -120,12 +162,21 @@ func (w *Watcher) Watch(ctx context.Context) error {
 			// Rename时会产生两个事件，一次旧文件的Rename，一次新文件的Create
 			// 如果Create的是目录，那么需要建立监听
 			if event.Has(fsnotify.Create) {
+				// 宽限期内重新创建的路径取消删除
+				w.cancelDelete(event.Name)
 				_ = w.Add(event.Name)
-				w.PutChan <- event.Name
+				// 与等待配对的旧路径是同一个文件或目录时按重命名处理，远端服务端复制不再重新上传
+				if from, ok := w.matchRename(event.Name); ok {
+					log.Infof("Rename detected %s -> %s", from, event.Name)
+					w.RenameChan <- Rename{From: from, To: event.Name}
+				} else {
+					w.PutChan <- event.Name
+				}
 			}
 
 			// 文件发生变更
 			if event.Has(fsnotify.Write) {
+				w.cancelDelete(event.Name)
 				// 判断文件是否热点文件，热点文件进行延迟更新，以节省流量和操作次数
 				if kv.Exists(event.Name) {
 					// 记录首次触发时间戳，实现精确延迟控制
@@ -140,6 +191,8 @@ func (w *Watcher) Watch(ctx context.Context) error {
 			// 如果删除或改在监听列表中，则需要移除监听
 			// 实验证明Remove的时候fsnotify会自动处理移除监听（包括子目录），而Rename的时候只会移除被rename的目录（不包括子目录）
 			if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+				// 重命名的旧路径在移除监听前记录inode，等待与新路径的Create事件配对
+				held := event.Has(fsnotify.Rename) && w.holdRename(event.Name, time.Now())
 				// 使用 watchListMap 进行 O(1) 查找，替代原有的数组遍历
 				w.watchListMap.Range(func(key, _ interface{}) bool {
 					name := key.(string)
@@ -153,7 +206,9 @@ func (w *Watcher) Watch(ctx context.Context) error {
 					}
 					return true
 				})
-				w.DeleteChan <- event.Name
+				if !held {
+					w.delete(event.Name)
+				}
 			}
 
 		case <-ticker.C:
@@ -170,6 +225,8 @@ func (w *Watcher) Watch(ctx context.Context) error {
 				}
 				return true
 			})
+			w.flushRenames(now)
+			w.flushDeletes(now)
 
 		case err, ok := <-w.Notify.Errors:
 			if !ok {
@@ -180,3 +237,113 @@ func (w *Watcher) Watch(ctx context.Context) error {
 
 	}
 }
+
+// delete 发送删除，启用宽限期时先进入待删除列表
+func (w *Watcher) delete(path string) {
+	if w.DeleteDelay > 0 {
+		// 删除先进入宽限期，避免先删除再创建的保存方式或挂载点短暂异常导致误删远端
+		w.pendingDeletes.Store(path, deleteItem{deletedAt: time.Now()})
+		log.Debugf("Pending delete %s", path)
+	} else {
+		w.DeleteChan <- path
+	}
+}
+
+// holdRename 记录被重命名的旧路径等待配对，目录使用监听时记录的inode，文件使用MD5缓存中的记录
+// 没有可用于配对的记录时返回false，由调用方按删除处理
+func (w *Watcher) holdRename(path string, now time.Time) bool {
+	if w.RenameChan == nil {
+		return false
+	}
+	if _, ok := w.pendingRenames.Load(path); ok {
+		// 目录移动时自身的监听还会产生一次Rename事件
+		return true
+	}
+	item := renameItem{renamedAt: now}
+	if value, ok := w.watchListMap.Load(path); ok {
+		item.isDir, item.inode = true, value.(uint64)
+	} else if entry, ok := helper.LookupMd5Cache(path); ok {
+		item.inode, item.size, item.md5 = entry.Inode, entry.Size, entry.Md5
+	}
+	if item.inode == 0 && item.md5 == "" {
+		return false
+	}
+	w.pendingRenames.Store(path, item)
+	log.Debugf("Pending rename %s", path)
+	return true
+}
+
+// matchRename 查找与新路径配对的旧路径，目录按inode匹配，文件按inode或大小和MD5匹配
+func (w *Watcher) matchRename(path string) (string, bool) {
+	info, err := os.Lstat(path)
+	if err != nil || (!info.IsDir() && !info.Mode().IsRegular()) {
+		return "", false
+	}
+	inode := helper.Inode(info)
+	var from, md5 string
+	var matched renameItem
+	w.pendingRenames.Range(func(key, value interface{}) bool {
+		item := value.(renameItem)
+		if item.paired || item.isDir != info.IsDir() {
+			return true
+		}
+		ok := item.inode != 0 && item.inode == inode && (item.isDir || item.size == info.Size())
+		if !ok && !item.isDir && item.md5 != "" && item.size == info.Size() {
+			// inode不同（如跨设备移动或不支持inode的系统）时按内容匹配，只计算一次新文件的MD5
+			if md5 == "" {
+				md5, _ = helper.GetCachedFileMd5(path)
+			}
+			ok = md5 != "" && md5 == item.md5
+		}
+		if ok {
+			from, matched = key.(string), item
+		}
+		return !ok
+	})
+	if from == "" {
+		return "", false
+	}
+	matched.paired = true
+	w.pendingRenames.Store(from, matched)
+	return from, true
+}
+
+// flushRenames 清理配对时间已过的旧路径，未配对的（移出了监听范围）按删除处理
+func (w *Watcher) flushRenames(now time.Time) {
+	w.pendingRenames.Range(func(key, value interface{}) bool {
+		item := value.(renameItem)
+		if now.Sub(item.renamedAt) < renameWindow {
+			return true
+		}
+		w.pendingRenames.Delete(key)
+		if !item.paired {
+			w.delete(key.(string))
+		}
+		return true
+	})
+}
+
+// cancelDelete 取消宽限期内的待删除路径
+func (w *Watcher) cancelDelete(path string) {
+	if _, loaded := w.pendingDeletes.LoadAndDelete(path); loaded {
+		log.Infof("Delete cancelled, path re-created %s", path)
+	}
+}
+
+// flushDeletes 把宽限期已过的待删除路径发送到删除队列，此时路径仍存在则取消删除
+func (w *Watcher) flushDeletes(now time.Time) {
+	w.pendingDeletes.Range(func(key, value interface{}) bool {
+		path := key.(string)
+		item := value.(deleteItem)
+		if now.Sub(item.deletedAt) < w.DeleteDelay {
+			return true
+		}
+		w.pendingDeletes.Delete(key)
+		if isExist, _ := helper.IsExist(path); isExist {
+			log.Infof("Delete cancelled, path reappeared %s", path)
+			return true
+		}
+		w.DeleteChan <- path
+		return true
+	})
+}
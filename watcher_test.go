@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/jorben/rsync-object-storage/helper"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestWatcher_FlushDeletes 测试删除宽限期到期后才发送删除，路径重新出现时取消删除
+func TestWatcher_FlushDeletes(t *testing.T) {
+	tmpDir := t.TempDir()
+	gone := filepath.Join(tmpDir, "gone.txt")
+	back := filepath.Join(tmpDir, "back.txt")
+	fresh := filepath.Join(tmpDir, "fresh.txt")
+	assert.NoError(t, os.WriteFile(back, []byte("back"), 0644))
+
+	now := time.Now()
+	w := &Watcher{DeleteDelay: 10 * time.Second, DeleteChan: make(chan string, 3)}
+	w.pendingDeletes.Store(gone, deleteItem{deletedAt: now.Add(-11 * time.Second)})
+	w.pendingDeletes.Store(back, deleteItem{deletedAt: now.Add(-11 * time.Second)})
+	w.pendingDeletes.Store(fresh, deleteItem{deletedAt: now.Add(-5 * time.Second)})
+
+	w.flushDeletes(now)
+
+	assert.Len(t, w.DeleteChan, 1)
+	assert.Equal(t, gone, <-w.DeleteChan)
+	_, pending := w.pendingDeletes.Load(back)
+	assert.False(t, pending)
+	// 宽限期未到的路径继续等待
+	_, pending = w.pendingDeletes.Load(fresh)
+	assert.True(t, pending)
+}
+
+// TestWatcher_WatchShutdown 测试退出时宽限期内的待删除路径发送到删除队列
+func TestWatcher_WatchShutdown(t *testing.T) {
+	tmpDir := t.TempDir()
+	notify, err := fsnotify.NewWatcher()
+	assert.NoError(t, err)
+	w := &Watcher{
+		Enable:        true,
+		DeleteDelay:   time.Minute,
+		LocalPrefix:   tmpDir,
+		Notify:        notify,
+		IgnoreMatcher: helper.NewIgnoreMatcher(nil),
+		PutChan:       make(chan string, 1),
+		DeleteChan:    make(chan string, 1),
+	}
+	defer w.Close()
+	gone := filepath.Join(tmpDir, "gone.txt")
+	w.pendingDeletes.Store(gone, deleteItem{deletedAt: time.Now()})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NoError(t, w.Watch(ctx))
+
+	assert.Len(t, w.DeleteChan, 1)
+	assert.Equal(t, gone, <-w.DeleteChan)
+}
+
+// TestWatcher_CancelDelete 测试宽限期内重新创建的路径取消删除
+func TestWatcher_CancelDelete(t *testing.T) {
+	now := time.Now()
+	w := &Watcher{DeleteDelay: 10 * time.Second, DeleteChan: make(chan string, 1)}
+	w.pendingDeletes.Store("/data/a.txt", deleteItem{deletedAt: now.Add(-11 * time.Second)})
+
+	w.cancelDelete("/data/a.txt")
+	w.cancelDelete("/data/b.txt")
+	w.flushDeletes(now)
+
+	assert.Len(t, w.DeleteChan, 0)
+}
+
+// TestWatcher_Rename 测试重命名的旧路径与新路径配对，无法配对时按删除处理
+func TestWatcher_Rename(t *testing.T) {
+	tmpDir := t.TempDir()
+	now := time.Now()
+	w := &Watcher{DeleteChan: make(chan string, 3), RenameChan: make(chan Rename)}
+
+	t.Run("目录按inode配对", func(t *testing.T) {
+		oldDir, newDir := filepath.Join(tmpDir, "photos"), filepath.Join(tmpDir, "album")
+		assert.NoError(t, os.Mkdir(oldDir, 0755))
+		info, err := os.Stat(oldDir)
+		assert.NoError(t, err)
+		w.watchListMap.Store(oldDir, helper.Inode(info))
+		assert.NoError(t, os.Rename(oldDir, newDir))
+
+		assert.True(t, w.holdRename(oldDir, now))
+		from, ok := w.matchRename(newDir)
+		assert.True(t, ok)
+		assert.Equal(t, oldDir, from)
+		// 目录自身的重复Rename事件被忽略，配对时间结束后不发送删除
+		assert.True(t, w.holdRename(oldDir, now))
+		w.flushRenames(now.Add(renameWindow))
+		assert.Len(t, w.DeleteChan, 0)
+	})
+
+	t.Run("文件按MD5缓存配对", func(t *testing.T) {
+		oldFile, newFile := filepath.Join(tmpDir, "a.txt"), filepath.Join(tmpDir, "b.txt")
+		assert.NoError(t, os.WriteFile(oldFile, []byte("rename me"), 0644))
+		_, err := helper.GetCachedFileMd5(oldFile)
+		assert.NoError(t, err)
+		// 复制后删除，inode不同但内容一致
+		assert.NoError(t, os.WriteFile(newFile, []byte("rename me"), 0644))
+		assert.NoError(t, os.Remove(oldFile))
+
+		assert.True(t, w.holdRename(oldFile, now))
+		_, ok := w.matchRename(filepath.Join(tmpDir, "missing.txt"))
+		assert.False(t, ok)
+		from, ok := w.matchRename(newFile)
+		assert.True(t, ok)
+		assert.Equal(t, oldFile, from)
+		w.flushRenames(now.Add(renameWindow))
+	})
+
+	t.Run("无法配对", func(t *testing.T) {
+		// 没有记录的路径直接按删除处理
+		assert.False(t, w.holdRename(filepath.Join(tmpDir, "unknown.txt"), now))
+
+		moved := filepath.Join(tmpDir, "moved.txt")
+		assert.NoError(t, os.WriteFile(moved, []byte("moved out"), 0644))
+		_, err := helper.GetCachedFileMd5(moved)
+		assert.NoError(t, err)
+		assert.NoError(t, os.Remove(moved))
+		assert.True(t, w.holdRename(moved, now))
+		// 配对时间内继续等待，超时后发送删除
+		w.flushRenames(now)
+		assert.Len(t, w.DeleteChan, 0)
+		w.flushRenames(now.Add(renameWindow))
+		assert.Len(t, w.DeleteChan, 1)
+		assert.Equal(t, moved, <-w.DeleteChan)
+	})
+}